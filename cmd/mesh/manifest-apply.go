@@ -22,6 +22,9 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/retry"
 )
 
 type manifestApplyArgs struct {
@@ -43,19 +46,44 @@ type manifestApplyArgs struct {
 	// set is a string with element format "path=value" where path is an IstioOperator path and the value is a
 	// value to set the node at that path to.
 	set []string
+	// output is the format of the apply report printed to stdout. No report is printed if empty.
+	output string
+	// plan prints the changes the apply would make to the cluster before applying them.
+	plan bool
+	// concurrency is the maximum number of components applied at the same time.
+	concurrency int
+	// maxRetries is the maximum number of times a request for an object is retried after a transient error.
+	maxRetries int
+	// multiCluster selects the clusters to apply to, if there are several.
+	multiCluster multiClusterArgs
+	// imageLock is the path to the image lock file images are pinned with.
+	imageLock string
 }
 
 func addManifestApplyFlags(cmd *cobra.Command, args *manifestApplyArgs) {
 	cmd.PersistentFlags().StringVarP(&args.inFilename, "filename", "f", "", filenameFlagHelpStr)
 	cmd.PersistentFlags().StringVarP(&args.kubeConfigPath, "kubeconfig", "c", "", "Path to kube config")
 	cmd.PersistentFlags().StringVar(&args.context, "context", "", "The name of the kubeconfig context to use")
+	cmd.PersistentFlags().StringVar(&args.imageLock, "image-lock", "", imageLockFlagHelpStr)
 	cmd.PersistentFlags().BoolVar(&args.skipConfirmation, "skip-confirmation", false, skipConfirmationFlagHelpStr)
 	cmd.PersistentFlags().BoolVar(&args.force, "force", false, "Proceed even with validation errors")
-	cmd.PersistentFlags().DurationVar(&args.readinessTimeout, "readiness-timeout", 300*time.Second, "Maximum seconds to wait for all Istio resources to be ready."+
+	cmd.PersistentFlags().DurationVar(&args.readinessTimeout, "readiness-timeout", 300*time.Second, "Maximum seconds to wait for the resources of each component to be ready."+
+		" It can be overridden for a component with components.<component>.spec.readinessTimeout in the IstioOperator spec."+
 		" The --wait flag must be set for this flag to apply")
-	cmd.PersistentFlags().BoolVarP(&args.wait, "wait", "w", false, "Wait, if set will wait until all Pods, Services, and minimum number of Pods "+
-		"of a Deployment are in a ready state before the command exits. It will wait for a maximum duration of --readiness-timeout seconds")
+	cmd.PersistentFlags().BoolVarP(&args.wait, "wait", "w", false, "Wait, if set will wait until the resources of each component, such as "+
+		"Deployments, DaemonSets, StatefulSets, Jobs, CRDs, webhooks and Services, are ready before applying the components which depend on it. "+
+		"It will wait for a maximum duration of --readiness-timeout seconds per component")
 	cmd.PersistentFlags().StringSliceVarP(&args.set, "set", "s", nil, SetFlagHelpStr)
+	cmd.PersistentFlags().BoolVar(&args.plan, "plan", false, "Print the objects which will be created, updated and pruned, "+
+		"computed with a server-side dry run against the cluster, and ask for confirmation before applying them. "+
+		"With --dry-run, only the plan is printed")
+	cmd.PersistentFlags().IntVar(&args.concurrency, "concurrency", defaultApplyConcurrency,
+		"Maximum number of components applied at the same time. 0 means no limit")
+	cmd.PersistentFlags().IntVar(&args.maxRetries, "max-retries", retry.DefaultBackoff.Steps-1,
+		"Maximum number of times a request for an object is retried, with exponential backoff, after a transient error "+
+			"such as a webhook which is not ready yet, a conflict or a reset connection")
+	addMultiClusterFlags(cmd, &args.multiCluster)
+	addApplyReportFlag(cmd, &args.output)
 }
 
 func manifestApplyCmd(rootArgs *rootArgs, maArgs *manifestApplyArgs) *cobra.Command {
@@ -65,28 +93,110 @@ func manifestApplyCmd(rootArgs *rootArgs, maArgs *manifestApplyArgs) *cobra.Comm
 		Long:  "The apply subcommand generates an Istio install manifest and applies it to a cluster.",
 		Args:  cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if err := validateApplyReportFormat(maArgs.output); err != nil {
+				return err
+			}
+			targets, err := maArgs.multiCluster.targets(maArgs.context)
+			if err != nil {
+				return err
+			}
+			if len(targets) != 0 && maArgs.plan {
+				return fmt.Errorf("--plan cannot be used with --cluster")
+			}
+			l := applyReportLogger(rootArgs, maArgs.output, cmd)
+			msg := "This will install Istio into the cluster. Proceed? (y/N)"
+			if len(targets) != 0 {
+				msg = fmt.Sprintf("This will install Istio into clusters %s. Proceed? (y/N)", targetContexts(targets))
+			}
+			if maArgs.plan {
+				plan, err := manifestPlan(rootArgs, maArgs, l)
+				if err != nil {
+					return err
+				}
+				if rootArgs.dryRun {
+					return nil
+				}
+				if !plan.HasChanges() {
+					l.logAndPrint("No changes to apply.")
+					return nil
+				}
+				msg = "This will apply the changes above to the cluster. Proceed? (y/N)"
+			}
 			// Warn users before starting to install Istio
 			if !rootArgs.dryRun && !maArgs.skipConfirmation {
-				if !confirm("This will install Istio into the cluster. Proceed? (y/N)", cmd.OutOrStdout()) {
+				if !confirm(msg, l.stdOut) {
 					cmd.Print("Cancelled.\n")
 					os.Exit(1)
 				}
 			}
-			return manifestApply(rootArgs, maArgs, l)
+			if len(targets) != 0 {
+				report, err := manifestApplyToClusters(rootArgs, maArgs, targets, l)
+				if werr := writeApplyReport(report, maArgs.output, cmd.OutOrStdout()); werr != nil {
+					return werr
+				}
+				return err
+			}
+			report, err := manifestApply(rootArgs, maArgs, l)
+			if werr := writeApplyReport(report, maArgs.output, cmd.OutOrStdout()); werr != nil {
+				return werr
+			}
+			return err
 		}}
 }
 
-func manifestApply(args *rootArgs, maArgs *manifestApplyArgs, l *Logger) error {
+func manifestApply(args *rootArgs, maArgs *manifestApplyArgs, l *Logger) (*manifest.ApplyReport, error) {
 	if err := configLogs(args.logToStdErr); err != nil {
-		return fmt.Errorf("could not configure logs: %s", err)
+		return nil, fmt.Errorf("could not configure logs: %s", err)
 	}
-	if err := genApplyManifests(maArgs.set, maArgs.inFilename, maArgs.force, args.dryRun, args.verbose,
-		maArgs.kubeConfigPath, maArgs.context, maArgs.wait, maArgs.readinessTimeout, l); err != nil {
-		return fmt.Errorf("failed to generate and apply manifests, error: %v", err)
+	report, err := genApplyManifests(maArgs.set, "", maArgs.imageLock, maArgs.inFilename, maArgs.force, args.dryRun, args.verbose,
+		maArgs.kubeConfigPath, maArgs.context, maArgs.wait, maArgs.readinessTimeout, maArgs.concurrency, &maArgs.maxRetries, l)
+	if err != nil {
+		return report, fmt.Errorf("failed to generate and apply manifests, error: %v", err)
 	}
 
-	return nil
+	return report, nil
+}
+
+// manifestApplyToClusters applies the generated manifests to each of targets, with the overlay file of each target
+// applied for that cluster only.
+func manifestApplyToClusters(args *rootArgs, maArgs *manifestApplyArgs, targets []clusterTarget,
+	l *Logger) (*manifest.MultiClusterApplyReport, error) {
+	if err := configLogs(args.logToStdErr); err != nil {
+		return nil, fmt.Errorf("could not configure logs: %s", err)
+	}
+	overlays := make(map[string]string)
+	for _, t := range targets {
+		overlay, err := readClusterOverlay(t.overlayFile, maArgs.force)
+		if err != nil {
+			return nil, err
+		}
+		overlays[t.context] = overlay
+	}
+	return applyToClusters(targets, maArgs.multiCluster.order, l, func(t clusterTarget, cl *Logger) (*manifest.ApplyReport, error) {
+		report, err := genApplyManifests(maArgs.set, overlays[t.context], maArgs.imageLock, maArgs.inFilename, maArgs.force, args.dryRun,
+			args.verbose, maArgs.kubeConfigPath, t.context, maArgs.wait, maArgs.readinessTimeout, maArgs.concurrency,
+			&maArgs.maxRetries, cl)
+		if err != nil {
+			return report, fmt.Errorf("failed to generate and apply manifests, error: %v", err)
+		}
+		return report, nil
+	})
+}
+
+// manifestPlan prints the changes applying the generated manifests would make to the cluster.
+func manifestPlan(args *rootArgs, maArgs *manifestApplyArgs, l *Logger) (manifest.Plan, error) {
+	if err := configLogs(args.logToStdErr); err != nil {
+		return nil, fmt.Errorf("could not configure logs: %s", err)
+	}
+	plan, err := genPlanManifests(maArgs.set, maArgs.inFilename, maArgs.imageLock, maArgs.force, maArgs.kubeConfigPath, maArgs.context, l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate and plan manifests, error: %v", err)
+	}
+	l.logAndPrint(plan.String())
+	if errs := plan.Errors(); len(errs) != 0 {
+		return plan, fmt.Errorf("server-side dry run failed for %d objects", len(errs))
+	}
+	return plan, nil
 }
 
 func confirm(msg string, writer io.Writer) bool {
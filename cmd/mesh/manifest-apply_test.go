@@ -0,0 +1,80 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"testing"
+
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/object"
+)
+
+func TestManifestApplyPrune(t *testing.T) {
+	f := useFakeCluster()
+	defer manifest.SetBackendFactory(nil)
+
+	const minimal = "--set profile=minimal"
+	const noPilot = minimal + " --set components.pilot.enabled=false"
+	if _, err := runCommand("manifest apply --skip-confirmation " + minimal); err != nil {
+		t.Fatal(err)
+	}
+	minimalYAML, err := runManifestGenerate("", minimal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjects(t, f, minimalYAML, true)
+
+	if _, err := runCommand("manifest apply --skip-confirmation " + noPilot); err != nil {
+		t.Fatal(err)
+	}
+	noPilotYAML, err := runManifestGenerate("", noPilot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjects(t, f, noPilotYAML, true)
+	pruned := objectsNotIn(t, minimalYAML, noPilotYAML)
+	if pruned == "" {
+		t.Fatal("disabling pilot removes no objects")
+	}
+	checkObjects(t, f, pruned, false)
+}
+
+// objectsNotIn returns the manifest of the objects in manifestStr which are not in otherManifestStr.
+func objectsNotIn(t *testing.T, manifestStr, otherManifestStr string) string {
+	t.Helper()
+	objs, err := object.ParseK8sObjectsFromYAMLManifest(manifestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := object.ParseK8sObjectsFromYAMLManifest(otherManifestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHashes := make(map[string]bool)
+	for _, o := range others {
+		otherHashes[o.Hash()] = true
+	}
+	var ret object.K8sObjects
+	for _, o := range objs {
+		if !otherHashes[o.Hash()] {
+			ret = append(ret, o)
+		}
+	}
+	s, err := ret.YAMLManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
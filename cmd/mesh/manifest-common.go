@@ -16,93 +16,246 @@ package mesh
 
 import (
 	"fmt"
+	"io"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
 
 	"istio.io/api/operator/v1alpha1"
 	"istio.io/operator/pkg/component/controlplane"
 	"istio.io/operator/pkg/helm"
-	"istio.io/operator/pkg/kubectlcmd"
+	"istio.io/operator/pkg/image"
 	"istio.io/operator/pkg/manifest"
 	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/readiness"
 	"istio.io/operator/pkg/tpath"
 	"istio.io/operator/pkg/translate"
 	"istio.io/operator/pkg/util"
 	"istio.io/operator/pkg/validate"
+	pkgversion "istio.io/operator/pkg/version"
 	"istio.io/operator/version"
 )
 
-var (
-	ignoreStdErrList = []string{
-		// TODO: remove when https://github.com/kubernetes/kubernetes/issues/82154 is fixed.
-		"Warning: kubectl apply should be used on resource created by either kubectl create --save-config or kubectl apply",
-	}
-)
+// defaultApplyConcurrency is the default maximum number of components applied at the same time.
+const defaultApplyConcurrency = 4
 
-func genApplyManifests(setOverlay []string, inFilename string, force bool, dryRun bool, verbose bool,
-	kubeConfigPath string, context string, wait bool, waitTimeout time.Duration, l *Logger) error {
+// revisionMu serializes recording install revisions, since the install history functions use the cluster set with
+// manifest.InitK8SRestClient.
+var revisionMu sync.Mutex
+
+// genApplyManifests generates manifests and applies them to the cluster. clusterOverlay is an IstioOperatorSpec in
+// YAML format which is overlaid on inFilename, with setOverlay overlaid on top of it. It may be empty.
+func genApplyManifests(setOverlay []string, clusterOverlay, imageLock, inFilename string, force bool, dryRun bool,
+	verbose bool, kubeConfigPath string, context string, wait bool, waitTimeout time.Duration, concurrency int,
+	maxRetries *int, l *Logger) (*manifest.ApplyReport, error) {
 	overlayFromSet, err := MakeTreeFromSetList(setOverlay, force, l)
 	if err != nil {
-		return fmt.Errorf("failed to generate tree from the set overlay, error: %v", err)
+		return nil, fmt.Errorf("failed to generate tree from the set overlay, error: %v", err)
+	}
+	if clusterOverlay != "" {
+		if overlayFromSet, err = util.OverlayYAML(clusterOverlay, overlayFromSet); err != nil {
+			return nil, fmt.Errorf("failed to overlay the set overlay on the cluster overlay, error: %v", err)
+		}
 	}
 
 	manifests, iops, err := GenManifests(inFilename, overlayFromSet, force, l)
 	if err != nil {
-		return fmt.Errorf("failed to generate manifest: %v", err)
+		return nil, fmt.Errorf("failed to generate manifest: %v", err)
+	}
+	if manifests, err = pinImages(manifests, imageLock); err != nil {
+		return nil, err
 	}
-	opts := &kubectlcmd.Options{
+	opts := &manifest.InstallOptions{
 		DryRun:      dryRun,
 		Verbose:     verbose,
 		Wait:        wait,
 		WaitTimeout: waitTimeout,
 		Kubeconfig:  kubeConfigPath,
 		Context:     context,
+		Concurrency: concurrency,
+		MaxRetries:  maxRetries,
+		ProgressOut: l.progressOut,
 	}
-	out, err := manifest.ApplyAll(manifests, version.OperatorBinaryVersion, opts)
+	report, err := applyManifests(manifests, iops, version.OperatorBinaryVersion, opts, l)
 	if err != nil {
-		return fmt.Errorf("failed to apply manifest with kubectl client: %v", err)
+		return report, err
 	}
-	gotError := false
-	skippedComponentMap := map[name.ComponentName]bool{}
-	for cn := range manifests {
-		enabledInSpec, err := name.IsComponentEnabledInSpec(cn, iops)
-		if err != nil {
-			l.logAndPrintf("failed to check if %s is enabled in IstioOperatorSpec: %v", cn, err)
+	if !dryRun {
+		revisionMu.Lock()
+		defer revisionMu.Unlock()
+		if err := manifest.InitK8SRestClient(kubeConfigPath, context); err != nil {
+			l.logAndPrintf("Warning: could not record install revision: %s", err)
+			return report, nil
 		}
-		// Skip the output of a component when it is disabled
-		// and not pruned (indicated by applied manifest out[cn].Manifest).
-		if !enabledInSpec && out[cn].Err == nil && out[cn].Manifest == "" {
-			skippedComponentMap[cn] = true
+		recordInstallRevision(manifests, iops, version.OperatorBinaryVersion, "", l)
+	}
+	return report, nil
+}
+
+// genPlanManifests generates manifests and computes the changes applying them would make to the cluster.
+func genPlanManifests(setOverlay []string, inFilename, imageLock string, force bool, kubeConfigPath string,
+	context string, l *Logger) (manifest.Plan, error) {
+	overlayFromSet, err := MakeTreeFromSetList(setOverlay, force, l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate tree from the set overlay, error: %v", err)
+	}
+	manifests, iops, err := GenManifests(inFilename, overlayFromSet, force, l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate manifest: %v", err)
+	}
+	if manifests, err = pinImages(manifests, imageLock); err != nil {
+		return nil, err
+	}
+	opts := &manifest.InstallOptions{
+		Kubeconfig:         kubeConfigPath,
+		Context:            context,
+		InventoryNamespace: historyNamespace(iops),
+		Revision:           iops.ResourceSuffix,
+	}
+	return manifest.PlanAll(manifests, version.OperatorBinaryVersion, opts)
+}
+
+// pinImages returns manifests with all images pinned to their digests in the image lock file at path. manifests are
+// returned unchanged if path is empty.
+func pinImages(manifests name.ManifestMap, path string) (name.ManifestMap, error) {
+	if path == "" {
+		return manifests, nil
+	}
+	lock, err := image.ReadLock(path)
+	if err != nil {
+		return nil, err
+	}
+	return lock.Pin(manifests)
+}
+
+// applyManifests applies manifests generated from iops to the cluster and logs the result for each component.
+// The returned report is nil only if the manifests could not be applied at all.
+func applyManifests(manifests name.ManifestMap, iops *v1alpha1.IstioOperatorSpec, ver pkgversion.Version,
+	opts *manifest.InstallOptions, l *Logger) (*manifest.ApplyReport, error) {
+	if opts.InventoryNamespace == "" {
+		opts.InventoryNamespace = historyNamespace(iops)
+	}
+	opts.Revision = iops.ResourceSuffix
+	if opts.Wait && opts.ReadinessTimeouts == nil {
+		timeouts, err := readiness.ComponentTimeouts(iops)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read readiness timeouts: %v", err)
 		}
+		opts.ReadinessTimeouts = timeouts
 	}
+	start := time.Now()
+	out, err := manifest.ApplyAll(manifests, ver, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply manifest: %v", err)
+	}
+	report := manifest.NewApplyReport(out, iops, time.Since(start))
 
-	for cn := range manifests {
+	for _, cr := range report.Components {
+		cn := cr.Component
 		if out[cn].Err != nil {
 			cs := fmt.Sprintf("Component %s - manifest apply returned the following errors:", cn)
 			l.logAndPrintf("\n%s", cs)
 			l.logAndPrint("Error: ", out[cn].Err, "\n")
-			gotError = true
-		} else if skippedComponentMap[cn] {
+		} else if cr.SkippedAsDisabled {
 			continue
 		}
 
-		if !ignoreError(out[cn].Stderr) {
-			l.logAndPrint("Error detail:\n", out[cn].Stderr, "\n", out[cn].Stdout, "\n")
-			gotError = true
+		if opts.Verbose {
+			for _, r := range out[cn].Objects {
+				l.logAndPrint(r.String())
+			}
 		}
 	}
 
-	if gotError {
+	if report.Status == manifest.ApplyFailed {
 		l.logAndPrint("\n\n✘ Errors were logged during apply operation. Please check component installation logs above.\n")
-		return fmt.Errorf("errors were logged during apply operation")
+		return report, fmt.Errorf("errors were logged during apply operation")
 	}
 
 	l.logAndPrint("\n\n✔ Installation complete\n")
-	return nil
+	return report, nil
+}
+
+// addApplyReportFlag adds the flag selecting the format of the apply report to cmd.
+func addApplyReportFlag(cmd *cobra.Command, output *string) {
+	cmd.PersistentFlags().StringVarP(output, "output", "o", "",
+		"If set, prints a report of the apply to stdout in the given format, one of json|yaml. "+
+			"All other output is printed to stderr")
+}
+
+// validateApplyReportFormat returns an error if format is not a valid value of the apply report flag.
+func validateApplyReportFormat(format string) error {
+	switch format {
+	case "", manifest.ReportFormatJSON, manifest.ReportFormatYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, must be one of %s|%s", format, manifest.ReportFormatJSON, manifest.ReportFormatYAML)
+}
+
+// applyReportLogger returns the Logger for a command which can print an apply report in the given format. If a
+// format is set, all human readable output goes to stderr, so that stdout only holds the report.
+func applyReportLogger(args *rootArgs, format string, cmd *cobra.Command) *Logger {
+	if format == "" {
+		return NewLogger(args.logToStdErr, cmd.OutOrStdout(), cmd.ErrOrStderr())
+	}
+	manifest.SetProgressOutput(cmd.ErrOrStderr())
+	return NewLogger(args.logToStdErr, cmd.ErrOrStderr(), cmd.ErrOrStderr())
+}
+
+// applyReport is implemented by manifest.ApplyReport and manifest.MultiClusterApplyReport.
+type applyReport interface {
+	Marshal(format string) ([]byte, error)
+}
+
+// writeApplyReport writes report to w in the given format. Nothing is written if format or report is empty.
+func writeApplyReport(report applyReport, format string, w io.Writer) error {
+	if format == "" || report == nil || reflect.ValueOf(report).IsNil() {
+		return nil
+	}
+	b, err := report.Marshal(format)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// recordInstallRevision stores a successfully applied install as a new revision in the cluster. Failing to record
+// the revision does not fail the install, since the install itself succeeded.
+func recordInstallRevision(manifests name.ManifestMap, iops *v1alpha1.IstioOperatorSpec, ver pkgversion.Version,
+	description string, l *Logger) {
+	spec, err := util.MarshalWithJSONPB(iops)
+	if err != nil {
+		l.logAndPrintf("Warning: could not record install revision: %s", err)
+		return
+	}
+	rev := &manifest.InstallRevision{
+		OperatorVersion: ver.String(),
+		Timestamp:       time.Now(),
+		Description:     description,
+		Spec:            spec,
+		Manifests:       manifests,
+	}
+	ns := historyNamespace(iops)
+	if err := manifest.SaveInstallRevision(ns, rev, manifest.DefaultMaxHistory); err != nil {
+		l.logAndPrintf("Warning: could not record install revision: %s", err)
+		return
+	}
+	l.logAndPrintf("Recorded install revision %d in namespace %s.", rev.Revision, ns)
+}
+
+// historyNamespace returns the namespace install revisions for iops are stored in.
+func historyNamespace(iops *v1alpha1.IstioOperatorSpec) string {
+	if ns := iops.GetMeshConfig().GetRootNamespace(); ns != "" {
+		return ns
+	}
+	return manifest.DefaultHistoryNamespace
 }
 
 // GenManifests generate manifest from input file and setOverLay
@@ -140,16 +293,6 @@ func GenManifests(inFilename string, setOverlayYAML string, force bool, l *Logge
 	return manifests, mergedIOPS, nil
 }
 
-func ignoreError(stderr string) bool {
-	trimmedStdErr := strings.TrimSpace(stderr)
-	for _, ignore := range ignoreStdErrList {
-		if strings.HasPrefix(trimmedStdErr, ignore) {
-			return true
-		}
-	}
-	return trimmedStdErr == ""
-}
-
 // fetchInstallPackageFromURL downloads installation packages from specified URL.
 func fetchInstallPackageFromURL(mergedIOPS *v1alpha1.IstioOperatorSpec) error {
 	if util.IsHTTPURL(mergedIOPS.InstallPackagePath) {
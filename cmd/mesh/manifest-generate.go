@@ -24,8 +24,10 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"istio.io/operator/pkg/image"
 	"istio.io/operator/pkg/manifest"
 	"istio.io/operator/pkg/name"
+	"istio.io/operator/version"
 )
 
 type manifestGenerateArgs struct {
@@ -38,13 +40,53 @@ type manifestGenerateArgs struct {
 	set []string
 	// force proceeds even if there are validation errors
 	force bool
+	// outputFormat is the layout of the manifests written to the output directory.
+	outputFormat string
+	// chartName is the name of the Helm chart written for the helm-chart output format.
+	chartName string
+	// rewriteRegistry are registry rewrites in from=to form applied to all image references.
+	rewriteRegistry []string
+	// imageLock is the path to the image lock file images are pinned with.
+	imageLock string
 }
 
+const (
+	// outputFormatManifest writes a YAML manifest for each component into directories following the dependency tree.
+	outputFormatManifest = "manifest"
+	// outputFormatKustomize writes a kustomize base for each component and a kustomization composing all of them.
+	outputFormatKustomize = "kustomize"
+	// outputFormatHelmChart writes a Helm chart holding the manifests of all components.
+	outputFormatHelmChart = "helm-chart"
+	// outputFormatPerResource writes each object to its own file, along with a manifest index.
+	outputFormatPerResource = "per-resource"
+	// outputFormatPerNamespace writes the objects in each namespace to their own file, along with a manifest index.
+	outputFormatPerNamespace = "per-namespace"
+)
+
+var (
+	// outputFormats are the valid values of --output-format.
+	outputFormats = []string{outputFormatManifest, outputFormatKustomize, outputFormatHelmChart, outputFormatPerResource,
+		outputFormatPerNamespace}
+	// flatLayouts maps the output formats which write all files directly to the output directory to their layout.
+	flatLayouts = map[string]manifest.FlatLayout{
+		outputFormatPerResource:  manifest.PerResourceLayout,
+		outputFormatPerNamespace: manifest.PerNamespaceLayout,
+	}
+)
+
 func addManifestGenerateFlags(cmd *cobra.Command, args *manifestGenerateArgs) {
 	cmd.PersistentFlags().StringVarP(&args.inFilename, "filename", "f", "", filenameFlagHelpStr)
 	cmd.PersistentFlags().StringVarP(&args.outFilename, "output", "o", "", "Manifest output directory path")
 	cmd.PersistentFlags().StringSliceVarP(&args.set, "set", "s", nil, SetFlagHelpStr)
 	cmd.PersistentFlags().BoolVar(&args.force, "force", false, "Proceed even with validation errors")
+	cmd.PersistentFlags().StringVar(&args.outputFormat, "output-format", outputFormatManifest,
+		fmt.Sprintf("Layout of the output directory, one of %s. All formats other than %s require --output",
+			strings.Join(outputFormats, ", "), outputFormatManifest))
+	cmd.PersistentFlags().StringVar(&args.chartName, "chart-name", "",
+		fmt.Sprintf("Name of the Helm chart written with --output-format %s", outputFormatHelmChart))
+	cmd.PersistentFlags().StringArrayVar(&args.rewriteRegistry, "rewrite-registry", nil, rewriteRegistryFlagHelpStr)
+	cmd.PersistentFlags().StringVar(&args.imageLock, "image-lock", "", imageLockFlagHelpStr+
+		". Images are pinned after registry rewrites")
 }
 
 func manifestGenerateCmd(rootArgs *rootArgs, mgArgs *manifestGenerateArgs) *cobra.Command {
@@ -70,14 +112,34 @@ func manifestGenerate(args *rootArgs, mgArgs *manifestGenerateArgs, l *Logger) e
 		return fmt.Errorf("could not configure logs: %s", err)
 	}
 
+	switch {
+	case mgArgs.outputFormat == outputFormatManifest:
+	case !isOutputFormat(mgArgs.outputFormat):
+		return fmt.Errorf("unknown output format %q, must be one of %s", mgArgs.outputFormat,
+			strings.Join(outputFormats, ", "))
+	case mgArgs.outFilename == "":
+		return fmt.Errorf("--output-format %s requires --output", mgArgs.outputFormat)
+	}
+	if (mgArgs.outputFormat == outputFormatHelmChart) != (mgArgs.chartName != "") {
+		return fmt.Errorf("--chart-name must be set if and only if --output-format is %s", outputFormatHelmChart)
+	}
+
+	rewrites, err := image.ParseRewrites(mgArgs.rewriteRegistry)
+	if err != nil {
+		return err
+	}
+
 	overlayFromSet, err := MakeTreeFromSetList(mgArgs.set, mgArgs.force, l)
 	if err != nil {
 		return err
 	}
-	manifests, _, err := GenManifests(mgArgs.inFilename, overlayFromSet, mgArgs.force, l)
+	manifests, iops, err := GenManifests(mgArgs.inFilename, overlayFromSet, mgArgs.force, l)
 	if err != nil {
 		return err
 	}
+	if manifests, err = pinImages(rewrites.RewriteManifests(manifests), mgArgs.imageLock); err != nil {
+		return err
+	}
 
 	if mgArgs.outFilename == "" {
 		for _, m := range orderedManifests(manifests) {
@@ -87,7 +149,22 @@ func manifestGenerate(args *rootArgs, mgArgs *manifestGenerateArgs, l *Logger) e
 		if err := os.MkdirAll(mgArgs.outFilename, os.ModePerm); err != nil {
 			return err
 		}
-		if err := manifest.RenderToDir(manifests, mgArgs.outFilename, args.dryRun); err != nil {
+		switch layout, flat := flatLayouts[mgArgs.outputFormat]; {
+		case flat:
+			err = manifest.RenderToFlatDir(manifests, mgArgs.outFilename, layout, args.dryRun)
+		case mgArgs.outputFormat == outputFormatKustomize:
+			err = manifest.RenderToKustomize(manifests, mgArgs.outFilename, args.dryRun)
+		case mgArgs.outputFormat == outputFormatHelmChart:
+			err = manifest.RenderToHelmChart(manifests, mgArgs.outFilename, &manifest.HelmChartOptions{
+				Name:    mgArgs.chartName,
+				Version: version.OperatorBinaryVersion.String(),
+				Hub:     iops.Hub,
+				Tag:     iops.Tag,
+			}, args.dryRun)
+		default:
+			err = manifest.RenderToDir(manifests, mgArgs.outFilename, args.dryRun)
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -95,6 +172,15 @@ func manifestGenerate(args *rootArgs, mgArgs *manifestGenerateArgs, l *Logger) e
 	return nil
 }
 
+func isOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
 func orderedManifests(mm name.ManifestMap) []string {
 	var keys, out []string
 	for k := range mm {
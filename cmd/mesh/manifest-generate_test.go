@@ -21,8 +21,11 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"text/template"
 
 	"istio.io/operator/pkg/compare"
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/object"
 	"istio.io/operator/pkg/util"
 	"istio.io/pkg/version"
 )
@@ -166,6 +169,121 @@ func TestManifestGenerateOrdered(t *testing.T) {
 	})
 }
 
+func TestManifestGenerateKustomize(t *testing.T) {
+	testDataDir = filepath.Join(repoRootDir, "cmd/mesh/testdata/manifest-generate")
+	outDir := createTempDirOrFail(t, "kustomize-output")
+	defer removeDirOrFail(t, outDir)
+
+	if _, err := runManifestGenerate("", "--output-format kustomize"); err == nil {
+		t.Error("expected error for kustomize output without output directory")
+	}
+	if _, err := runManifestGenerate(filepath.Join(testDataDir, "input", "all_on.yaml"),
+		"--output-format kustomize -o "+outDir); err != nil {
+		t.Fatal(err)
+	}
+	top, err := readFile(filepath.Join(outDir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, pilot := strings.Index(top, "- Base\n"), strings.Index(top, "- Pilot\n")
+	if base == -1 || pilot == -1 || base > pilot {
+		t.Errorf("top-level kustomization does not list Base before Pilot:\n%s", top)
+	}
+	for _, c := range []string{"Base", "Pilot"} {
+		k, err := readFile(filepath.Join(outDir, c, "kustomization.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(k, "- "+c+".yaml\n") {
+			t.Errorf("%s kustomization does not list its manifest:\n%s", c, k)
+		}
+	}
+}
+
+func TestManifestGenerateHelmChart(t *testing.T) {
+	testDataDir = filepath.Join(repoRootDir, "cmd/mesh/testdata/manifest-generate")
+	outDir := createTempDirOrFail(t, "helm-chart-output")
+	defer removeDirOrFail(t, outDir)
+
+	if _, err := runManifestGenerate("", "--output-format helm-chart -o "+outDir); err == nil {
+		t.Error("expected error for helm-chart output without chart name")
+	}
+	if _, err := runManifestGenerate(filepath.Join(testDataDir, "input", "all_on.yaml"),
+		"--output-format helm-chart --chart-name istio-rendered -o "+outDir); err != nil {
+		t.Fatal(err)
+	}
+	chartDir := filepath.Join(outDir, "istio-rendered")
+	chart, err := readFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(chart, "name: istio-rendered\n") {
+		t.Errorf("unexpected Chart.yaml:\n%s", chart)
+	}
+	templates, err := filepath.Glob(filepath.Join(chartDir, "templates", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) == 0 {
+		t.Fatal("no templates written")
+	}
+	// All templates, including those holding escaped template delimiters, must be valid templates.
+	for _, f := range templates {
+		b, err := readFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := template.New(f).Parse(b); err != nil {
+			t.Errorf("%s: %s", f, err)
+		}
+	}
+}
+
+func TestManifestGenerateFlatLayouts(t *testing.T) {
+	testDataDir = filepath.Join(repoRootDir, "cmd/mesh/testdata/manifest-generate")
+	inPath := filepath.Join(testDataDir, "input", "all_on.yaml")
+	for _, format := range []string{outputFormatPerResource, outputFormatPerNamespace} {
+		t.Run(format, func(t *testing.T) {
+			outDir := createTempDirOrFail(t, format+"-output")
+			defer removeDirOrFail(t, outDir)
+			if _, err := runManifestGenerate(inPath, "--output-format "+format+" -o "+outDir); err != nil {
+				t.Fatal(err)
+			}
+			index, err := readFile(filepath.Join(outDir, manifest.ManifestIndexFilename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := make(map[string]bool)
+			lines := strings.Split(strings.TrimSpace(index), "\n")[1:]
+			for _, l := range lines {
+				files[strings.Fields(l)[1]] = true
+			}
+			count := 0
+			for f := range files {
+				y, err := readFile(filepath.Join(outDir, f))
+				if err != nil {
+					t.Fatal(err)
+				}
+				objs, err := object.ParseK8sObjectsFromYAMLManifest(y)
+				if err != nil {
+					t.Fatal(err)
+				}
+				count += len(objs)
+			}
+			if count != len(lines) {
+				t.Errorf("got %d objects in output files, want %d from index", count, len(lines))
+			}
+			entries, err := ioutil.ReadDir(outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(files)+1 {
+				t.Errorf("got %d files, want %d files from index and the index", len(entries), len(files)+1)
+			}
+		})
+	}
+}
+
 // TestLDFlags checks whether building mesh command with
 // -ldflags "-X istio.io/pkg/version.buildHub=myhub -X istio.io/pkg/version.buildVersion=mytag"
 // results in these values showing up in a generated manifest.
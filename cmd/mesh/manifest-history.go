@@ -0,0 +1,92 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/util"
+)
+
+type manifestHistoryArgs struct {
+	// kubeConfigPath is the path to kube config file.
+	kubeConfigPath string
+	// context is the cluster context in the kube config
+	context string
+	// namespace is the namespace install revisions are stored in.
+	namespace string
+}
+
+func addManifestHistoryFlags(cmd *cobra.Command, args *manifestHistoryArgs) {
+	cmd.PersistentFlags().StringVarP(&args.kubeConfigPath, "kubeconfig", "c", "", "Path to kube config")
+	cmd.PersistentFlags().StringVar(&args.context, "context", "", "The name of the kubeconfig context to use")
+	cmd.PersistentFlags().StringVarP(&args.namespace, "namespace", "n", manifest.DefaultHistoryNamespace,
+		"The namespace install revisions are stored in, which is the Istio root namespace")
+}
+
+func manifestHistoryCmd(rootArgs *rootArgs, mhArgs *manifestHistoryArgs) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history",
+		Short: "Lists the install revisions recorded in the cluster.",
+		Long:  "The history subcommand lists the install revisions recorded by manifest apply, upgrade and rollback.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			return manifestHistory(rootArgs, mhArgs, l)
+		}}
+}
+
+func manifestHistory(args *rootArgs, mhArgs *manifestHistoryArgs, l *Logger) error {
+	initLogsOrExit(args)
+
+	if err := manifest.InitK8SRestClient(mhArgs.kubeConfigPath, mhArgs.context); err != nil {
+		return err
+	}
+	revs, err := manifest.ListInstallRevisions(mhArgs.namespace)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		l.logAndPrintf("No install revisions found in namespace %s.", mhArgs.namespace)
+		return nil
+	}
+	l.logAndPrint(installRevisionsTable(revs))
+	return nil
+}
+
+// installRevisionsTable returns a table of revs in human readable form.
+func installRevisionsTable(revs []*manifest.InstallRevision) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tUPDATED\tVERSION\tPROFILE\tDESCRIPTION")
+	for _, rev := range revs {
+		profile := ""
+		iops := &v1alpha1.IstioOperatorSpec{}
+		if err := util.UnmarshalWithJSONPB(rev.Spec, iops); err == nil {
+			profile = iops.Profile
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", rev.Revision, rev.Timestamp.Format(time.RFC3339), rev.OperatorVersion,
+			profile, rev.Description)
+	}
+	_ = w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/operator/pkg/image"
+)
+
+const (
+	imagesOutputList = "list"
+	imagesOutputJSON = "json"
+
+	rewriteRegistryFlagHelpStr = "Rewrite the registry of all image references in the rendered manifests, in from=to " +
+		"form, e.g. docker.io/istio=registry.local/istio. Can be repeated"
+)
+
+type manifestImagesArgs struct {
+	// inFilename is the path to the input IstioOperator CR.
+	inFilename string
+	// set is a string with element format "path=value" where path is an IstioOperator path and the value is a
+	// value to set the node at that path to.
+	set []string
+	// force proceeds even if there are validation errors
+	force bool
+	// output is the output format, list or json.
+	output string
+	// rewriteRegistry are registry rewrites in from=to form.
+	rewriteRegistry []string
+}
+
+func addManifestImagesFlags(cmd *cobra.Command, args *manifestImagesArgs) {
+	cmd.PersistentFlags().StringVarP(&args.inFilename, "filename", "f", "", filenameFlagHelpStr)
+	cmd.PersistentFlags().StringSliceVarP(&args.set, "set", "s", nil, SetFlagHelpStr)
+	cmd.PersistentFlags().BoolVar(&args.force, "force", false, "Proceed even with validation errors")
+	cmd.PersistentFlags().StringVarP(&args.output, "output", "o", imagesOutputList,
+		fmt.Sprintf("Output format, %s prints one image per line and %s prints each image with the objects using it",
+			imagesOutputList, imagesOutputJSON))
+	cmd.PersistentFlags().StringArrayVar(&args.rewriteRegistry, "rewrite-registry", nil, rewriteRegistryFlagHelpStr+
+		". The list output prints each image followed by its rewritten reference")
+}
+
+func manifestImagesCmd(rootArgs *rootArgs, miArgs *manifestImagesArgs) *cobra.Command {
+	return &cobra.Command{
+		Use:   "images",
+		Short: "Lists the container images used by an Istio install manifest",
+		Long: "The images subcommand generates an Istio install manifest and lists the container images it uses, " +
+			"including the proxy images used by the sidecar injector, e.g. to mirror them for an air-gapped install.",
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			return manifestImages(rootArgs, miArgs, l)
+		}}
+}
+
+func manifestImages(args *rootArgs, miArgs *manifestImagesArgs, l *Logger) error {
+	if err := configLogs(args.logToStdErr); err != nil {
+		return fmt.Errorf("could not configure logs: %s", err)
+	}
+	if miArgs.output != imagesOutputList && miArgs.output != imagesOutputJSON {
+		return fmt.Errorf("unknown output format %q, must be one of %s, %s", miArgs.output, imagesOutputList,
+			imagesOutputJSON)
+	}
+	rewrites, err := image.ParseRewrites(miArgs.rewriteRegistry)
+	if err != nil {
+		return err
+	}
+
+	overlayFromSet, err := MakeTreeFromSetList(miArgs.set, miArgs.force, l)
+	if err != nil {
+		return err
+	}
+	manifests, _, err := GenManifests(miArgs.inFilename, overlayFromSet, miArgs.force, l)
+	if err != nil {
+		return err
+	}
+	images, err := image.List(manifests, rewrites)
+	if err != nil {
+		return err
+	}
+
+	if miArgs.output == imagesOutputJSON {
+		j, err := json.MarshalIndent(images, "", "  ")
+		if err != nil {
+			return err
+		}
+		l.print(string(j) + "\n")
+		return nil
+	}
+	var sb strings.Builder
+	for _, img := range images {
+		sb.WriteString(img.Image)
+		if img.Rewritten != "" {
+			sb.WriteString(" " + img.Rewritten)
+		}
+		sb.WriteString("\n")
+	}
+	l.print(sb.String())
+	return nil
+}
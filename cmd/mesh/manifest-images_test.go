@@ -0,0 +1,104 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/operator/pkg/image"
+)
+
+func TestManifestImages(t *testing.T) {
+	out, err := runCommand("manifest images -s hub=docker.io/istio -s tag=1.5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"docker.io/istio/pilot:1.5.0\n", "docker.io/istio/proxyv2:1.5.0\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not list %q:\n%s", want, out)
+		}
+	}
+
+	out, err = runCommand("manifest images -s hub=docker.io/istio -s tag=1.5.0 -o json " +
+		"--rewrite-registry docker.io/istio=registry.local/istio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images []*image.Image
+	if err := json.Unmarshal([]byte(out[strings.Index(out, "["):]), &images); err != nil {
+		t.Fatalf("%s:\n%s", err, out)
+	}
+	found := false
+	for _, img := range images {
+		if img.Image == "docker.io/istio/pilot:1.5.0" {
+			found = true
+			if img.Rewritten != "registry.local/istio/pilot:1.5.0" || len(img.Sources) == 0 {
+				t.Errorf("got %v", img)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("pilot image not listed:\n%s", out)
+	}
+
+	out, err = runManifestGenerate("", "-s hub=docker.io/istio --rewrite-registry docker.io/istio=registry.local/istio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "docker.io/istio") || !strings.Contains(out, "registry.local/istio/pilot:") {
+		t.Error("manifest generate did not rewrite all image references")
+	}
+}
+
+func TestManifestGenerateImageLock(t *testing.T) {
+	dir := createTempDirOrFail(t, "image-lock")
+	defer removeDirOrFail(t, dir)
+	lockPath := filepath.Join(dir, "images.lock")
+
+	out, err := runCommand("manifest images -s hub=docker.io/istio -s tag=1.5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock strings.Builder
+	for _, img := range strings.Fields(out) {
+		if strings.Contains(img, "/pilot:") {
+			continue
+		}
+		lock.WriteString(img + ": sha256:" + strings.Repeat("0", 64) + "\n")
+	}
+	if err := ioutil.WriteFile(lockPath, []byte(lock.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = runManifestGenerate("", "-s hub=docker.io/istio -s tag=1.5.0 --image-lock "+lockPath)
+	if err == nil || !strings.Contains(err.Error(), "no image lock entry for docker.io/istio/pilot:1.5.0") {
+		t.Fatalf("got error %v, want missing lock entry for pilot", err)
+	}
+
+	lock.WriteString("docker.io/istio/pilot:1.5.0: sha256:" + strings.Repeat("1", 64) + "\n")
+	if err := ioutil.WriteFile(lockPath, []byte(lock.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = runManifestGenerate("", "-s hub=docker.io/istio -s tag=1.5.0 --image-lock "+lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "image: docker.io/istio/pilot:1.5.0@sha256:"+strings.Repeat("1", 64)) {
+		t.Error("pilot image not pinned")
+	}
+}
@@ -96,16 +96,26 @@ func translateFunc(values []byte, l *Logger) error {
 		return fmt.Errorf("error translating values.yaml: %s", err)
 	}
 
-	isCP := &iopv1alpha1.IstioOperator{Spec: translatedIOPS, Kind: "IstioOperator", ApiVersion: "install.istio.io/v1alpha1"}
-
 	ms := jsonpb.Marshaler{}
-	gotString, err := ms.MarshalToString(isCP)
+	spec, err := ms.MarshalToString(translatedIOPS)
 	l.logAndPrint("there is a known issue about the proto tag above, check https://github.com/istio/istio/issues/19735 for more details.\n\n")
 	if err != nil {
 		return fmt.Errorf("error marshaling translated IstioOperator: %s", err)
 	}
+	// Only the spec is a proto message, so it is marshaled with jsonpb and the rest of the resource with json.
+	isCP := struct {
+		*iopv1alpha1.IstioOperator
+		Spec json.RawMessage `json:"spec"`
+	}{
+		IstioOperator: &iopv1alpha1.IstioOperator{Kind: "IstioOperator", ApiVersion: "install.istio.io/v1alpha1"},
+		Spec:          json.RawMessage(spec),
+	}
+	gotString, err := json.Marshal(isCP)
+	if err != nil {
+		return fmt.Errorf("error marshaling translated IstioOperator: %s", err)
+	}
 
-	isCPYaml, err := yaml.JSONToYAML([]byte(gotString))
+	isCPYaml, err := yaml.JSONToYAML(gotString)
 	if err != nil {
 		return fmt.Errorf("error converting JSON: %s\n%s", gotString, err)
 	}
@@ -0,0 +1,117 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/util"
+	pkgversion "istio.io/operator/pkg/version"
+	"istio.io/operator/version"
+)
+
+type manifestRollbackArgs struct {
+	manifestHistoryArgs
+	// revision is the install revision to roll back to.
+	revision int
+	// readinessTimeout is maximum time to wait for all Istio resources to be ready.
+	readinessTimeout time.Duration
+	// wait is flag that indicates whether to wait resources ready before exiting.
+	wait bool
+	// skipConfirmation determines whether the user is prompted for confirmation.
+	// If set to true, the user is not prompted and a Yes response is assumed in all cases.
+	skipConfirmation bool
+}
+
+func addManifestRollbackFlags(cmd *cobra.Command, args *manifestRollbackArgs) {
+	addManifestHistoryFlags(cmd, &args.manifestHistoryArgs)
+	cmd.PersistentFlags().IntVar(&args.revision, "revision", 0, "The install revision to roll back to, as listed by manifest history")
+	cmd.PersistentFlags().BoolVar(&args.skipConfirmation, "skip-confirmation", false, skipConfirmationFlagHelpStr)
+	cmd.PersistentFlags().DurationVar(&args.readinessTimeout, "readiness-timeout", 300*time.Second, "Maximum seconds to wait for the resources of each component to be ready."+
+		" It can be overridden for a component with components.<component>.spec.readinessTimeout in the IstioOperator spec."+
+		" The --wait flag must be set for this flag to apply")
+	cmd.PersistentFlags().BoolVarP(&args.wait, "wait", "w", false, "Wait, if set will wait until the resources of each component, such as "+
+		"Deployments, DaemonSets, StatefulSets, Jobs, CRDs, webhooks and Services, are ready before applying the components which depend on it. "+
+		"It will wait for a maximum duration of --readiness-timeout seconds per component")
+}
+
+func manifestRollbackCmd(rootArgs *rootArgs, mrArgs *manifestRollbackArgs) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback",
+		Short: "Re-applies a previously recorded install revision.",
+		Long: "The rollback subcommand re-applies the manifests of an install revision listed by manifest history. " +
+			"Components which did not exist in that revision are pruned.",
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mrArgs.revision <= 0 {
+				return fmt.Errorf("--revision must be set to a revision listed by manifest history")
+			}
+			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if !rootArgs.dryRun && !mrArgs.skipConfirmation {
+				msg := fmt.Sprintf("This will roll back the Istio installation to revision %d. Proceed? (y/N)", mrArgs.revision)
+				if !confirm(msg, cmd.OutOrStdout()) {
+					cmd.Print("Cancelled.\n")
+					os.Exit(1)
+				}
+			}
+			return manifestRollback(rootArgs, mrArgs, l)
+		}}
+}
+
+func manifestRollback(args *rootArgs, mrArgs *manifestRollbackArgs, l *Logger) error {
+	initLogsOrExit(args)
+
+	if err := manifest.InitK8SRestClient(mrArgs.kubeConfigPath, mrArgs.context); err != nil {
+		return err
+	}
+	rev, err := manifest.GetInstallRevision(mrArgs.namespace, mrArgs.revision)
+	if err != nil {
+		return err
+	}
+	iops := &v1alpha1.IstioOperatorSpec{}
+	if err := util.UnmarshalWithJSONPB(rev.Spec, iops); err != nil {
+		return fmt.Errorf("could not parse IstioOperatorSpec of install revision %d: %s", rev.Revision, err)
+	}
+	ver := version.OperatorBinaryVersion
+	if v, err := pkgversion.NewVersionFromString(rev.OperatorVersion); err == nil {
+		ver = *v
+	} else {
+		l.logAndPrintf("Warning: could not parse version %q of install revision %d, using %s: %s",
+			rev.OperatorVersion, rev.Revision, ver.String(), err)
+	}
+
+	l.logAndPrintf("Rolling back to install revision %d (version %s).", rev.Revision, rev.OperatorVersion)
+	opts := &manifest.InstallOptions{
+		DryRun:      args.dryRun,
+		Verbose:     args.verbose,
+		Wait:        mrArgs.wait,
+		WaitTimeout: mrArgs.readinessTimeout,
+		Kubeconfig:  mrArgs.kubeConfigPath,
+		Context:     mrArgs.context,
+	}
+	if _, err := applyManifests(manifest.WithMissingComponents(rev.Manifests), iops, ver, opts, l); err != nil {
+		return fmt.Errorf("failed to roll back to install revision %d: %v", rev.Revision, err)
+	}
+	if !args.dryRun {
+		recordInstallRevision(rev.Manifests, iops, ver, fmt.Sprintf("Rollback to %d", rev.Revision), l)
+	}
+	return nil
+}
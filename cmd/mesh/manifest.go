@@ -23,7 +23,7 @@ func ManifestCmd() *cobra.Command {
 	mc := &cobra.Command{
 		Use:   "manifest",
 		Short: "Commands related to Istio manifests",
-		Long:  "The manifest subcommand generates, applies, diffs or migrates Istio manifests.",
+		Long:  "The manifest subcommand generates, applies, diffs, migrates or rolls back Istio manifests, or lists their images.",
 	}
 
 	mgcArgs := &manifestGenerateArgs{}
@@ -31,6 +31,9 @@ func ManifestCmd() *cobra.Command {
 	macArgs := &manifestApplyArgs{}
 	mvArgs := &manifestVersionsArgs{}
 	mmcArgs := &manifestMigrateArgs{}
+	mhcArgs := &manifestHistoryArgs{}
+	mrcArgs := &manifestRollbackArgs{}
+	micArgs := &manifestImagesArgs{}
 
 	args := &rootArgs{}
 
@@ -39,6 +42,9 @@ func ManifestCmd() *cobra.Command {
 	mac := manifestApplyCmd(args, macArgs)
 	mvc := manifestVersionsCmd(args, mvArgs)
 	mmc := manifestMigrateCmd(args, mmcArgs)
+	mhc := manifestHistoryCmd(args, mhcArgs)
+	mrc := manifestRollbackCmd(args, mrcArgs)
+	mic := manifestImagesCmd(args, micArgs)
 
 	addFlags(mc, args)
 	addFlags(mgc, args)
@@ -46,18 +52,27 @@ func ManifestCmd() *cobra.Command {
 	addFlags(mac, args)
 	addFlags(mvc, args)
 	addFlags(mmc, args)
+	addFlags(mhc, args)
+	addFlags(mrc, args)
+	addFlags(mic, args)
 
 	addManifestGenerateFlags(mgc, mgcArgs)
 	addManifestDiffFlags(mdc, mdcArgs)
 	addManifestApplyFlags(mac, macArgs)
 	addManifestVersionsFlags(mvc, mvArgs)
 	addManifestMigrateFlags(mmc, mmcArgs)
+	addManifestHistoryFlags(mhc, mhcArgs)
+	addManifestRollbackFlags(mrc, mrcArgs)
+	addManifestImagesFlags(mic, micArgs)
 
 	mc.AddCommand(mgc)
 	mc.AddCommand(mdc)
 	mc.AddCommand(mac)
 	mc.AddCommand(mmc)
 	mc.AddCommand(mvc)
+	mc.AddCommand(mhc)
+	mc.AddCommand(mrc)
+	mc.AddCommand(mic)
 
 	return mc
 }
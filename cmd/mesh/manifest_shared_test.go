@@ -21,6 +21,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/manifest"
 )
 
 var (
@@ -51,6 +56,16 @@ func runCommand(command string) (string, error) {
 	return out.String(), nil
 }
 
+// useFakeCluster makes all commands run against a fake cluster holding objects, whatever the kubeconfig and
+// context. Callers must defer manifest.SetBackendFactory(nil).
+func useFakeCluster(objects ...*unstructured.Unstructured) *cluster.Fake {
+	f := cluster.NewFake(objects...)
+	manifest.SetBackendFactory(func(string, string) (cluster.Backend, error) {
+		return f, nil
+	})
+	return f
+}
+
 func syncCharts() error {
 	cmd := exec.Command(filepath.Join(repoRootDir, "scripts/run_update_charts.sh"))
 	return cmd.Run()
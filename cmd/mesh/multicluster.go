@@ -0,0 +1,309 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/operator/pkg/manifest"
+)
+
+const (
+	// clusterOrderPrimaryFirst applies the primary cluster first, then all remote clusters in parallel. Remote
+	// clusters are not applied if the primary cluster fails.
+	clusterOrderPrimaryFirst = "primary-first"
+	// clusterOrderParallel applies all clusters in parallel.
+	clusterOrderParallel = "parallel"
+)
+
+type multiClusterArgs struct {
+	// clusters is a list of kubeconfig contexts to apply to, each optionally followed by =<path> to an IstioOperator
+	// CR overlaid for that cluster only.
+	clusters []string
+	// primary is the context of the primary cluster. It defaults to the first cluster.
+	primary string
+	// order is the order clusters are applied in, one of clusterOrderPrimaryFirst or clusterOrderParallel.
+	order string
+}
+
+func addMultiClusterFlags(cmd *cobra.Command, args *multiClusterArgs) {
+	cmd.PersistentFlags().StringArrayVar(&args.clusters, "cluster", nil,
+		"Apply to the cluster of the given kubeconfig context, in the format context[=path]. If path is set, the "+
+			"IstioOperator CR at path is overlaid on --filename for this cluster only, and --set is overlaid on it. "+
+			"Can be repeated to apply to several clusters. Cannot be used with --context")
+	cmd.PersistentFlags().StringVar(&args.primary, "primary", "",
+		"The context of the primary cluster, which must be one of the --cluster contexts. Defaults to the first --cluster")
+	cmd.PersistentFlags().StringVar(&args.order, "cluster-order", clusterOrderPrimaryFirst,
+		"The order to apply the --cluster clusters in, one of primary-first|parallel. With primary-first, the primary "+
+			"cluster is applied first and the remote clusters are only applied, in parallel, if it succeeds")
+}
+
+// clusterTarget is a cluster a multi-cluster command applies to.
+type clusterTarget struct {
+	// context is the kubeconfig context of the cluster.
+	context string
+	// overlayFile is the path to an IstioOperator CR overlaid for this cluster only. It may be empty.
+	overlayFile string
+	// primary is set for the primary cluster.
+	primary bool
+}
+
+// targets returns the clusters given with --cluster, or nil if the flag is not set. context is the value of the
+// --context flag, which cannot be combined with --cluster.
+func (a *multiClusterArgs) targets(context string) ([]clusterTarget, error) {
+	if len(a.clusters) == 0 {
+		if a.primary != "" {
+			return nil, fmt.Errorf("--primary can only be used with --cluster")
+		}
+		return nil, nil
+	}
+	if context != "" {
+		return nil, fmt.Errorf("--context cannot be used with --cluster")
+	}
+	switch a.order {
+	case clusterOrderPrimaryFirst, clusterOrderParallel:
+	default:
+		return nil, fmt.Errorf("unknown cluster order %q, must be one of %s|%s", a.order, clusterOrderPrimaryFirst,
+			clusterOrderParallel)
+	}
+	primary := a.primary
+	seen := make(map[string]bool)
+	var out []clusterTarget
+	for _, c := range a.clusters {
+		kv := strings.SplitN(c, "=", 2)
+		t := clusterTarget{context: strings.TrimSpace(kv[0])}
+		if len(kv) == 2 {
+			t.overlayFile = strings.TrimSpace(kv[1])
+		}
+		if t.context == "" {
+			return nil, fmt.Errorf("bad argument %q for --cluster: expect format context[=path]", c)
+		}
+		if seen[t.context] {
+			return nil, fmt.Errorf("cluster %s is given more than once", t.context)
+		}
+		seen[t.context] = true
+		if primary == "" {
+			primary = t.context
+		}
+		t.primary = t.context == primary
+		out = append(out, t)
+	}
+	if !seen[primary] {
+		return nil, fmt.Errorf("primary cluster %s is not one of the --cluster contexts", primary)
+	}
+	return out, nil
+}
+
+// targetContexts returns the contexts of targets as a comma separated list.
+func targetContexts(targets []clusterTarget) string {
+	var out []string
+	for _, t := range targets {
+		out = append(out, t.context)
+	}
+	return strings.Join(out, ", ")
+}
+
+// readClusterOverlay reads the IstioOperator CR at path and returns its spec in YAML format, so that it can be
+// overlaid like a --set tree. It returns an empty string if path is empty.
+func readClusterOverlay(path string, force bool) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read cluster overlay file %s: %s", path, err)
+	}
+	_, overlay, err := unmarshalAndValidateIOP(string(b), force)
+	if err != nil {
+		return "", fmt.Errorf("bad cluster overlay file %s: %s", path, err)
+	}
+	return overlay, nil
+}
+
+// clusterApplyFunc applies to a single cluster, printing all output with l.
+type clusterApplyFunc func(t clusterTarget, l *Logger) (*manifest.ApplyReport, error)
+
+// applyToClusters calls apply for each of targets in the given order and prints a summary of the outcome for each
+// cluster. The output of each cluster is printed with the cluster context as prefix on every line. The returned error
+// is non-nil if any cluster failed.
+func applyToClusters(targets []clusterTarget, order string, l *Logger, apply clusterApplyFunc) (*manifest.MultiClusterApplyReport, error) {
+	start := time.Now()
+	report := &manifest.MultiClusterApplyReport{Status: manifest.ApplySucceeded}
+	var mu sync.Mutex
+	run := func(t clusterTarget) *manifest.ClusterApplyReport {
+		cl, flush := newClusterLogger(l, t.context, &mu)
+		defer flush()
+		cstart := time.Now()
+		r, err := apply(t, cl)
+		cr := &manifest.ClusterApplyReport{
+			Context:  t.context,
+			Primary:  t.primary,
+			Status:   manifest.ApplySucceeded,
+			Duration: time.Since(cstart).Round(time.Millisecond).String(),
+			Report:   r,
+		}
+		if err != nil {
+			cr.Status = manifest.ApplyFailed
+			cr.Error = err.Error()
+			cl.logAndError("Error: ", err)
+		}
+		return cr
+	}
+
+	results := make([]*manifest.ClusterApplyReport, len(targets))
+	remaining := make([]int, 0, len(targets))
+	for i, t := range targets {
+		if order == clusterOrderPrimaryFirst && t.primary {
+			l.logAndPrintf("Applying to primary cluster %s.", t.context)
+			results[i] = run(t)
+			continue
+		}
+		remaining = append(remaining, i)
+	}
+	var primaryErr *manifest.ClusterApplyReport
+	for _, r := range results {
+		if r != nil && r.Status == manifest.ApplyFailed {
+			primaryErr = r
+		}
+	}
+
+	if primaryErr == nil && len(remaining) != 0 {
+		l.logAndPrintf("Applying to %d clusters in parallel.", len(remaining))
+	}
+	var wg sync.WaitGroup
+	for _, i := range remaining {
+		t := targets[i]
+		if primaryErr != nil {
+			results[i] = &manifest.ClusterApplyReport{
+				Context: t.context,
+				Status:  manifest.ApplySkipped,
+				Error:   fmt.Sprintf("not applied because primary cluster %s failed", primaryErr.Context),
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, t clusterTarget) {
+			defer wg.Done()
+			results[i] = run(t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, r := range results {
+		if r.Status != manifest.ApplySucceeded {
+			report.Status = manifest.ApplyFailed
+			failed = append(failed, r.Context)
+		}
+	}
+	report.Clusters = results
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	l.logAndPrint("\n" + clusterSummary(report))
+	if len(failed) != 0 {
+		return report, fmt.Errorf("failed to apply to clusters %s", strings.Join(failed, ", "))
+	}
+	return report, nil
+}
+
+// clusterSummary returns a table with the outcome for each cluster in report.
+func clusterSummary(report *manifest.MultiClusterApplyReport) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tROLE\tSTATUS\tDURATION\tERROR")
+	for _, c := range report.Clusters {
+		role := "remote"
+		if c.Primary {
+			role = "primary"
+		}
+		duration := c.Duration
+		if duration == "" {
+			duration = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Context, role, c.Status, duration, firstLine(c.Error))
+	}
+	_ = w.Flush()
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func firstLine(s string) string {
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// newClusterLogger returns a Logger printing through l with the given cluster context as prefix on every line. mu
+// serializes the lines written by all cluster loggers. The returned function prints any incomplete last line and
+// must be called once the cluster is done.
+func newClusterLogger(l *Logger, context string, mu *sync.Mutex) (*Logger, func()) {
+	prefix := "[" + context + "] "
+	stdOut := &prefixWriter{mu: mu, out: l.stdOut, prefix: prefix}
+	stdErr := &prefixWriter{mu: mu, out: l.stdErr, prefix: prefix}
+	cl := NewLogger(l.logToStdErr, stdOut, stdErr)
+	cl.progressOut = stdOut
+	return cl, func() {
+		stdOut.flush()
+		stdErr.flush()
+	}
+}
+
+// prefixWriter writes complete lines to out, each starting with prefix.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+// Write implements io.Writer. Incomplete lines are buffered until they are completed or flush is called.
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLocked(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (w *prefixWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) != 0 {
+		_ = w.writeLocked(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLocked(line []byte) error {
+	_, err := io.WriteString(w.out, w.prefix+string(line))
+	return err
+}
@@ -0,0 +1,201 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"istio.io/operator/pkg/manifest"
+)
+
+func TestMultiClusterTargets(t *testing.T) {
+	tests := []struct {
+		desc    string
+		args    multiClusterArgs
+		context string
+		want    []clusterTarget
+		wantErr string
+	}{
+		{
+			desc: "no clusters",
+		},
+		{
+			desc: "first cluster is primary",
+			args: multiClusterArgs{clusters: []string{"east=east.yaml", "west"}, order: clusterOrderPrimaryFirst},
+			want: []clusterTarget{
+				{context: "east", overlayFile: "east.yaml", primary: true},
+				{context: "west"},
+			},
+		},
+		{
+			desc: "explicit primary",
+			args: multiClusterArgs{clusters: []string{"east", "west=west.yaml"}, primary: "west", order: clusterOrderParallel},
+			want: []clusterTarget{
+				{context: "east"},
+				{context: "west", overlayFile: "west.yaml", primary: true},
+			},
+		},
+		{
+			desc:    "context with clusters",
+			args:    multiClusterArgs{clusters: []string{"east"}, order: clusterOrderPrimaryFirst},
+			context: "east",
+			wantErr: "--context cannot be used with --cluster",
+		},
+		{
+			desc:    "unknown primary",
+			args:    multiClusterArgs{clusters: []string{"east"}, primary: "west", order: clusterOrderPrimaryFirst},
+			wantErr: "primary cluster west is not one of the --cluster contexts",
+		},
+		{
+			desc:    "duplicate cluster",
+			args:    multiClusterArgs{clusters: []string{"east", "east=east.yaml"}, order: clusterOrderPrimaryFirst},
+			wantErr: "cluster east is given more than once",
+		},
+		{
+			desc:    "bad order",
+			args:    multiClusterArgs{clusters: []string{"east"}, order: "random"},
+			wantErr: `unknown cluster order "random"`,
+		},
+		{
+			desc:    "primary without clusters",
+			args:    multiClusterArgs{primary: "east"},
+			wantErr: "--primary can only be used with --cluster",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := tt.args.targets(tt.context)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyToClusters(t *testing.T) {
+	targets := []clusterTarget{{context: "east", primary: true}, {context: "west"}, {context: "north"}}
+	tests := []struct {
+		desc       string
+		order      string
+		fail       map[string]bool
+		wantStatus map[string]manifest.ApplyStatus
+		wantCalled []string
+		wantErr    bool
+	}{
+		{
+			desc:       "all succeed",
+			order:      clusterOrderPrimaryFirst,
+			wantStatus: map[string]manifest.ApplyStatus{"east": manifest.ApplySucceeded, "west": manifest.ApplySucceeded, "north": manifest.ApplySucceeded},
+			wantCalled: []string{"east", "north", "west"},
+		},
+		{
+			desc:       "primary fails",
+			order:      clusterOrderPrimaryFirst,
+			fail:       map[string]bool{"east": true},
+			wantStatus: map[string]manifest.ApplyStatus{"east": manifest.ApplyFailed, "west": manifest.ApplySkipped, "north": manifest.ApplySkipped},
+			wantCalled: []string{"east"},
+			wantErr:    true,
+		},
+		{
+			desc:       "remote fails",
+			order:      clusterOrderPrimaryFirst,
+			fail:       map[string]bool{"west": true},
+			wantStatus: map[string]manifest.ApplyStatus{"east": manifest.ApplySucceeded, "west": manifest.ApplyFailed, "north": manifest.ApplySucceeded},
+			wantCalled: []string{"east", "north", "west"},
+			wantErr:    true,
+		},
+		{
+			desc:       "parallel does not abort remotes",
+			order:      clusterOrderParallel,
+			fail:       map[string]bool{"east": true},
+			wantStatus: map[string]manifest.ApplyStatus{"east": manifest.ApplyFailed, "west": manifest.ApplySucceeded, "north": manifest.ApplySucceeded},
+			wantCalled: []string{"east", "north", "west"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var mu sync.Mutex
+			var called []string
+			var out bytes.Buffer
+			l := NewLogger(false, &out, &out)
+			report, err := applyToClusters(targets, tt.order, l, func(ct clusterTarget, cl *Logger) (*manifest.ApplyReport, error) {
+				mu.Lock()
+				called = append(called, ct.context)
+				mu.Unlock()
+				cl.logAndPrint("applying")
+				if tt.fail[ct.context] {
+					return nil, fmt.Errorf("boom")
+				}
+				return &manifest.ApplyReport{Status: manifest.ApplySucceeded}, nil
+			})
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			for c, want := range tt.wantStatus {
+				if got := report.Cluster(c); got == nil || got.Status != want {
+					t.Errorf("cluster %s: got %+v, want status %s", c, got, want)
+				}
+			}
+			if report.Cluster("east").Primary != true {
+				t.Errorf("east is not reported as primary")
+			}
+			sort.Strings(called)
+			if !reflect.DeepEqual(called, tt.wantCalled) {
+				t.Errorf("got clusters applied %v, want %v", called, tt.wantCalled)
+			}
+			for _, c := range tt.wantCalled {
+				if !strings.Contains(out.String(), "["+c+"] applying\n") {
+					t.Errorf("output of cluster %s is not prefixed:\n%s", c, out.String())
+				}
+			}
+			if !strings.Contains(out.String(), "CLUSTER") {
+				t.Errorf("no cluster summary in output:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &prefixWriter{mu: &sync.Mutex{}, out: &out, prefix: "[east] "}
+	for _, s := range []string{"one\ntw", "o\n", "three"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := out.String(), "[east] one\n[east] two\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	w.flush()
+	if got, want := out.String(), "[east] one\n[east] two\n[east] three\n"; got != want {
+		t.Errorf("after flush got %q, want %q", got, want)
+	}
+}
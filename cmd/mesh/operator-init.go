@@ -25,7 +25,6 @@ import (
 	"k8s.io/utils/pointer"
 
 	"istio.io/operator/pkg/helm"
-	"istio.io/operator/pkg/kubectlcmd"
 	"istio.io/operator/pkg/manifest"
 	"istio.io/operator/pkg/name"
 	"istio.io/operator/pkg/object"
@@ -55,6 +54,8 @@ type operatorInitArgs struct {
 	readinessTimeout time.Duration
 	// wait is flag that indicates whether to wait resources ready before exiting.
 	wait bool
+	// output is the format of the apply report printed to stdout. No report is printed if empty.
+	output string
 }
 
 const (
@@ -63,13 +64,6 @@ const (
 	istioOperatorCRComponentName = "OperatorCustomResource"
 )
 
-// manifestApplier is used for test dependency injection.
-type manifestApplier func(manifestStr, componentName string, opts *kubectlcmd.Options, verbose bool, l *Logger) bool
-
-var (
-	defaultManifestApplier = applyManifest
-)
-
 func addOperatorInitFlags(cmd *cobra.Command, args *operatorInitArgs) {
 	hub, tag := buildversion.DockerInfo.Hub, buildversion.DockerInfo.Tag
 	if hub == "" {
@@ -101,13 +95,19 @@ func operatorInitCmd(rootArgs *rootArgs, oiArgs *operatorInitArgs) *cobra.Comman
 		Long:  "The init subcommand installs the Istio operator controller in the cluster.",
 		Args:  cobra.ExactArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
-			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.OutOrStderr())
-			operatorInit(rootArgs, oiArgs, l, defaultManifestApplier)
+			if err := validateApplyReportFormat(oiArgs.output); err != nil {
+				NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.OutOrStderr()).logAndFatal(err)
+			}
+			l := applyReportLogger(rootArgs, oiArgs.output, cmd)
+			report := operatorInit(rootArgs, oiArgs, l)
+			if err := writeApplyReport(report, oiArgs.output, cmd.OutOrStdout()); err != nil {
+				l.logAndFatal(err)
+			}
 		}}
 }
 
-// operatorInit installs the Istio operator controller into the cluster.
-func operatorInit(args *rootArgs, oiArgs *operatorInitArgs, l *Logger, apply manifestApplier) {
+// operatorInit installs the Istio operator controller into the cluster and returns a report of the install.
+func operatorInit(args *rootArgs, oiArgs *operatorInitArgs, l *Logger) *manifest.ApplyReport {
 	initLogsOrExit(args)
 
 	// Error here likely indicates Deployment is missing. If some other K8s error, we will hit it again later.
@@ -131,60 +131,61 @@ func operatorInit(args *rootArgs, oiArgs *operatorInitArgs, l *Logger, apply man
 		l.logAndFatal(err)
 	}
 
-	opts := &kubectlcmd.Options{
+	opts := &manifest.InstallOptions{
 		DryRun:      args.dryRun,
 		Verbose:     args.verbose,
 		WaitTimeout: 1 * time.Minute,
 		Kubeconfig:  oiArgs.kubeConfigPath,
 		Context:     oiArgs.context,
+		// The operator namespace is created by the controller component, which is applied first.
+		InventoryNamespace: oiArgs.operatorNamespace,
 	}
 
 	if err := manifest.InitK8SRestClient(opts.Kubeconfig, opts.Context); err != nil {
 		l.logAndFatal(err)
 	}
 
-	success := apply(mstr, istioControllerComponentName, opts, args.verbose, l)
+	start := time.Now()
+	out := manifest.CompositeOutput{}
+	applyComponent := func(manifestStr, componentName string) bool {
+		co := applyManifest(manifestStr, componentName, opts, args.verbose, l)
+		out[name.ComponentName(componentName)] = co
+		return co.Err == nil
+	}
+	success := applyComponent(mstr, istioControllerComponentName)
 
 	if customResource != "" {
-		success = success && apply(genNamespaceResource(istioNamespace), istioNamespaceComponentName, opts, args.verbose, l)
-		success = success && apply(customResource, istioOperatorCRComponentName, opts, args.verbose, l)
+		success = success && applyComponent(genNamespaceResource(istioNamespace), istioNamespaceComponentName)
+		success = success && applyComponent(customResource, istioOperatorCRComponentName)
 	}
+	report := manifest.NewApplyReport(out, nil, time.Since(start))
 
 	if !success {
 		l.logAndPrint("\n*** Errors were logged during apply operation. Please check component installation logs above. ***\n")
-		return
+		return report
 	}
 
 	l.logAndPrint("\n*** Success. ***\n")
+	return report
 }
 
-func applyManifest(manifestStr, componentName string, opts *kubectlcmd.Options, verbose bool, l *Logger) bool {
+func applyManifest(manifestStr, componentName string, opts *manifest.InstallOptions, verbose bool, l *Logger) *manifest.ComponentApplyOutput {
 	l.logAndPrint("")
 	// Specifically don't prune operator installation since it leads to a lot of resources being reapplied.
 	opts.Prune = pointer.BoolPtr(false)
 	out, objs := manifest.ApplyManifest(name.ComponentName(componentName), manifestStr, version.OperatorBinaryVersion.String(), *opts)
 
-	success := true
 	if out.Err != nil {
 		cs := fmt.Sprintf("Component %s install returned the following errors:", componentName)
 		l.logAndPrintf("\n%s\n%s", cs, strings.Repeat("=", len(cs)))
 		l.logAndPrint("Error: ", out.Err, "\n")
-		success = false
 	} else {
 		l.logAndPrintf("Component %s installed successfully.", componentName)
 		if opts.Verbose {
 			l.logAndPrintf("The following objects were installed:\n%s", k8sObjectsString(objs))
 		}
 	}
-
-	if !ignoreError(out.Stderr) {
-		l.logAndPrint("Error detail:\n", out.Stderr, "\n")
-		success = false
-	}
-	if !ignoreError(out.Stderr) {
-		l.logAndPrint(out.Stdout, "\n")
-	}
-	return success
+	return out
 }
 
 func getCRAndNamespaceFromFile(filePath string, l *Logger) (customResource string, istioNamespace string, err error) {
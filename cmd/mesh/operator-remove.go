@@ -21,7 +21,6 @@ import (
 
 	"github.com/spf13/cobra"
 
-	"istio.io/operator/pkg/kubectlcmd"
 	"istio.io/operator/pkg/manifest"
 	"istio.io/operator/pkg/object"
 	"istio.io/pkg/log"
@@ -33,12 +32,6 @@ type operatorRemoveArgs struct {
 	force bool
 }
 
-type manifestDeleter func(manifestStr, componentName string, opts *kubectlcmd.Options, l *Logger) bool
-
-var (
-	defaultManifestDeleter = deleteManifest
-)
-
 func addOperatorRemoveFlags(cmd *cobra.Command, oiArgs *operatorRemoveArgs) {
 	addOperatorInitFlags(cmd, &oiArgs.operatorInitArgs)
 	cmd.PersistentFlags().BoolVar(&oiArgs.force, "force", false, "Proceed even with errors")
@@ -52,12 +45,12 @@ func operatorRemoveCmd(rootArgs *rootArgs, orArgs *operatorRemoveArgs) *cobra.Co
 		Args:  cobra.ExactArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
 			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.OutOrStderr())
-			operatorRemove(rootArgs, orArgs, l, defaultManifestDeleter)
+			operatorRemove(rootArgs, orArgs, l)
 		}}
 }
 
 // operatorRemove removes the Istio operator controller from the cluster.
-func operatorRemove(args *rootArgs, orArgs *operatorRemoveArgs, l *Logger, deleteManifestFunc manifestDeleter) {
+func operatorRemove(args *rootArgs, orArgs *operatorRemoveArgs, l *Logger) {
 	initLogsOrExit(args)
 
 	installed, err := isControllerInstalled(orArgs.kubeConfigPath, orArgs.context, orArgs.operatorNamespace)
@@ -80,7 +73,7 @@ func operatorRemove(args *rootArgs, orArgs *operatorRemoveArgs, l *Logger, delet
 
 	log.Infof("Using the following manifest to install operator:\n%s\n", mstr)
 
-	opts := &kubectlcmd.Options{
+	opts := &manifest.InstallOptions{
 		DryRun:      args.dryRun,
 		Verbose:     args.verbose,
 		WaitTimeout: 1 * time.Minute,
@@ -92,23 +85,23 @@ func operatorRemove(args *rootArgs, orArgs *operatorRemoveArgs, l *Logger, delet
 		l.logAndFatal(err)
 	}
 
-	success := deleteManifestFunc(mstr, "Operator", opts, l)
+	success := deleteManifest(mstr, "Operator", opts, l)
 	if !success {
-		l.logAndPrint("\n*** Errors were logged during deleteManifestFunc operation. Please check logs above. ***\n")
+		l.logAndPrint("\n*** Errors were logged during delete operation. Please check logs above. ***\n")
 		return
 	}
 
 	l.logAndPrint("\n*** Success. ***\n")
 }
 
-func deleteManifest(manifestStr, componentName string, opts *kubectlcmd.Options, l *Logger) bool {
+func deleteManifest(manifestStr, componentName string, opts *manifest.InstallOptions, l *Logger) bool {
 	l.logAndPrintf("Deleting manifest for component %s...", componentName)
 	objs, err := object.ParseK8sObjectsFromYAMLManifest(manifestStr)
 	if err != nil {
 		l.logAndPrint("Parse error: ", err, "\n")
 		return false
 	}
-	stdout, stderr, err := kubectlcmd.New().Delete(manifestStr, opts)
+	results, err := manifest.DeleteManifest(manifestStr, *opts)
 
 	success := true
 	if err != nil {
@@ -122,11 +115,10 @@ func deleteManifest(manifestStr, componentName string, opts *kubectlcmd.Options,
 			l.logAndPrintf("The following objects were deleted:\n%s", k8sObjectsString(objs))
 		}
 	}
-
-	if !ignoreError(stderr) {
-		l.logAndPrint("Error detail:\n", stderr, "\n")
-		l.logAndPrint(stdout, "\n")
-		success = false
+	if opts.Verbose {
+		for _, r := range results {
+			l.logAndPrint(r.String())
+		}
 	}
 	return success
 }
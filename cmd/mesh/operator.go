@@ -37,6 +37,7 @@ func OperatorCmd() *cobra.Command {
 	addFlags(orc, args)
 
 	addOperatorInitFlags(oic, oiArgs)
+	addApplyReportFlag(oic, &oiArgs.output)
 	addOperatorRemoveFlags(orc, orArgs)
 
 	oc.AddCommand(oic)
@@ -15,142 +15,161 @@
 package mesh
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
-	"time"
 
-	"github.com/kr/pretty"
+	"k8s.io/apimachinery/pkg/api/errors"
 
-	"istio.io/operator/pkg/kubectlcmd"
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/inventory"
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
 	"istio.io/operator/pkg/util"
 )
 
-// applyParams is used to capture the inputs to operatorInit applyManifest call.
-type applyParams struct {
-	manifest      string
-	componentName string
-	opts          kubectlcmd.Options
-}
-
-var (
-	applyOutput  []applyParams
-	deleteOutput = ""
-)
-
-func TestOperatorInit(t *testing.T) {
-	goldenFilepath := filepath.Join(repoRootDir, "cmd/mesh/testdata/operator/output/operator-init.yaml")
+const operatorTestNamespace = "operator-test-namespace"
 
-	rootArgs := &rootArgs{}
-	oiArgs := &operatorInitArgs{
+func testOperatorInitArgs() *operatorInitArgs {
+	return &operatorInitArgs{
 		hub:               "foo.io/istio",
 		tag:               "1.2.3",
-		operatorNamespace: "operator-test-namespace",
+		operatorNamespace: operatorTestNamespace,
 		istioNamespace:    "istio-test-namespace",
 	}
+}
 
-	operatorInit(rootArgs, oiArgs, NewLogger(rootArgs.logToStdErr, os.Stdout, os.Stderr), mockApplyManifest)
-	gotYAML := ""
-	for _, ao := range applyOutput {
-		gotYAML += ao.manifest
-	}
+func TestOperatorInit(t *testing.T) {
+	goldenFilepath := filepath.Join(repoRootDir, "cmd/mesh/testdata/operator/output/operator-init.yaml")
+	f := useFakeCluster()
+	defer manifest.SetBackendFactory(nil)
 
-	fmt.Println(gotYAML)
+	rootArgs := &rootArgs{}
+	oiArgs := testOperatorInitArgs()
+	l := NewLogger(rootArgs.logToStdErr, os.Stdout, os.Stderr)
+	gotYAML, err := renderOperatorManifest(rootArgs, oiArgs, l)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if refreshGoldenFiles() {
 		t.Logf("Refreshing golden file for %s", goldenFilepath)
 		if err := ioutil.WriteFile(goldenFilepath, []byte(gotYAML), 0644); err != nil {
 			t.Error(err)
 		}
 	}
-
 	wantYAML, err := readFile(goldenFilepath)
 	if err != nil {
 		t.Fatal(err)
 	}
-
 	if diff := util.YAMLDiff(wantYAML, gotYAML); diff != "" {
 		t.Fatalf("diff: %s", diff)
 	}
 
-	wantOpts := kubectlcmd.Options{
-		WaitTimeout: time.Minute,
-		Prune:       nil,
-		ExtraArgs:   nil,
+	checkApplyReport(t, operatorInit(rootArgs, oiArgs, l), istioControllerComponentName)
+	checkObjects(t, f, gotYAML, true)
+	inv, err := inventory.NewConfigMapStore(cluster.NewClient(f), operatorTestNamespace).Get(istioControllerComponentName)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	wantParams := []applyParams{
-		{
-			componentName: istioControllerComponentName,
-			opts:          wantOpts,
-		},
-		{
-			componentName: istioNamespaceComponentName,
-			opts:          wantOpts,
-		},
-		{
-			componentName: istioOperatorCRComponentName,
-			opts:          wantOpts,
-		},
+	if inv == nil || len(inv.Entries) == 0 {
+		t.Fatalf("got inventory %v, want the operator objects", inv)
 	}
+}
 
-	for i, ao := range applyOutput {
-		if got, want := ao.componentName, wantParams[i].componentName; got != want {
-			t.Fatalf("wrong component name: got:%s, want:%s", got, want)
-		}
-		if !reflect.DeepEqual(ao.opts, wantParams[i].opts) {
-			t.Fatalf("wrong opts: got:%v, want:%v", pretty.Sprint(ao.opts), pretty.Sprint(wantParams[i].opts))
-		}
+func TestOperatorInitWithCR(t *testing.T) {
+	f := useFakeCluster()
+	defer manifest.SetBackendFactory(nil)
+
+	dir := createTempDirOrFail(t, "operator-init")
+	defer os.RemoveAll(dir)
+	crYAML := `apiVersion: install.istio.io/v1alpha1
+kind: IstioOperator
+metadata:
+  namespace: istio-test-namespace
+  name: test-istiocontrolplane
+spec:
+  profile: minimal
+  meshConfig:
+    rootNamespace: istio-test-namespace
+`
+	crPath := filepath.Join(dir, "iop.yaml")
+	if err := ioutil.WriteFile(crPath, []byte(crYAML), 0644); err != nil {
+		t.Fatal(err)
 	}
-}
 
-func mockApplyManifest(manifestStr, componentName string, opts *kubectlcmd.Options, _ bool, _ *Logger) bool {
-	applyOutput = append(applyOutput, applyParams{
-		componentName: componentName,
-		manifest:      manifestStr,
-		opts:          *opts,
-	})
-	return true
+	rootArgs := &rootArgs{}
+	oiArgs := testOperatorInitArgs()
+	oiArgs.inFilename = crPath
+	checkApplyReport(t, operatorInit(rootArgs, oiArgs, NewLogger(rootArgs.logToStdErr, os.Stdout, os.Stderr)),
+		istioNamespaceComponentName, istioControllerComponentName, istioOperatorCRComponentName)
+	checkObjects(t, f, crYAML, true)
 }
 
 func TestOperatorRemove(t *testing.T) {
 	goldenFilepath := filepath.Join(repoRootDir, "cmd/mesh/testdata/operator/output/operator-remove.yaml")
+	f := useFakeCluster()
+	defer manifest.SetBackendFactory(nil)
 
 	rootArgs := &rootArgs{}
-	orArgs := &operatorRemoveArgs{
-		operatorInitArgs: operatorInitArgs{
-			hub:               "foo.io/istio",
-			tag:               "1.2.3",
-			operatorNamespace: "operator-test-namespace",
-			istioNamespace:    "istio-test-namespace",
-		},
-		force: true,
-	}
-
-	operatorRemove(rootArgs, orArgs, NewLogger(rootArgs.logToStdErr, os.Stdout, os.Stderr), mockDeleteManifest)
-	gotYAML := deleteOutput
+	orArgs := &operatorRemoveArgs{operatorInitArgs: *testOperatorInitArgs()}
+	l := NewLogger(rootArgs.logToStdErr, os.Stdout, os.Stderr)
+	operatorInit(rootArgs, &orArgs.operatorInitArgs, l)
 
-	fmt.Println(gotYAML)
+	gotYAML, err := renderOperatorManifest(rootArgs, &orArgs.operatorInitArgs, l)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if refreshGoldenFiles() {
 		t.Logf("Refreshing golden file for %s", goldenFilepath)
 		if err := ioutil.WriteFile(goldenFilepath, []byte(gotYAML), 0644); err != nil {
 			t.Error(err)
 		}
 	}
-
 	wantYAML, err := readFile(goldenFilepath)
 	if err != nil {
 		t.Fatal(err)
 	}
-
 	if diff := util.YAMLDiff(wantYAML, gotYAML); diff != "" {
 		t.Fatalf("diff: %s", diff)
 	}
+	checkObjects(t, f, gotYAML, true)
+
+	operatorRemove(rootArgs, orArgs, l)
+	checkObjects(t, f, gotYAML, false)
+}
+
+// checkApplyReport checks that report is successful and covers exactly components, in report order.
+func checkApplyReport(t *testing.T, report *manifest.ApplyReport, components ...name.ComponentName) {
+	t.Helper()
+	if report.Status != manifest.ApplySucceeded {
+		t.Errorf("got status %s, want %s: %v", report.Status, manifest.ApplySucceeded, report.Components)
+	}
+	var got []name.ComponentName
+	for _, c := range report.Components {
+		got = append(got, c.Component)
+	}
+	if !reflect.DeepEqual(got, components) {
+		t.Errorf("got components %v, want %v", got, components)
+	}
 }
 
-func mockDeleteManifest(manifestStr, _ string, _ *kubectlcmd.Options, _ *Logger) bool {
-	deleteOutput = manifestStr
-	return true
+// checkObjects checks whether all objects in manifestStr exist in the cluster.
+func checkObjects(t *testing.T, f *cluster.Fake, manifestStr string, want bool) {
+	t.Helper()
+	objs, err := object.ParseK8sObjectsFromYAMLManifest(manifestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objs {
+		_, err := f.Get(o.GroupVersionKind(), o.Namespace, o.Name)
+		switch {
+		case want && err != nil:
+			t.Errorf("%s: %s", o.Hash(), err)
+		case !want && !errors.IsNotFound(err):
+			t.Errorf("%s: got %v, want not found", o.Hash(), err)
+		}
+	}
 }
@@ -29,7 +29,9 @@ Overrides the corresponding path value in the selected profile or passed through
 customization file`
 	skipConfirmationFlagHelpStr = `skipConfirmation determines whether the user is prompted for confirmation. 
 If set to true, the user is not prompted and a Yes response is assumed in all cases.`
-	filenameFlagHelpStr = `Path to file containing IstioOperator CustomResource`
+	filenameFlagHelpStr  = `Path to file containing IstioOperator CustomResource`
+	imageLockFlagHelpStr = `Path to an image lock file, a YAML map of image references to sha256 digests. All images are
+pinned to their digests, and it is an error if an image has no entry`
 )
 
 type rootArgs struct {
@@ -46,6 +46,9 @@ type Logger struct {
 	logToStdErr bool
 	stdOut      io.Writer
 	stdErr      io.Writer
+	// progressOut is where the progress of applying manifests is printed. If nil, the output set with
+	// manifest.SetProgressOutput is used.
+	progressOut io.Writer
 }
 
 // NewLogger creates a new logger and returns a pointer to it.
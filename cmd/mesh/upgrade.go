@@ -17,7 +17,6 @@ package mesh
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"strings"
 	"time"
 
@@ -64,6 +63,10 @@ type upgradeArgs struct {
 	skipConfirmation bool
 	// force means directly applying the upgrade without eligibility checks.
 	force bool
+	// output is the format of the apply report printed to stdout. No report is printed if empty.
+	output string
+	// multiCluster selects the clusters to upgrade, if there are several.
+	multiCluster multiClusterArgs
 }
 
 // addUpgradeFlags adds upgrade related flags into cobra command
@@ -85,6 +88,8 @@ func addUpgradeFlags(cmd *cobra.Command, args *upgradeArgs) {
 			upgradeWaitCheckVerMaxAttempts).String())
 	cmd.PersistentFlags().BoolVar(&args.force, "force", false,
 		"Apply the upgrade without eligibility checks")
+	addMultiClusterFlags(cmd, &args.multiCluster)
+	addApplyReportFlag(cmd, &args.output)
 }
 
 // Upgrade command upgrades Istio control plane in-place with eligibility checks
@@ -97,14 +102,35 @@ func UpgradeCmd() *cobra.Command {
 		Long: "The upgrade command checks for upgrade version eligibility and," +
 			" if eligible, upgrades the Istio control plane components in-place. Warning: " +
 			"traffic may be disrupted during upgrade. Please ensure PodDisruptionBudgets " +
-			"are defined to maintain service continuity.",
+			"are defined to maintain service continuity, or install the new version side by side as a " +
+			"revision set in spec.resourceSuffix and move workloads over to it.",
 		RunE: func(cmd *cobra.Command, args []string) (e error) {
-			l := NewLogger(rootArgs.logToStdErr, cmd.OutOrStdout(), cmd.OutOrStderr())
+			if err := validateApplyReportFormat(macArgs.output); err != nil {
+				return err
+			}
+			targets, err := macArgs.multiCluster.targets(macArgs.context)
+			if err != nil {
+				return err
+			}
+			l := applyReportLogger(rootArgs, macArgs.output, cmd)
 			initLogsOrExit(rootArgs)
-			err := upgrade(rootArgs, macArgs, l)
+			if len(targets) != 0 {
+				report, err := upgradeClusters(rootArgs, macArgs, targets, l)
+				if err != nil {
+					log.Infof("Error: %v\n", err)
+				}
+				if werr := writeApplyReport(report, macArgs.output, cmd.OutOrStdout()); werr != nil {
+					return werr
+				}
+				return err
+			}
+			report, err := upgrade(rootArgs, macArgs, "", l)
 			if err != nil {
 				log.Infof("Error: %v\n", err)
 			}
+			if werr := writeApplyReport(report, macArgs.output, cmd.OutOrStdout()); werr != nil {
+				return werr
+			}
 			return err
 		},
 	}
@@ -113,21 +139,48 @@ func UpgradeCmd() *cobra.Command {
 	return cmd
 }
 
-// upgrade is the main function for Upgrade command
-func upgrade(rootArgs *rootArgs, args *upgradeArgs, l *Logger) (err error) {
+// upgradeClusters upgrades each of targets in the order given with --cluster-order. Since the clusters are upgraded
+// in parallel, the confirmation is asked for once for all clusters, before any of them is checked.
+func upgradeClusters(rootArgs *rootArgs, args *upgradeArgs, targets []clusterTarget,
+	l *Logger) (*manifest.MultiClusterApplyReport, error) {
+	overlays := make(map[string]string)
+	for _, t := range targets {
+		overlay, err := readClusterOverlay(t.overlayFile, args.force)
+		if err != nil {
+			return nil, err
+		}
+		overlays[t.context] = overlay
+	}
+	if !args.skipConfirmation && !rootArgs.dryRun {
+		if !confirm(fmt.Sprintf("This will upgrade Istio in clusters %s. Confirm to proceed [y/N]?",
+			targetContexts(targets)), l.stdOut) {
+			l.logAndFatalf("Abort.")
+		}
+	}
+	return applyToClusters(targets, args.multiCluster.order, l, func(t clusterTarget, cl *Logger) (*manifest.ApplyReport, error) {
+		cargs := *args
+		cargs.context = t.context
+		cargs.skipConfirmation = true
+		return upgrade(rootArgs, &cargs, overlays[t.context], cl)
+	})
+}
+
+// upgrade is the main function for Upgrade command. clusterOverlay is an IstioOperatorSpec in YAML format overlaid on
+// args.inFilename for the cluster being upgraded. It may be empty.
+func upgrade(rootArgs *rootArgs, args *upgradeArgs, clusterOverlay string, l *Logger) (report *manifest.ApplyReport, err error) {
 	args.inFilename = strings.TrimSpace(args.inFilename)
 
 	// Generate IOPS objects
-	targetIOPSYaml, targetIOPS, err := genIOPS(args.inFilename, "", "", "", args.force, l)
+	targetIOPSYaml, targetIOPS, err := genIOPS(args.inFilename, "", clusterOverlay, "", args.force, l)
 	if err != nil {
-		return fmt.Errorf("failed to generate IOPS from file %s, error: %s", args.inFilename, err)
+		return nil, fmt.Errorf("failed to generate IOPS from file %s, error: %s", args.inFilename, err)
 	}
 
 	// Get the target version from the tag in the IOPS
 	targetVersion := targetIOPS.GetTag()
 	if targetVersion != opversion.OperatorVersionString {
 		if !args.force {
-			return fmt.Errorf("the target version %v is not supported by istioctl %v, "+
+			return nil, fmt.Errorf("the target version %v is not supported by istioctl %v, "+
 				"please download istioctl %v and run upgrade again", targetVersion,
 				opversion.OperatorVersionString, targetVersion)
 		}
@@ -136,7 +189,7 @@ func upgrade(rootArgs *rootArgs, args *upgradeArgs, l *Logger) (err error) {
 	// Create a kube client from args.kubeConfigPath and  args.context
 	kubeClient, err := manifest.NewClient(args.kubeConfigPath, args.context)
 	if err != nil {
-		return fmt.Errorf("failed to connect Kubernetes API server, error: %v", err)
+		return nil, fmt.Errorf("failed to connect Kubernetes API server, error: %v", err)
 	}
 
 	// Get Istio control plane namespace
@@ -146,13 +199,13 @@ func upgrade(rootArgs *rootArgs, args *upgradeArgs, l *Logger) (err error) {
 	// Read the current Istio version from the the cluster
 	currentVersion, err := retrieveControlPlaneVersion(kubeClient, istioNamespace, l)
 	if err != nil && !args.force {
-		return fmt.Errorf("failed to read the current Istio version, error: %v", err)
+		return nil, fmt.Errorf("failed to read the current Istio version, error: %v", err)
 	}
 
 	// Check if the upgrade currentVersion -> targetVersion is supported
 	err = checkSupportedVersions(currentVersion, targetVersion, args.versionsURI, l)
 	if err != nil && !args.force {
-		return fmt.Errorf("upgrade version check failed: %v -> %v. Error: %v",
+		return nil, fmt.Errorf("upgrade version check failed: %v -> %v. Error: %v",
 			currentVersion, targetVersion, err)
 	}
 	l.logAndPrintf("Upgrade version check passed: %v -> %v.\n", currentVersion, targetVersion)
@@ -162,7 +215,7 @@ func upgrade(rootArgs *rootArgs, args *upgradeArgs, l *Logger) (err error) {
 	if args.inFilename != "" {
 		b, err := ioutil.ReadFile(args.inFilename)
 		if err != nil {
-			return fmt.Errorf("failed to read override IOPS from file: %v, error: %v", args.inFilename, err)
+			return nil, fmt.Errorf("failed to read override IOPS from file: %v, error: %v", args.inFilename, err)
 		}
 		overrideIOPSYaml = string(b)
 	}
@@ -170,9 +223,9 @@ func upgrade(rootArgs *rootArgs, args *upgradeArgs, l *Logger) (err error) {
 	// Generates IOPS for args.inFilename IOP specs yaml. Param force is set to true to
 	// skip the validation because the code only has the validation proto for the
 	// target version.
-	currentIOPSYaml, _, err := genIOPS(args.inFilename, "", "", currentVersion, true, l)
+	currentIOPSYaml, _, err := genIOPS(args.inFilename, "", clusterOverlay, currentVersion, true, l)
 	if err != nil {
-		return fmt.Errorf("failed to generate IOPS from file: %s for the current version: %s, error: %v",
+		return nil, fmt.Errorf("failed to generate IOPS from file: %s for the current version: %s, error: %v",
 			args.inFilename, currentVersion, err)
 	}
 	checkUpgradeIOPS(currentIOPSYaml, targetIOPSYaml, overrideIOPSYaml, l)
@@ -188,44 +241,44 @@ func upgrade(rootArgs *rootArgs, args *upgradeArgs, l *Logger) (err error) {
 	}
 	errs := hooks.RunPreUpgradeHooks(kubeClient, hparams, rootArgs.dryRun)
 	if len(errs) != 0 && !args.force {
-		return fmt.Errorf("failed in pre-upgrade hooks, error: %v", errs.ToError())
+		return nil, fmt.Errorf("failed in pre-upgrade hooks, error: %v", errs.ToError())
 	}
 
 	// Apply the Istio Control Plane specs reading from inFilename to the cluster
-	err = genApplyManifests(nil, args.inFilename, args.force, rootArgs.dryRun,
-		rootArgs.verbose, args.kubeConfigPath, args.context, args.wait, upgradeWaitSecWhenApply, l)
+	report, err = genApplyManifests(nil, clusterOverlay, "", args.inFilename, args.force, rootArgs.dryRun,
+		rootArgs.verbose, args.kubeConfigPath, args.context, args.wait, upgradeWaitSecWhenApply, defaultApplyConcurrency, nil, l)
 	if err != nil {
-		return fmt.Errorf("failed to apply the Istio Control Plane specs. Error: %v", err)
+		return report, fmt.Errorf("failed to apply the Istio Control Plane specs. Error: %v", err)
 	}
 
 	// Run post-upgrade hooks
 	errs = hooks.RunPostUpgradeHooks(kubeClient, hparams, rootArgs.dryRun)
 	if len(errs) != 0 && !args.force {
-		return fmt.Errorf("failed in post-upgrade hooks, error: %v", errs.ToError())
+		return report, fmt.Errorf("failed in post-upgrade hooks, error: %v", errs.ToError())
 	}
 
 	if !args.wait {
 		l.logAndPrintf("Upgrade submitted. Please use `istioctl version` to check the current versions.")
 		l.logAndPrintf(upgradeSidecarMessage)
-		return nil
+		return report, nil
 	}
 
 	// Waits for the upgrade to complete by periodically comparing the each
 	// component version to the target version.
 	err = waitUpgradeComplete(kubeClient, istioNamespace, targetVersion, l)
 	if err != nil {
-		return fmt.Errorf("failed to wait for the upgrade to complete. Error: %v", err)
+		return report, fmt.Errorf("failed to wait for the upgrade to complete. Error: %v", err)
 	}
 
 	// Read the upgraded Istio version from the the cluster
 	upgradeVer, err := retrieveControlPlaneVersion(kubeClient, istioNamespace, l)
 	if err != nil {
-		return fmt.Errorf("failed to read the upgraded Istio version. Error: %v", err)
+		return report, fmt.Errorf("failed to read the upgraded Istio version. Error: %v", err)
 	}
 
 	l.logAndPrintf("Success. Now the Istio control plane is running at version %v.\n", upgradeVer)
 	l.logAndPrintf(upgradeSidecarMessage)
-	return nil
+	return report, nil
 }
 
 // checkUpgradeIOPS checks the upgrade eligibility by comparing the current IOPS with the target IOPS
@@ -244,7 +297,7 @@ func waitForConfirmation(skipConfirmation bool, l *Logger) {
 	if skipConfirmation {
 		return
 	}
-	if !confirm("Confirm to proceed [y/N]?", os.Stdout) {
+	if !confirm("Confirm to proceed [y/N]?", l.stdOut) {
 		l.logAndFatalf("Abort.")
 	}
 }
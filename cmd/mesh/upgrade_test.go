@@ -0,0 +1,108 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/operator/pkg/manifest"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+const upgradeTestPilotPod = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: istio-pilot-1
+  namespace: istio-system
+  labels:
+    istio: pilot
+spec:
+  containers:
+  - name: discovery
+    image: docker.io/istio/pilot:1.5.0
+status:
+  phase: Running
+`
+
+func TestUpgrade(t *testing.T) {
+	ns := objecttest.New("Namespace", "", "istio-system")
+	f := useFakeCluster(ns, objecttest.Unstructured(t, upgradeTestPilotPod))
+	defer manifest.SetBackendFactory(nil)
+
+	dir := createTempDirOrFail(t, "upgrade")
+	defer os.RemoveAll(dir)
+	// The profile is given as a path, so that the profile of the current version is not downloaded.
+	iopYAML := fmt.Sprintf(`apiVersion: install.istio.io/v1alpha1
+kind: IstioOperator
+spec:
+  profile: %s
+  hub: docker.io/istio
+  tag: 1.5.1
+`, filepath.Join(repoRootDir, "data/profiles/minimal.yaml"))
+	iopPath := filepath.Join(dir, "iop.yaml")
+	if err := ioutil.WriteFile(iopPath, []byte(iopYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// --force is needed since the target version differs from the version of the binary.
+	out, err := runCommand("upgrade --force --skip-confirmation -u " + filepath.Join(repoRootDir, "data/versions.yaml") +
+		" -f " + iopPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Upgrade version check passed: 1.5.0 -> 1.5.1."; !strings.Contains(out, want) {
+		t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+	}
+
+	wantYAML, err := runManifestGenerate(iopPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjects(t, f, wantYAML, true)
+	objs, err := object.ParseK8sObjectsFromYAMLManifest(wantYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployments := 0
+	for _, o := range objs {
+		if o.Kind != "Deployment" {
+			continue
+		}
+		deployments++
+		got, err := f.Get(o.GroupVersionKind(), o.Namespace, o.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		containers, _, _ := unstructured.NestedSlice(got.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			image, _, _ := unstructured.NestedString(c.(map[string]interface{}), "image")
+			if !strings.HasSuffix(image, ":1.5.1") {
+				t.Errorf("%s: got image %s, want tag 1.5.1", o.Hash(), image)
+			}
+		}
+	}
+	if deployments == 0 {
+		t.Fatal("no Deployments were upgraded")
+	}
+}
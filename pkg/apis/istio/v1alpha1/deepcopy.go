@@ -17,7 +17,6 @@ package v1alpha1
 import (
 	"encoding/json"
 
-	proto "github.com/golang/protobuf/proto"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -26,7 +25,7 @@ import (
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (m *IstioOperator) DeepCopyInto(out proto.Message) {
+func (m *IstioOperator) DeepCopyInto(out *IstioOperator) {
 	bytes, err := json.Marshal(m)
 	if err != nil {
 		log.Error(err.Error())
@@ -0,0 +1,176 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/api/operator/v1alpha1"
+)
+
+// ConditionType is the type of a status condition.
+type ConditionType string
+
+const (
+	// ConditionReady is true when all workloads are ready.
+	ConditionReady ConditionType = "Ready"
+	// ConditionProgressing is true while a reconcile is in progress or workloads are becoming ready.
+	ConditionProgressing ConditionType = "Progressing"
+	// ConditionDegraded is true when resources failed to apply.
+	ConditionDegraded ConditionType = "Degraded"
+	// ConditionReconciled is true when all resources of the observed generation were applied.
+	ConditionReconciled ConditionType = "Reconciled"
+	// ConditionDrifted is true when resources were changed outside the operator and the change was not reverted.
+	ConditionDrifted ConditionType = "Drifted"
+	// ConditionPaused is true when reconciliation of the IstioOperator or of some of its components is suspended.
+	ConditionPaused ConditionType = "Paused"
+	// ConditionConflicted is true when rendered resources are owned by another IstioOperator, so nothing was applied.
+	ConditionConflicted ConditionType = "Conflicted"
+)
+
+// IstioOperatorStatus is the status of an IstioOperator. It extends the InstallStatus with Kubernetes style
+// conditions for the IstioOperator as a whole and for each component.
+type IstioOperatorStatus struct {
+	*v1alpha1.InstallStatus `json:",inline"`
+	// ObservedGeneration is the generation of the IstioOperator last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the conditions of the IstioOperator as a whole.
+	Conditions []Condition `json:"conditions,omitempty"`
+	// Components are the conditions of each component, keyed by component name.
+	Components map[string]*ComponentStatus `json:"components,omitempty"`
+	// ReconciledHash is the hash of the inputs of the last successful reconcile: the merged spec, the profile, the
+	// charts and the operator version. Reconciles are skipped while it does not change.
+	ReconciledHash string `json:"reconciledHash,omitempty"`
+	// Upgrade is the progress of the last orchestrated upgrade, if any.
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+}
+
+// ComponentStatus is the status of a single component.
+type ComponentStatus struct {
+	// Conditions are the conditions of the component.
+	Conditions []Condition `json:"conditions,omitempty"`
+	// ReadyWorkloads is the number of Deployments, DaemonSets and StatefulSets of the component which are ready.
+	ReadyWorkloads int32 `json:"readyWorkloads"`
+	// PendingWorkloads is the number of Deployments, DaemonSets and StatefulSets of the component which are not ready.
+	PendingWorkloads int32 `json:"pendingWorkloads"`
+}
+
+// UpgradePhase is the phase of an orchestrated upgrade.
+type UpgradePhase string
+
+const (
+	// UpgradePhaseUpgrading is set while components are upgraded one at a time.
+	UpgradePhaseUpgrading UpgradePhase = "Upgrading"
+	// UpgradePhaseSucceeded is set once all components were upgraded and passed their health gates.
+	UpgradePhaseSucceeded UpgradePhase = "Succeeded"
+	// UpgradePhaseRolledBack is set when a component failed its health gates, and the upgraded components were
+	// rolled back to the previously applied manifests.
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+	// UpgradePhaseRollbackFailed is set when rolling back the upgraded components failed.
+	UpgradePhaseRollbackFailed UpgradePhase = "RollbackFailed"
+	// UpgradePhaseSuperseded is set when the spec changed before the upgrade finished.
+	UpgradePhaseSuperseded UpgradePhase = "Superseded"
+)
+
+// ComponentUpgradePhase is the phase of a single component in an orchestrated upgrade.
+type ComponentUpgradePhase string
+
+const (
+	// ComponentUpgradePending is set until the component is applied.
+	ComponentUpgradePending ComponentUpgradePhase = "Pending"
+	// ComponentUpgradeVerifying is set once the component is applied, until it passes its health gates.
+	ComponentUpgradeVerifying ComponentUpgradePhase = "Verifying"
+	// ComponentUpgradeUpgraded is set once the component passed its health gates.
+	ComponentUpgradeUpgraded ComponentUpgradePhase = "Upgraded"
+	// ComponentUpgradeFailed is set if the component did not pass its health gates before the deadline.
+	ComponentUpgradeFailed ComponentUpgradePhase = "Failed"
+	// ComponentUpgradeRolledBack is set once the previously applied manifest of the component was applied again.
+	ComponentUpgradeRolledBack ComponentUpgradePhase = "RolledBack"
+)
+
+// UpgradeStatus is the progress of an orchestrated upgrade, which upgrades components one at a time in dependency
+// order and rolls back if one of them fails its health gates.
+type UpgradeStatus struct {
+	// Phase is the phase of the upgrade.
+	Phase UpgradePhase `json:"phase"`
+	// FromTag is the tag of the previously applied manifests.
+	FromTag string `json:"fromTag,omitempty"`
+	// ToTag is the tag being upgraded to.
+	ToTag string `json:"toTag,omitempty"`
+	// Hash is the input hash of the spec being upgraded to, see ReconciledHash.
+	Hash string `json:"hash"`
+	// StartTime is the time the upgrade started.
+	StartTime metav1.Time `json:"startTime"`
+	// CompletionTime is the time the upgrade succeeded or was rolled back.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Message is a human readable description of the outcome.
+	Message string `json:"message,omitempty"`
+	// Components are the components in the order they are upgraded.
+	Components []ComponentUpgrade `json:"components,omitempty"`
+}
+
+// ComponentUpgrade is the progress of a single component in an orchestrated upgrade.
+type ComponentUpgrade struct {
+	// Name is the name of the component.
+	Name string `json:"name"`
+	// Phase is the phase of the component.
+	Phase ComponentUpgradePhase `json:"phase"`
+	// AppliedTime is the time the upgraded manifest of the component was applied. Its health gates must pass within
+	// the readiness timeout of the component from then on.
+	AppliedTime *metav1.Time `json:"appliedTime,omitempty"`
+	// Message describes the health gates which did not pass yet, or why the component failed.
+	Message string `json:"message,omitempty"`
+}
+
+// Condition is a Kubernetes style status condition.
+type Condition struct {
+	// Type is the type of the condition.
+	Type ConditionType `json:"type"`
+	// Status is True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// ObservedGeneration is the generation of the IstioOperator the condition was computed for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastTransitionTime is the last time Status changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a CamelCase reason for the last transition.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the condition.
+	Message string `json:"message,omitempty"`
+}
+
+// GetCondition returns the condition of type t in conditions, or nil if there is none.
+func GetCondition(conditions []Condition, t ConditionType) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition returns conditions with c added, or replacing the existing condition of the same type. If the status
+// of the existing condition is unchanged, its LastTransitionTime is kept.
+func SetCondition(conditions []Condition, c Condition) []Condition {
+	existing := GetCondition(conditions, c.Type)
+	if existing == nil {
+		return append(conditions, c)
+	}
+	if existing.Status == c.Status {
+		c.LastTransitionTime = existing.LastTransitionTime
+	}
+	*existing = c
+	return conditions
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/api/operator/v1alpha1"
+)
+
+func TestIstioOperatorStatus(t *testing.T) {
+	in := &IstioOperator{
+		Status: &IstioOperatorStatus{
+			InstallStatus: &v1alpha1.InstallStatus{
+				ComponentStatus: map[string]*v1alpha1.InstallStatus_VersionStatus{
+					"Pilot": {Status: v1alpha1.InstallStatus_ERROR, Error: "webhook denied"},
+				},
+			},
+			ObservedGeneration: 2,
+			Conditions:         []Condition{{Type: ConditionReady, Status: corev1.ConditionFalse, Reason: "ComponentsFailed"}},
+			Components: map[string]*ComponentStatus{
+				"Pilot": {Conditions: []Condition{{Type: ConditionDegraded, Status: corev1.ConditionTrue}}, PendingWorkloads: 1},
+			},
+		},
+	}
+	y, err := yaml.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"observedGeneration: 2", "type: Ready", "pendingWorkloads: 1", "error: webhook denied"} {
+		if !strings.Contains(string(y), want) {
+			t.Errorf("marshaled status does not contain %q:\n%s", want, y)
+		}
+	}
+
+	got := in.DeepCopy()
+	if got.Status.ComponentStatus["Pilot"].Error != "webhook denied" || got.Status.ObservedGeneration != 2 ||
+		got.Status.Components["Pilot"].PendingWorkloads != 1 || GetCondition(got.Status.Conditions, ConditionReady) == nil {
+		t.Errorf("status not copied: %s", got)
+	}
+	if s := in.String(); !strings.Contains(s, `"observedGeneration":2`) {
+		t.Errorf("String() does not contain the status: %s", s)
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	t1 := metav1.NewTime(time.Unix(1000, 0))
+	t2 := metav1.NewTime(time.Unix(2000, 0))
+	conditions := SetCondition(nil, Condition{Type: ConditionReady, Status: corev1.ConditionFalse, LastTransitionTime: t1})
+	conditions = SetCondition(conditions, Condition{Type: ConditionDegraded, Status: corev1.ConditionFalse, LastTransitionTime: t1})
+
+	conditions = SetCondition(conditions, Condition{Type: ConditionReady, Status: corev1.ConditionFalse, Reason: "Pending",
+		LastTransitionTime: t2})
+	c := GetCondition(conditions, ConditionReady)
+	if len(conditions) != 2 || c.Reason != "Pending" || !c.LastTransitionTime.Equal(&t1) {
+		t.Errorf("unchanged status: got %+v, want reason Pending and time %s", conditions, t1)
+	}
+
+	conditions = SetCondition(conditions, Condition{Type: ConditionReady, Status: corev1.ConditionTrue, LastTransitionTime: t2})
+	if c := GetCondition(conditions, ConditionReady); c.Status != corev1.ConditionTrue || !c.LastTransitionTime.Equal(&t2) {
+		t.Errorf("changed status: got %+v, want True at %s", c, t2)
+	}
+	if GetCondition(conditions, ConditionReconciled) != nil {
+		t.Error("got Reconciled condition, want none")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/api/operator/v1alpha1"
+)
+
+// IstioOperator is the custom resource installing Istio with the operator. The spec is the IstioOperatorSpec API,
+// while the status is specific to the custom resource.
+type IstioOperator struct {
+	Kind              string                      `json:"kind,omitempty"`
+	ApiVersion        string                      `json:"apiVersion,omitempty"`
+	Spec              *v1alpha1.IstioOperatorSpec `json:"spec,omitempty"`
+	Status            *IstioOperatorStatus        `json:"status,omitempty"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	metav1.TypeMeta   `json:",inline"`
+}
+
+// String implements the Stringer interface, using the JSON encoding of the IstioOperator.
+func (m *IstioOperator) String() string {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return err.Error()
+	}
+	return string(j)
+}
+
+// GetKind returns the kind of the IstioOperator.
+func (m *IstioOperator) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+// GetApiVersion returns the API version of the IstioOperator.
+func (m *IstioOperator) GetApiVersion() string {
+	if m != nil {
+		return m.ApiVersion
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// backendClient is a controller-runtime client which reads and writes objects through a Backend. Typed objects are
+// converted to and from unstructured objects using the client-go scheme. Only server-side apply patches are
+// supported, and DeleteAllOf is not supported.
+type backendClient struct {
+	backend Backend
+}
+
+// NewClient returns a controller-runtime client for b.
+func NewClient(b Backend) client.Client {
+	return &backendClient{backend: b}
+}
+
+// Get implements client.Client.
+func (c *backendClient) Get(_ context.Context, key client.ObjectKey, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme.Scheme)
+	if err != nil {
+		return err
+	}
+	u, err := c.backend.Get(gvk, key.Namespace, key.Name)
+	if err != nil {
+		return err
+	}
+	return fromUnstructured(u, obj)
+}
+
+// List implements client.Client.
+func (c *backendClient) List(_ context.Context, list runtime.Object, opts ...client.ListOption) error {
+	gvk, err := apiutil.GVKForObject(list, scheme.Scheme)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(gvk.Kind, "List") {
+		return fmt.Errorf("non-list type %T (kind %q) passed as output", list, gvk)
+	}
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	items, err := c.backend.List(gvk.GroupVersion().WithKind(strings.TrimSuffix(gvk.Kind, "List")),
+		listOpts.Namespace, listOpts.LabelSelector)
+	if err != nil {
+		return err
+	}
+	if ul, ok := list.(*unstructured.UnstructuredList); ok {
+		ul.Items = items
+		return nil
+	}
+	objs := make([]runtime.Object, 0, len(items))
+	for i := range items {
+		objs = append(objs, &items[i])
+	}
+	// Convert the items through the list, so that they get the item type of the list.
+	ul := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+	ul.SetGroupVersionKind(gvk)
+	if err := meta.SetList(ul, objs); err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(ul.UnstructuredContent(), list)
+}
+
+// Create implements client.Client.
+func (c *backendClient) Create(_ context.Context, obj runtime.Object, _ ...client.CreateOption) error {
+	return c.write(obj, c.backend.Create)
+}
+
+// Update implements client.Client.
+func (c *backendClient) Update(_ context.Context, obj runtime.Object, _ ...client.UpdateOption) error {
+	return c.write(obj, c.backend.Update)
+}
+
+// Patch implements client.Client.
+func (c *backendClient) Patch(_ context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return fmt.Errorf("patch type %s is not supported", patch.Type())
+	}
+	patchOpts := &client.PatchOptions{}
+	patchOpts.ApplyOptions(opts)
+	dryRun := len(patchOpts.DryRun) != 0
+	return c.write(obj, func(u *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		return c.backend.Apply(u, dryRun)
+	})
+}
+
+// Delete implements client.Client.
+func (c *backendClient) Delete(_ context.Context, obj runtime.Object, _ ...client.DeleteOption) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme.Scheme)
+	if err != nil {
+		return err
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	return c.backend.Delete(gvk, m.GetNamespace(), m.GetName())
+}
+
+// DeleteAllOf implements client.Client.
+func (c *backendClient) DeleteAllOf(_ context.Context, obj runtime.Object, _ ...client.DeleteAllOfOption) error {
+	return fmt.Errorf("DeleteAllOf is not supported")
+}
+
+// Status implements client.Client. The status of an object is written along with the rest of the object.
+func (c *backendClient) Status() client.StatusWriter {
+	return c
+}
+
+// write converts obj to an unstructured object, writes it with fn and converts the result back into obj.
+func (c *backendClient) write(obj runtime.Object, fn func(*unstructured.Unstructured) (*unstructured.Unstructured, error)) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme.Scheme)
+	if err != nil {
+		return err
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	out, err := fn(u)
+	if err != nil {
+		return err
+	}
+	return fromUnstructured(out, obj)
+}
+
+func fromUnstructured(u *unstructured.Unstructured, obj runtime.Object) error {
+	if uo, ok := obj.(*unstructured.Unstructured); ok {
+		uo.Object = u.DeepCopy().Object
+		return nil
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return err
+	}
+	// The converter does not fill in the type of typed objects.
+	obj.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(u.GetAPIVersion(), u.GetKind()))
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient(NewFake())
+
+	for _, name := range []string{"a", "b"} {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"cm": name}},
+			Data:       map[string]string{"name": name},
+		}
+		if err := c.Create(ctx, cm); err != nil {
+			t.Fatal(err)
+		}
+		if cm.ResourceVersion == "" || cm.Kind != "ConfigMap" {
+			t.Errorf("created object not filled in: %v", cm)
+		}
+	}
+
+	got := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "a"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data["name"] != "a" {
+		t.Errorf("got data %v, want name a", got.Data)
+	}
+	got.Data["name"] = "c"
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+
+	list := &corev1.ConfigMapList{}
+	if err := c.List(ctx, list, client.InNamespace("default"), client.MatchingLabels{"cm": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Data["name"] != "c" {
+		t.Errorf("got %v, want updated ConfigMap a", list.Items)
+	}
+
+	if err := c.Delete(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "a"}, got); !apierrors.IsNotFound(err) {
+		t.Errorf("got %v, want not found after delete", err)
+	}
+	if err := c.Patch(ctx, got, client.MergeFrom(got)); err == nil {
+		t.Error("got no error for unsupported patch type")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package cluster is the single point through which the installer talks to a K8s API server.
+
+A Backend reads and writes objects in unstructured form. NewRESTBackend returns a Backend for a real API server, and
+NewFake returns an in-memory Backend which simulates the parts of the API server the installer depends on: kinds
+which are only served once their CRD is established, workloads which become ready, Services which get endpoints and
+label selector queries. NewClient wraps any Backend in a controller-runtime client for code which works with typed
+objects.
+*/
+package cluster
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// FieldManager is the field manager used for server-side apply of all objects applied by the installer.
+	FieldManager = "istio-operator"
+)
+
+// Backend reads and writes objects in a cluster. Kinds which are not served by the cluster return a
+// meta.NoKindMatchError, and missing objects return a NotFound API error. Namespaced objects without a namespace are
+// in the default namespace.
+type Backend interface {
+	// Get returns the object of the given kind, namespace and name.
+	Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error)
+	// List returns the objects of the given kind in namespace whose labels match selector. An empty namespace lists
+	// objects in all namespaces.
+	List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]unstructured.Unstructured, error)
+	// Create creates u and returns the created object.
+	Create(u *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	// Update replaces u and returns the updated object.
+	Update(u *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	// Apply applies u with server-side apply under FieldManager, taking ownership of conflicting fields, and returns
+	// the applied object. If dryRun is set, the cluster is not changed.
+	Apply(u *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error)
+	// Delete deletes the object of the given kind, namespace and name. Dependents are deleted in the background.
+	Delete(gvk schema.GroupVersionKind, namespace, name string) error
+	// ResetMapping drops cached API discovery information. It must be called after new CRDs are established so that
+	// instances of them can be mapped.
+	ResetMapping()
+}
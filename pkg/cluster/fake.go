@@ -0,0 +1,492 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var (
+	crdGroupKind       = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+	namespaceGroupKind = schema.GroupKind{Kind: "Namespace"}
+	serviceGroupKind   = schema.GroupKind{Kind: "Service"}
+	endpointsGroupKind = schema.GroupKind{Kind: "Endpoints"}
+
+	// clusterScopedKinds are the built-in kinds which are not namespaced.
+	clusterScopedKinds = map[string]bool{
+		"Namespace":                      true,
+		"Node":                           true,
+		"PersistentVolume":               true,
+		"ClusterRole":                    true,
+		"ClusterRoleBinding":             true,
+		"CustomResourceDefinition":       true,
+		"MutatingWebhookConfiguration":   true,
+		"ValidatingWebhookConfiguration": true,
+		"APIService":                     true,
+		"PriorityClass":                  true,
+		"StorageClass":                   true,
+		"PodSecurityPolicy":              true,
+		"CertificateSigningRequest":      true,
+	}
+)
+
+// kindInfo describes a served kind.
+type kindInfo struct {
+	versions   map[string]bool
+	namespaced bool
+}
+
+// objectKey identifies an object independently of its API version.
+type objectKey struct {
+	gk        schema.GroupKind
+	namespace string
+	name      string
+}
+
+// Fake is an in-memory Backend. It serves the built-in kinds and the kinds of established CRDs, and simulates the
+// behavior of the API server and the controllers the installer depends on:
+//
+// - CRDs are established immediately. Their kinds are served, but only visible once ResetMapping is called, as with a
+// cached discovery client.
+// - Namespaced objects can only be created in existing namespaces. Deleting a namespace or a CRD deletes the objects
+// in it.
+// - Deployments, StatefulSets, DaemonSets, Jobs, Pods and Namespaces become ready as soon as they are written.
+// - Services are assigned a cluster IP, and an Endpoints object with a ready address is created for them.
+// - The resource version of an object only changes when it is changed, and the generation only when its spec changes.
+//
+// Server-side apply replaces all fields of the object other than its status and server set metadata. A Fake is safe
+// for concurrent use.
+type Fake struct {
+	// OnWrite, if set, is called with every object written to the fake after its status is simulated, and may change
+	// it, e.g. to keep a Deployment from becoming ready.
+	OnWrite func(u *unstructured.Unstructured)
+
+	mu      sync.Mutex
+	served  map[schema.GroupKind]*kindInfo
+	mapped  map[schema.GroupKind]*kindInfo
+	objects map[objectKey]*unstructured.Unstructured
+	version int
+	nextIP  int
+}
+
+// NewFake creates a Fake holding the default namespaces and the given objects. It panics if an object cannot be
+// created.
+func NewFake(objects ...*unstructured.Unstructured) *Fake {
+	f := &Fake{
+		served:  make(map[schema.GroupKind]*kindInfo),
+		objects: make(map[objectKey]*unstructured.Unstructured),
+	}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.Version == "__internal" || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		f.serve(gvk.GroupKind(), gvk.Version, !clusterScopedKinds[gvk.Kind])
+	}
+	f.serve(crdGroupKind, "v1beta1", false)
+	f.serve(crdGroupKind, "v1", false)
+	f.ResetMapping()
+
+	for _, ns := range []string{metav1.NamespaceDefault, metav1.NamespaceSystem, metav1.NamespacePublic} {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("v1")
+		u.SetKind("Namespace")
+		u.SetName(ns)
+		objects = append([]*unstructured.Unstructured{u}, objects...)
+	}
+	for _, o := range objects {
+		if _, err := f.Create(o); err != nil {
+			panic(fmt.Sprintf("failed to add object %s/%s to fake cluster: %s", o.GetKind(), o.GetName(), err))
+		}
+	}
+	return f
+}
+
+func (f *Fake) serve(gk schema.GroupKind, version string, namespaced bool) {
+	ki := f.served[gk]
+	if ki == nil {
+		ki = &kindInfo{versions: make(map[string]bool), namespaced: namespaced}
+		f.served[gk] = ki
+	}
+	ki.versions[version] = true
+}
+
+// mappingLocked returns the served kind of gvk, or a NoKindMatchError if it is not mapped.
+func (f *Fake) mappingLocked(gvk schema.GroupVersionKind) (*kindInfo, error) {
+	ki := f.mapped[gvk.GroupKind()]
+	if ki == nil || !ki.versions[gvk.Version] {
+		return nil, &meta.NoKindMatchError{GroupKind: gvk.GroupKind(), SearchedVersions: []string{gvk.Version}}
+	}
+	return ki, nil
+}
+
+// keyLocked returns the key of the object with the given kind, namespace and name, after checking the kind is mapped.
+func (f *Fake) keyLocked(gvk schema.GroupVersionKind, namespace, name string) (objectKey, *kindInfo, error) {
+	ki, err := f.mappingLocked(gvk)
+	if err != nil {
+		return objectKey{}, nil, err
+	}
+	switch {
+	case !ki.namespaced:
+		namespace = ""
+	case namespace == "":
+		namespace = metav1.NamespaceDefault
+	}
+	return objectKey{gk: gvk.GroupKind(), namespace: namespace, name: name}, ki, nil
+}
+
+// Get implements Backend.
+func (f *Fake) Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, _, err := f.keyLocked(gvk, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := f.objects[key]
+	if !ok {
+		return nil, notFound(gvk, name)
+	}
+	return withVersion(o, gvk), nil
+}
+
+// List implements Backend.
+func (f *Fake) List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]unstructured.Unstructured, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ki, err := f.mappingLocked(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if !ki.namespaced {
+		namespace = ""
+	}
+	var out []unstructured.Unstructured
+	for k, o := range f.objects {
+		if k.gk != gvk.GroupKind() || (namespace != "" && k.namespace != namespace) {
+			continue
+		}
+		if selector != nil && !selector.Matches(labels.Set(o.GetLabels())) {
+			continue
+		}
+		out = append(out, *withVersion(o, gvk))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].GetNamespace() != out[j].GetNamespace() {
+			return out[i].GetNamespace() < out[j].GetNamespace()
+		}
+		return out[i].GetName() < out[j].GetName()
+	})
+	return out, nil
+}
+
+// writeMode is the kind of request writing an object.
+type writeMode int
+
+const (
+	modeCreate writeMode = iota
+	modeUpdate
+	modeApply
+)
+
+// Create implements Backend.
+func (f *Fake) Create(u *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return f.write(u, modeCreate, false)
+}
+
+// Update implements Backend.
+func (f *Fake) Update(u *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return f.write(u, modeUpdate, false)
+}
+
+// Apply implements Backend.
+func (f *Fake) Apply(u *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	return f.write(u, modeApply, dryRun)
+}
+
+func (f *Fake) write(u *unstructured.Unstructured, mode writeMode, dryRun bool) (*unstructured.Unstructured, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	gvk := u.GroupVersionKind()
+	if u.GetName() == "" {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("%s has no name", gvk.Kind))
+	}
+	key, ki, err := f.keyLocked(gvk, u.GetNamespace(), u.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if ki.namespaced {
+		if _, ok := f.objects[objectKey{gk: namespaceGroupKind, name: key.namespace}]; !ok {
+			return nil, notFound(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, key.namespace)
+		}
+	}
+	existing := f.objects[key]
+	switch {
+	case mode == modeCreate && existing != nil:
+		return nil, apierrors.NewAlreadyExists(resource(gvk), u.GetName())
+	case mode == modeUpdate && existing == nil:
+		return nil, notFound(gvk, u.GetName())
+	case mode == modeUpdate && u.GetResourceVersion() != "" && u.GetResourceVersion() != existing.GetResourceVersion():
+		return nil, apierrors.NewConflict(resource(gvk), u.GetName(),
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	o := u.DeepCopy()
+	o.SetNamespace(key.namespace)
+	o.SetGeneration(1)
+	o.SetResourceVersion("")
+	if existing != nil {
+		o.SetUID(existing.GetUID())
+		o.SetCreationTimestamp(existing.GetCreationTimestamp())
+		o.SetResourceVersion(existing.GetResourceVersion())
+		o.SetGeneration(existing.GetGeneration())
+		// Like the API server, keep the allocated cluster IP of a Service when it is not set.
+		if ip, _, _ := unstructured.NestedString(existing.Object, "spec", "clusterIP"); ip != "" && key.gk == serviceGroupKind {
+			if cur, _, _ := unstructured.NestedString(o.Object, "spec", "clusterIP"); cur == "" {
+				_ = unstructured.SetNestedField(o.Object, ip, "spec", "clusterIP")
+			}
+		}
+		if !reflect.DeepEqual(o.Object["spec"], existing.Object["spec"]) {
+			o.SetGeneration(existing.GetGeneration() + 1)
+		}
+		if status, ok := existing.Object["status"]; ok && (mode != modeUpdate || o.Object["status"] == nil) {
+			o.Object["status"] = status
+		}
+	} else {
+		o.SetUID(types.UID(fmt.Sprintf("%s-%d", strings.ToLower(gvk.Kind), f.version+1)))
+		o.SetCreationTimestamp(metav1.Now())
+	}
+	f.simulateStatusLocked(o)
+	if f.OnWrite != nil {
+		f.OnWrite(o)
+	}
+	if existing != nil && reflect.DeepEqual(o.Object, existing.Object) {
+		return withVersion(existing, gvk), nil
+	}
+	if dryRun {
+		return o, nil
+	}
+
+	f.version++
+	o.SetResourceVersion(strconv.Itoa(f.version))
+	f.objects[key] = o
+	f.afterWriteLocked(key, o)
+	return o.DeepCopy(), nil
+}
+
+// simulateStatusLocked sets the status the controllers of a cluster would eventually set on o.
+func (f *Fake) simulateStatusLocked(o *unstructured.Unstructured) {
+	gen := o.GetGeneration()
+	replicas := int64(1)
+	spec, _ := o.Object["spec"].(map[string]interface{})
+	switch r := spec["replicas"].(type) {
+	case int64:
+		replicas = r
+	case float64:
+		replicas = int64(r)
+	}
+	switch o.GroupVersionKind().GroupKind() {
+	case namespaceGroupKind:
+		setStatus(o, map[string]interface{}{"phase": "Active"})
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}, schema.GroupKind{Group: "extensions", Kind: "Deployment"}:
+		setStatus(o, map[string]interface{}{
+			"observedGeneration": gen,
+			"replicas":           replicas,
+			"updatedReplicas":    replicas,
+			"readyReplicas":      replicas,
+			"availableReplicas":  replicas,
+		})
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		rev := fmt.Sprintf("%s-%d", o.GetName(), gen)
+		setStatus(o, map[string]interface{}{
+			"observedGeneration": gen,
+			"replicas":           replicas,
+			"readyReplicas":      replicas,
+			"updatedReplicas":    replicas,
+			"currentRevision":    rev,
+			"updateRevision":     rev,
+		})
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}, schema.GroupKind{Group: "extensions", Kind: "DaemonSet"}:
+		setStatus(o, map[string]interface{}{
+			"observedGeneration":     gen,
+			"desiredNumberScheduled": int64(1),
+			"currentNumberScheduled": int64(1),
+			"updatedNumberScheduled": int64(1),
+			"numberReady":            int64(1),
+			"numberAvailable":        int64(1),
+		})
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		setStatus(o, map[string]interface{}{
+			"succeeded":  int64(1),
+			"conditions": []interface{}{condition("Complete", "True")},
+		})
+	case schema.GroupKind{Kind: "Pod"}:
+		setStatus(o, map[string]interface{}{
+			"phase":      "Running",
+			"conditions": []interface{}{condition("Ready", "True")},
+		})
+	case crdGroupKind:
+		names, _, _ := unstructured.NestedMap(o.Object, "spec", "names")
+		setStatus(o, map[string]interface{}{
+			"acceptedNames": names,
+			"conditions":    []interface{}{condition("NamesAccepted", "True"), condition("Established", "True")},
+		})
+	case serviceGroupKind:
+		svcType, _, _ := unstructured.NestedString(o.Object, "spec", "type")
+		ip, _, _ := unstructured.NestedString(o.Object, "spec", "clusterIP")
+		if ip == "" && svcType != "ExternalName" {
+			f.nextIP++
+			_ = unstructured.SetNestedField(o.Object, fmt.Sprintf("10.0.%d.%d", f.nextIP/256, f.nextIP%256), "spec", "clusterIP")
+		}
+		if svcType == "LoadBalancer" {
+			setStatus(o, map[string]interface{}{
+				"loadBalancer": map[string]interface{}{
+					"ingress": []interface{}{map[string]interface{}{"ip": "192.0.2.1"}},
+				},
+			})
+		}
+	}
+}
+
+// afterWriteLocked simulates the effects of storing o on other objects.
+func (f *Fake) afterWriteLocked(key objectKey, o *unstructured.Unstructured) {
+	switch key.gk {
+	case crdGroupKind:
+		group, _, _ := unstructured.NestedString(o.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(o.Object, "spec", "names", "kind")
+		scope, _, _ := unstructured.NestedString(o.Object, "spec", "scope")
+		gk := schema.GroupKind{Group: group, Kind: kind}
+		if version, _, _ := unstructured.NestedString(o.Object, "spec", "version"); version != "" {
+			f.serve(gk, version, scope != "Cluster")
+		}
+		versions, _, _ := unstructured.NestedSlice(o.Object, "spec", "versions")
+		for _, v := range versions {
+			if vm, ok := v.(map[string]interface{}); ok {
+				if name, ok := vm["name"].(string); ok {
+					f.serve(gk, name, scope != "Cluster")
+				}
+			}
+		}
+	case serviceGroupKind:
+		epKey := objectKey{gk: endpointsGroupKind, namespace: key.namespace, name: key.name}
+		if _, ok := f.objects[epKey]; ok {
+			return
+		}
+		ip, _, _ := unstructured.NestedString(o.Object, "spec", "clusterIP")
+		if ip == "" || ip == "None" {
+			ip = "10.1.0.1"
+		}
+		ep := &unstructured.Unstructured{}
+		ep.SetAPIVersion("v1")
+		ep.SetKind("Endpoints")
+		ep.SetNamespace(key.namespace)
+		ep.SetName(key.name)
+		ep.SetLabels(o.GetLabels())
+		ep.Object["subsets"] = []interface{}{
+			map[string]interface{}{"addresses": []interface{}{map[string]interface{}{"ip": ip}}},
+		}
+		f.version++
+		ep.SetResourceVersion(strconv.Itoa(f.version))
+		f.objects[epKey] = ep
+	}
+}
+
+// Delete implements Backend.
+func (f *Fake) Delete(gvk schema.GroupVersionKind, namespace, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, _, err := f.keyLocked(gvk, namespace, name)
+	if err != nil {
+		return err
+	}
+	o, ok := f.objects[key]
+	if !ok {
+		return notFound(gvk, name)
+	}
+	delete(f.objects, key)
+	switch key.gk {
+	case namespaceGroupKind:
+		for k := range f.objects {
+			if k.namespace == name {
+				delete(f.objects, k)
+			}
+		}
+	case crdGroupKind:
+		group, _, _ := unstructured.NestedString(o.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(o.Object, "spec", "names", "kind")
+		gk := schema.GroupKind{Group: group, Kind: kind}
+		delete(f.served, gk)
+		delete(f.mapped, gk)
+		for k := range f.objects {
+			if k.gk == gk {
+				delete(f.objects, k)
+			}
+		}
+	case serviceGroupKind:
+		delete(f.objects, objectKey{gk: endpointsGroupKind, namespace: key.namespace, name: key.name})
+	}
+	return nil
+}
+
+// ResetMapping implements Backend. Kinds served since the last call become visible.
+func (f *Fake) ResetMapping() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mapped = make(map[schema.GroupKind]*kindInfo)
+	for gk, ki := range f.served {
+		versions := make(map[string]bool)
+		for v := range ki.versions {
+			versions[v] = true
+		}
+		f.mapped[gk] = &kindInfo{versions: versions, namespaced: ki.namespaced}
+	}
+}
+
+// withVersion returns a copy of o with the API version of gvk.
+func withVersion(o *unstructured.Unstructured, gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	out := o.DeepCopy()
+	out.SetAPIVersion(gvk.GroupVersion().String())
+	return out
+}
+
+func setStatus(o *unstructured.Unstructured, status map[string]interface{}) {
+	o.Object["status"] = status
+}
+
+func condition(conditionType, status string) map[string]interface{} {
+	return map[string]interface{}{"type": conditionType, "status": status}
+}
+
+func resource(gvk schema.GroupVersionKind) schema.GroupResource {
+	plural, _ := meta.UnsafeGuessKindToResource(gvk)
+	return plural.GroupResource()
+}
+
+func notFound(gvk schema.GroupVersionKind, name string) error {
+	return apierrors.NewNotFound(resource(gvk), name)
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+var (
+	deploymentGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	endpointsGVK  = schema.GroupVersionKind{Version: "v1", Kind: "Endpoints"}
+	widgetGVK     = schema.GroupVersionKind{Group: "example.istio.io", Version: "v1alpha1", Kind: "Widget"}
+)
+
+func TestFakeCRD(t *testing.T) {
+	f := NewFake()
+	widget := objecttest.Unstructured(t, `
+apiVersion: example.istio.io/v1alpha1
+kind: Widget
+metadata:
+  name: w
+  namespace: default
+`)
+	if _, err := f.Apply(widget, false); !meta.IsNoMatchError(err) {
+		t.Fatalf("got %v, want no match error before the CRD exists", err)
+	}
+
+	crd, err := f.Apply(objecttest.Unstructured(t, `
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.istio.io
+spec:
+  group: example.istio.io
+  names:
+    kind: Widget
+  scope: Namespaced
+  versions:
+  - name: v1alpha1
+`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	if len(conditions) != 2 {
+		t.Errorf("got conditions %v, want NamesAccepted and Established", conditions)
+	}
+	if _, err := f.Apply(widget, false); !meta.IsNoMatchError(err) {
+		t.Fatalf("got %v, want no match error before the mapping is reset", err)
+	}
+	f.ResetMapping()
+	if _, err := f.Apply(widget, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Delete(crd.GroupVersionKind(), "", crd.GetName()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Get(widgetGVK, "default", "w"); !meta.IsNoMatchError(err) {
+		t.Errorf("got %v, want no match error after the CRD is deleted", err)
+	}
+}
+
+func TestFakeWrite(t *testing.T) {
+	f := NewFake()
+	d := objecttest.Unstructured(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: d
+  namespace: test
+  labels:
+    app: d
+spec:
+  replicas: 3
+`)
+	if _, err := f.Apply(d, false); !apierrors.IsNotFound(err) {
+		t.Fatalf("got %v, want namespace not found", err)
+	}
+	if _, err := f.Create(objecttest.Unstructured(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Apply(d, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Get(deploymentGVK, "test", "d"); !apierrors.IsNotFound(err) {
+		t.Fatalf("got %v, want dry run to not store the object", err)
+	}
+
+	got, err := f.Apply(d, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready, _, _ := unstructured.NestedInt64(got.Object, "status", "readyReplicas"); ready != 3 {
+		t.Errorf("got %d ready replicas, want 3", ready)
+	}
+	if _, err := f.Create(d); !apierrors.IsAlreadyExists(err) {
+		t.Errorf("got %v, want already exists", err)
+	}
+
+	again, err := f.Apply(d, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.GetResourceVersion() != got.GetResourceVersion() {
+		t.Errorf("unchanged apply changed resource version from %s to %s", got.GetResourceVersion(), again.GetResourceVersion())
+	}
+	d.Object["spec"].(map[string]interface{})["replicas"] = int64(1)
+	changed, err := f.Apply(d, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.GetResourceVersion() == got.GetResourceVersion() || changed.GetGeneration() != 2 {
+		t.Errorf("got resource version %s, generation %d, want changed resource version and generation 2",
+			changed.GetResourceVersion(), changed.GetGeneration())
+	}
+
+	stale := got.DeepCopy()
+	if _, err := f.Update(stale); !apierrors.IsConflict(err) {
+		t.Errorf("got %v, want conflict for stale resource version", err)
+	}
+}
+
+func TestFakeListAndDelete(t *testing.T) {
+	f := NewFake(
+		objecttest.Unstructured(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n"),
+		objecttest.Unstructured(t, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: a
+  namespace: test
+  labels:
+    app: a
+`),
+		objecttest.Unstructured(t, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: b
+  namespace: default
+  labels:
+    app: b
+`),
+	)
+	svcGVK := schema.GroupVersionKind{Version: "v1", Kind: "Service"}
+
+	all, err := f.List(svcGVK, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("got %d services, want 2", len(all))
+	}
+	selected, err := f.List(svcGVK, "", labels.SelectorFromSet(labels.Set{"app": "a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || selected[0].GetName() != "a" {
+		t.Errorf("got %v, want service a", selected)
+	}
+	if ip, _, _ := unstructured.NestedString(selected[0].Object, "spec", "clusterIP"); ip == "" {
+		t.Error("service was not assigned a cluster IP")
+	}
+	if _, err := f.Get(endpointsGVK, "test", "a"); err != nil {
+		t.Errorf("endpoints not created: %v", err)
+	}
+
+	if err := f.Delete(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, "", "test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, gvk := range []schema.GroupVersionKind{svcGVK, endpointsGVK} {
+		if _, err := f.Get(gvk, "test", "a"); !apierrors.IsNotFound(err) {
+			t.Errorf("got %v, want %s deleted with its namespace", err, gvk.Kind)
+		}
+	}
+	if _, err := f.Get(svcGVK, "default", "b"); err != nil {
+		t.Errorf("service in other namespace deleted: %v", err)
+	}
+}
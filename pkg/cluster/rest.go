@@ -0,0 +1,149 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// restBackend is a Backend for a real API server, using the dynamic client.
+type restBackend struct {
+	client dynamic.Interface
+	mapper *restmapper.DeferredDiscoveryRESTMapper
+}
+
+// NewRESTBackend creates a Backend for the API server given by config.
+func NewRESTBackend(config *rest.Config) (Backend, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("k8s discovery client error: %s", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("k8s dynamic client error: %s", err)
+	}
+	return &restBackend{
+		client: client,
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)),
+	}, nil
+}
+
+// resource returns the dynamic resource interface for objects of the given kind in namespace. If namespace is empty,
+// namespaced kinds use the default namespace unless all is set, in which case all namespaces are used.
+func (b *restBackend) resource(gvk schema.GroupVersionKind, namespace string, all bool) (dynamic.ResourceInterface, error) {
+	mapping, err := b.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return b.client.Resource(mapping.Resource), nil
+	}
+	if namespace == "" {
+		if all {
+			return b.client.Resource(mapping.Resource), nil
+		}
+		namespace = metav1.NamespaceDefault
+	}
+	return b.client.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// Get implements Backend.
+func (b *restBackend) Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	ri, err := b.resource(gvk, namespace, false)
+	if err != nil {
+		return nil, err
+	}
+	return ri.Get(name, metav1.GetOptions{})
+}
+
+// List implements Backend.
+func (b *restBackend) List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]unstructured.Unstructured, error) {
+	ri, err := b.resource(gvk, namespace, true)
+	if err != nil {
+		return nil, err
+	}
+	opts := metav1.ListOptions{}
+	if selector != nil {
+		opts.LabelSelector = selector.String()
+	}
+	list, err := ri.List(opts)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Create implements Backend.
+func (b *restBackend) Create(u *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ri, err := b.resource(u.GroupVersionKind(), u.GetNamespace(), false)
+	if err != nil {
+		return nil, err
+	}
+	return ri.Create(u, metav1.CreateOptions{})
+}
+
+// Update implements Backend.
+func (b *restBackend) Update(u *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ri, err := b.resource(u.GroupVersionKind(), u.GetNamespace(), false)
+	if err != nil {
+		return nil, err
+	}
+	return ri.Update(u, metav1.UpdateOptions{})
+}
+
+// Apply implements Backend.
+func (b *restBackend) Apply(u *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	ri, err := b.resource(u.GroupVersionKind(), u.GetNamespace(), false)
+	if err != nil {
+		return nil, err
+	}
+	j, err := u.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	force := true
+	opts := metav1.PatchOptions{FieldManager: FieldManager, Force: &force}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return ri.Patch(u.GetName(), types.ApplyPatchType, j, opts)
+}
+
+// Delete implements Backend.
+func (b *restBackend) Delete(gvk schema.GroupVersionKind, namespace, name string) error {
+	ri, err := b.resource(gvk, namespace, false)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	return ri.Delete(name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+// ResetMapping implements Backend.
+func (b *restBackend) ResetMapping() {
+	b.mapper.Reset()
+}
@@ -16,6 +16,7 @@ package controlplane
 
 import (
 	"fmt"
+	"time"
 
 	"istio.io/api/operator/v1alpha1"
 	"istio.io/operator/pkg/component/component"
@@ -28,12 +29,14 @@ import (
 type IstioOperator struct {
 	// components is a slice of components that are part of the feature.
 	components []component.IstioComponent
-	started    bool
+	// revision is the revision of the control plane, see translate.ApplyRevision.
+	revision string
+	started  bool
 }
 
 // NewIstioOperator creates a new IstioOperator and returns a pointer to it.
 func NewIstioOperator(installSpec *v1alpha1.IstioOperatorSpec, translator *translate.Translator) (*IstioOperator, error) {
-	out := &IstioOperator{}
+	out := &IstioOperator{revision: installSpec.ResourceSuffix}
 	opts := &component.Options{
 		InstallSpec: installSpec,
 		Translator:  translator,
@@ -100,18 +103,33 @@ func (i *IstioOperator) Run() error {
 
 // RenderManifest returns a manifest rendered against
 func (i *IstioOperator) RenderManifest() (manifests name.ManifestMap, errsOut util.Errors) {
+	return i.RenderManifestWithObserver(nil)
+}
+
+// RenderManifestWithObserver is like RenderManifest, but calls observe with the time taken to render each component.
+// observe may be nil.
+func (i *IstioOperator) RenderManifestWithObserver(observe func(c name.ComponentName, d time.Duration)) (
+	manifests name.ManifestMap, errsOut util.Errors) {
 	if !i.started {
 		return nil, util.NewErrs(fmt.Errorf("istioControlPlane must be Run before calling RenderManifest"))
 	}
 
 	manifests = make(name.ManifestMap)
 	for _, c := range i.components {
+		start := time.Now()
 		ms, err := c.RenderManifest()
+		if observe != nil {
+			observe(c.ComponentName(), time.Since(start))
+		}
 		errsOut = util.AppendErr(errsOut, err)
 		manifests[c.ComponentName()] = append(manifests[c.ComponentName()], ms)
 	}
 	if len(errsOut) > 0 {
 		return nil, errsOut
 	}
-	return
+	manifests, err := translate.ApplyRevision(manifests, i.revision)
+	if err != nil {
+		return nil, util.NewErrs(err)
+	}
+	return manifests, nil
 }
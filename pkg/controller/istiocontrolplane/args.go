@@ -15,6 +15,8 @@
 package istiocontrolplane
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
@@ -26,6 +28,14 @@ type Options struct {
 	// DefaultChartPath is the relative path used added to BaseChartPath when no value is specified in
 	// IstioOperator.Spec.ChartPath
 	DefaultChartPath string
+	// Concurrency is the maximum number of components reconciled at the same time. 0 means no limit.
+	Concurrency int
+	// ResyncPeriod is how long reconciles of an IstioOperator whose inputs did not change are skipped. After that,
+	// it is reconciled fully again, which reverts drift and prunes resources. 0 means reconciles are never skipped.
+	ResyncPeriod time.Duration
+	// UpgradeTimeout is how long an upgraded component may take to pass its health gates in an orchestrated upgrade
+	// before the upgrade is rolled back, unless the readinessTimeout of the component is set. 0 means no limit.
+	UpgradeTimeout time.Duration
 }
 
 // ControllerOptions represents the options used by the controller
@@ -33,6 +43,9 @@ var controllerOptions = &Options{
 	// XXX: update this once we add charts to the operator
 	BaseChartPath:    "/etc/istio-operator/helm",
 	DefaultChartPath: "istio",
+	Concurrency:      4,
+	ResyncPeriod:     10 * time.Minute,
+	UpgradeTimeout:   5 * time.Minute,
 }
 
 // AttachCobraFlags attaches a set of Cobra flags to the given Cobra command.
@@ -45,4 +58,13 @@ func AttachCobraFlags(cmd *cobra.Command) {
 			"This will be used as the base path for any IstioOperator instances specifying a relative ChartPath.")
 	cmd.PersistentFlags().StringVar(&controllerOptions.BaseChartPath, "default-chart-path", "",
 		"A path relative to base-chart-path containing charts to be used when no ChartPath is specified by an IstioOperator resource, e.g. 1.1.0/istio")
+	cmd.PersistentFlags().IntVar(&controllerOptions.Concurrency, "concurrency", controllerOptions.Concurrency,
+		"The maximum number of components reconciled at the same time. 0 means no limit.")
+	cmd.PersistentFlags().DurationVar(&controllerOptions.ResyncPeriod, "resync-period", controllerOptions.ResyncPeriod,
+		"How long reconciles of an IstioOperator whose spec, profile, charts and operator version did not change are "+
+			"skipped before it is reconciled fully again. 0 means reconciles are never skipped.")
+	cmd.PersistentFlags().DurationVar(&controllerOptions.UpgradeTimeout, "upgrade-timeout", controllerOptions.UpgradeTimeout,
+		"How long a component may take to pass its health gates in an orchestrated upgrade before the upgrade is "+
+			"rolled back. It can be overridden for a component with components.<component>.spec.readinessTimeout in "+
+			"the IstioOperator spec. 0 means no limit.")
 }
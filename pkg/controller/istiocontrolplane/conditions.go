@@ -0,0 +1,160 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+)
+
+// Reasons set on the conditions of the IstioOperator and its components.
+const (
+	reasonReconciling       = "Reconciling"
+	reasonApplied           = "Applied"
+	reasonApplyFailed       = "ApplyFailed"
+	reasonWorkloadsReady    = "WorkloadsReady"
+	reasonWorkloadsPending  = "WorkloadsPending"
+	reasonComponentsFailed  = "ComponentsFailed"
+	reasonComponentsReady   = "ComponentsReady"
+	reasonComponentsPending = "ComponentsPending"
+)
+
+// workloadState is the readiness of the workloads of a component at the end of a reconcile.
+type workloadState struct {
+	ready int32
+	// notReady describes each workload which is not ready, with the reason.
+	notReady []string
+}
+
+// componentStatus returns the status of a component from its install status vs and the readiness of its workloads,
+// for the given generation of the IstioOperator. Condition transition times are kept from old, which may be nil.
+func componentStatus(vs *v1alpha1.InstallStatus_VersionStatus, ws workloadState, generation int64,
+	old *iop.ComponentStatus, now metav1.Time) *iop.ComponentStatus {
+	out := &iop.ComponentStatus{ReadyWorkloads: ws.ready, PendingWorkloads: int32(len(ws.notReady))}
+	if old != nil {
+		out.Conditions = append([]iop.Condition(nil), old.Conditions...)
+	}
+	set := func(t iop.ConditionType, status bool, reason, message string) {
+		out.Conditions = setCondition(out.Conditions, t, status, reason, message, generation, now)
+	}
+
+	if vs.Status == v1alpha1.InstallStatus_ERROR {
+		set(iop.ConditionReconciled, false, reasonApplyFailed, vs.Error)
+		set(iop.ConditionDegraded, true, reasonApplyFailed, vs.Error)
+		set(iop.ConditionReady, false, reasonApplyFailed, vs.Error)
+		set(iop.ConditionProgressing, false, reasonApplyFailed, vs.Error)
+		return out
+	}
+
+	set(iop.ConditionReconciled, true, reasonApplied, "")
+	set(iop.ConditionDegraded, false, reasonApplied, "")
+	total := ws.ready + out.PendingWorkloads
+	if out.PendingWorkloads != 0 {
+		msg := fmt.Sprintf("%d of %d workloads ready, waiting for %s", ws.ready, total, strings.Join(ws.notReady, ", "))
+		set(iop.ConditionReady, false, reasonWorkloadsPending, msg)
+		set(iop.ConditionProgressing, true, reasonWorkloadsPending, msg)
+		return out
+	}
+	msg := fmt.Sprintf("%d of %d workloads ready", ws.ready, total)
+	set(iop.ConditionReady, true, reasonWorkloadsReady, msg)
+	set(iop.ConditionProgressing, false, reasonWorkloadsReady, msg)
+	return out
+}
+
+// aggregateConditions returns conditions with the conditions of the IstioOperator as a whole set from the conditions
+// of its components. Drifted and Conflicted are cleared if set, since the reconcile applied the desired state.
+func aggregateConditions(conditions []iop.Condition, components map[string]*iop.ComponentStatus, generation int64,
+	now metav1.Time) []iop.Condition {
+	var failed, pending []string
+	for c, cs := range components {
+		if isTrue(cs.Conditions, iop.ConditionDegraded) {
+			failed = append(failed, c)
+		}
+		if !isTrue(cs.Conditions, iop.ConditionReady) {
+			pending = append(pending, c)
+		}
+	}
+	sort.Strings(failed)
+	sort.Strings(pending)
+
+	set := func(t iop.ConditionType, status bool, reason, message string) {
+		conditions = setCondition(conditions, t, status, reason, message, generation, now)
+	}
+	if len(failed) != 0 {
+		msg := "failed components: " + strings.Join(failed, ", ")
+		set(iop.ConditionReconciled, false, reasonComponentsFailed, msg)
+		set(iop.ConditionDegraded, true, reasonComponentsFailed, msg)
+	} else {
+		set(iop.ConditionReconciled, true, reasonApplied, "")
+		set(iop.ConditionDegraded, false, reasonApplied, "")
+	}
+	switch {
+	case len(pending) == 0:
+		set(iop.ConditionReady, true, reasonComponentsReady, "")
+		set(iop.ConditionProgressing, false, reasonComponentsReady, "")
+	case len(failed) != 0:
+		set(iop.ConditionReady, false, reasonComponentsFailed, "components not ready: "+strings.Join(pending, ", "))
+		set(iop.ConditionProgressing, false, reasonComponentsFailed, "failed components: "+strings.Join(failed, ", "))
+	default:
+		msg := "components not ready: " + strings.Join(pending, ", ")
+		set(iop.ConditionReady, false, reasonComponentsPending, msg)
+		set(iop.ConditionProgressing, true, reasonComponentsPending, msg)
+	}
+	for _, t := range []iop.ConditionType{iop.ConditionDrifted, iop.ConditionConflicted} {
+		if iop.GetCondition(conditions, t) != nil {
+			set(t, false, reasonApplied, "")
+		}
+	}
+	return conditions
+}
+
+// beginConditions returns conditions updated for the start of a reconcile of the given generation. Reconciled is
+// reset if the generation was not reconciled before, so that waiting for it does not succeed on stale status.
+func beginConditions(conditions []iop.Condition, observedGeneration, generation int64, now metav1.Time) []iop.Condition {
+	msg := fmt.Sprintf("reconciling generation %d", generation)
+	conditions = setCondition(conditions, iop.ConditionProgressing, true, reasonReconciling, msg, generation, now)
+	if observedGeneration != generation {
+		conditions = setCondition(conditions, iop.ConditionReconciled, false, reasonReconciling, msg, generation, now)
+	}
+	return conditions
+}
+
+func setCondition(conditions []iop.Condition, t iop.ConditionType, status bool, reason, message string,
+	generation int64, now metav1.Time) []iop.Condition {
+	cs := corev1.ConditionFalse
+	if status {
+		cs = corev1.ConditionTrue
+	}
+	return iop.SetCondition(conditions, iop.Condition{
+		Type:               t,
+		Status:             cs,
+		ObservedGeneration: generation,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func isTrue(conditions []iop.Condition, t iop.ConditionType) bool {
+	c := iop.GetCondition(conditions, t)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
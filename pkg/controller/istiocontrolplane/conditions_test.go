@@ -0,0 +1,213 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+)
+
+var (
+	healthy = &v1alpha1.InstallStatus_VersionStatus{Status: v1alpha1.InstallStatus_HEALTHY}
+	failed  = &v1alpha1.InstallStatus_VersionStatus{Status: v1alpha1.InstallStatus_ERROR, Error: "webhook denied"}
+)
+
+// conditionStatuses returns the status of each condition type, e.g. {Ready: True}.
+func conditionStatuses(conditions []iop.Condition) map[iop.ConditionType]corev1.ConditionStatus {
+	out := make(map[iop.ConditionType]corev1.ConditionStatus)
+	for _, c := range conditions {
+		out[c.Type] = c.Status
+	}
+	return out
+}
+
+func TestComponentStatus(t *testing.T) {
+	now := metav1.NewTime(time.Unix(1000, 0))
+	tests := []struct {
+		desc        string
+		vs          *v1alpha1.InstallStatus_VersionStatus
+		ws          workloadState
+		wantReason  string
+		wantStatus  map[iop.ConditionType]corev1.ConditionStatus
+		wantReady   int32
+		wantPending int32
+	}{
+		{
+			desc:       "ready",
+			vs:         healthy,
+			ws:         workloadState{ready: 2},
+			wantReason: reasonWorkloadsReady,
+			wantStatus: map[iop.ConditionType]corev1.ConditionStatus{
+				iop.ConditionReconciled:  corev1.ConditionTrue,
+				iop.ConditionReady:       corev1.ConditionTrue,
+				iop.ConditionProgressing: corev1.ConditionFalse,
+				iop.ConditionDegraded:    corev1.ConditionFalse,
+			},
+			wantReady: 2,
+		},
+		{
+			desc:       "pending",
+			vs:         healthy,
+			ws:         workloadState{ready: 1, notReady: []string{"Deployment/istio-system/istiod (0 of 1 updated replicas available)"}},
+			wantReason: reasonWorkloadsPending,
+			wantStatus: map[iop.ConditionType]corev1.ConditionStatus{
+				iop.ConditionReconciled:  corev1.ConditionTrue,
+				iop.ConditionReady:       corev1.ConditionFalse,
+				iop.ConditionProgressing: corev1.ConditionTrue,
+				iop.ConditionDegraded:    corev1.ConditionFalse,
+			},
+			wantReady:   1,
+			wantPending: 1,
+		},
+		{
+			desc:       "failed",
+			vs:         failed,
+			wantReason: reasonApplyFailed,
+			wantStatus: map[iop.ConditionType]corev1.ConditionStatus{
+				iop.ConditionReconciled:  corev1.ConditionFalse,
+				iop.ConditionReady:       corev1.ConditionFalse,
+				iop.ConditionProgressing: corev1.ConditionFalse,
+				iop.ConditionDegraded:    corev1.ConditionTrue,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := componentStatus(tt.vs, tt.ws, 3, nil, now)
+			if gs := conditionStatuses(got.Conditions); !equalStatuses(gs, tt.wantStatus) {
+				t.Errorf("conditions: got %v, want %v", gs, tt.wantStatus)
+			}
+			if got.ReadyWorkloads != tt.wantReady || got.PendingWorkloads != tt.wantPending {
+				t.Errorf("workloads: got %d ready, %d pending, want %d, %d", got.ReadyWorkloads, got.PendingWorkloads,
+					tt.wantReady, tt.wantPending)
+			}
+			ready := iop.GetCondition(got.Conditions, iop.ConditionReady)
+			if ready.Reason != tt.wantReason || ready.ObservedGeneration != 3 || !ready.LastTransitionTime.Equal(&now) {
+				t.Errorf("Ready condition: got %+v, want reason %s, generation 3, time %s", ready, tt.wantReason, now)
+			}
+		})
+	}
+}
+
+func TestComponentStatusTransitionTime(t *testing.T) {
+	t1 := metav1.NewTime(time.Unix(1000, 0))
+	t2 := metav1.NewTime(time.Unix(2000, 0))
+	pending := workloadState{notReady: []string{"Deployment/istio-system/istiod (not found)"}}
+
+	s := componentStatus(healthy, pending, 1, nil, t1)
+	s = componentStatus(healthy, pending, 2, s, t2)
+	ready := iop.GetCondition(s.Conditions, iop.ConditionReady)
+	if !ready.LastTransitionTime.Equal(&t1) || ready.ObservedGeneration != 2 {
+		t.Errorf("unchanged Ready condition: got time %s, generation %d, want %s, 2", ready.LastTransitionTime, ready.ObservedGeneration, t1)
+	}
+
+	s = componentStatus(healthy, workloadState{ready: 1}, 2, s, t2)
+	ready = iop.GetCondition(s.Conditions, iop.ConditionReady)
+	if ready.Status != corev1.ConditionTrue || !ready.LastTransitionTime.Equal(&t2) {
+		t.Errorf("changed Ready condition: got %s at %s, want True at %s", ready.Status, ready.LastTransitionTime, t2)
+	}
+	if len(s.Conditions) != 4 {
+		t.Errorf("got %d conditions, want 4", len(s.Conditions))
+	}
+}
+
+func TestAggregateConditions(t *testing.T) {
+	now := metav1.NewTime(time.Unix(1000, 0))
+	ready := componentStatus(healthy, workloadState{ready: 1}, 1, nil, now)
+	pending := componentStatus(healthy, workloadState{notReady: []string{"Deployment/istio-system/istiod (not found)"}}, 1, nil, now)
+	broken := componentStatus(failed, workloadState{}, 1, nil, now)
+	tests := []struct {
+		desc        string
+		components  map[string]*iop.ComponentStatus
+		wantStatus  map[iop.ConditionType]corev1.ConditionStatus
+		wantMessage string
+	}{
+		{
+			desc:       "no components",
+			components: map[string]*iop.ComponentStatus{},
+			wantStatus: map[iop.ConditionType]corev1.ConditionStatus{
+				iop.ConditionReconciled:  corev1.ConditionTrue,
+				iop.ConditionReady:       corev1.ConditionTrue,
+				iop.ConditionProgressing: corev1.ConditionFalse,
+				iop.ConditionDegraded:    corev1.ConditionFalse,
+			},
+		},
+		{
+			desc:        "pending",
+			components:  map[string]*iop.ComponentStatus{"Base": ready, "Pilot": pending},
+			wantMessage: "components not ready: Pilot",
+			wantStatus: map[iop.ConditionType]corev1.ConditionStatus{
+				iop.ConditionReconciled:  corev1.ConditionTrue,
+				iop.ConditionReady:       corev1.ConditionFalse,
+				iop.ConditionProgressing: corev1.ConditionTrue,
+				iop.ConditionDegraded:    corev1.ConditionFalse,
+			},
+		},
+		{
+			desc:        "failed",
+			components:  map[string]*iop.ComponentStatus{"Base": ready, "Pilot": pending, "Policy": broken},
+			wantMessage: "components not ready: Pilot, Policy",
+			wantStatus: map[iop.ConditionType]corev1.ConditionStatus{
+				iop.ConditionReconciled:  corev1.ConditionFalse,
+				iop.ConditionReady:       corev1.ConditionFalse,
+				iop.ConditionProgressing: corev1.ConditionFalse,
+				iop.ConditionDegraded:    corev1.ConditionTrue,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := aggregateConditions(nil, tt.components, 1, now)
+			if gs := conditionStatuses(got); !equalStatuses(gs, tt.wantStatus) {
+				t.Errorf("conditions: got %v, want %v", gs, tt.wantStatus)
+			}
+			if msg := iop.GetCondition(got, iop.ConditionReady).Message; msg != tt.wantMessage {
+				t.Errorf("Ready message: got %q, want %q", msg, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestBeginConditions(t *testing.T) {
+	now := metav1.NewTime(time.Unix(1000, 0))
+	reconciled := aggregateConditions(nil, nil, 1, now)
+
+	got := conditionStatuses(beginConditions(append([]iop.Condition(nil), reconciled...), 1, 1, now))
+	if got[iop.ConditionProgressing] != corev1.ConditionTrue || got[iop.ConditionReconciled] != corev1.ConditionTrue {
+		t.Errorf("same generation: got %v, want Progressing and Reconciled True", got)
+	}
+	got = conditionStatuses(beginConditions(append([]iop.Condition(nil), reconciled...), 1, 2, now))
+	if got[iop.ConditionProgressing] != corev1.ConditionTrue || got[iop.ConditionReconciled] != corev1.ConditionFalse {
+		t.Errorf("new generation: got %v, want Progressing True and Reconciled False", got)
+	}
+}
+
+func equalStatuses(a, b map[iop.ConditionType]corev1.ConditionStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
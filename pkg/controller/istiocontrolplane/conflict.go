@@ -0,0 +1,104 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/pkg/log"
+)
+
+const (
+	// AdoptKey is the IstioOperator annotation which, when set to "true", allows it to take over resources owned by
+	// another IstioOperator. Otherwise such an IstioOperator is not reconciled, and the Conflicted condition is set.
+	AdoptKey = MetadataNamespace + "/adopt"
+
+	// EventReasonOwnershipConflict is the reason of the Event recorded when resources are owned by another
+	// IstioOperator.
+	EventReasonOwnershipConflict = "OwnershipConflict"
+
+	reasonOwnershipConflict = "OwnershipConflict"
+
+	// maxConflictsListed is the maximum number of conflicting objects listed in the Conflicted condition.
+	maxConflictsListed = 10
+)
+
+// adopt reports whether instance may take over resources owned by another IstioOperator.
+func adopt(instance *iop.IstioOperator) bool {
+	s, ok := instance.GetAnnotations()[AdoptKey]
+	if !ok {
+		return false
+	}
+	a, err := strconv.ParseBool(s)
+	if err != nil {
+		log.Warnf("ignoring invalid value %q of annotation %s: %s", s, AdoptKey, err)
+		return false
+	}
+	return a
+}
+
+// conflictMessage describes the objects in conflict and their owners.
+func conflictMessage(conflict *helmreconciler.OwnershipConflictError) string {
+	var objects []string
+	for i, c := range conflict.Conflicts {
+		if i == maxConflictsListed {
+			objects = append(objects, fmt.Sprintf("and %d more", len(conflict.Conflicts)-maxConflictsListed))
+			break
+		}
+		owner := c.OwnerLabels[OwnerNameKey]
+		if ns := c.OwnerLabels[OwnerNamespaceKey]; ns != "" {
+			owner = ns + "/" + owner
+		}
+		objects = append(objects, fmt.Sprintf("%s (%s) owned by %s", c.Object, c.Component, owner))
+	}
+	return fmt.Sprintf("resources are owned by another IstioOperator, set annotation %s=true to adopt them: %s",
+		AdoptKey, strings.Join(objects, ", "))
+}
+
+// reportConflict records an Event and sets the Conflicted condition on instance, whose resources are owned by another
+// IstioOperator.
+func (r *ReconcileIstioOperator) reportConflict(instance *iop.IstioOperator,
+	conflict *helmreconciler.OwnershipConflictError) error {
+	msg := conflictMessage(conflict)
+	log.Warnf("not reconciling IstioOperator %s/%s: %s", instance.Namespace, instance.Name, msg)
+	r.recordEvent(instance, corev1.EventTypeWarning, EventReasonOwnershipConflict, msg)
+
+	// The reconcile started and may have updated the status, so it is fetched again.
+	current := &iop.IstioOperator{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name},
+		current); err != nil {
+		return err
+	}
+	if current.Status == nil {
+		current.Status = &iop.IstioOperatorStatus{}
+	}
+	now := metav1.Now()
+	for _, t := range []iop.ConditionType{iop.ConditionReconciled, iop.ConditionProgressing} {
+		current.Status.Conditions = setCondition(current.Status.Conditions, t, false, reasonOwnershipConflict, msg,
+			current.Generation, now)
+	}
+	current.Status.Conditions = setCondition(current.Status.Conditions, iop.ConditionConflicted, true,
+		reasonOwnershipConflict, msg, current.Generation, now)
+	return r.client.Status().Update(context.TODO(), current)
+}
@@ -0,0 +1,289 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mesh "istio.io/api/mesh/v1alpha1"
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/inventory"
+	"istio.io/operator/pkg/name"
+)
+
+func TestConflictMessage(t *testing.T) {
+	conflict := &helmreconciler.OwnershipConflictError{}
+	for i := 0; i < 12; i++ {
+		conflict.Conflicts = append(conflict.Conflicts, helmreconciler.OwnershipConflict{
+			Object:      fmt.Sprintf("ConfigMap/istio-system/cm-%02d", i),
+			Component:   "Pilot",
+			OwnerLabels: map[string]string{OwnerNameKey: "other", OwnerNamespaceKey: "istio-system"},
+		})
+	}
+	conflict.Conflicts[0].OwnerLabels = map[string]string{OwnerNameKey: "old"}
+	got := conflictMessage(conflict)
+	for _, want := range []string{
+		"set annotation " + AdoptKey + "=true",
+		"ConfigMap/istio-system/cm-00 (Pilot) owned by old, ",
+		"ConfigMap/istio-system/cm-09 (Pilot) owned by istio-system/other, and 2 more",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestIOPController_Conflict(t *testing.T) {
+	newIOP := func(name string) *iop.IstioOperator {
+		return &iop.IstioOperator{
+			Kind:       "IstioOperator",
+			ApiVersion: "install.istio.io/v1alpha1",
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"},
+			Spec: &v1alpha1.IstioOperatorSpec{
+				Profile:    "minimal",
+				MeshConfig: &mesh.MeshConfig{RootNamespace: "istio-system"},
+			},
+		}
+	}
+	first, second := newIOP("conflict-first"), newIOP("conflict-second")
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, first)
+	cl := fake.NewFakeClientWithScheme(s, first, second)
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
+	firstReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "istio-system", Name: first.Name}}
+	secondReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "istio-system", Name: second.Name}}
+
+	pilotOwner := func() string {
+		t.Helper()
+		pilot := &unstructured.Unstructured{}
+		pilot.SetAPIVersion("apps/v1")
+		pilot.SetKind("Deployment")
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "istio-system", Name: "istio-pilot"}, pilot); err != nil {
+			t.Fatal(err)
+		}
+		return pilot.GetLabels()[OwnerNameKey]
+	}
+	conflicted := func(key types.NamespacedName) *iop.Condition {
+		t.Helper()
+		instance := &iop.IstioOperator{}
+		if err := cl.Get(context.TODO(), key, instance); err != nil {
+			t.Fatal(err)
+		}
+		if instance.Status == nil {
+			return nil
+		}
+		return iop.GetCondition(instance.Status.Conditions, iop.ConditionConflicted)
+	}
+
+	if _, err := r.Reconcile(firstReq); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := pilotOwner(); got != first.Name {
+		t.Fatalf("istio-pilot owned by %q, want %q", got, first.Name)
+	}
+
+	// The second IstioOperator renders the same objects, so it is not reconciled.
+	res, err := r.Reconcile(secondReq)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if res.RequeueAfter != conflictRequeueInterval {
+		t.Errorf("got RequeueAfter %s, want %s", res.RequeueAfter, conflictRequeueInterval)
+	}
+	if got := pilotOwner(); got != first.Name {
+		t.Errorf("istio-pilot taken over by %q", got)
+	}
+	c := conflicted(secondReq.NamespacedName)
+	if c == nil || c.Status != corev1.ConditionTrue || !strings.Contains(c.Message, "owned by istio-system/"+first.Name) {
+		t.Errorf("Conflicted condition: got %+v, want True", c)
+	}
+	checkEvents(t, recorder, EventReasonOwnershipConflict)
+
+	// Adopting takes the objects over, after which the first IstioOperator is in conflict.
+	setAnnotation(t, cl, secondReq.NamespacedName, AdoptKey, "true")
+	if _, err := r.Reconcile(secondReq); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := pilotOwner(); got != second.Name {
+		t.Errorf("istio-pilot owned by %q, want %q", got, second.Name)
+	}
+	if c := conflicted(secondReq.NamespacedName); c == nil || c.Status != corev1.ConditionFalse {
+		t.Errorf("Conflicted condition: got %+v, want False", c)
+	}
+	if _, err := r.Reconcile(firstReq); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if c := conflicted(firstReq.NamespacedName); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("Conflicted condition: got %+v, want True", c)
+	}
+
+	// Disabling Pilot in the first IstioOperator does not prune the adopted objects still in its inventory.
+	if err := switchIstioOperatorProfile(cl, firstReq.NamespacedName, "empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(firstReq); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := pilotOwner(); got != second.Name {
+		t.Errorf("istio-pilot owned by %q, want %q", got, second.Name)
+	}
+}
+
+func TestIOPController_Revision(t *testing.T) {
+	stable := &iop.IstioOperator{
+		Kind:       "IstioOperator",
+		ApiVersion: "install.istio.io/v1alpha1",
+		ObjectMeta: metav1.ObjectMeta{Name: "revision-stable", Namespace: "istio-system"},
+		Spec: &v1alpha1.IstioOperatorSpec{
+			Profile:    "minimal",
+			MeshConfig: &mesh.MeshConfig{RootNamespace: "istio-system"},
+		},
+	}
+	canary := stable.DeepCopy()
+	canary.Name = "revision-canary"
+	canary.Spec.ResourceSuffix = "canary"
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, stable)
+	cl := fake.NewFakeClientWithScheme(s, stable, canary)
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
+
+	pilot := func(n string) *unstructured.Unstructured {
+		t.Helper()
+		d := &unstructured.Unstructured{}
+		d.SetAPIVersion("apps/v1")
+		d.SetKind("Deployment")
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "istio-system", Name: n}, d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// Both revisions are installed side by side without conflict.
+	for _, instance := range []*iop.IstioOperator{stable, canary} {
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "istio-system", Name: instance.Name}}
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("reconcile %s: %v", instance.Name, err)
+		}
+	}
+	if got := pilot("istio-pilot").GetLabels()[OwnerNameKey]; got != stable.Name {
+		t.Errorf("istio-pilot owned by %q, want %q", got, stable.Name)
+	}
+	d := pilot("istio-pilot-canary")
+	if got := d.GetLabels()[OwnerNameKey]; got != canary.Name {
+		t.Errorf("istio-pilot-canary owned by %q, want %q", got, canary.Name)
+	}
+	if got := d.GetLabels()[name.RevisionLabel]; got != "canary" {
+		t.Errorf("istio-pilot-canary has revision %q, want canary", got)
+	}
+
+	// Pruning the canary revision leaves the stable revision alone.
+	if err := switchIstioOperatorProfile(cl, types.NamespacedName{Namespace: "istio-system", Name: canary.Name},
+		"empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "istio-system",
+		Name: canary.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	pilot("istio-pilot")
+	err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "istio-system", Name: "istio-pilot-canary"}, d)
+	if err == nil {
+		t.Error("istio-pilot-canary not pruned")
+	}
+}
+
+func TestIOPController_SeparateInventories(t *testing.T) {
+	newIOP := func(name, profile string) *iop.IstioOperator {
+		return &iop.IstioOperator{
+			Kind:       "IstioOperator",
+			ApiVersion: "install.istio.io/v1alpha1",
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"},
+			Spec: &v1alpha1.IstioOperatorSpec{
+				Profile:    profile,
+				MeshConfig: &mesh.MeshConfig{RootNamespace: "istio-system"},
+			},
+		}
+	}
+	// The second IstioOperator renders no components, so Pilot is disabled in it.
+	first, second := newIOP("inventory-first", "minimal"), newIOP("inventory-second", "empty")
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, first)
+	cl := fake.NewFakeClientWithScheme(s, first, second)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{},
+		EventRecorder: record.NewFakeRecorder(1000)}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
+	pilotExists := func() bool {
+		t.Helper()
+		err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "istio-system", Name: "istio-pilot"},
+			&appsv1.Deployment{})
+		if err != nil && !errors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	for _, instance := range []*iop.IstioOperator{first, second} {
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "istio-system", Name: instance.Name}}
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("reconcile %s: %v", instance.Name, err)
+		}
+	}
+	if !pilotExists() {
+		t.Fatalf("istio-pilot of %s pruned by %s", first.Name, second.Name)
+	}
+
+	// Deleting the second IstioOperator prunes all of its inventories, but none of the first.
+	deleted := &iop.IstioOperator{}
+	key := types.NamespacedName{Namespace: "istio-system", Name: second.Name}
+	if err := cl.Get(context.TODO(), key, deleted); err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	deleted.SetDeletionTimestamp(&now)
+	if err := cl.Update(context.TODO(), deleted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !pilotExists() {
+		t.Errorf("istio-pilot of %s pruned when deleting %s", first.Name, second.Name)
+	}
+	inv, err := inventory.NewOwnerConfigMapStore(cl, "istio-system", "istio-system/"+first.Name, "").Get(
+		name.PilotComponentName)
+	if err != nil || inv == nil {
+		t.Errorf("Pilot inventory of %s deleted: %v", first.Name, err)
+	}
+}
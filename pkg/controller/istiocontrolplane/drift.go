@@ -0,0 +1,556 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/pkg/log"
+)
+
+// DriftPolicy is how the controller responds to changes made to owned resources outside of the operator, e.g. with
+// kubectl edit.
+type DriftPolicy string
+
+const (
+	// DriftPolicyRevert reconciles the IstioOperator, which applies the desired state again.
+	DriftPolicyRevert DriftPolicy = "Revert"
+	// DriftPolicyReport records an Event and sets the Drifted condition, but leaves the change in place.
+	DriftPolicyReport DriftPolicy = "Report"
+	// DriftPolicyIgnore does nothing.
+	DriftPolicyIgnore DriftPolicy = "Ignore"
+
+	// DriftPolicyKey is the IstioOperator annotation setting the DriftPolicy of all components.
+	DriftPolicyKey = MetadataNamespace + "/drift-policy"
+	// ComponentDriftPolicyKey is the IstioOperator annotation setting the DriftPolicy of individual components, as a
+	// comma separated list of component=policy, e.g. "Pilot=Report,IngressGateways=Ignore".
+	ComponentDriftPolicyKey = MetadataNamespace + "/component-drift-policy"
+
+	// defaultDriftPolicy is the DriftPolicy of components without a policy set.
+	defaultDriftPolicy = DriftPolicyRevert
+
+	// maxDriftReverts is the maximum number of times the drift of an object is reverted within driftRevertWindow.
+	// Further drift is reported instead, so that the controller does not fight another controller over the object.
+	maxDriftReverts   = 3
+	driftRevertWindow = 10 * time.Minute
+)
+
+// Reasons of the Events recorded for drift.
+const (
+	EventReasonDriftDetected        = "DriftDetected"
+	EventReasonDriftReverted        = "DriftReverted"
+	EventReasonDriftRevertThrottled = "DriftRevertThrottled"
+
+	reasonResourcesDrifted = "ResourcesDrifted"
+)
+
+// defaultDriftDetector tracks the desired state of the resources of all IstioOperators reconciled by the controller.
+var defaultDriftDetector = newDriftDetector()
+
+// desiredObject is the last rendered state of an owned object.
+type desiredObject struct {
+	owner     types.NamespacedName
+	component string
+	policy    DriftPolicy
+	object    *unstructured.Unstructured
+}
+
+// objectDrift is a change made to an owned object outside of the operator.
+type objectDrift struct {
+	object    string
+	component string
+	policy    DriftPolicy
+	// throttled is set if the policy is DriftPolicyRevert, but the object was reverted too often recently.
+	throttled bool
+	// fields are the paths of the fields which differ from the desired state.
+	fields []string
+	// deleted is set if the object was deleted.
+	deleted bool
+}
+
+func (d objectDrift) String() string {
+	if d.deleted {
+		return fmt.Sprintf("%s (%s): deleted", d.object, d.component)
+	}
+	return fmt.Sprintf("%s (%s): %s", d.object, d.component, strings.Join(d.fields, ", "))
+}
+
+// driftDetector compares updated owned objects against their last rendered state and remembers the drift for the
+// next reconcile of their owner.
+type driftDetector struct {
+	mu sync.Mutex
+	// desired is the desired state of each owned object, keyed by objectKey.
+	desired map[string]*desiredObject
+	// reverts are the times the drift of each object was reverted within driftRevertWindow, keyed by objectKey.
+	reverts map[string][]time.Time
+	// drifted is the drift which was not handled yet for each owner, keyed by objectKey.
+	drifted map[types.NamespacedName]map[string]objectDrift
+	// now returns the current time, overridden in tests.
+	now func() time.Time
+}
+
+func newDriftDetector() *driftDetector {
+	return &driftDetector{
+		desired: make(map[string]*desiredObject),
+		reverts: make(map[string][]time.Time),
+		drifted: make(map[types.NamespacedName]map[string]objectDrift),
+		now:     time.Now,
+	}
+}
+
+// record sets the desired state of obj, owned by the given IstioOperator.
+func (d *driftDetector) record(owner types.NamespacedName, component string, policy DriftPolicy,
+	obj *unstructured.Unstructured) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.desired[objectKey(obj)] = &desiredObject{owner: owner, component: component, policy: policy, object: obj}
+}
+
+// forget forgets the desired state of obj, e.g. because it was pruned.
+func (d *driftDetector) forget(obj *unstructured.Unstructured) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.desired, objectKey(obj))
+}
+
+// forgetOwner forgets the desired state and the drift of all objects of owner. Drift is not detected for them until
+// their desired state is recorded again, e.g. while they are being reconciled.
+func (d *driftDetector) forgetOwner(owner types.NamespacedName) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, do := range d.desired {
+		if do.owner == owner {
+			delete(d.desired, k)
+		}
+	}
+	delete(d.drifted, owner)
+}
+
+// detect compares obj against its desired state. It returns true if obj drifted and its owner should be reconciled
+// to handle the drift according to the DriftPolicy of its component.
+func (d *driftDetector) detect(obj runtime.Object) bool {
+	live, err := toUnstructured(obj)
+	if err != nil {
+		return false
+	}
+	key := objectKey(live)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	do, ok := d.desired[key]
+	if !ok || do.object.GetAPIVersion() != live.GetAPIVersion() {
+		return false
+	}
+	fields := driftedFields(do.object.Object, live.Object)
+	if len(fields) == 0 || do.policy == DriftPolicyIgnore {
+		return false
+	}
+	d.addDrift(key, do, objectDrift{object: objectRef(live), component: do.component, policy: do.policy,
+		fields: fields})
+	return true
+}
+
+// detectDeleted handles the deletion of obj. It returns true if obj has a desired state and its owner should be
+// reconciled to handle the deletion according to the DriftPolicy of its component, or if no desired state is known
+// for its owner yet, e.g. since the controller started. Objects which are no longer desired, e.g. pruned ones, do not
+// reconcile their owner.
+func (d *driftDetector) detectDeleted(obj runtime.Object) bool {
+	deleted, err := toUnstructured(obj)
+	if err != nil {
+		return false
+	}
+	key := objectKey(deleted)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	do, ok := d.desired[key]
+	if !ok {
+		labels := deleted.GetLabels()
+		return !d.knowsOwner(types.NamespacedName{Namespace: labels[OwnerNamespaceKey], Name: labels[OwnerNameKey]})
+	}
+	if do.policy == DriftPolicyIgnore {
+		return false
+	}
+	d.addDrift(key, do, objectDrift{object: objectRef(deleted), component: do.component, policy: do.policy,
+		deleted: true})
+	return true
+}
+
+// addDrift remembers the drift of the object with the given key for its owner. Reverting is throttled if the object
+// was reverted too often recently. d.mu must be held.
+func (d *driftDetector) addDrift(key string, do *desiredObject, drift objectDrift) {
+	if drift.policy == DriftPolicyRevert && !d.allowRevert(key) {
+		drift.policy, drift.throttled = DriftPolicyReport, true
+	}
+	log.Infof("drift detected on %s, policy %s", drift, drift.policy)
+	if d.drifted[do.owner] == nil {
+		d.drifted[do.owner] = make(map[string]objectDrift)
+	}
+	d.drifted[do.owner][key] = drift
+}
+
+// knowsOwner reports whether the desired state of any object of owner is known. d.mu must be held.
+func (d *driftDetector) knowsOwner(owner types.NamespacedName) bool {
+	for _, do := range d.desired {
+		if do.owner == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// take returns the drift of the objects of owner which was not handled yet, sorted by object.
+func (d *driftDetector) take(owner types.NamespacedName) []objectDrift {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []objectDrift
+	for _, drift := range d.drifted[owner] {
+		out = append(out, drift)
+	}
+	delete(d.drifted, owner)
+	sort.Slice(out, func(i, j int) bool { return out[i].object < out[j].object })
+	return out
+}
+
+// allowRevert reports whether the drift of the object with the given key may be reverted now, and if so counts the
+// revert. d.mu must be held.
+func (d *driftDetector) allowRevert(key string) bool {
+	now := d.now()
+	var recent []time.Time
+	for _, t := range d.reverts[key] {
+		if now.Sub(t) < driftRevertWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= maxDriftReverts {
+		d.reverts[key] = recent
+		return false
+	}
+	d.reverts[key] = append(recent, now)
+	return true
+}
+
+// driftPolicies returns the DriftPolicy of all components and the policies of individual components set in the
+// annotations of instance. Invalid policies are logged and ignored.
+func driftPolicies(instance *iop.IstioOperator) (DriftPolicy, map[string]DriftPolicy) {
+	parse := func(s string) (DriftPolicy, bool) {
+		switch p := DriftPolicy(strings.TrimSpace(s)); p {
+		case DriftPolicyRevert, DriftPolicyReport, DriftPolicyIgnore:
+			return p, true
+		default:
+			log.Warnf("ignoring invalid drift policy %q, must be one of %s, %s or %s", s, DriftPolicyRevert,
+				DriftPolicyReport, DriftPolicyIgnore)
+			return "", false
+		}
+	}
+	annotations := instance.GetAnnotations()
+	def := defaultDriftPolicy
+	if s, ok := annotations[DriftPolicyKey]; ok {
+		if p, ok := parse(s); ok {
+			def = p
+		}
+	}
+	components := make(map[string]DriftPolicy)
+	for _, kv := range strings.Split(annotations[ComponentDriftPolicyKey], ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			log.Warnf("ignoring invalid component drift policy %q, must be component=policy", kv)
+			continue
+		}
+		if p, ok := parse(parts[1]); ok {
+			components[strings.TrimSpace(parts[0])] = p
+		}
+	}
+	return def, components
+}
+
+// driftRecordingListener is a RenderingListener which records the desired state of the resources of an
+// IstioOperator with the driftDetector.
+type driftRecordingListener struct {
+	*helmreconciler.DefaultRenderingListener
+	detector   *driftDetector
+	owner      types.NamespacedName
+	policy     DriftPolicy
+	components map[string]DriftPolicy
+}
+
+// newDriftRecordingListener returns a driftRecordingListener for instance, recording with detector.
+func newDriftRecordingListener(instance *iop.IstioOperator, detector *driftDetector) helmreconciler.RenderingListener {
+	policy, components := driftPolicies(instance)
+	return &driftRecordingListener{
+		DefaultRenderingListener: &helmreconciler.DefaultRenderingListener{},
+		detector:                 detector,
+		owner:                    types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name},
+		policy:                   policy,
+		components:               components,
+	}
+}
+
+// BeginReconcile forgets the desired state of the previous reconcile, so that updates made while reconciling are not
+// detected as drift.
+func (l *driftRecordingListener) BeginReconcile(_ runtime.Object) error {
+	l.detector.forgetOwner(l.owner)
+	return nil
+}
+
+// BeginDelete forgets the desired state of all resources.
+func (l *driftRecordingListener) BeginDelete(_ runtime.Object) error {
+	l.detector.forgetOwner(l.owner)
+	return nil
+}
+
+// BeginResource records a copy of obj as its desired state.
+func (l *driftRecordingListener) BeginResource(chart string, obj runtime.Object) (runtime.Object, error) {
+	u, err := toUnstructured(obj)
+	if err != nil {
+		return obj, err
+	}
+	policy, ok := l.components[chart]
+	if !ok {
+		policy = l.policy
+	}
+	l.detector.record(l.owner, chart, policy, u.DeepCopy())
+	return obj, nil
+}
+
+// ResourceDeleted forgets the desired state of the pruned object.
+func (l *driftRecordingListener) ResourceDeleted(deleted runtime.Object) error {
+	if u, err := toUnstructured(deleted); err == nil {
+		l.detector.forget(u)
+	}
+	return nil
+}
+
+// reportDrift records Events for the drift of the objects of instance which is not reverted, and sets the Drifted
+// condition on instance. It returns true if some of the drift is to be reverted, in which case instance needs to be
+// reconciled and the condition is not set.
+func (r *ReconcileIstioOperator) reportDrift(instance *iop.IstioOperator, drifts []objectDrift) (bool, error) {
+	revert := false
+	var reported []string
+	for _, d := range drifts {
+		switch {
+		case d.policy == DriftPolicyRevert:
+			revert = true
+		case d.throttled:
+			reported = append(reported, d.object)
+			r.recordEvent(instance, corev1.EventTypeWarning, EventReasonDriftRevertThrottled,
+				fmt.Sprintf("%s: reverted %d times in %s, not reverting", d, maxDriftReverts, driftRevertWindow))
+		default:
+			reported = append(reported, d.object)
+			r.recordEvent(instance, corev1.EventTypeWarning, EventReasonDriftDetected, d.String())
+		}
+	}
+	if revert || len(reported) == 0 {
+		return revert, nil
+	}
+	status := instance.Status
+	if status == nil {
+		status = &iop.IstioOperatorStatus{}
+	}
+	msg := fmt.Sprintf("changed outside the operator: %s", strings.Join(reported, ", "))
+	status.Conditions = setCondition(status.Conditions, iop.ConditionDrifted, true, reasonResourcesDrifted, msg,
+		instance.Generation, metav1.Now())
+	instance.Status = status
+	return false, r.client.Status().Update(context.TODO(), instance)
+}
+
+func (r *ReconcileIstioOperator) recordEvent(instance *iop.IstioOperator, eventType, reason, message string) {
+	if r.factory == nil || r.factory.EventRecorder == nil {
+		return
+	}
+	r.factory.EventRecorder.Event(instance, eventType, reason, message)
+}
+
+// driftedFields returns the paths of the fields set in desired which differ in live. Fields populated by the server,
+// such as the status and metadata other than labels and annotations, are ignored.
+func driftedFields(desired, live map[string]interface{}) []string {
+	desired = filterComparedFields(desired)
+	live = filterComparedFields(live)
+	var out []string
+	diffValues("", desired, live, &out)
+	sort.Strings(out)
+	return out
+}
+
+// filterComparedFields returns a shallow copy of obj with only the fields which are compared for drift.
+func filterComparedFields(obj map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for k, v := range obj {
+		switch k {
+		// stringData is write-only, the server merges it into data.
+		case "status", "stringData":
+		case "metadata":
+			md, _ := v.(map[string]interface{})
+			filtered := make(map[string]interface{})
+			for _, f := range []string{"labels", "annotations"} {
+				if fv, ok := md[f]; ok {
+					filtered[f] = fv
+				}
+			}
+			if a, ok := filtered["annotations"].(map[string]interface{}); ok {
+				a = copyMap(a)
+				delete(a, corev1.LastAppliedConfigAnnotation)
+				filtered["annotations"] = a
+			}
+			out[k] = filtered
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// diffValues appends the paths below path at which live differs from desired to out. Maps in live may have
+// additional keys, since the server populates defaults. Null values in desired are not compared. Resource quantities
+// are compared by value, since the server returns them in canonical form, e.g. 2Gi for 2048Mi.
+func diffValues(path string, desired, live interface{}, out *[]string) {
+	switch dv := desired.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		lv, ok := live.(map[string]interface{})
+		if !ok && live != nil {
+			*out = append(*out, path)
+			return
+		}
+		// A missing map only differs if desired sets some field in it.
+		for k, v := range dv {
+			diffValues(joinPath(path, k), v, lv[k], out)
+		}
+	case []interface{}:
+		lv, ok := live.([]interface{})
+		if !ok || len(lv) != len(dv) {
+			if len(dv) != 0 || len(lv) != 0 {
+				*out = append(*out, path)
+			}
+			return
+		}
+		for i := range dv {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), dv[i], lv[i], out)
+		}
+	default:
+		if !scalarEqual(dv, live) && !(isQuantityPath(path) && quantityEqual(dv, live)) {
+			*out = append(*out, path)
+		}
+	}
+}
+
+// isQuantityPath reports whether the field at path holds a resource quantity, i.e. is a resource in requests, limits,
+// a resource quota or a capacity, or an emptyDir size limit.
+func isQuantityPath(path string) bool {
+	fields := strings.Split(path, ".")
+	if len(fields) >= 2 {
+		switch fields[len(fields)-2] {
+		case "requests", "limits", "hard", "capacity":
+			return true
+		}
+	}
+	return fields[len(fields)-1] == "sizeLimit"
+}
+
+// quantityEqual reports whether a and b are equal resource quantities.
+func quantityEqual(a, b interface{}) bool {
+	aq, ok := toQuantity(a)
+	if !ok {
+		return false
+	}
+	bq, ok := toQuantity(b)
+	return ok && aq.Cmp(bq) == 0
+}
+
+func toQuantity(v interface{}) (resource.Quantity, bool) {
+	s, ok := v.(string)
+	if !ok {
+		f, ok := toFloat(v)
+		if !ok {
+			return resource.Quantity{}, false
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	q, err := resource.ParseQuantity(s)
+	return q, err == nil
+}
+
+// scalarEqual compares scalars, treating all numbers as float64 since the same number may be decoded as an int64 or
+// a float64.
+func scalarEqual(a, b interface{}) bool {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		return ok && af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// objectKey identifies obj independently of its API version.
+func objectKey(obj *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s", obj.GroupVersionKind().GroupKind(), objectRef(obj))
+}
+
+// objectRef returns obj as Kind/namespace/name, or Kind/name if it is not namespaced.
+func objectRef(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", obj.GetKind(), obj.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+}
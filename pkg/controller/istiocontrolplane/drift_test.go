@@ -0,0 +1,424 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mesh "istio.io/api/mesh/v1alpha1"
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+func TestDriftedFields(t *testing.T) {
+	desired := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+  labels:
+    app: pilot
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: "{}"
+spec:
+  replicas: 1
+  template:
+    metadata:
+      creationTimestamp: null
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.4
+        args: ["discovery"]
+`
+	tests := []struct {
+		desc string
+		live string
+		want []string
+	}{
+		{
+			desc: "server populated fields",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+  resourceVersion: "42"
+  generation: 3
+  labels:
+    app: pilot
+  annotations:
+    deployment.kubernetes.io/revision: "2"
+spec:
+  replicas: 1.0
+  progressDeadlineSeconds: 600
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.4
+        args: ["discovery"]
+        imagePullPolicy: IfNotPresent
+status:
+  replicas: 1
+`,
+		},
+		{
+			desc: "edited",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+  labels:
+    app: pilot-edited
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"edited": true}'
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.4
+        args: ["discovery", "--log_output_level=debug"]
+`,
+			want: []string{"metadata.labels.app", "spec.replicas", "spec.template.spec.containers[0].args"},
+		},
+		{
+			desc: "removed",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.4
+        args: ["discovery"]
+`,
+			want: []string{"metadata.labels.app", "spec.replicas"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := driftedFields(objecttest.Unstructured(t, desired).Object, objecttest.Unstructured(t, tt.live).Object)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriftedFieldsQuantities(t *testing.T) {
+	desired := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        resources:
+          requests:
+            cpu: 500m
+            memory: 2048Mi
+          limits:
+            cpu: 2000m
+            memory: 1024Mi
+`
+	tests := []struct {
+		desc string
+		live string
+		want []string
+	}{
+		{
+			desc: "canonical form",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        resources:
+          requests:
+            cpu: 500m
+            memory: 2Gi
+          limits:
+            cpu: "2"
+            memory: 1Gi
+`,
+		},
+		{
+			desc: "edited",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        resources:
+          requests:
+            cpu: 100m
+            memory: 2Gi
+          limits:
+            cpu: 2
+            memory: 1Gi
+`,
+			want: []string{"spec.template.spec.containers[0].resources.requests.cpu"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := driftedFields(objecttest.Unstructured(t, desired).Object, objecttest.Unstructured(t, tt.live).Object)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriftPolicies(t *testing.T) {
+	tests := []struct {
+		desc           string
+		annotations    map[string]string
+		wantPolicy     DriftPolicy
+		wantComponents map[string]DriftPolicy
+	}{
+		{
+			desc:           "default",
+			wantPolicy:     DriftPolicyRevert,
+			wantComponents: map[string]DriftPolicy{},
+		},
+		{
+			desc: "per component",
+			annotations: map[string]string{
+				DriftPolicyKey:          "Report",
+				ComponentDriftPolicyKey: "Pilot=Ignore, IngressGateways = Revert",
+			},
+			wantPolicy:     DriftPolicyReport,
+			wantComponents: map[string]DriftPolicy{"Pilot": DriftPolicyIgnore, "IngressGateways": DriftPolicyRevert},
+		},
+		{
+			desc: "invalid",
+			annotations: map[string]string{
+				DriftPolicyKey:          "Fix",
+				ComponentDriftPolicyKey: "Pilot,Policy=Maybe,Galley=Report",
+			},
+			wantPolicy:     DriftPolicyRevert,
+			wantComponents: map[string]DriftPolicy{"Galley": DriftPolicyReport},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			instance := &iop.IstioOperator{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			policy, components := driftPolicies(instance)
+			if policy != tt.wantPolicy {
+				t.Errorf("got policy %s, want %s", policy, tt.wantPolicy)
+			}
+			if !reflect.DeepEqual(components, tt.wantComponents) {
+				t.Errorf("got component policies %v, want %v", components, tt.wantComponents)
+			}
+		})
+	}
+}
+
+func TestDriftDetector(t *testing.T) {
+	owner := types.NamespacedName{Namespace: "istio-system", Name: "iop"}
+	cm := func(data string) *unstructured.Unstructured {
+		return objecttest.Unstructured(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: istio
+  namespace: istio-system
+data:
+  mesh: `+data)
+	}
+	now := time.Unix(1000, 0)
+	d := newDriftDetector()
+	d.now = func() time.Time { return now }
+
+	if d.detect(cm("edited")) {
+		t.Error("drift detected without desired state")
+	}
+	d.record(owner, "Pilot", DriftPolicyRevert, cm("desired"))
+	if d.detect(cm("desired")) {
+		t.Error("drift detected for desired state")
+	}
+
+	// Reverts are throttled after maxDriftReverts within driftRevertWindow.
+	for i := 0; i <= maxDriftReverts; i++ {
+		if !d.detect(cm("edited")) {
+			t.Fatalf("%d: no drift detected", i)
+		}
+		drifts := d.take(owner)
+		if len(drifts) != 1 {
+			t.Fatalf("%d: got %d drifts, want 1", i, len(drifts))
+		}
+		wantThrottled := i == maxDriftReverts
+		if drifts[0].throttled != wantThrottled || (drifts[0].policy == DriftPolicyRevert) == wantThrottled {
+			t.Errorf("%d: got policy %s, throttled %v", i, drifts[0].policy, drifts[0].throttled)
+		}
+		if got, want := drifts[0].String(), "ConfigMap/istio-system/istio (Pilot): data.mesh"; got != want {
+			t.Errorf("%d: got %q, want %q", i, got, want)
+		}
+	}
+	now = now.Add(driftRevertWindow)
+	if !d.detect(cm("edited")) {
+		t.Fatal("no drift detected")
+	}
+	if drifts := d.take(owner); drifts[0].throttled {
+		t.Error("revert throttled after driftRevertWindow")
+	}
+
+	d.record(owner, "Pilot", DriftPolicyIgnore, cm("desired"))
+	if d.detect(cm("edited")) {
+		t.Error("ignored drift detected")
+	}
+	d.record(owner, "Pilot", DriftPolicyReport, cm("desired"))
+	d.forgetOwner(owner)
+	if d.detect(cm("edited")) {
+		t.Error("drift detected after forgetting owner")
+	}
+}
+
+func TestIOPController_Drift(t *testing.T) {
+	key := types.NamespacedName{Name: "drift-istiocontrolplane", Namespace: "istio-system"}
+	instance := &iop.IstioOperator{
+		Kind:       "IstioOperator",
+		ApiVersion: "install.istio.io/v1alpha1",
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Annotations: map[string]string{ComponentDriftPolicyKey: "Base=Report"},
+		},
+		Spec: &v1alpha1.IstioOperatorSpec{
+			Profile:    "minimal",
+			MeshConfig: &mesh.MeshConfig{RootNamespace: "istio-system"},
+		},
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, instance)
+	cl := fake.NewFakeClientWithScheme(s, instance)
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
+	req := reconcile.Request{NamespacedName: key}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	// edit changes an owned object and returns whether the update event triggers a reconcile.
+	edit := func(obj *unstructured.Unstructured, path []string, value string) bool {
+		t.Helper()
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := unstructured.SetNestedField(obj.Object, value, path...); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Update(context.TODO(), obj); err != nil {
+			t.Fatal(err)
+		}
+		return ownedResourcePredicates.Update(event.UpdateEvent{MetaNew: obj, ObjectNew: obj})
+	}
+	get := func(obj *unstructured.Unstructured, path []string) string {
+		t.Helper()
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, obj); err != nil {
+			t.Fatal(err)
+		}
+		v, _, _ := unstructured.NestedString(obj.Object, path...)
+		return v
+	}
+
+	// Pilot uses the default policy, which reverts the drift.
+	pilot := &unstructured.Unstructured{}
+	pilot.SetAPIVersion("apps/v1")
+	pilot.SetKind("Deployment")
+	pilot.SetNamespace("istio-system")
+	pilot.SetName("istio-pilot")
+	label := []string{"metadata", "labels", "app"}
+	want := get(pilot, label)
+	if !edit(pilot, label, "edited") {
+		t.Fatal("drift of istio-pilot not detected")
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := get(pilot, label); got != want {
+		t.Errorf("istio-pilot label not reverted: got %q, want %q", got, want)
+	}
+	checkEvents(t, recorder, EventReasonDriftReverted)
+
+	// Base reports the drift.
+	sa := &unstructured.Unstructured{}
+	sa.SetAPIVersion("v1")
+	sa.SetKind("ServiceAccount")
+	sa.SetNamespace("istio-system")
+	sa.SetName("istio-reader-service-account")
+	if !edit(sa, label, "edited") {
+		t.Fatal("drift of istio-reader-service-account not detected")
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := get(sa, label); got != "edited" {
+		t.Errorf("reported drift reverted: got label %q", got)
+	}
+	checkEvents(t, recorder, EventReasonDriftDetected)
+	got := &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if c := iop.GetCondition(got.Status.Conditions, iop.ConditionDrifted); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("Drifted condition: got %v, want True", c)
+	}
+}
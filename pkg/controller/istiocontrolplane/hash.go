@@ -0,0 +1,159 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helm"
+	"istio.io/operator/pkg/util"
+	binversion "istio.io/operator/version"
+	"istio.io/pkg/version"
+)
+
+// inputHash returns a hash of everything the resources of instance are rendered and applied from: the spec merged
+// with its profile, the profile files, the charts, the operator version and the annotations of the operator, e.g.
+// the paused components.
+func inputHash(instance *iop.IstioOperator, merged *v1alpha1.IstioOperatorSpec) (string, error) {
+	h := sha256.New()
+	write := func(field, value string) {
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00%s", field, len(value), value)
+	}
+
+	spec, err := util.MarshalWithJSONPB(merged)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal the merged spec: %s", err)
+	}
+	write("spec", spec)
+
+	profiles := []string{merged.Profile}
+	if !helm.IsDefaultProfile(merged.Profile) {
+		dfn, err := helm.DefaultFilenameForProfile(merged.Profile)
+		if err != nil {
+			return "", err
+		}
+		profiles = append(profiles, dfn)
+	}
+	for _, p := range profiles {
+		y, err := helm.ReadProfileYAML(p)
+		if err != nil {
+			return "", fmt.Errorf("could not read the profile %s: %s", p, err)
+		}
+		write("profile", y)
+	}
+
+	charts, err := helm.ChartsDigest(merged.InstallPackagePath)
+	if err != nil {
+		return "", fmt.Errorf("could not read the charts: %s", err)
+	}
+	write("charts", charts)
+
+	write("version", binversion.OperatorBinaryVersion.String()+" "+version.Info.String())
+
+	var keys []string
+	for k := range instance.GetAnnotations() {
+		if strings.HasPrefix(k, MetadataNamespace+"/") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write(k, instance.GetAnnotations()[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// defaultSyncTracker tracks the last full reconcile of all IstioOperators reconciled by the controller.
+var defaultSyncTracker = newSyncTracker()
+
+// syncRecord is a successful full reconcile.
+type syncRecord struct {
+	hash string
+	time time.Time
+}
+
+// syncTracker remembers the inputs and the time of the last successful full reconcile of each IstioOperator, to
+// skip reconciles which would not change anything. It is kept in memory, so that every IstioOperator is fully
+// reconciled once after the controller starts, which also records the desired state for drift detection.
+type syncTracker struct {
+	mu      sync.Mutex
+	records map[types.NamespacedName]syncRecord
+	// now returns the current time, overridden in tests.
+	now func() time.Time
+}
+
+func newSyncTracker() *syncTracker {
+	return &syncTracker{
+		records: make(map[types.NamespacedName]syncRecord),
+		now:     time.Now,
+	}
+}
+
+// upToDate reports whether key was fully reconciled with the inputs given by hash less than resyncPeriod ago. If so,
+// it also returns the time until the next full reconcile is due. A resyncPeriod of 0 is never up to date.
+func (s *syncTracker) upToDate(key types.NamespacedName, hash string, resyncPeriod time.Duration) (time.Duration,
+	bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[key]
+	if !ok || rec.hash != hash {
+		return 0, false
+	}
+	remaining := resyncPeriod - s.now().Sub(rec.time)
+	return remaining, remaining > 0
+}
+
+// synced records a successful full reconcile of key with the inputs given by hash.
+func (s *syncTracker) synced(key types.NamespacedName, hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = syncRecord{hash: hash, time: s.now()}
+}
+
+// forget forgets the last full reconcile of key, so that it is fully reconciled next time, e.g. because the last
+// reconcile failed.
+func (s *syncTracker) forget(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+}
+
+// setReconciledHash sets the ReconciledHash of the IstioOperator with the given key, unless it is already set to
+// hash.
+func (r *ReconcileIstioOperator) setReconciledHash(key types.NamespacedName, hash string) error {
+	instance := &iop.IstioOperator{}
+	if err := r.client.Get(context.TODO(), key, instance); err != nil {
+		return err
+	}
+	if instance.Status == nil {
+		instance.Status = &iop.IstioOperatorStatus{}
+	}
+	if instance.Status.ReconciledHash == hash {
+		return nil
+	}
+	instance.Status.ReconciledHash = hash
+	return r.client.Status().Update(context.TODO(), instance)
+}
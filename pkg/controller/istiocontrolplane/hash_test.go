@@ -0,0 +1,255 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mesh "istio.io/api/mesh/v1alpha1"
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+)
+
+func TestInputHash(t *testing.T) {
+	newIOP := func(profile string, annotations map[string]string) *iop.IstioOperator {
+		return &iop.IstioOperator{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       &v1alpha1.IstioOperatorSpec{Profile: profile},
+		}
+	}
+	hash := func(instance *iop.IstioOperator) string {
+		t.Helper()
+		merged, err := helmreconciler.MergeIOPSWithProfile(instance.Spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := inputHash(instance, merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	base := hash(newIOP("minimal", nil))
+	tests := []struct {
+		desc     string
+		instance *iop.IstioOperator
+		changed  bool
+	}{
+		{
+			desc:     "unchanged",
+			instance: newIOP("minimal", nil),
+		},
+		{
+			desc:     "other annotation",
+			instance: newIOP("minimal", map[string]string{"example.com/note": "x"}),
+		},
+		{
+			desc:     "profile",
+			instance: newIOP("default", nil),
+			changed:  true,
+		},
+		{
+			desc:     "operator annotation",
+			instance: newIOP("minimal", map[string]string{PausedComponentsKey: "Pilot"}),
+			changed:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := hash(tt.instance) != base; got != tt.changed {
+				t.Errorf("got hash changed %v, want %v", got, tt.changed)
+			}
+		})
+	}
+}
+
+func TestSyncTracker(t *testing.T) {
+	key := types.NamespacedName{Namespace: "istio-system", Name: "iop"}
+	now := time.Unix(1000, 0)
+	s := newSyncTracker()
+	s.now = func() time.Time { return now }
+
+	if _, ok := s.upToDate(key, "a", time.Hour); ok {
+		t.Error("up to date before first reconcile")
+	}
+	s.synced(key, "a")
+	now = now.Add(10 * time.Minute)
+	if remaining, ok := s.upToDate(key, "a", time.Hour); !ok || remaining != 50*time.Minute {
+		t.Errorf("got %s, %v, want 50m, true", remaining, ok)
+	}
+	if _, ok := s.upToDate(key, "b", time.Hour); ok {
+		t.Error("up to date with changed inputs")
+	}
+	if _, ok := s.upToDate(key, "a", 0); ok {
+		t.Error("up to date without resync period")
+	}
+	now = now.Add(time.Hour)
+	if _, ok := s.upToDate(key, "a", time.Hour); ok {
+		t.Error("up to date after resync period")
+	}
+	s.synced(key, "a")
+	s.forget(key)
+	if _, ok := s.upToDate(key, "a", time.Hour); ok {
+		t.Error("up to date after forget")
+	}
+}
+
+// markDeploymentsReady sets the status of the given Deployments to ready, as the Deployment controller would.
+func markDeploymentsReady(t *testing.T, cl client.Client, keys ...types.NamespacedName) {
+	t.Helper()
+	for _, key := range keys {
+		d := &unstructured.Unstructured{}
+		d.SetAPIVersion("apps/v1")
+		d.SetKind("Deployment")
+		if err := cl.Get(context.TODO(), key, d); err != nil {
+			t.Fatal(err)
+		}
+		replicas, found, err := unstructured.NestedInt64(d.Object, "spec", "replicas")
+		if err != nil || !found {
+			replicas = 1
+		}
+		status := map[string]interface{}{
+			"observedGeneration": d.GetGeneration(),
+			"replicas":           replicas,
+			"updatedReplicas":    replicas,
+			"availableReplicas":  replicas,
+		}
+		if err := unstructured.SetNestedMap(d.Object, status, "status"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Update(context.TODO(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIOPController_SkipUnchanged(t *testing.T) {
+	key := types.NamespacedName{Name: "skip-istiocontrolplane", Namespace: "istio-system"}
+	instance := &iop.IstioOperator{
+		Kind:       "IstioOperator",
+		ApiVersion: "install.istio.io/v1alpha1",
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec: &v1alpha1.IstioOperatorSpec{
+			Profile:    "minimal",
+			MeshConfig: &mesh.MeshConfig{RootNamespace: "istio-system"},
+		},
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, instance)
+	cl := fake.NewFakeClientWithScheme(s, instance)
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory, resyncPeriod: time.Hour}
+	req := reconcile.Request{NamespacedName: key}
+	now := time.Now()
+	defaultSyncTracker.now = func() time.Time { return now }
+	defer func() { defaultSyncTracker.now = time.Now }()
+
+	reconcileOnce := func() reconcile.Result {
+		t.Helper()
+		res, err := r.Reconcile(req)
+		if err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+		return res
+	}
+	serviceAccount := func() *unstructured.Unstructured {
+		sa := &unstructured.Unstructured{}
+		sa.SetAPIVersion("v1")
+		sa.SetKind("ServiceAccount")
+		sa.SetNamespace("istio-system")
+		sa.SetName("istio-pilot-service-account")
+		return sa
+	}
+	exists := func() bool {
+		t.Helper()
+		sa := serviceAccount()
+		err := cl.Get(context.TODO(), types.NamespacedName{Namespace: sa.GetNamespace(), Name: sa.GetName()}, sa)
+		return err == nil
+	}
+	deleteServiceAccount := func() *unstructured.Unstructured {
+		t.Helper()
+		sa := serviceAccount()
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: sa.GetNamespace(), Name: sa.GetName()},
+			sa); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Delete(context.TODO(), sa); err != nil {
+			t.Fatal(err)
+		}
+		return sa
+	}
+
+	// Workloads are becoming ready, so the IstioOperator is fully reconciled until they are.
+	if res := reconcileOnce(); res.RequeueAfter != progressingRequeueInterval {
+		t.Errorf("got RequeueAfter %s, want %s", res.RequeueAfter, progressingRequeueInterval)
+	}
+	markDeploymentsReady(t, cl, types.NamespacedName{Namespace: "istio-system", Name: "istio-pilot"})
+	if res := reconcileOnce(); res.RequeueAfter != time.Hour {
+		t.Errorf("got RequeueAfter %s, want %s", res.RequeueAfter, time.Hour)
+	}
+	got := &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.ReconciledHash == "" {
+		t.Error("ReconciledHash not set")
+	}
+
+	// Nothing changed, so nothing is applied.
+	deleteServiceAccount()
+	now = now.Add(10 * time.Minute)
+	if res := reconcileOnce(); res.RequeueAfter != 50*time.Minute {
+		t.Errorf("got RequeueAfter %s, want %s", res.RequeueAfter, 50*time.Minute)
+	}
+	if exists() {
+		t.Fatal("unchanged IstioOperator reconciled")
+	}
+
+	// Once the resync period elapsed, it is fully reconciled again.
+	now = now.Add(time.Hour)
+	reconcileOnce()
+	if !exists() {
+		t.Fatal("deleted resource not created after resync period")
+	}
+
+	// Deleting a desired resource reconciles it, deleting a resource which is not desired does not.
+	if !defaultDriftDetector.detectDeleted(deleteServiceAccount()) {
+		t.Error("deleting a desired resource does not reconcile")
+	}
+	reconcileOnce()
+	if !exists() {
+		t.Error("deleted resource not created again")
+	}
+	checkEvents(t, recorder, EventReasonDriftReverted)
+	pruned := serviceAccount()
+	pruned.SetName("pruned")
+	pruned.SetLabels(map[string]string{OwnerNameKey: key.Name, OwnerNamespaceKey: key.Namespace})
+	if defaultDriftDetector.detectDeleted(pruned) {
+		t.Error("deleting a resource which is not desired reconciles")
+	}
+}
@@ -16,41 +16,11 @@ package istiocontrolplane
 
 import (
 	"istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/dag"
 	"istio.io/operator/pkg/helmreconciler"
 	"istio.io/operator/pkg/name"
 )
 
-var (
-	componentDependencies = helmreconciler.ComponentNameToListMap{
-		name.IstioBaseComponentName: {
-			name.PilotComponentName,
-			name.PolicyComponentName,
-			name.TelemetryComponentName,
-			name.GalleyComponentName,
-			name.CitadelComponentName,
-			name.NodeAgentComponentName,
-			name.CertManagerComponentName,
-			name.SidecarInjectorComponentName,
-			name.IngressComponentName,
-			name.EgressComponentName,
-			name.CNIComponentName,
-		},
-	}
-
-	installTree      = make(helmreconciler.ComponentTree)
-	dependencyWaitCh = make(helmreconciler.DependencyWaitCh)
-)
-
-func init() {
-	buildInstallTree()
-	for _, parent := range componentDependencies {
-		for _, child := range parent {
-			dependencyWaitCh[child] = make(chan struct{}, 1)
-		}
-	}
-
-}
-
 // IstioRenderingInput is a RenderingInput specific to an v1alpha1 IstioOperator instance.
 type IstioRenderingInput struct {
 	instance *v1alpha1.IstioOperator
@@ -78,25 +48,12 @@ func (i *IstioRenderingInput) GetTargetNamespace() string {
 	return i.instance.Spec.MeshConfig.RootNamespace
 }
 
-// GetProcessingOrder returns the order in which the rendered charts should be processed.
-func (i *IstioRenderingInput) GetProcessingOrder(m helmreconciler.ChartManifestsMap) (helmreconciler.ComponentNameToListMap, helmreconciler.DependencyWaitCh) {
-	componentNameList := make([]name.ComponentName, 0)
-	dependencyWaitCh := make(helmreconciler.DependencyWaitCh)
+// GetProcessingOrder returns the dependency graph of the rendered charts, which determines the order in which they are
+// processed.
+func (i *IstioRenderingInput) GetProcessingOrder(m helmreconciler.ChartManifestsMap) (*dag.Graph, error) {
+	var components []name.ComponentName
 	for c := range m {
-		cn := name.ComponentName(c)
-		if cn == name.IstioBaseComponentName {
-			continue
-		}
-		componentNameList = append(componentNameList, cn)
-		dependencyWaitCh[cn] = make(chan struct{}, 1)
-	}
-	componentDependencies := helmreconciler.ComponentNameToListMap{
-		name.IstioBaseComponentName: componentNameList,
+		components = append(components, name.ComponentName(c))
 	}
-	return componentDependencies, dependencyWaitCh
-}
-
-func buildInstallTree() {
-	// Starting with root, recursively insert each first level child into each node.
-	helmreconciler.InsertChildrenRecursive(name.IstioBaseComponentName, installTree, componentDependencies)
+	return dag.ComponentGraph(components)
 }
@@ -16,8 +16,9 @@ package istiocontrolplane
 
 import (
 	"context"
-	"fmt"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -44,6 +45,14 @@ const (
 	finalizer = "istio-finalizer.install.istio.io"
 	// finalizerMaxRetries defines the maximum number of attempts to add finalizers.
 	finalizerMaxRetries = 10
+	// progressingRequeueInterval is how long to wait before reconciling again while workloads are becoming ready, so
+	// that the status conditions are refreshed.
+	progressingRequeueInterval = 15 * time.Second
+	// conflictRequeueInterval is how long to wait before reconciling an IstioOperator again whose resources are owned
+	// by another IstioOperator, in case the other one was changed or deleted.
+	conflictRequeueInterval = time.Minute
+	// eventRecorderName is the source of the Events recorded on IstioOperator resources.
+	eventRecorderName = "istio-operator"
 )
 
 /**
@@ -59,8 +68,13 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}}
-	return &ReconcileIstioOperator{client: mgr.GetClient(), scheme: mgr.GetScheme(), factory: factory}
+	factory := &helmreconciler.Factory{
+		CustomizerFactory: &IstioRenderingCustomizerFactory{},
+		Concurrency:       controllerOptions.Concurrency,
+		EventRecorder:     mgr.GetEventRecorderFor(eventRecorderName),
+	}
+	return &ReconcileIstioOperator{client: mgr.GetClient(), apiReader: mgr.GetAPIReader(), scheme: mgr.GetScheme(),
+		factory: factory, resyncPeriod: controllerOptions.ResyncPeriod, upgradeTimeout: controllerOptions.UpgradeTimeout}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -92,9 +106,16 @@ var _ reconcile.Reconciler = &ReconcileIstioOperator{}
 type ReconcileIstioOperator struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client  client.Client
-	scheme  *runtime.Scheme
-	factory *helmreconciler.Factory
+	client client.Client
+	// apiReader reads directly from the apiserver, for state which must not be stale. If nil, client is used.
+	apiReader client.Reader
+	scheme    *runtime.Scheme
+	factory   *helmreconciler.Factory
+	// resyncPeriod is how long reconciles are skipped while the inputs of an IstioOperator do not change.
+	resyncPeriod time.Duration
+	// upgradeTimeout is how long an upgraded component may take to pass its health gates in an orchestrated upgrade,
+	// unless the component sets its own readiness timeout. 0 means no limit.
+	upgradeTimeout time.Duration
 }
 
 // Reconcile reads that state of the cluster for a IstioOperator object and makes changes based on the state read
@@ -137,8 +158,16 @@ func (r *ReconcileIstioOperator) Reconcile(request reconcile.Request) (reconcile
 			return reconcile.Result{}, nil
 		}
 		log.Info("Deleting IstioOperator")
+		defaultSyncTracker.forget(reqNamespacedName)
 
-		reconciler, err := r.factory.New(iop, r.client)
+		// The inventories are found from the merged spec, e.g. the root namespace set by the profile.
+		var reconciler *helmreconciler.HelmReconciler
+		var err error
+		iopMerged := *iop
+		iopMerged.Spec, err = helmreconciler.MergeIOPSWithProfile(iop.Spec)
+		if err == nil {
+			reconciler, err = r.factory.New(&iopMerged, r.client)
+		}
 		if err == nil {
 			err = reconciler.Delete()
 		} else {
@@ -174,6 +203,22 @@ func (r *ReconcileIstioOperator) Reconcile(request reconcile.Request) (reconcile
 		}
 	}
 
+	// drift is discarded while paused
+	drifts := defaultDriftDetector.take(reqNamespacedName)
+	if paused(iop) {
+		// resources may be edited by hand while paused, so resuming reconciles fully
+		defaultSyncTracker.forget(reqNamespacedName)
+		return reconcile.Result{}, r.pause(iop)
+	}
+	components := pausedComponents(iop)
+	r.recordPauseEvents(iop, false, components)
+	if len(drifts) != 0 && iop.Status != nil && iop.Status.ObservedGeneration == iop.Generation {
+		revert, err := r.reportDrift(iop, drifts)
+		if err != nil || !revert {
+			return reconcile.Result{}, err
+		}
+	}
+
 	log.Info("Updating IstioOperator")
 	var err error
 	iopMerged := *iop
@@ -181,28 +226,82 @@ func (r *ReconcileIstioOperator) Reconcile(request reconcile.Request) (reconcile
 	if err != nil {
 		return reconcile.Result{}, err
 	}
-	reconciler, err := r.getOrCreateReconciler(&iopMerged)
+	hash, err := inputHash(iop, iopMerged.Spec)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// A new tag may be rolled out one component at a time, see UpgradeStrategyOrchestrated.
+	if iop.Status == nil || iop.Status.ReconciledHash != hash || upgrading(iop) {
+		res, handled, err := r.upgrade(iop, &iopMerged, hash, components)
+		if err != nil {
+			defaultSyncTracker.forget(reqNamespacedName)
+		}
+		if handled || err != nil {
+			return res, err
+		}
+	}
+	// Rendering and applying is skipped if nothing changed since the last full reconcile, unless drift is to be
+	// reverted or workloads are becoming ready. Full reconciles still run every resyncPeriod.
+	if len(drifts) == 0 && iop.Status != nil && iop.Status.ReconciledHash == hash &&
+		!r.progressing(reqNamespacedName) {
+		if remaining, ok := defaultSyncTracker.upToDate(reqNamespacedName, hash, r.resyncPeriod); ok {
+			log.Infof("IstioOperator %s is up to date, next full reconcile in %s", reqNamespacedName,
+				remaining.Round(time.Second))
+			return reconcile.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	reconciler, err := r.factory.New(&iopMerged, r.client)
 	if err == nil {
+		reconciler.SetPausedComponents(components)
+		reconciler.SetAdopt(adopt(iop))
 		err = reconciler.Reconcile()
+		if conflict, ok := helmreconciler.IsOwnershipConflict(err); ok {
+			defaultSyncTracker.forget(reqNamespacedName)
+			return reconcile.Result{RequeueAfter: conflictRequeueInterval}, r.reportConflict(iop, conflict)
+		}
 		if err != nil {
 			log.Errorf("reconciling err: %s", err)
 		}
 	} else {
 		log.Errorf("failed to create reconciler: %s", err)
 	}
-
-	return reconcile.Result{}, err
+	if err == nil {
+		err = r.setReconciledHash(reqNamespacedName, hash)
+	}
+	if err != nil {
+		defaultSyncTracker.forget(reqNamespacedName)
+		return reconcile.Result{}, err
+	}
+	defaultSyncTracker.synced(reqNamespacedName, hash)
+	for _, d := range drifts {
+		if d.policy == DriftPolicyRevert {
+			r.recordEvent(iop, corev1.EventTypeNormal, EventReasonDriftReverted, d.String())
+		}
+	}
+	if r.progressing(reqNamespacedName) {
+		return reconcile.Result{RequeueAfter: progressingRequeueInterval}, nil
+	}
+	// all components are ready, so orchestrated upgrades may roll back to these manifests
+	if err := r.saveAppliedManifests(iop, reconciler, hash, iopMerged.Spec.GetTag()); err != nil {
+		log.Warnf("could not save the applied manifests of IstioOperator %s: %s", reqNamespacedName, err)
+	}
+	return reconcile.Result{RequeueAfter: r.resyncPeriod}, nil
 }
 
-var (
-	defaultNs   string
-	reconcilers = map[string]*helmreconciler.HelmReconciler{}
-)
-
-func reconcilersMapKey(iop *iop.IstioOperator) string {
-	return fmt.Sprintf("%s/%s", iop.Namespace, iop.Name)
+// progressing reports whether the IstioOperator has the Progressing condition set, i.e. its workloads are not all
+// ready yet.
+func (r *ReconcileIstioOperator) progressing(key types.NamespacedName) bool {
+	instance := &iop.IstioOperator{}
+	if err := r.client.Get(context.TODO(), key, instance); err != nil || instance.Status == nil {
+		return false
+	}
+	c := iop.GetCondition(instance.Status.Conditions, iop.ConditionProgressing)
+	return c != nil && c.Status == corev1.ConditionTrue
 }
 
+var defaultNs string
+
 var ownedResourcePredicates = predicate.Funcs{
 	CreateFunc: func(_ event.CreateEvent) bool {
 		// no action
@@ -218,38 +317,19 @@ var ownedResourcePredicates = predicate.Funcs{
 		if err != nil {
 			return false
 		}
-		if object.GetLabels()[OwnerNameKey] != "" {
-			return true
+		if object.GetLabels()[OwnerNameKey] == "" {
+			return false
 		}
-		return false
+		// reconcile if a desired object was deleted, but not if e.g. it was pruned
+		return defaultDriftDetector.detectDeleted(e.Object)
 	},
 	UpdateFunc: func(e event.UpdateEvent) bool {
-		// no action
-		return false
-	},
-}
-
-func (r *ReconcileIstioOperator) getOrCreateReconciler(iop *iop.IstioOperator) (*helmreconciler.HelmReconciler, error) {
-	key := reconcilersMapKey(iop)
-	var err error
-	var reconciler *helmreconciler.HelmReconciler
-	if reconciler, ok := reconcilers[key]; ok {
-		reconciler.SetNeedUpdateAndPrune(false)
-		oldInstance := reconciler.GetInstance()
-		reconciler.SetInstance(iop)
-		if reconciler.GetInstance() != oldInstance {
-			//regenerate the reconciler
-			if reconciler, err = r.factory.New(iop, r.client); err == nil {
-				reconcilers[key] = reconciler
-			}
+		if e.MetaNew == nil || e.MetaNew.GetLabels()[OwnerNameKey] == "" {
+			return false
 		}
-		return reconciler, err
-	}
-	//not found - generate the reconciler
-	if reconciler, err = r.factory.New(iop, r.client); err == nil {
-		reconcilers[key] = reconciler
-	}
-	return reconciler, err
+		// reconcile if the object drifted from its desired state and the drift is not ignored
+		return defaultDriftDetector.detect(e.ObjectNew)
+	},
 }
 
 // Watch changes for Istio resources managed by the operator
@@ -266,7 +346,8 @@ func watchIstioResources(c controller.Controller) error {
 				log.Debugf("watch a change for istio resource: %s.%s", a.Meta.GetName(), a.Meta.GetNamespace())
 				return []reconcile.Request{
 					{NamespacedName: types.NamespacedName{
-						Name: a.Meta.GetLabels()[OwnerNameKey],
+						Name:      a.Meta.GetLabels()[OwnerNameKey],
+						Namespace: a.Meta.GetLabels()[OwnerNamespaceKey],
 					}},
 				}
 			}),
@@ -18,13 +18,18 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/kr/pretty"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -33,6 +38,7 @@ import (
 	"istio.io/api/operator/v1alpha1"
 	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
 	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/inventory"
 	"istio.io/operator/pkg/name"
 )
 
@@ -148,7 +154,8 @@ func testSwitchProfile(t *testing.T, c testCase) {
 	s := scheme.Scheme
 	s.AddKnownTypes(iop.SchemeGroupVersion, iopinstance)
 	cl := fake.NewFakeClientWithScheme(s, objs...)
-	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}}
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
 	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
 
 	req := reconcile.Request{
@@ -166,6 +173,8 @@ func testSwitchProfile(t *testing.T, c testCase) {
 	if !succeed || err != nil {
 		t.Fatalf("failed to get initial expected IstioOperator status: (%v)", err)
 	}
+	checkEvents(t, recorder, helmreconciler.EventReasonReconcileStarted, helmreconciler.EventReasonComponentApplied,
+		helmreconciler.EventReasonReconcileCompleted)
 
 	//update IstioOperator : switch profile from minimal to default and reconcile
 	err = switchIstioOperatorProfile(cl, req.NamespacedName, c.targetProfile)
@@ -186,6 +195,23 @@ func testSwitchProfile(t *testing.T, c testCase) {
 	}
 }
 
+// checkEvents checks that the events recorded so far include events with each of the reasons.
+func checkEvents(t *testing.T, recorder *record.FakeRecorder, reasons ...string) {
+	t.Helper()
+	got := make(map[string]bool)
+	for len(recorder.Events) > 0 {
+		// FakeRecorder events have the form "type reason message".
+		if f := strings.Fields(<-recorder.Events); len(f) > 1 {
+			got[f[1]] = true
+		}
+	}
+	for _, r := range reasons {
+		if !got[r] {
+			t.Errorf("no %s event recorded", r)
+		}
+	}
+}
+
 func statusExpected(s1, s2 *v1alpha1.InstallStatus_VersionStatus) bool {
 	return s1.Status.String() == s2.Status.String()
 }
@@ -236,6 +262,64 @@ func checkIOPStatus(cl client.Client, key client.ObjectKey, profile string) (boo
 		} else {
 			return false, fmt.Errorf("failed to find Expected IstioOperator status: (%s)", k)
 		}
+		if cs := status.Components[k]; cs == nil || iop.GetCondition(cs.Conditions, iop.ConditionReconciled) == nil {
+			return false, fmt.Errorf("missing conditions for component %s", k)
+		}
+	}
+	if len(status.Components) != expectedSize {
+		return false, fmt.Errorf("got conditions for %d components, want %d", len(status.Components), expectedSize)
+	}
+	if status.ObservedGeneration != instance.Generation {
+		return false, fmt.Errorf("observedGeneration: got %d, want %d", status.ObservedGeneration, instance.Generation)
+	}
+	if c := iop.GetCondition(status.Conditions, iop.ConditionReconciled); c == nil || c.Status != corev1.ConditionTrue {
+		return false, fmt.Errorf("Reconciled condition: got %s, want True", pretty.Sprint(c))
 	}
 	return true, nil
 }
+
+func TestIOPController_Delete(t *testing.T) {
+	// The root namespace, and so the namespace of the inventories, only comes from the profile.
+	instance := &iop.IstioOperator{
+		Kind:       "IstioOperator",
+		ApiVersion: "install.istio.io/v1alpha1",
+		ObjectMeta: metav1.ObjectMeta{Name: "delete", Namespace: "istio-system"},
+		Spec:       &v1alpha1.IstioOperatorSpec{Profile: "minimal"},
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, instance)
+	cl := fake.NewFakeClientWithScheme(s, instance)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{},
+		EventRecorder: record.NewFakeRecorder(1000)}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "istio-system", Name: instance.Name}}
+	pilot := types.NamespacedName{Namespace: "istio-system", Name: "istio-pilot"}
+
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if err := cl.Get(context.TODO(), pilot, &appsv1.Deployment{}); err != nil {
+		t.Fatalf("istio-pilot not applied: %v", err)
+	}
+
+	deleted := &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), req.NamespacedName, deleted); err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	deleted.SetDeletionTimestamp(&now)
+	if err := cl.Update(context.TODO(), deleted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if err := cl.Get(context.TODO(), pilot, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("istio-pilot not pruned: %v", err)
+	}
+	inv, err := inventory.NewOwnerConfigMapStore(cl, "istio-system", "istio-system/delete", "").Get(
+		name.PilotComponentName)
+	if err != nil || inv != nil {
+		t.Errorf("Pilot inventory not deleted: %v, %v", inv, err)
+	}
+}
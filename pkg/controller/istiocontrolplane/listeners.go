@@ -17,22 +17,24 @@ package istiocontrolplane
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"istio.io/api/operator/v1alpha1"
 	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
 	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/readiness"
 	"istio.io/pkg/log"
 )
 
@@ -40,9 +42,9 @@ const (
 	// ChartOwnerKey is the annotation key used to store the name of the chart that created the resource
 	ChartOwnerKey = MetadataNamespace + "/chart-owner"
 
-	finalizerRemovalBackoffSteps    = 10
-	finalizerRemovalBackoffDuration = 6 * time.Second
-	finalizerRemovalBackoffFactor   = 1.1
+	// defaultReadinessTimeout is how long to wait for the resources of a chart to become ready, unless a timeout is
+	// set for the component in the IstioOperator spec.
+	defaultReadinessTimeout = 5 * time.Minute
 )
 
 // IstioRenderingListener is a RenderingListener specific to IstioOperator resources
@@ -51,21 +53,35 @@ type IstioRenderingListener struct {
 }
 
 // IstioStatusUpdater is a RenderingListener that updates the status field on the IstioOperator
-// instance based on the results of the Reconcile operation.
+// instance based on the results of the Reconcile operation. Besides the InstallStatus, it sets Ready, Progressing,
+// Degraded and Reconciled conditions on the IstioOperator and each of its components.
 type IstioStatusUpdater struct {
 	*helmreconciler.DefaultRenderingListener
 	instance   *iop.IstioOperator
 	reconciler *helmreconciler.HelmReconciler
+
+	// mu protects workloads, since components are processed concurrently.
+	mu sync.Mutex
+	// workloads are the workloads applied for each component in the current reconcile.
+	workloads map[string][]*unstructured.Unstructured
+}
+
+// workloadKinds are the kinds counted as workloads of a component.
+var workloadKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "Deployment"}:  true,
+	{Group: "apps", Kind: "DaemonSet"}:   true,
+	{Group: "apps", Kind: "StatefulSet"}: true,
 }
 
-// NewIstioRenderingListener returns a new IstioRenderingListener, which is a composite that includes IstioStatusUpdater
-// and IstioChartCustomizerListener.
+// NewIstioRenderingListener returns a new IstioRenderingListener, which is a composite that includes IstioStatusUpdater,
+// IstioChartCustomizerListener and a listener recording the desired state of resources for drift detection.
 func NewIstioRenderingListener(instance *iop.IstioOperator) *IstioRenderingListener {
 	return &IstioRenderingListener{
 		&helmreconciler.CompositeRenderingListener{
 			Listeners: []helmreconciler.RenderingListener{
 				NewChartCustomizerListener(),
 				NewIstioStatusUpdater(instance),
+				newDriftRecordingListener(instance, defaultDriftDetector),
 			},
 		},
 	}
@@ -79,18 +95,113 @@ func NewIstioStatusUpdater(instance *iop.IstioOperator) helmreconciler.Rendering
 	}
 }
 
-// EndReconcile updates the status field on the IstioOperator instance based on the resulting err parameter.
+// BeginReconcile sets the Progressing condition on the IstioOperator instance. Failing to update the status does not
+// fail the reconcile, since the status is updated again at the end.
+func (u *IstioStatusUpdater) BeginReconcile(_ runtime.Object) error {
+	u.mu.Lock()
+	u.workloads = make(map[string][]*unstructured.Unstructured)
+	u.mu.Unlock()
+	generation := u.instance.GetGeneration()
+	err := u.updateStatus(func(s *iop.IstioOperatorStatus) {
+		s.Conditions = beginConditions(s.Conditions, s.ObservedGeneration, generation, metav1.Now())
+	})
+	if err != nil {
+		log.Warnf("could not update IstioOperator status at start of reconcile: %s", err)
+	}
+	return nil
+}
+
+// BeginResource records the workloads of each chart, to report their readiness at the end of the reconcile.
+func (u *IstioStatusUpdater) BeginResource(chart string, obj runtime.Object) (runtime.Object, error) {
+	if !workloadKinds[obj.GetObjectKind().GroupVersionKind().GroupKind()] {
+		return obj, nil
+	}
+	wl, err := toUnstructured(obj)
+	if err != nil {
+		return obj, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.workloads == nil {
+		u.workloads = make(map[string][]*unstructured.Unstructured)
+	}
+	u.workloads[chart] = append(u.workloads[chart], wl)
+	return obj, nil
+}
+
+// EndReconcile updates the status field on the IstioOperator instance based on the resulting status of each
+// component and the readiness of its workloads. Paused components keep their old status.
 func (u *IstioStatusUpdater) EndReconcile(_ runtime.Object, status *v1alpha1.InstallStatus) error {
-	iop := &iop.IstioOperator{}
+	generation := u.instance.GetGeneration()
+	now := metav1.Now()
+	workloads := u.workloadStates(status)
+	paused := pausedComponents(u.instance)
+	isPaused := make(map[string]bool)
+	for _, c := range paused {
+		isPaused[string(c)] = true
+	}
+	return u.updateStatus(func(s *iop.IstioOperatorStatus) {
+		components := make(map[string]*iop.ComponentStatus)
+		for c, vs := range status.ComponentStatus {
+			if isPaused[c] {
+				components[c] = pausedComponentStatus(s.Components[c], generation, now)
+				continue
+			}
+			cs := componentStatus(vs, workloads[c], generation, s.Components[c], now)
+			if iop.GetCondition(cs.Conditions, iop.ConditionPaused) != nil {
+				cs.Conditions = setCondition(cs.Conditions, iop.ConditionPaused, false, reasonResumed, "", generation, now)
+			}
+			components[c] = cs
+		}
+		s.InstallStatus = status
+		s.ObservedGeneration = generation
+		s.Components = components
+		s.Conditions = aggregateConditions(s.Conditions, components, generation, now)
+		s.Conditions = pauseConditions(s.Conditions, false, paused, generation, now)
+	})
+}
+
+// workloadStates checks the readiness of the workloads applied for each component in status.
+func (u *IstioStatusUpdater) workloadStates(status *v1alpha1.InstallStatus) map[string]workloadState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	getter := &readiness.ClientGetter{Client: u.reconciler.GetClient()}
+	out := make(map[string]workloadState)
+	for c := range status.ComponentStatus {
+		var ws workloadState
+		for _, wl := range u.workloads[c] {
+			ready, reason, err := readiness.Check(getter, wl)
+			if err != nil {
+				reason = err.Error()
+			}
+			if ready {
+				ws.ready++
+				continue
+			}
+			ws.notReady = append(ws.notReady, fmt.Sprintf("%s/%s/%s (%s)", wl.GetKind(), wl.GetNamespace(),
+				wl.GetName(), reason))
+		}
+		sort.Strings(ws.notReady)
+		out[c] = ws
+	}
+	return out
+}
+
+// updateStatus fetches the IstioOperator instance, applies fn to its status and writes the status back.
+func (u *IstioStatusUpdater) updateStatus(fn func(status *iop.IstioOperatorStatus)) error {
+	instance := &iop.IstioOperator{}
 	namespacedName := types.NamespacedName{
 		Name:      u.instance.Name,
 		Namespace: u.instance.Namespace,
 	}
-	if err := u.reconciler.GetClient().Get(context.TODO(), namespacedName, iop); err != nil {
+	if err := u.reconciler.GetClient().Get(context.TODO(), namespacedName, instance); err != nil {
 		return fmt.Errorf("failed to get IstioOperator before updating status due to %v", err)
 	}
-	iop.Status = status
-	return u.reconciler.GetClient().Status().Update(context.TODO(), iop)
+	if instance.Status == nil {
+		instance.Status = &iop.IstioOperatorStatus{}
+	}
+	fn(instance.Status)
+	return u.reconciler.GetClient().Status().Update(context.TODO(), instance)
 }
 
 // RegisterReconciler registers the HelmReconciler with this object
@@ -151,111 +262,57 @@ func NewIstioDefaultChartCustomizer(chartName, chartAnnotationKey string) *Istio
 	}
 }
 
-// EndChart waits for any deployments or stateful sets that were created to become ready
+// EndChart waits for the resources created or updated for the chart to become ready.
 func (c *IstioDefaultChartCustomizer) EndChart(chartName string) error {
 	// ignore any errors.  things should settle out
-	c.waitForResources()
+	c.waitForResources(chartName)
 	return nil
 }
 
-func (c *IstioDefaultChartCustomizer) waitForResources() {
-	if statefulSets, ok := c.NewResourcesByKind["StatefulSet"]; ok {
-		for _, statefulSet := range statefulSets {
-			c.waitForDeployment(statefulSet)
-		}
-	}
-	if deployments, ok := c.NewResourcesByKind["Deployment"]; ok {
-		for _, deployment := range deployments {
-			c.waitForDeployment(deployment)
-		}
-	}
-	if daemonSets, ok := c.NewResourcesByKind["DaemonSet"]; ok {
-		for _, daemonSet := range daemonSets {
-			c.waitForDeployment(daemonSet)
-		}
-	}
-	if services, ok := c.NewResourcesByKind["Service"]; ok {
-		for _, service := range services {
-			c.waitForService(service)
+// waitForResources waits for the resources of the chart to become ready, using the readiness timeout set for the
+// component in the IstioOperator spec, if any.
+func (c *IstioDefaultChartCustomizer) waitForResources(chartName string) {
+	var objects []*unstructured.Unstructured
+	for kind, resources := range c.NewResourcesByKind {
+		for _, r := range resources {
+			u, err := toUnstructured(r)
+			if err != nil {
+				log.Errorf("could not convert %s to unstructured: %s", kind, err)
+				continue
+			}
+			objects = append(objects, u)
 		}
 	}
-}
-
-func (c *IstioDefaultChartCustomizer) serviceReady(svc *corev1.Service) bool {
-	// ExternalName Services are external to cluster so they should not be checked
-	if svc.Spec.Type == corev1.ServiceTypeExternalName {
-		return true
-	}
-	// Check if services except the headless services have the IP set
-	if svc.Spec.ClusterIP != corev1.ClusterIPNone && svc.Spec.ClusterIP == "" {
-		log.Info(fmt.Sprintf("Service is not ready: %s/%s", svc.GetNamespace(), svc.GetName()))
-		return false
-	}
-	// Check if the service has a LoadBalancer with an Ingress ready
-	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && svc.Status.LoadBalancer.Ingress == nil {
-		log.Info(fmt.Sprintf("Service is not ready: %s/%s", svc.GetNamespace(), svc.GetName()))
-		return false
-	}
-	return true
-}
-
-func (c *IstioDefaultChartCustomizer) waitForService(object runtime.Object) {
-	gvk := object.GetObjectKind().GroupVersionKind()
-	objectAccessor, err := meta.Accessor(object)
-	if err != nil {
-		log.Error(fmt.Sprintf("could not get object accessor for %s", gvk.Kind))
+	if len(objects) == 0 {
 		return
 	}
-	name := objectAccessor.GetName()
-	service, ok := object.(*corev1.Service)
-	if ok {
-		log.Infof("waiting for service to become ready; %s", name)
-		err = wait.ExponentialBackoff(wait.Backoff{
-			Duration: finalizerRemovalBackoffDuration,
-			Steps:    finalizerRemovalBackoffSteps,
-			Factor:   finalizerRemovalBackoffFactor,
-		}, func() (bool, error) {
-			return c.serviceReady(service), nil
-		})
+	timeout := defaultReadinessTimeout
+	if instance := c.Reconciler.GetInstance(); instance != nil {
+		timeouts, err := readiness.ComponentTimeouts(instance.Spec)
 		if err != nil {
-			log.Errorf("service failed to become ready in a timely manner: %s", name)
+			log.Errorf("could not read readiness timeouts, using %s: %s", timeout, err)
+		} else if t, ok := timeouts[name.ComponentName(chartName)]; ok {
+			timeout = t
 		}
 	}
+	log.Infof("waiting for resources of %s to become ready with timeout of %s", chartName, timeout)
+	getter := &readiness.ClientGetter{Client: c.Reconciler.GetClient()}
+	if err := readiness.Wait(getter, objects, readiness.DefaultPollInterval, timeout); err != nil {
+		log.Errorf("resources of %s failed to become ready in a timely manner: %s", chartName, err)
+	}
 }
 
-// XXX: configure wait period
-func (c *IstioDefaultChartCustomizer) waitForDeployment(object runtime.Object) {
-	gvk := object.GetObjectKind().GroupVersionKind()
-	objectAccessor, err := meta.Accessor(object)
-	if err != nil {
-		log.Error(fmt.Sprintf("could not get object accessor for %s", gvk.Kind))
-		return
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u, nil
 	}
-	name := objectAccessor.GetName()
-	namespace := objectAccessor.GetNamespace()
-	deployment := &unstructured.Unstructured{}
-	deployment.SetGroupVersionKind(gvk)
-	// wait for deployment replicas >= 1
-	log.Infof("waiting for deployment to become ready: %s, %s", gvk.Kind, name)
-	err = wait.ExponentialBackoff(wait.Backoff{
-		Duration: finalizerRemovalBackoffDuration,
-		Steps:    finalizerRemovalBackoffSteps,
-		Factor:   finalizerRemovalBackoffFactor,
-	}, func() (bool, error) {
-		err := c.Reconciler.GetClient().Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, deployment)
-		if err == nil {
-			val, _, _ := unstructured.NestedInt64(deployment.UnstructuredContent(), "status", "readyReplicas")
-			return val > 0, nil
-		} else if errors.IsNotFound(err) {
-			log.Errorf("attempting to wait on unknown deployment: %s, %s", gvk.Kind, name)
-			return true, nil
-		}
-		log.Errorf("unexpected error occurred waiting for deployment to become ready: %s, %s: %s", gvk.Kind, name, err)
-		return false, err
-	})
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
 	if err != nil {
-		log.Errorf("deployment failed to become ready in a timely manner: %s, %s", gvk.Kind, name)
+		return nil, err
 	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	return u, nil
 }
 
 // CitadelChartCustomizer is a ChartCustomizer for the citadel chart
@@ -0,0 +1,152 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/name"
+	"istio.io/pkg/log"
+)
+
+const (
+	// PausedKey is the IstioOperator annotation which suspends reconciling it when set to "true". Resources are
+	// neither applied nor pruned while paused, but are still deleted when the IstioOperator is deleted.
+	PausedKey = MetadataNamespace + "/paused"
+	// PausedComponentsKey is the IstioOperator annotation listing components which are not reconciled, separated by
+	// commas, e.g. "Pilot,IngressGateways".
+	PausedComponentsKey = MetadataNamespace + "/paused-components"
+)
+
+// Reasons of the Events recorded when pausing and resuming.
+const (
+	EventReasonPaused  = "Paused"
+	EventReasonResumed = "Resumed"
+
+	reasonPaused           = "Paused"
+	reasonComponentsPaused = "ComponentsPaused"
+	reasonResumed          = "Resumed"
+)
+
+// paused reports whether reconciling instance is suspended.
+func paused(instance *iop.IstioOperator) bool {
+	s, ok := instance.GetAnnotations()[PausedKey]
+	if !ok {
+		return false
+	}
+	p, err := strconv.ParseBool(s)
+	if err != nil {
+		log.Warnf("ignoring invalid value %q of annotation %s: %s", s, PausedKey, err)
+		return false
+	}
+	return p
+}
+
+// pausedComponents returns the sorted components of instance which are not reconciled.
+func pausedComponents(instance *iop.IstioOperator) []name.ComponentName {
+	var out []name.ComponentName
+	for _, c := range strings.Split(instance.GetAnnotations()[PausedComponentsKey], ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, name.ComponentName(c))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// pauseConditions returns conditions with the Paused condition of the IstioOperator as a whole set for the given
+// pause state. The condition is only added once something is paused.
+func pauseConditions(conditions []iop.Condition, all bool, components []name.ComponentName, generation int64,
+	now metav1.Time) []iop.Condition {
+	switch {
+	case all:
+		return setCondition(conditions, iop.ConditionPaused, true, reasonPaused,
+			fmt.Sprintf("reconciliation paused by annotation %s", PausedKey), generation, now)
+	case len(components) != 0:
+		return setCondition(conditions, iop.ConditionPaused, true, reasonComponentsPaused,
+			fmt.Sprintf("paused components: %s", joinComponents(components)), generation, now)
+	case iop.GetCondition(conditions, iop.ConditionPaused) != nil:
+		return setCondition(conditions, iop.ConditionPaused, false, reasonResumed, "", generation, now)
+	default:
+		return conditions
+	}
+}
+
+// recordPauseEvents records a Paused Event if the pause state of instance changed from the Paused condition in its
+// status to the given one, or a Resumed Event if nothing is paused any more.
+func (r *ReconcileIstioOperator) recordPauseEvents(instance *iop.IstioOperator, all bool,
+	components []name.ComponentName) {
+	var old []iop.Condition
+	if instance.Status != nil {
+		old = instance.Status.Conditions
+	}
+	was := iop.GetCondition(old, iop.ConditionPaused)
+	wasPaused := was != nil && was.Status == corev1.ConditionTrue
+	now := iop.GetCondition(pauseConditions(copyConditions(old), all, components, 0, metav1.Now()), iop.ConditionPaused)
+	switch {
+	case now != nil && now.Status == corev1.ConditionTrue && (!wasPaused || was.Message != now.Message):
+		r.recordEvent(instance, corev1.EventTypeNormal, EventReasonPaused, now.Message)
+	case wasPaused && (now == nil || now.Status != corev1.ConditionTrue):
+		r.recordEvent(instance, corev1.EventTypeNormal, EventReasonResumed, "resuming reconciliation")
+	}
+}
+
+// pause sets the Paused condition on instance, whose reconciliation is suspended.
+func (r *ReconcileIstioOperator) pause(instance *iop.IstioOperator) error {
+	log.Infof("Reconciliation of IstioOperator %s/%s is paused", instance.Namespace, instance.Name)
+	r.recordPauseEvents(instance, true, nil)
+	if instance.Status == nil {
+		instance.Status = &iop.IstioOperatorStatus{}
+	}
+	if c := iop.GetCondition(instance.Status.Conditions, iop.ConditionPaused); c != nil && c.Reason == reasonPaused &&
+		c.Status == corev1.ConditionTrue {
+		return nil
+	}
+	instance.Status.Conditions = pauseConditions(instance.Status.Conditions, true, nil, instance.Generation,
+		metav1.Now())
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// pausedComponentStatus returns the status of a paused component, which keeps its old status, if any, and has the
+// Paused condition set.
+func pausedComponentStatus(old *iop.ComponentStatus, generation int64, now metav1.Time) *iop.ComponentStatus {
+	out := &iop.ComponentStatus{}
+	if old != nil {
+		*out = *old
+		out.Conditions = copyConditions(old.Conditions)
+	}
+	out.Conditions = setCondition(out.Conditions, iop.ConditionPaused, true, reasonPaused, "", generation, now)
+	return out
+}
+
+func joinComponents(components []name.ComponentName) string {
+	s := make([]string, 0, len(components))
+	for _, c := range components {
+		s = append(s, string(c))
+	}
+	return strings.Join(s, ", ")
+}
+
+func copyConditions(conditions []iop.Condition) []iop.Condition {
+	return append([]iop.Condition(nil), conditions...)
+}
@@ -0,0 +1,210 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mesh "istio.io/api/mesh/v1alpha1"
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/name"
+)
+
+func TestPausedComponents(t *testing.T) {
+	instance := &iop.IstioOperator{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		PausedKey:           "yes",
+		PausedComponentsKey: " Pilot, ,IngressGateways",
+	}}}
+	if paused(instance) {
+		t.Error("paused with invalid annotation value")
+	}
+	want := []name.ComponentName{name.IngressComponentName, name.PilotComponentName}
+	if got := pausedComponents(instance); !reflect.DeepEqual(got, want) {
+		t.Errorf("got paused components %v, want %v", got, want)
+	}
+}
+
+func TestPauseConditions(t *testing.T) {
+	now := metav1.NewTime(time.Unix(1000, 0))
+	tests := []struct {
+		desc       string
+		conditions []iop.Condition
+		all        bool
+		components []name.ComponentName
+		want       *iop.Condition
+	}{
+		{
+			desc: "never paused",
+		},
+		{
+			desc: "paused",
+			all:  true,
+			want: &iop.Condition{Type: iop.ConditionPaused, Status: corev1.ConditionTrue, Reason: reasonPaused,
+				Message: "reconciliation paused by annotation " + PausedKey},
+		},
+		{
+			desc:       "components paused",
+			components: []name.ComponentName{name.IngressComponentName, name.PilotComponentName},
+			want: &iop.Condition{Type: iop.ConditionPaused, Status: corev1.ConditionTrue, Reason: reasonComponentsPaused,
+				Message: "paused components: IngressGateways, Pilot"},
+		},
+		{
+			desc:       "resumed",
+			conditions: []iop.Condition{{Type: iop.ConditionPaused, Status: corev1.ConditionTrue, Reason: reasonPaused}},
+			want:       &iop.Condition{Type: iop.ConditionPaused, Status: corev1.ConditionFalse, Reason: reasonResumed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := iop.GetCondition(pauseConditions(tt.conditions, tt.all, tt.components, 2, now), iop.ConditionPaused)
+			if tt.want != nil {
+				tt.want.ObservedGeneration = 2
+				tt.want.LastTransitionTime = now
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func setAnnotation(t *testing.T, cl client.Client, key types.NamespacedName, annotation, value string) {
+	t.Helper()
+	instance := &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), key, instance); err != nil {
+		t.Fatal(err)
+	}
+	annotations := instance.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if value == "" {
+		delete(annotations, annotation)
+	} else {
+		annotations[annotation] = value
+	}
+	instance.SetAnnotations(annotations)
+	if err := cl.Update(context.TODO(), instance); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIOPController_Pause(t *testing.T) {
+	key := types.NamespacedName{Name: "pause-istiocontrolplane", Namespace: "istio-system"}
+	instance := &iop.IstioOperator{
+		Kind:       "IstioOperator",
+		ApiVersion: "install.istio.io/v1alpha1",
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec: &v1alpha1.IstioOperatorSpec{
+			Profile:    "minimal",
+			MeshConfig: &mesh.MeshConfig{RootNamespace: "istio-system"},
+		},
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, instance)
+	cl := fake.NewFakeClientWithScheme(s, instance)
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory}
+	req := reconcile.Request{NamespacedName: key}
+	reconcileAndCheck := func(profile string) *iop.IstioOperator {
+		t.Helper()
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+		if ok, err := checkIOPStatus(cl, key, profile); !ok || err != nil {
+			t.Fatalf("status of profile %s: %v", profile, err)
+		}
+		got := &iop.IstioOperator{}
+		if err := cl.Get(context.TODO(), key, got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	reconcileAndCheck("minimal")
+
+	// While paused, the profile change is not applied.
+	setAnnotation(t, cl, key, PausedKey, "true")
+	if err := switchIstioOperatorProfile(cl, key, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	got := &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.ComponentStatus) != len(minimalStatus) {
+		t.Errorf("components reconciled while paused: %v", got.Status.ComponentStatus)
+	}
+	if !isTrue(got.Status.Conditions, iop.ConditionPaused) {
+		t.Error("Paused condition not set")
+	}
+	checkEvents(t, recorder, EventReasonPaused)
+
+	// Resuming reconciles the profile change.
+	setAnnotation(t, cl, key, PausedKey, "")
+	got = reconcileAndCheck("default")
+	if c := iop.GetCondition(got.Status.Conditions, iop.ConditionPaused); c == nil || c.Status != corev1.ConditionFalse {
+		t.Errorf("Paused condition: got %v, want False", c)
+	}
+	checkEvents(t, recorder, EventReasonResumed, helmreconciler.EventReasonReconcileCompleted)
+
+	// A paused component keeps its status and is neither applied nor pruned.
+	setAnnotation(t, cl, key, PausedComponentsKey, string(name.PolicyComponentName))
+	if err := switchIstioOperatorProfile(cl, key, "minimal"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	got = &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Status.ComponentStatus[string(name.PolicyComponentName)]; !ok {
+		t.Error("paused component Policy has no status")
+	}
+	if _, ok := got.Status.ComponentStatus[string(name.GalleyComponentName)]; ok {
+		t.Error("component Galley not removed")
+	}
+	if cs := got.Status.Components[string(name.PolicyComponentName)]; cs == nil || !isTrue(cs.Conditions, iop.ConditionPaused) {
+		t.Error("Paused condition not set on component Policy")
+	}
+	if c := iop.GetCondition(got.Status.Conditions, iop.ConditionPaused); c == nil || c.Reason != reasonComponentsPaused {
+		t.Errorf("Paused condition: got %v, want reason %s", c, reasonComponentsPaused)
+	}
+	checkEvents(t, recorder, EventReasonPaused)
+	policy := &corev1.ServiceAccount{}
+	if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "istio-system", Name: "istio-policy-service-account"},
+		policy); err != nil {
+		t.Errorf("resource of paused component pruned: %v", err)
+	}
+}
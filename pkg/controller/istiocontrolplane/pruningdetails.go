@@ -31,6 +31,8 @@ const (
 
 	// OwnerNameKey represents the name of the owner to which the resource relates
 	OwnerNameKey = MetadataNamespace + "/owner-name"
+	// OwnerNamespaceKey represents the namespace of the owner to which the resource relates
+	OwnerNamespaceKey = MetadataNamespace + "/owner-namespace"
 	// OwnerKindKey represents the kind of the owner to which the resource relates
 	OwnerKindKey = MetadataNamespace + "/owner-kind"
 	// OwnerGroupKey represents the group of the owner to which the resource relates
@@ -141,9 +143,10 @@ func NewIstioPruningDetails(instance *v1alpha1.IstioOperator) helmreconciler.Pru
 	generation := strconv.FormatInt(instance.GetGeneration(), 10)
 	return &helmreconciler.SimplePruningDetails{
 		OwnerLabels: map[string]string{
-			OwnerNameKey:  name,
-			OwnerGroupKey: v1alpha1.IstioOperatorGVK.Group,
-			OwnerKindKey:  v1alpha1.IstioOperatorGVK.Kind,
+			OwnerNameKey:      name,
+			OwnerNamespaceKey: instance.GetNamespace(),
+			OwnerGroupKey:     v1alpha1.IstioOperatorGVK.Group,
+			OwnerKindKey:      v1alpha1.IstioOperatorGVK.Kind,
 		},
 		OwnerAnnotations: map[string]string{
 			OwnerGenerationKey: generation,
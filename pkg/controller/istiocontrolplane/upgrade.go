@@ -0,0 +1,550 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/image"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/readiness"
+	"istio.io/pkg/log"
+)
+
+// UpgradeStrategy is how the controller applies new images set on an IstioOperator, e.g. by a new tag.
+type UpgradeStrategy string
+
+const (
+	// UpgradeStrategyAllAtOnce applies all components at once, like any other change.
+	UpgradeStrategyAllAtOnce UpgradeStrategy = "AllAtOnce"
+	// UpgradeStrategyOrchestrated upgrades components one at a time in dependency order. Each component must pass its
+	// health gates within its readiness timeout before the next one is upgraded. Otherwise, the upgraded components
+	// are rolled back to the previously applied manifests.
+	UpgradeStrategyOrchestrated UpgradeStrategy = "Orchestrated"
+
+	// UpgradeStrategyKey is the IstioOperator annotation setting the UpgradeStrategy.
+	UpgradeStrategyKey = MetadataNamespace + "/upgrade-strategy"
+
+	// defaultUpgradeStrategy is the UpgradeStrategy of IstioOperators without a strategy set.
+	defaultUpgradeStrategy = UpgradeStrategyAllAtOnce
+
+	// upgradeRequeueInterval is how long to wait before checking the health gates of an upgraded component again.
+	upgradeRequeueInterval = 5 * time.Second
+
+	// appliedManifestsSecretType is the type of the Secrets holding the last applied manifests of an IstioOperator.
+	appliedManifestsSecretType corev1.SecretType = MetadataNamespace + "/applied-manifests"
+	// appliedManifestsDataKey is the data key holding the compressed manifests in an applied manifests Secret.
+	appliedManifestsDataKey = "manifests"
+)
+
+// Reasons of the Events recorded and the conditions set during orchestrated upgrades.
+const (
+	EventReasonUpgradeStarted    = "UpgradeStarted"
+	EventReasonComponentUpgraded = "ComponentUpgraded"
+	EventReasonUpgradeSucceeded  = "UpgradeSucceeded"
+	EventReasonUpgradeFailed     = "UpgradeFailed"
+	EventReasonUpgradeRolledBack = "UpgradeRolledBack"
+
+	reasonUpgrading         = "Upgrading"
+	reasonUpgradeRolledBack = "UpgradeRolledBack"
+)
+
+// upgradeStrategy returns the UpgradeStrategy of instance.
+func upgradeStrategy(instance *iop.IstioOperator) UpgradeStrategy {
+	s, ok := instance.GetAnnotations()[UpgradeStrategyKey]
+	if !ok {
+		return defaultUpgradeStrategy
+	}
+	switch us := UpgradeStrategy(s); us {
+	case UpgradeStrategyAllAtOnce, UpgradeStrategyOrchestrated:
+		return us
+	}
+	log.Warnf("ignoring invalid value %q of annotation %s", s, UpgradeStrategyKey)
+	return defaultUpgradeStrategy
+}
+
+// upgrading reports whether an orchestrated upgrade of instance is in progress.
+func upgrading(instance *iop.IstioOperator) bool {
+	return instance.Status != nil && instance.Status.Upgrade != nil &&
+		instance.Status.Upgrade.Phase == iop.UpgradePhaseUpgrading
+}
+
+// appliedManifests are the manifests of the last reconcile of an IstioOperator after which all its components were
+// ready. Orchestrated upgrades roll back to them.
+type appliedManifests struct {
+	// Hash is the input hash of the reconcile, see inputHash.
+	Hash string `json:"hash"`
+	// Tag is the tag of the merged spec.
+	Tag string `json:"tag"`
+	// Manifests are the manifests of each component.
+	Manifests map[string]string `json:"manifests"`
+}
+
+// appliedManifestsSecretName returns the name of the Secret holding the applied manifests of the IstioOperator name.
+func appliedManifestsSecretName(name string) string {
+	return name + "-applied-manifests"
+}
+
+// loadAppliedManifests returns the applied manifests of the IstioOperator key, or nil if none were saved.
+func (r *ReconcileIstioOperator) loadAppliedManifests(key types.NamespacedName) (*appliedManifests, error) {
+	s := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: key.Namespace,
+		Name: appliedManifestsSecretName(key.Name)}, s)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(s.Data[appliedManifestsDataKey]))
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress the applied manifests: %s", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress the applied manifests: %s", err)
+	}
+	out := &appliedManifests{}
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, fmt.Errorf("could not decode the applied manifests: %s", err)
+	}
+	return out, nil
+}
+
+// saveAppliedManifests saves the manifests rendered by reconciler as the applied manifests of instance, unless they
+// were already saved for hash. The Secret holding them is owned by instance, so that it is garbage collected with it.
+func (r *ReconcileIstioOperator) saveAppliedManifests(instance *iop.IstioOperator,
+	reconciler *helmreconciler.HelmReconciler, hash, tag string) error {
+	key := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	prev, err := r.loadAppliedManifests(key)
+	if err != nil {
+		log.Warnf("overwriting the applied manifests of IstioOperator %s: %s", key, err)
+	}
+	if prev != nil && prev.Hash == hash {
+		return nil
+	}
+	manifests, err := reconciler.RenderManifests()
+	if err != nil {
+		return err
+	}
+	am := &appliedManifests{Hash: hash, Tag: tag, Manifests: make(map[string]string)}
+	for c, ms := range manifests {
+		if len(ms) != 0 {
+			am.Manifests[c] = ms[0].Content
+		}
+	}
+	b, err := json.Marshal(am)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	s := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: key.Namespace,
+		Name: appliedManifestsSecretName(key.Name)}, s)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	create := apierrors.IsNotFound(err)
+	s.Name = appliedManifestsSecretName(key.Name)
+	s.Namespace = key.Namespace
+	s.Type = appliedManifestsSecretType
+	s.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: iop.SchemeGroupVersion.String(),
+		Kind:       "IstioOperator",
+		Name:       instance.Name,
+		UID:        instance.UID,
+	}}
+	s.Data = map[string][]byte{appliedManifestsDataKey: buf.Bytes()}
+	if create {
+		return r.client.Create(context.TODO(), s)
+	}
+	return r.client.Update(context.TODO(), s)
+}
+
+// upgrade runs the next step of an orchestrated upgrade of instance to merged, the spec merged with its profile, whose
+// input hash is hash. It returns false if instance is to be reconciled fully instead, which is the case if the
+// UpgradeStrategy is not Orchestrated, if the images of no component changed since the manifests were last applied,
+// or once the upgrade succeeded. After a rollback, instance is not reconciled until its spec changes.
+func (r *ReconcileIstioOperator) upgrade(instance, merged *iop.IstioOperator, hash string,
+	paused []name.ComponentName) (reconcile.Result, bool, error) {
+	key := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	var up *iop.UpgradeStatus
+	if instance.Status != nil && instance.Status.Upgrade != nil {
+		c := *instance.Status.Upgrade
+		c.Components = append([]iop.ComponentUpgrade(nil), c.Components...)
+		up = &c
+	}
+	if up != nil && up.Hash == hash {
+		switch up.Phase {
+		case iop.UpgradePhaseSucceeded:
+			return reconcile.Result{}, false, nil
+		case iop.UpgradePhaseRolledBack, iop.UpgradePhaseRollbackFailed:
+			log.Infof("not reconciling IstioOperator %s, its upgrade to %s was rolled back", key, up.ToTag)
+			return reconcile.Result{RequeueAfter: r.resyncPeriod}, true, nil
+		}
+	}
+	if up != nil && up.Hash != hash && up.Phase == iop.UpgradePhaseUpgrading {
+		// The spec changed mid-upgrade, e.g. to revert the tag. The new spec is applied as usual.
+		up.Phase = iop.UpgradePhaseSuperseded
+		up.Message = "the spec changed before the upgrade finished"
+		if err := r.updateUpgradeStatus(key, up, nil); err != nil {
+			return reconcile.Result{}, true, err
+		}
+		// the spec may be back to the last reconciled one, which must still be reconciled fully
+		defaultSyncTracker.forget(key)
+	}
+	if upgradeStrategy(instance) != UpgradeStrategyOrchestrated {
+		return reconcile.Result{}, false, nil
+	}
+
+	prev, err := r.loadAppliedManifests(key)
+	if err != nil {
+		return reconcile.Result{}, true, err
+	}
+	inProgress := up != nil && up.Hash == hash && up.Phase == iop.UpgradePhaseUpgrading
+	if !inProgress && (prev == nil || prev.Hash == hash) {
+		return reconcile.Result{}, false, nil
+	}
+	if prev == nil {
+		return reconcile.Result{}, true, fmt.Errorf("the applied manifests of IstioOperator %s were deleted mid-upgrade", key)
+	}
+
+	reconciler, err := r.factory.New(merged, r.client)
+	if err != nil {
+		return reconcile.Result{}, true, err
+	}
+	reconciler.SetAdopt(adopt(instance))
+	manifests, err := reconciler.RenderManifests()
+	if conflict, ok := helmreconciler.IsOwnershipConflict(err); ok {
+		return reconcile.Result{RequeueAfter: conflictRequeueInterval}, true, r.reportConflict(instance, conflict)
+	}
+	if err != nil {
+		return reconcile.Result{}, true, err
+	}
+	if !inProgress {
+		changed, err := imagesChanged(prev, manifests)
+		if err != nil {
+			return reconcile.Result{}, true, err
+		}
+		if !changed {
+			return reconcile.Result{}, false, nil
+		}
+		up, err = r.startUpgrade(reconciler, manifests, prev, merged, hash, paused)
+		if err != nil {
+			return reconcile.Result{}, true, err
+		}
+		r.recordEvent(instance, corev1.EventTypeNormal, EventReasonUpgradeStarted,
+			fmt.Sprintf("upgrading from %s to %s: %s", up.FromTag, up.ToTag, upgradeOrder(up)))
+	}
+
+	timeouts, err := readiness.ComponentTimeouts(merged.Spec)
+	if err != nil {
+		return reconcile.Result{}, true, err
+	}
+	gates, err := readiness.ComponentHealthGates(merged.Spec)
+	if err != nil {
+		return reconcile.Result{}, true, err
+	}
+	// The health of components is read from the API server rather than the cache, which may not have seen an apply yet.
+	getter := &readiness.ClientGetter{Client: r.apiReader}
+	if r.apiReader == nil {
+		getter.Client = r.client
+	}
+	for i := range up.Components {
+		cu := &up.Components[i]
+		c := name.ComponentName(cu.Name)
+		if cu.Phase == iop.ComponentUpgradeUpgraded {
+			continue
+		}
+		applied := false
+		if cu.Phase == iop.ComponentUpgradePending {
+			applied = true
+			log.Infof("upgrading component %s of IstioOperator %s", c, key)
+			now := metav1.Now()
+			cu.Phase, cu.AppliedTime = iop.ComponentUpgradeVerifying, &now
+			if err := reconciler.ApplyComponent(c, manifestContent(manifests, c), true); err != nil {
+				return r.rollback(instance, reconciler, up, prev, i, fmt.Sprintf("applying failed: %s", err))
+			}
+		}
+
+		// Right after applying, the workloads may not have started rolling out yet and still report the health of
+		// the previous version, so the health is only checked from the next reconcile on.
+		healthy, msg := false, "waiting for the applied resources to roll out"
+		if !applied {
+			healthy, msg, err = checkComponentHealth(getter, manifestContent(manifests, c), gates[c])
+			if err != nil {
+				return r.rollback(instance, reconciler, up, prev, i, err.Error())
+			}
+		}
+		if healthy {
+			cu.Phase, cu.Message = iop.ComponentUpgradeUpgraded, ""
+			r.recordEvent(instance, corev1.EventTypeNormal, EventReasonComponentUpgraded,
+				fmt.Sprintf("component %s upgraded to %s", c, up.ToTag))
+			continue
+		}
+		timeout := r.upgradeTimeout
+		if t, ok := timeouts[c]; ok {
+			timeout = t
+		}
+		if !applied && timeout != 0 && time.Since(cu.AppliedTime.Time) >= timeout {
+			return r.rollback(instance, reconciler, up, prev, i,
+				fmt.Sprintf("health gates did not pass within %s: %s", timeout, msg))
+		}
+		cu.Message = msg
+		err = r.updateUpgradeStatus(key, up, func(s *iop.IstioOperatorStatus) {
+			s.Conditions = setCondition(s.Conditions, iop.ConditionProgressing, true, reasonUpgrading,
+				fmt.Sprintf("upgrading component %s (%d of %d) to %s", c, i+1, len(up.Components), up.ToTag),
+				instance.Generation, metav1.Now())
+		})
+		return reconcile.Result{RequeueAfter: upgradeRequeueInterval}, true, err
+	}
+
+	// All components are upgraded, the full reconcile which follows applies and prunes the remaining resources.
+	now := metav1.Now()
+	up.Phase, up.CompletionTime = iop.UpgradePhaseSucceeded, &now
+	up.Message = fmt.Sprintf("upgraded from %s to %s", up.FromTag, up.ToTag)
+	r.recordEvent(instance, corev1.EventTypeNormal, EventReasonUpgradeSucceeded, up.Message)
+	return reconcile.Result{}, false, r.updateUpgradeStatus(key, up, nil)
+}
+
+// startUpgrade returns the status of a new upgrade from the previously applied manifests prev to manifests, rendered
+// from merged. Components are upgraded in dependency order, except the paused ones and those without resources.
+func (r *ReconcileIstioOperator) startUpgrade(reconciler *helmreconciler.HelmReconciler,
+	manifests helmreconciler.ChartManifestsMap, prev *appliedManifests, merged *iop.IstioOperator, hash string,
+	paused []name.ComponentName) (*iop.UpgradeStatus, error) {
+	g, err := reconciler.GetCustomizer().Input().GetProcessingOrder(manifests)
+	if err != nil {
+		return nil, err
+	}
+	isPaused := make(map[name.ComponentName]bool)
+	for _, c := range paused {
+		isPaused[c] = true
+	}
+	up := &iop.UpgradeStatus{
+		Phase:     iop.UpgradePhaseUpgrading,
+		FromTag:   prev.Tag,
+		ToTag:     merged.Spec.GetTag(),
+		Hash:      hash,
+		StartTime: metav1.Now(),
+	}
+	for _, c := range g.Sorted() {
+		// components without resources before or after the upgrade have nothing to upgrade
+		if isPaused[c] || !hasObjects(manifestContent(manifests, c)) && !hasObjects(prev.Manifests[string(c)]) {
+			continue
+		}
+		up.Components = append(up.Components, iop.ComponentUpgrade{Name: string(c), Phase: iop.ComponentUpgradePending})
+	}
+	log.Infof("upgrading IstioOperator %s/%s from %s to %s: %s", merged.Namespace, merged.Name, up.FromTag, up.ToTag,
+		upgradeOrder(up))
+	return up, nil
+}
+
+// rollback applies the previously applied manifests prev again to the components of up which were applied, in reverse
+// order, after the component at index failed with msg.
+func (r *ReconcileIstioOperator) rollback(instance *iop.IstioOperator, reconciler *helmreconciler.HelmReconciler,
+	up *iop.UpgradeStatus, prev *appliedManifests, failed int, msg string) (reconcile.Result, bool, error) {
+	key := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	c := up.Components[failed].Name
+	failure := fmt.Sprintf("component %s failed: %s", c, msg)
+	log.Warnf("upgrade of IstioOperator %s to %s failed, rolling back: %s", key, up.ToTag, failure)
+	r.recordEvent(instance, corev1.EventTypeWarning, EventReasonUpgradeFailed, failure)
+	up.Components[failed].Phase, up.Components[failed].Message = iop.ComponentUpgradeFailed, msg
+
+	var rolledBack, rollbackFailed []string
+	for i := failed; i >= 0; i-- {
+		cu := &up.Components[i]
+		err := reconciler.ApplyComponent(name.ComponentName(cu.Name), prev.Manifests[cu.Name], true)
+		if err != nil {
+			rollbackFailed = append(rollbackFailed, cu.Name)
+			cu.Message = fmt.Sprintf("rollback failed: %s", err)
+			continue
+		}
+		rolledBack = append(rolledBack, cu.Name)
+		if i != failed {
+			cu.Phase = iop.ComponentUpgradeRolledBack
+		}
+	}
+
+	now := metav1.Now()
+	up.CompletionTime = &now
+	up.Phase = iop.UpgradePhaseRolledBack
+	up.Message = fmt.Sprintf("%s, rolled back %s to %s", failure, strings.Join(rolledBack, ", "), up.FromTag)
+	eventType := corev1.EventTypeNormal
+	if len(rollbackFailed) != 0 {
+		up.Phase = iop.UpgradePhaseRollbackFailed
+		up.Message += fmt.Sprintf(", rollback of %s failed", strings.Join(rollbackFailed, ", "))
+		eventType = corev1.EventTypeWarning
+	}
+	r.recordEvent(instance, eventType, EventReasonUpgradeRolledBack, up.Message)
+	err := r.updateUpgradeStatus(key, up, func(s *iop.IstioOperatorStatus) {
+		generation, now := instance.Generation, metav1.Now()
+		s.Conditions = setCondition(s.Conditions, iop.ConditionProgressing, false, reasonUpgradeRolledBack,
+			up.Message, generation, now)
+		s.Conditions = setCondition(s.Conditions, iop.ConditionReconciled, false, reasonUpgradeRolledBack,
+			up.Message, generation, now)
+		s.Conditions = setCondition(s.Conditions, iop.ConditionDegraded, true, reasonUpgradeRolledBack,
+			up.Message, generation, now)
+	})
+	return reconcile.Result{RequeueAfter: r.resyncPeriod}, true, err
+}
+
+// updateUpgradeStatus sets the upgrade status of the IstioOperator key to up, and applies fn to its status if not
+// nil.
+func (r *ReconcileIstioOperator) updateUpgradeStatus(key types.NamespacedName, up *iop.UpgradeStatus,
+	fn func(s *iop.IstioOperatorStatus)) error {
+	instance := &iop.IstioOperator{}
+	if err := r.client.Get(context.TODO(), key, instance); err != nil {
+		return err
+	}
+	if instance.Status == nil {
+		instance.Status = &iop.IstioOperatorStatus{}
+	}
+	instance.Status.Upgrade = up
+	if fn != nil {
+		fn(instance.Status)
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// checkComponentHealth reports whether all objects in manifest are ready and all gates pass. If not, msg lists what
+// is not ready yet. An error is returned if an object or gate can never become ready.
+func checkComponentHealth(getter readiness.Getter, manifest string, gates []readiness.HealthGate) (bool, string, error) {
+	objects, err := object.ParseK8sObjectsFromYAMLManifest(manifest)
+	if err != nil {
+		return false, "", err
+	}
+	var notReady []string
+	for _, o := range objects {
+		u := o.UnstructuredObject()
+		ready, reason, err := readiness.Check(getter, u)
+		if err != nil {
+			return false, "", fmt.Errorf("%s/%s/%s: %s", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+		}
+		if !ready {
+			notReady = append(notReady, fmt.Sprintf("%s/%s/%s (%s)", u.GetKind(), u.GetNamespace(), u.GetName(),
+				reason))
+		}
+	}
+	sort.Strings(notReady)
+	for _, g := range gates {
+		ready, reason, err := g.Check(getter)
+		if err != nil {
+			return false, "", fmt.Errorf("health gate %s: %s", g, err)
+		}
+		if !ready {
+			notReady = append(notReady, fmt.Sprintf("health gate %s (%s)", g, reason))
+		}
+	}
+	if len(notReady) != 0 {
+		return false, "waiting for " + strings.Join(notReady, ", "), nil
+	}
+	return true, "", nil
+}
+
+// manifestContent returns the manifest of component c in manifests.
+func manifestContent(manifests helmreconciler.ChartManifestsMap, c name.ComponentName) string {
+	if ms := manifests[string(c)]; len(ms) != 0 {
+		return ms[0].Content
+	}
+	return ""
+}
+
+// imagesChanged reports whether the images of any component which is rendered both in the previously applied
+// manifests prev and in manifests changed, e.g. by a new tag or by a new hub of the component. Enabling or disabling
+// a component is not an upgrade.
+func imagesChanged(prev *appliedManifests, manifests helmreconciler.ChartManifestsMap) (bool, error) {
+	before := make(name.ManifestMap)
+	for c, m := range prev.Manifests {
+		before[name.ComponentName(c)] = []string{m}
+	}
+	after := make(name.ManifestMap)
+	for c := range manifests {
+		after[name.ComponentName(c)] = []string{manifestContent(manifests, name.ComponentName(c))}
+	}
+	beforeImages, err := componentImages(before)
+	if err != nil {
+		return false, err
+	}
+	afterImages, err := componentImages(after)
+	if err != nil {
+		return false, err
+	}
+	for c, images := range afterImages {
+		if old, ok := beforeImages[c]; ok && !reflect.DeepEqual(old, images) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// componentImages returns the set of images used by each component of manifests. Components without images are
+// left out.
+func componentImages(manifests name.ManifestMap) (map[name.ComponentName]map[string]bool, error) {
+	images, err := image.List(manifests, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[name.ComponentName]map[string]bool)
+	for _, img := range images {
+		for _, src := range img.Sources {
+			if out[src.Component] == nil {
+				out[src.Component] = make(map[string]bool)
+			}
+			out[src.Component][img.Image] = true
+		}
+	}
+	return out, nil
+}
+
+// hasObjects reports whether manifest holds any objects. Manifests which cannot be parsed are assumed to.
+func hasObjects(manifest string) bool {
+	objects, err := object.ParseK8sObjectsFromYAMLManifest(manifest)
+	return err != nil || len(objects) != 0
+}
+
+// upgradeOrder describes the order in which the components of up are upgraded.
+func upgradeOrder(up *iop.UpgradeStatus) string {
+	components := make([]string, 0, len(up.Components))
+	for _, cu := range up.Components {
+		components = append(components, cu.Name)
+	}
+	return strings.Join(components, ", ")
+}
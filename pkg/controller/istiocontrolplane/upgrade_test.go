@@ -0,0 +1,366 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package istiocontrolplane
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/helmreconciler"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/util"
+)
+
+func TestUpgradeStrategy(t *testing.T) {
+	tests := []struct {
+		desc  string
+		value string
+		want  UpgradeStrategy
+	}{
+		{
+			desc: "default",
+			want: UpgradeStrategyAllAtOnce,
+		},
+		{
+			desc:  "orchestrated",
+			value: "Orchestrated",
+			want:  UpgradeStrategyOrchestrated,
+		},
+		{
+			desc:  "invalid",
+			value: "orchestrated",
+			want:  UpgradeStrategyAllAtOnce,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			instance := &iop.IstioOperator{}
+			if tt.value != "" {
+				instance.SetAnnotations(map[string]string{UpgradeStrategyKey: tt.value})
+			}
+			if got := upgradeStrategy(instance); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// markReady makes the objects in manifest ready for the readiness checks, as far as the fake client allows.
+func markReady(t *testing.T, cl client.Client, manifest string) {
+	t.Helper()
+	objects, err := object.ParseK8sObjectsFromYAMLManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objects {
+		u := o.UnstructuredObject()
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(u.GroupVersionKind())
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()},
+			live); err != nil {
+			t.Fatal(err)
+		}
+		switch u.GetKind() {
+		case "CustomResourceDefinition":
+			err = unstructured.SetNestedSlice(live.Object, []interface{}{
+				map[string]interface{}{"type": "Established", "status": "True"},
+			}, "status", "conditions")
+		case "Namespace":
+			err = unstructured.SetNestedField(live.Object, "Active", "status", "phase")
+		case "Service":
+			err = unstructured.SetNestedField(live.Object, "10.0.0.1", "spec", "clusterIP")
+		case "Deployment":
+			markDeploymentsReady(t, cl, types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()})
+			continue
+		default:
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Update(context.TODO(), live); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIOPController_OrchestratedUpgrade(t *testing.T) {
+	key := types.NamespacedName{Name: "upgrade-istiocontrolplane", Namespace: "istio-system"}
+	instance := &iop.IstioOperator{
+		Kind:       "IstioOperator",
+		ApiVersion: "install.istio.io/v1alpha1",
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace,
+			Annotations: map[string]string{UpgradeStrategyKey: string(UpgradeStrategyOrchestrated)}},
+		Spec: newUpgradeSpec(t, "1.4.0", ""),
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(iop.SchemeGroupVersion, instance)
+	cl := fake.NewFakeClientWithScheme(s, instance)
+	recorder := record.NewFakeRecorder(1000)
+	factory := &helmreconciler.Factory{CustomizerFactory: &IstioRenderingCustomizerFactory{}, EventRecorder: recorder}
+	r := &ReconcileIstioOperator{client: cl, scheme: s, factory: factory, resyncPeriod: time.Hour,
+		upgradeTimeout: time.Hour}
+	req := reconcile.Request{NamespacedName: key}
+	pilotKey := types.NamespacedName{Namespace: "istio-system", Name: "istio-pilot"}
+
+	reconcileOnce := func() {
+		t.Helper()
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+	}
+	pilotImage := func() string {
+		t.Helper()
+		d := &unstructured.Unstructured{}
+		d.SetAPIVersion("apps/v1")
+		d.SetKind("Deployment")
+		if err := cl.Get(context.TODO(), pilotKey, d); err != nil {
+			t.Fatal(err)
+		}
+		containers, _, _ := unstructured.NestedSlice(d.Object, "spec", "template", "spec", "containers")
+		return containers[0].(map[string]interface{})["image"].(string)
+	}
+	setSpec := func(spec *v1alpha1.IstioOperatorSpec) {
+		t.Helper()
+		got := &iop.IstioOperator{}
+		if err := cl.Get(context.TODO(), key, got); err != nil {
+			t.Fatal(err)
+		}
+		got.Spec = spec
+		got.Generation++
+		if err := cl.Update(context.TODO(), got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// upgradeUntil reconciles until the upgrade reaches phase, and returns its status.
+	upgradeUntil := func(phase iop.UpgradePhase) *iop.UpgradeStatus {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			reconcileOnce()
+			got := &iop.IstioOperator{}
+			if err := cl.Get(context.TODO(), key, got); err != nil {
+				t.Fatal(err)
+			}
+			if up := got.Status.Upgrade; up != nil && up.Phase == phase {
+				return up
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("upgrade did not reach phase %s", phase)
+		return nil
+	}
+
+	// The first install is not orchestrated. Its manifests are saved once all components are ready.
+	reconcileOnce()
+	markDeploymentsReady(t, cl, pilotKey)
+	reconcileOnce()
+	applied, err := r.loadAppliedManifests(key)
+	if err != nil || applied == nil {
+		t.Fatalf("applied manifests not saved: %v", err)
+	}
+	if applied.Tag != "1.4.0" {
+		t.Errorf("got applied tag %q, want 1.4.0", applied.Tag)
+	}
+	for _, m := range applied.Manifests {
+		markReady(t, cl, m)
+	}
+	checkEvents(t, recorder)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	// Pilot fails its health gate, so the upgrade is rolled back.
+	setSpec(newUpgradeSpec(t, "1.5.0", ts.URL+"/unhealthy"))
+	up := upgradeUntil(iop.UpgradePhaseRolledBack)
+	if up.FromTag != "1.4.0" || up.ToTag != "1.5.0" {
+		t.Errorf("got upgrade from %s to %s, want from 1.4.0 to 1.5.0", up.FromTag, up.ToTag)
+	}
+	phases := make(map[string]iop.ComponentUpgradePhase)
+	for _, cu := range up.Components {
+		phases[cu.Name] = cu.Phase
+	}
+	if phases["Base"] != iop.ComponentUpgradeRolledBack || phases["Pilot"] != iop.ComponentUpgradeFailed {
+		t.Errorf("got component phases %v, want Base rolled back and Pilot failed", phases)
+	}
+	if got := pilotImage(); !strings.HasSuffix(got, ":1.4.0") {
+		t.Errorf("got pilot image %s after rollback, want tag 1.4.0", got)
+	}
+	checkEvents(t, recorder, EventReasonUpgradeStarted, EventReasonComponentUpgraded, EventReasonUpgradeFailed,
+		EventReasonUpgradeRolledBack)
+
+	// The rolled back spec is not applied again.
+	reconcileOnce()
+	if got := pilotImage(); !strings.HasSuffix(got, ":1.4.0") {
+		t.Errorf("got pilot image %s, want the rolled back tag 1.4.0", got)
+	}
+
+	// The reconcile which applies a component does not check its health, since the reader may still return the
+	// objects from before the apply, which are healthy.
+	stale := &staleReader{Reader: cl, objects: make(map[types.NamespacedName]*unstructured.Unstructured)}
+	for _, m := range applied.Manifests {
+		objects, err := object.ParseK8sObjectsFromYAMLManifest(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objects {
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(o.GroupVersionKind())
+			k := types.NamespacedName{Namespace: o.Namespace, Name: o.Name}
+			if err := cl.Get(context.TODO(), k, live); err != nil {
+				t.Fatal(err)
+			}
+			stale.objects[k] = live
+		}
+	}
+	r.apiReader = stale
+	setSpec(newUpgradeSpec(t, "1.5.0", ts.URL+"/ready"))
+	res, err := r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if res.RequeueAfter != upgradeRequeueInterval {
+		t.Errorf("got requeue after %v, want %v", res.RequeueAfter, upgradeRequeueInterval)
+	}
+	got := &iop.IstioOperator{}
+	if err := cl.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if up = got.Status.Upgrade; up == nil || up.Components[0].Phase != iop.ComponentUpgradeVerifying {
+		t.Fatalf("got upgrade %+v, want the first component verifying", up)
+	}
+	r.apiReader = nil
+
+	// Once the health gate passes, the upgrade succeeds and is followed by a full reconcile.
+	up = upgradeUntil(iop.UpgradePhaseSucceeded)
+	for _, cu := range up.Components {
+		if cu.Phase != iop.ComponentUpgradeUpgraded {
+			t.Errorf("component %s in phase %s, want %s", cu.Name, cu.Phase, iop.ComponentUpgradeUpgraded)
+		}
+	}
+	if got := pilotImage(); !strings.HasSuffix(got, ":1.5.0") {
+		t.Errorf("got pilot image %s, want tag 1.5.0", got)
+	}
+	if err := cl.Get(context.TODO(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if c := iop.GetCondition(got.Status.Conditions, iop.ConditionReconciled); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("Reconciled condition: got %+v, want True", c)
+	}
+	checkEvents(t, recorder, EventReasonUpgradeStarted, EventReasonUpgradeSucceeded)
+
+	// The upgraded manifests are saved once all components are ready.
+	markDeploymentsReady(t, cl, pilotKey)
+	reconcileOnce()
+	if applied, err = r.loadAppliedManifests(key); err != nil || applied == nil || applied.Tag != "1.5.0" {
+		t.Fatalf("got applied manifests %v (%v), want tag 1.5.0", applied, err)
+	}
+	upgradeHash := up.Hash
+
+	// Changes which keep the images of all components are applied at once.
+	spec := newUpgradeSpec(t, "1.5.0", ts.URL+"/ready")
+	spec.Values = map[string]interface{}{"pilot": map[string]interface{}{"traceSampling": 2.0}}
+	setSpec(spec)
+	reconcileOnce()
+	checkNoEvent(t, recorder, EventReasonUpgradeStarted)
+
+	// A new image of a single component is upgraded like a new tag.
+	spec = newUpgradeSpec(t, "1.5.0", ts.URL+"/ready")
+	spec.Values = map[string]interface{}{"pilot": map[string]interface{}{"traceSampling": 2.0,
+		"image": "example.io/istio/pilot:1.5.0"}}
+	setSpec(spec)
+	for i := 0; i < 10; i++ {
+		reconcileOnce()
+		got := &iop.IstioOperator{}
+		if err := cl.Get(context.TODO(), key, got); err != nil {
+			t.Fatal(err)
+		}
+		if up = got.Status.Upgrade; up.Hash != upgradeHash && up.Phase == iop.UpgradePhaseSucceeded {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if up.Hash == upgradeHash || up.Phase != iop.UpgradePhaseSucceeded {
+		t.Fatalf("got upgrade %+v, want the image change upgraded", up)
+	}
+	if got := pilotImage(); got != "example.io/istio/pilot:1.5.0" {
+		t.Errorf("got pilot image %s, want example.io/istio/pilot:1.5.0", got)
+	}
+	checkEvents(t, recorder, EventReasonUpgradeStarted, EventReasonUpgradeSucceeded)
+}
+
+// staleReader is a client.Reader which returns the given objects instead of their live state.
+type staleReader struct {
+	client.Reader
+	objects map[types.NamespacedName]*unstructured.Unstructured
+}
+
+func (s *staleReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if o := s.objects[key]; ok && o != nil && o.GroupVersionKind() == u.GroupVersionKind() {
+		o.DeepCopyInto(u)
+		return nil
+	}
+	return s.Reader.Get(ctx, key, obj)
+}
+
+// checkNoEvent checks that no event with the given reason was recorded, and drains the recorded events.
+func checkNoEvent(t *testing.T, recorder *record.FakeRecorder, reason string) {
+	t.Helper()
+	for len(recorder.Events) > 0 {
+		// FakeRecorder events have the form "type reason message".
+		if f := strings.Fields(<-recorder.Events); len(f) > 1 && f[1] == reason {
+			t.Errorf("unexpected %s event recorded", reason)
+		}
+	}
+}
+
+// newUpgradeSpec returns a minimal spec with the given tag. If gate is set, it is the URL of a health gate of pilot,
+// which must pass within a short readiness timeout.
+func newUpgradeSpec(t *testing.T, tag, gate string) *v1alpha1.IstioOperatorSpec {
+	t.Helper()
+	pilot := ""
+	if gate != "" {
+		pilot = fmt.Sprintf(`, "components": {"pilot": {"spec": {"readinessTimeout": "1ms", "healthGates": [{"httpGet": %q}]}}}`,
+			gate)
+	}
+	spec := &v1alpha1.IstioOperatorSpec{}
+	if err := util.UnmarshalWithJSONPB(fmt.Sprintf(`{"profile": "minimal", "tag": %q, "meshConfig": {"rootNamespace": "istio-system"}%s}`,
+		tag, pilot), spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
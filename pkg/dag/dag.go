@@ -0,0 +1,295 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package dag executes work for a set of components in dependency order.
+
+A Graph is built fresh for each invocation from the set of components to process and the dependencies they declare.
+Execute runs a function for each component concurrently, as soon as all of its dependencies have completed
+successfully. If a dependency fails or is skipped, the component is skipped and its result is a *SkippedError naming
+the dependency.
+*/
+package dag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"istio.io/operator/pkg/name"
+)
+
+// Graph is a directed acyclic graph of components. An edge from a component to one of its dependencies means the
+// dependency must be processed successfully before the component can be processed.
+type Graph struct {
+	// nodes are the components in the graph, in sorted order.
+	nodes []name.ComponentName
+	// deps maps each component to its direct dependencies within the graph.
+	deps map[name.ComponentName][]name.ComponentName
+}
+
+// SkippedError is the result for a component which was not processed because one of its dependencies failed or was
+// skipped itself.
+type SkippedError struct {
+	// Component is the skipped component.
+	Component name.ComponentName
+	// Dependency is the dependency which failed or was skipped.
+	Dependency name.ComponentName
+	// Cause is the result of the dependency.
+	Cause error
+}
+
+// Error implements the error interface.
+func (e *SkippedError) Error() string {
+	if _, ok := e.Cause.(*SkippedError); ok {
+		return fmt.Sprintf("skipped because dependency %s was skipped", e.Dependency)
+	}
+	return fmt.Sprintf("skipped because dependency %s failed: %s", e.Dependency, e.Cause)
+}
+
+// IsSkipped reports whether err is a *SkippedError.
+func IsSkipped(err error) bool {
+	_, ok := err.(*SkippedError)
+	return ok
+}
+
+// NewGraph returns a Graph for the given components, using deps to look up the dependencies of each component.
+// Dependencies on components which are not in components are replaced with their own dependencies, so that the
+// ordering between the remaining components is preserved. An error is returned if the dependencies contain a cycle.
+func NewGraph(components []name.ComponentName, deps map[name.ComponentName][]name.ComponentName) (*Graph, error) {
+	in := make(map[name.ComponentName]bool)
+	for _, c := range components {
+		in[c] = true
+	}
+	g := &Graph{deps: make(map[name.ComponentName][]name.ComponentName)}
+	for c := range in {
+		g.nodes = append(g.nodes, c)
+	}
+	sort.Slice(g.nodes, func(i, j int) bool { return g.nodes[i] < g.nodes[j] })
+
+	if err := checkCycles(deps); err != nil {
+		return nil, err
+	}
+	for _, c := range g.nodes {
+		g.deps[c] = resolveDependencies(c, in, deps)
+	}
+	return g, nil
+}
+
+// ComponentGraph returns a Graph for the given components using the dependencies declared in
+// name.ComponentDependencies. Components without declared dependencies depend on the base component.
+func ComponentGraph(components []name.ComponentName) (*Graph, error) {
+	deps := make(map[name.ComponentName][]name.ComponentName)
+	for c, d := range name.ComponentDependencies {
+		deps[c] = d
+	}
+	for _, c := range components {
+		if _, ok := deps[c]; !ok && c != name.IstioBaseComponentName {
+			deps[c] = []name.ComponentName{name.IstioBaseComponentName}
+		}
+	}
+	return NewGraph(components, deps)
+}
+
+// Nodes returns the components in the graph in sorted order.
+func (g *Graph) Nodes() []name.ComponentName {
+	return append([]name.ComponentName(nil), g.nodes...)
+}
+
+// Sorted returns the components in the graph in dependency order: every component comes after all of its
+// dependencies. Components whose order is not determined by their dependencies are in sorted order.
+func (g *Graph) Sorted() []name.ComponentName {
+	done := make(map[name.ComponentName]bool)
+	var out []name.ComponentName
+	for len(out) < len(g.nodes) {
+		for _, c := range g.nodes {
+			if done[c] {
+				continue
+			}
+			ready := true
+			for _, d := range g.deps[c] {
+				ready = ready && done[d]
+			}
+			if ready {
+				done[c] = true
+				out = append(out, c)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Dependencies returns the direct dependencies of c within the graph.
+func (g *Graph) Dependencies(c name.ComponentName) []name.ComponentName {
+	return append([]name.ComponentName(nil), g.deps[c]...)
+}
+
+// Execute calls fn for every component in the graph and returns the result for each component. fn is called
+// concurrently for components whose dependencies have all completed successfully. Components with a failed or
+// skipped dependency are not passed to fn; their result is a *SkippedError. Execute returns once all components are
+// done.
+func (g *Graph) Execute(fn func(c name.ComponentName) error) map[name.ComponentName]error {
+	return g.ExecuteWithLimit(0, fn)
+}
+
+// ExecuteWithLimit is like Execute, but runs fn for at most limit components at a time. A limit of 0 or less means no
+// limit.
+func (g *Graph) ExecuteWithLimit(limit int, fn func(c name.ComponentName) error) map[name.ComponentName]error {
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+	var mu sync.Mutex
+	results := make(map[name.ComponentName]error)
+	done := make(map[name.ComponentName]chan struct{})
+	for _, c := range g.nodes {
+		done[c] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range g.nodes {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[c])
+			var skipped error
+			for _, d := range g.deps[c] {
+				<-done[d]
+				mu.Lock()
+				derr := results[d]
+				mu.Unlock()
+				if derr != nil && skipped == nil {
+					skipped = &SkippedError{Component: c, Dependency: d, Cause: derr}
+				}
+			}
+			err := skipped
+			if err == nil {
+				if sem != nil {
+					sem <- struct{}{}
+				}
+				err = fn(c)
+				if sem != nil {
+					<-sem
+				}
+			}
+			mu.Lock()
+			results[c] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// String returns the graph as an indented tree, with each component listed below its dependencies.
+func (g *Graph) String() string {
+	children := make(map[name.ComponentName][]name.ComponentName)
+	var roots []name.ComponentName
+	for _, c := range g.nodes {
+		if len(g.deps[c]) == 0 {
+			roots = append(roots, c)
+		}
+		for _, d := range g.deps[c] {
+			children[d] = append(children[d], c)
+		}
+	}
+	var sb strings.Builder
+	var write func(c name.ComponentName, prefix string)
+	write = func(c name.ComponentName, prefix string) {
+		sb.WriteString(prefix + string(c) + "\n")
+		for _, ch := range children[c] {
+			write(ch, prefix+"  ")
+		}
+	}
+	for _, r := range roots {
+		write(r, "")
+	}
+	return sb.String()
+}
+
+// resolveDependencies returns the dependencies of c which are in the graph, replacing dependencies which are not in
+// the graph with their own dependencies. deps must not have cycles.
+func resolveDependencies(c name.ComponentName, in map[name.ComponentName]bool,
+	deps map[name.ComponentName][]name.ComponentName) []name.ComponentName {
+	seen := make(map[name.ComponentName]bool)
+	var out []name.ComponentName
+	var visit func(n name.ComponentName)
+	visit = func(n name.ComponentName) {
+		for _, d := range deps[n] {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			if in[d] {
+				out = append(out, d)
+				continue
+			}
+			visit(d)
+		}
+	}
+	visit(c)
+	return out
+}
+
+// checkCycles returns an error describing a cycle in deps, if there is one.
+func checkCycles(deps map[name.ComponentName][]name.ComponentName) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[name.ComponentName]int)
+	var path []name.ComponentName
+	var visit func(n name.ComponentName) error
+	visit = func(n name.ComponentName) error {
+		switch state[n] {
+		case visiting:
+			var cycle []string
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{string(path[i])}, cycle...)
+				if path[i] == n {
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(cycle, " -> "), n)
+		case visited:
+			return nil
+		}
+		state[n] = visiting
+		path = append(path, n)
+		for _, d := range deps[n] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = visited
+		return nil
+	}
+
+	// Visit in sorted order so that the reported cycle is deterministic.
+	var keys []name.ComponentName
+	for n := range deps {
+		keys = append(keys, n)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, n := range keys {
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dag
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/operator/pkg/name"
+)
+
+func TestNewGraph(t *testing.T) {
+	deps := map[name.ComponentName][]name.ComponentName{
+		"b": {"a"},
+		"c": {"b"},
+		"d": {"c", "a"},
+	}
+	tests := []struct {
+		desc       string
+		components []name.ComponentName
+		want       map[name.ComponentName][]name.ComponentName
+	}{
+		{
+			desc:       "all components",
+			components: []name.ComponentName{"d", "c", "b", "a"},
+			want: map[name.ComponentName][]name.ComponentName{
+				"a": nil,
+				"b": {"a"},
+				"c": {"b"},
+				"d": {"c", "a"},
+			},
+		},
+		{
+			desc:       "missing components are resolved through their dependencies",
+			components: []name.ComponentName{"a", "d"},
+			want: map[name.ComponentName][]name.ComponentName{
+				"a": nil,
+				"d": {"a"},
+			},
+		},
+		{
+			desc:       "missing root",
+			components: []name.ComponentName{"b", "c"},
+			want: map[name.ComponentName][]name.ComponentName{
+				"b": nil,
+				"c": {"b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			g, err := NewGraph(tt.components, deps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[name.ComponentName][]name.ComponentName)
+			for _, c := range g.Nodes() {
+				got[c] = g.Dependencies(c)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got dependencies %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewGraphCycle(t *testing.T) {
+	deps := map[name.ComponentName][]name.ComponentName{
+		"a": {"c"},
+		"b": {"a"},
+		"c": {"b"},
+	}
+	_, err := NewGraph([]name.ComponentName{"a", "b", "c"}, deps)
+	if err == nil {
+		t.Fatal("expected error for dependency cycle")
+	}
+	if want := "dependency cycle: a -> c -> b -> a"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
+
+func TestSorted(t *testing.T) {
+	deps := map[name.ComponentName][]name.ComponentName{
+		"a": {"e"},
+		"b": {"d"},
+		"c": {"e"},
+		"d": {"e"},
+	}
+	g, err := NewGraph([]name.ComponentName{"a", "b", "c", "d", "e"}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []name.ComponentName{"e", "a", "c", "d", "b"}
+	if got := g.Sorted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestComponentGraph(t *testing.T) {
+	g, err := ComponentGraph([]name.ComponentName{
+		name.IstioBaseComponentName,
+		name.PilotComponentName,
+		name.IngressComponentName,
+		name.AddonComponentName,
+		"Custom",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[name.ComponentName][]name.ComponentName{
+		name.IstioBaseComponentName: nil,
+		name.PilotComponentName:     {name.IstioBaseComponentName},
+		name.IngressComponentName:   {name.PilotComponentName},
+		// Telemetry is not in the graph, so Addon depends on its dependency instead.
+		name.AddonComponentName: {name.IstioBaseComponentName},
+		"Custom":                {name.IstioBaseComponentName},
+	}
+	for c, w := range want {
+		if got := g.Dependencies(c); !reflect.DeepEqual(got, w) {
+			t.Errorf("%s: got dependencies %v, want %v", c, got, w)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	deps := map[name.ComponentName][]name.ComponentName{
+		"b": {"a"},
+		"c": {"b"},
+		"d": {"c"},
+		"e": {"a"},
+	}
+	g, err := NewGraph([]name.ComponentName{"a", "b", "c", "d", "e"}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	done := make(map[name.ComponentName]bool)
+	results := g.Execute(func(c name.ComponentName) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, d := range g.Dependencies(c) {
+			if !done[d] {
+				t.Errorf("%s started before its dependency %s completed", c, d)
+			}
+		}
+		done[c] = true
+		if c == "b" {
+			return fmt.Errorf("b failed")
+		}
+		return nil
+	})
+
+	for _, c := range []name.ComponentName{"a", "e"} {
+		if results[c] != nil {
+			t.Errorf("%s: got error %v, want nil", c, results[c])
+		}
+	}
+	if results["b"] == nil || IsSkipped(results["b"]) {
+		t.Errorf("b: got %v, want failure", results["b"])
+	}
+	for c, want := range map[name.ComponentName]string{
+		"c": "skipped because dependency b failed: b failed",
+		"d": "skipped because dependency c was skipped",
+	} {
+		if !IsSkipped(results[c]) || results[c].Error() != want {
+			t.Errorf("%s: got %v, want %q", c, results[c], want)
+		}
+		if done[c] {
+			t.Errorf("%s: was executed after its dependency failed", c)
+		}
+	}
+}
+
+func TestExecuteWithLimit(t *testing.T) {
+	var components []name.ComponentName
+	for i := 0; i < 8; i++ {
+		components = append(components, name.ComponentName(fmt.Sprintf("c%d", i)))
+	}
+	g, err := NewGraph(components, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	results := g.ExecuteWithLimit(3, func(c name.ComponentName) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if len(results) != len(components) {
+		t.Errorf("got %d results, want %d", len(results), len(components))
+	}
+	if maxRunning > 3 {
+		t.Errorf("got %d components running at once, want at most 3", maxRunning)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"istio.io/operator/pkg/vfs"
+)
+
+var (
+	// vfsChartsDigest is the digest of the compiled-in charts, which never change.
+	vfsChartsDigest     string
+	vfsChartsDigestErr  error
+	vfsChartsDigestOnce sync.Once
+)
+
+// ChartsDigest returns a digest of the path and content of all files of the charts in chartsRootDir, or of the
+// compiled-in charts if chartsRootDir is empty. It changes whenever a chart is added, removed or edited.
+func ChartsDigest(chartsRootDir string) (string, error) {
+	if chartsRootDir == "" {
+		vfsChartsDigestOnce.Do(func() {
+			vfsChartsDigest, vfsChartsDigestErr = vfsDigest(chartsRoot)
+		})
+		return vfsChartsDigest, vfsChartsDigestErr
+	}
+	return dirDigest(chartsRootDir)
+}
+
+// vfsDigest returns a digest of the compiled-in files under root.
+func vfsDigest(root string) (string, error) {
+	paths, err := vfs.GetFilesRecursive(root)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, p := range paths {
+		b, err := vfs.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		writeDigestEntry(h, p, b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dirDigest returns a digest of the files under the local directory root. filepath.Walk visits them in lexical
+// order.
+func dirDigest(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		writeDigestEntry(h, rel, b)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeDigestEntry writes a file to h, with its path and length so that entries cannot run into each other.
+func writeDigestEntry(h hash.Hash, path string, content []byte) {
+	_, _ = fmt.Fprintf(h, "%s\x00%d\x00", path, len(content))
+	_, _ = h.Write(content)
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChartsDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	digest := func() string {
+		t.Helper()
+		d, err := ChartsDigest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	write("base/Chart.yaml", "name: base")
+	write("base/templates/a.yaml", "kind: ConfigMap")
+	first := digest()
+	if got := digest(); got != first {
+		t.Errorf("digest of unchanged charts changed from %s to %s", first, got)
+	}
+	write("base/templates/a.yaml", "kind: Secret")
+	second := digest()
+	if second == first {
+		t.Error("digest unchanged after editing a file")
+	}
+	write("base/templates/b.yaml", "")
+	if got := digest(); got == second {
+		t.Error("digest unchanged after adding an empty file")
+	}
+
+	vfsDigest, err := ChartsDigest("")
+	if err != nil || vfsDigest == "" {
+		t.Errorf("digest of compiled-in charts: got %q, %v", vfsDigest, err)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmreconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/api/operator/v1alpha1"
+)
+
+// Reasons of the Events recorded on the custom resource.
+const (
+	EventReasonReconcileStarted   = "ReconcileStarted"
+	EventReasonReconcileCompleted = "ReconcileCompleted"
+	EventReasonReconcileFailed    = "ReconcileFailed"
+	EventReasonComponentApplied   = "ComponentApplied"
+	EventReasonComponentFailed    = "ComponentFailed"
+	EventReasonPatchConflict      = "PatchConflict"
+	EventReasonPruned             = "Pruned"
+	EventReasonPruneFailed        = "PruneFailed"
+	EventReasonDeleteStarted      = "DeleteStarted"
+	EventReasonDeleted            = "Deleted"
+	EventReasonDeleteFailed       = "DeleteFailed"
+)
+
+// maxEventObjects is the maximum number of objects listed in the message of an Event.
+const maxEventObjects = 10
+
+// NewEventRecordingListener returns a RenderingListener which records Events on instance for the start and end of
+// reconciles and deletes, the outcome of each component, pruned objects and patch conflicts. Events are aggregated
+// per component, rather than recorded for each object.
+func NewEventRecordingListener(instance runtime.Object, recorder record.EventRecorder) RenderingListener {
+	l := &eventRecordingListener{
+		DefaultRenderingListener: &DefaultRenderingListener{},
+		instance:                 instance,
+		recorder:                 recorder,
+	}
+	l.reset()
+	return l
+}
+
+type eventRecordingListener struct {
+	*DefaultRenderingListener
+	instance runtime.Object
+	recorder record.EventRecorder
+
+	// mu protects the fields below, since components are processed concurrently.
+	mu sync.Mutex
+	// charts maps the objects processed in the current reconcile to their chart.
+	charts map[string]string
+	// created, updated and conflicts are the objects created, updated and failing with a conflict for each chart.
+	created, updated, conflicts map[string][]string
+	// pruned and pruneFailed are the objects deleted and failing to be deleted during pruning.
+	pruned, pruneFailed []string
+}
+
+// BeginReconcile records a ReconcileStarted Event.
+func (l *eventRecordingListener) BeginReconcile(instance runtime.Object) error {
+	l.reset()
+	msg := "reconciling"
+	if a, err := meta.Accessor(l.instance); err == nil {
+		msg = fmt.Sprintf("reconciling generation %d", a.GetGeneration())
+	}
+	l.recorder.Event(l.instance, corev1.EventTypeNormal, EventReasonReconcileStarted, msg)
+	return nil
+}
+
+// BeginDelete records a DeleteStarted Event.
+func (l *eventRecordingListener) BeginDelete(instance runtime.Object) error {
+	l.reset()
+	l.recorder.Event(l.instance, corev1.EventTypeNormal, EventReasonDeleteStarted, "deleting all resources")
+	return nil
+}
+
+// BeginResource remembers the chart of obj, so that the outcome of processing it is recorded for the chart.
+func (l *eventRecordingListener) BeginResource(chart string, obj runtime.Object) (runtime.Object, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.charts[objectRef(obj)] = chart
+	return obj, nil
+}
+
+// ResourceCreated counts the created object for its chart.
+func (l *eventRecordingListener) ResourceCreated(created runtime.Object) error {
+	l.add(l.created, created)
+	return nil
+}
+
+// ResourceUpdated counts the updated object for its chart.
+func (l *eventRecordingListener) ResourceUpdated(updated, _ runtime.Object) error {
+	l.add(l.updated, updated)
+	return nil
+}
+
+// ResourceError remembers objects failing with a conflict and objects which could not be pruned. Other errors are
+// reported with the status of the component.
+func (l *eventRecordingListener) ResourceError(obj runtime.Object, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ref := objectRef(obj)
+	if _, ok := l.charts[ref]; !ok {
+		l.pruneFailed = append(l.pruneFailed, ref)
+		return nil
+	}
+	if apierrors.IsConflict(err) {
+		l.conflicts[l.charts[ref]] = append(l.conflicts[l.charts[ref]], ref)
+	}
+	return nil
+}
+
+// ResourceDeleted remembers the deleted object.
+func (l *eventRecordingListener) ResourceDeleted(deleted runtime.Object) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruned = append(l.pruned, objectRef(deleted))
+	return nil
+}
+
+// EndPrune records a Pruned Event listing the deleted objects, and a PruneFailed Event listing the objects which
+// could not be deleted.
+func (l *eventRecordingListener) EndPrune() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pruned) != 0 {
+		l.recorder.Event(l.instance, corev1.EventTypeNormal, EventReasonPruned,
+			fmt.Sprintf("pruned %s", objectList(l.pruned)))
+	}
+	if len(l.pruneFailed) != 0 {
+		l.recorder.Event(l.instance, corev1.EventTypeWarning, EventReasonPruneFailed,
+			fmt.Sprintf("failed to prune %s", objectList(l.pruneFailed)))
+	}
+	l.pruned, l.pruneFailed = nil, nil
+	return nil
+}
+
+// EndDelete records a Deleted or DeleteFailed Event.
+func (l *eventRecordingListener) EndDelete(_ runtime.Object, err error) error {
+	if err != nil {
+		l.recorder.Event(l.instance, corev1.EventTypeWarning, EventReasonDeleteFailed, err.Error())
+		return nil
+	}
+	l.recorder.Event(l.instance, corev1.EventTypeNormal, EventReasonDeleted, "deleted all resources")
+	return nil
+}
+
+// EndReconcile records a ComponentApplied or ComponentFailed Event for each component in status, a PatchConflict
+// Event for each component with conflicts, and a ReconcileCompleted or ReconcileFailed Event.
+func (l *eventRecordingListener) EndReconcile(_ runtime.Object, status *v1alpha1.InstallStatus) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var components, failed []string
+	for c := range status.GetComponentStatus() {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		vs := status.ComponentStatus[c]
+		counts := fmt.Sprintf("%d created, %d updated", len(l.created[c]), len(l.updated[c]))
+		if vs.Status == v1alpha1.InstallStatus_ERROR {
+			failed = append(failed, c)
+			l.recorder.Event(l.instance, corev1.EventTypeWarning, EventReasonComponentFailed,
+				fmt.Sprintf("%s: %s: %s", c, counts, vs.Error))
+		} else {
+			l.recorder.Event(l.instance, corev1.EventTypeNormal, EventReasonComponentApplied,
+				fmt.Sprintf("%s: %s", c, counts))
+		}
+		if len(l.conflicts[c]) != 0 {
+			l.recorder.Event(l.instance, corev1.EventTypeWarning, EventReasonPatchConflict,
+				fmt.Sprintf("%s: conflict updating %s", c, objectList(l.conflicts[c])))
+		}
+	}
+	if len(failed) != 0 {
+		l.recorder.Event(l.instance, corev1.EventTypeWarning, EventReasonReconcileFailed,
+			fmt.Sprintf("failed components: %s", strings.Join(failed, ", ")))
+		return nil
+	}
+	l.recorder.Event(l.instance, corev1.EventTypeNormal, EventReasonReconcileCompleted,
+		fmt.Sprintf("reconciled %d components", len(components)))
+	return nil
+}
+
+func (l *eventRecordingListener) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.charts = make(map[string]string)
+	l.created = make(map[string][]string)
+	l.updated = make(map[string][]string)
+	l.conflicts = make(map[string][]string)
+	l.pruned, l.pruneFailed = nil, nil
+}
+
+// add adds obj to the objects of its chart in m.
+func (l *eventRecordingListener) add(m map[string][]string, obj runtime.Object) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ref := objectRef(obj)
+	m[l.charts[ref]] = append(m[l.charts[ref]], ref)
+}
+
+// objectRef returns obj as Kind/namespace/name, or Kind/name if it is not namespaced.
+func objectRef(obj runtime.Object) string {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	a, err := meta.Accessor(obj)
+	if err != nil {
+		return kind
+	}
+	if a.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", kind, a.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", kind, a.GetNamespace(), a.GetName())
+}
+
+// objectList returns the number of objects in refs and a sorted list of them, with at most maxEventObjects listed.
+func objectList(refs []string) string {
+	n := len(refs)
+	refs = append([]string(nil), refs...)
+	sort.Strings(refs)
+	more := ""
+	if n > maxEventObjects {
+		more = fmt.Sprintf(" and %d more", n-maxEventObjects)
+		refs = refs[:maxEventObjects]
+	}
+	noun := "objects"
+	if n == 1 {
+		noun = "object"
+	}
+	return fmt.Sprintf("%d %s: %s%s", n, noun, strings.Join(refs, ", "), more)
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmreconciler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+// recordedEvents returns the events recorded so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var out []string
+	for len(recorder.Events) > 0 {
+		out = append(out, <-recorder.Events)
+	}
+	return out
+}
+
+func TestEventRecordingListenerReconcile(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	instance := &iop.IstioOperator{}
+	instance.SetGeneration(3)
+	l := NewEventRecordingListener(instance, recorder)
+
+	if err := l.BeginReconcile(instance); err != nil {
+		t.Fatal(err)
+	}
+	// 40 created objects in a single chart result in a single event.
+	for i := 0; i < 40; i++ {
+		obj := objecttest.New("ConfigMap", "istio-system", fmt.Sprintf("cm-%d", i))
+		if _, err := l.BeginResource("Pilot", obj); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.ResourceCreated(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := objecttest.New("Service", "istio-system", "istio-ingressgateway")
+	if _, err := l.BeginResource("IngressGateways", svc); err != nil {
+		t.Fatal(err)
+	}
+	conflict := apierrors.NewConflict(schema.GroupResource{Resource: "services"}, "istio-ingressgateway", fmt.Errorf("modified"))
+	if err := l.ResourceError(svc, conflict); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.BeginPrune(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []*unstructured.Unstructured{objecttest.New("Deployment", "istio-system", "istio-policy"),
+		objecttest.New("ClusterRole", "", "istio-policy")} {
+		if err := l.ResourceDeleted(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.ResourceError(objecttest.New("Service", "istio-system", "istio-policy"), fmt.Errorf("forbidden")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.EndPrune(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := &v1alpha1.InstallStatus{
+		ComponentStatus: map[string]*v1alpha1.InstallStatus_VersionStatus{
+			"Pilot":           {Status: v1alpha1.InstallStatus_HEALTHY},
+			"IngressGateways": {Status: v1alpha1.InstallStatus_ERROR, Error: "conflict"},
+		},
+	}
+	if err := l.EndReconcile(instance, status); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Normal ReconcileStarted reconciling generation 3",
+		"Normal Pruned pruned 2 objects: ClusterRole/istio-policy, Deployment/istio-system/istio-policy",
+		"Warning PruneFailed failed to prune 1 object: Service/istio-system/istio-policy",
+		"Warning ComponentFailed IngressGateways: 0 created, 0 updated: conflict",
+		"Warning PatchConflict IngressGateways: conflict updating 1 object: Service/istio-system/istio-ingressgateway",
+		"Normal ComponentApplied Pilot: 40 created, 0 updated",
+		"Warning ReconcileFailed failed components: IngressGateways",
+	}
+	if got := recordedEvents(recorder); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events:\n%q\nwant:\n%q", got, want)
+	}
+
+	// A new reconcile starts from scratch.
+	if err := l.BeginReconcile(instance); err != nil {
+		t.Fatal(err)
+	}
+	status.ComponentStatus = map[string]*v1alpha1.InstallStatus_VersionStatus{"Pilot": {Status: v1alpha1.InstallStatus_HEALTHY}}
+	if err := l.EndReconcile(instance, status); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"Normal ReconcileStarted reconciling generation 3",
+		"Normal ComponentApplied Pilot: 0 created, 0 updated",
+		"Normal ReconcileCompleted reconciled 1 components",
+	}
+	if got := recordedEvents(recorder); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestEventRecordingListenerDelete(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	instance := &iop.IstioOperator{}
+	l := NewEventRecordingListener(instance, recorder)
+
+	if err := l.BeginDelete(instance); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.EndDelete(instance, fmt.Errorf("timed out")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Normal DeleteStarted deleting all resources",
+		"Warning DeleteFailed timed out",
+	}
+	if got := recordedEvents(recorder); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestObjectList(t *testing.T) {
+	var refs []string
+	for i := 0; i < 12; i++ {
+		refs = append(refs, fmt.Sprintf("ConfigMap/ns/cm-%02d", i))
+	}
+	want := "12 objects: ConfigMap/ns/cm-00, ConfigMap/ns/cm-01, ConfigMap/ns/cm-02, ConfigMap/ns/cm-03, " +
+		"ConfigMap/ns/cm-04, ConfigMap/ns/cm-05, ConfigMap/ns/cm-06, ConfigMap/ns/cm-07, ConfigMap/ns/cm-08, " +
+		"ConfigMap/ns/cm-09 and 2 more"
+	if got := objectList(refs); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -25,7 +25,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"istio.io/api/operator/v1alpha1"
-	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/dag"
 )
 
 // RenderingCustomizer encompasses all the customization details for a specific rendering invocation.
@@ -82,10 +82,9 @@ type RenderingInput interface {
 	// GetTargetNamespace returns the target namespace which should be applied to namespaced resources
 	// (i.e. used to set Release.Namespace)
 	GetTargetNamespace() string
-	// GetProcessingOrder returns a dependency tree for the given manifests. ComponentNameToListMap is a map of
-	// each component to its dependencies. DependencyWaitCh is a map of channels, indexed by name. The component with
-	// the given name must wait on the channel before starting its processing.
-	GetProcessingOrder(manifests ChartManifestsMap) (ComponentNameToListMap, DependencyWaitCh)
+	// GetProcessingOrder returns the dependency graph for the given manifests. A new graph is returned for each call,
+	// so that concurrent reconciles do not share state.
+	GetProcessingOrder(manifests ChartManifestsMap) (*dag.Graph, error)
 }
 
 // RenderingListener is the main hook into the rendering process.  The methods represent each stage in the
@@ -202,13 +201,3 @@ type ClientProvider interface {
 	// GetClient returns a kubernetes client.
 	GetClient() client.Client
 }
-
-// ComponentNameToListMap is a map of ComponentName to a list of ComponentNames.
-type ComponentNameToListMap map[name.ComponentName][]name.ComponentName
-
-// ComponentTree defines a dependency tree.
-type ComponentTree map[name.ComponentName]interface{}
-
-// DependencyWaitCh defines a map of component name to a channel which signals when its parent dependency has
-// completed.
-type DependencyWaitCh map[name.ComponentName]chan struct{}
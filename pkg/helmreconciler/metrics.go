@@ -0,0 +1,279 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmreconciler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/name"
+)
+
+const (
+	metricsNamespace = "istio_operator"
+
+	// resultSuccess and resultError are the values of the result label.
+	resultSuccess = "success"
+	resultError   = "error"
+
+	// Values of the operation label of objectsTotal.
+	operationCreated = "created"
+	operationUpdated = "updated"
+	operationDeleted = "deleted"
+	operationError   = "error"
+)
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of IstioOperator reconciles.",
+		Buckets:   []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"name", "namespace", "result"})
+
+	reconcilesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconciles_total",
+		Help:      "Number of IstioOperator reconciles.",
+	}, []string{"name", "namespace", "result"})
+
+	componentReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "component_reconcile_duration_seconds",
+		Help:      "Duration of applying the manifest of a component.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"name", "namespace", "component", "result"})
+
+	renderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_duration_seconds",
+		Help:      "Duration of rendering the manifest of a chart.",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"chart"})
+
+	objectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_total",
+		Help:      "Number of objects created, updated, deleted or failing, by GroupVersionKind.",
+	}, []string{"operation", "group", "version", "kind"})
+
+	prunesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "prunes_total",
+		Help:      "Number of times objects which are no longer rendered were pruned.",
+	}, []string{"name", "namespace"})
+
+	prunedObjectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pruned_objects_total",
+		Help:      "Number of objects deleted by pruning.",
+	}, []string{"name", "namespace"})
+
+	componentStatusGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "component_status",
+		Help:      "Status of each component after the last reconcile. The series of the current status is 1, the others 0.",
+	}, []string{"name", "namespace", "component", "status"})
+
+	// gaugeComponentsMu protects gaugeComponents.
+	gaugeComponentsMu sync.Mutex
+	// gaugeComponents are the components with componentStatusGauge series for each IstioOperator, keyed by
+	// namespace/name, so that the series of removed components can be deleted.
+	gaugeComponents = make(map[string][]string)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		reconcileDuration,
+		reconcilesTotal,
+		componentReconcileDuration,
+		renderDuration,
+		objectsTotal,
+		prunesTotal,
+		prunedObjectsTotal,
+		componentStatusGauge,
+	)
+}
+
+// NewMetricsListener returns a RenderingListener which records the Prometheus metrics of reconciles of instance.
+func NewMetricsListener(instance runtime.Object) RenderingListener {
+	l := &metricsListener{DefaultRenderingListener: &DefaultRenderingListener{}}
+	if a, err := meta.Accessor(instance); err == nil {
+		l.name, l.namespace = a.GetName(), a.GetNamespace()
+	}
+	return l
+}
+
+type metricsListener struct {
+	*DefaultRenderingListener
+	name, namespace string
+
+	// mu protects the fields below.
+	mu sync.Mutex
+	// start is the start time of the current reconcile.
+	start time.Time
+	// pruned is the number of objects deleted in the current prune.
+	pruned int
+}
+
+// BeginReconcile records the start time of the reconcile.
+func (l *metricsListener) BeginReconcile(_ runtime.Object) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start = time.Now()
+	return nil
+}
+
+// ResourceCreated counts the created object.
+func (l *metricsListener) ResourceCreated(created runtime.Object) error {
+	countObject(operationCreated, created)
+	return nil
+}
+
+// ResourceUpdated counts the updated object.
+func (l *metricsListener) ResourceUpdated(updated, _ runtime.Object) error {
+	countObject(operationUpdated, updated)
+	return nil
+}
+
+// ResourceDeleted counts the deleted object.
+func (l *metricsListener) ResourceDeleted(deleted runtime.Object) error {
+	countObject(operationDeleted, deleted)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruned++
+	return nil
+}
+
+// ResourceError counts the object the error occurred on.
+func (l *metricsListener) ResourceError(obj runtime.Object, _ error) error {
+	countObject(operationError, obj)
+	return nil
+}
+
+// BeginPrune resets the count of pruned objects.
+func (l *metricsListener) BeginPrune(_ bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruned = 0
+	return nil
+}
+
+// EndPrune counts the prune and the objects it deleted.
+func (l *metricsListener) EndPrune() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prunesTotal.WithLabelValues(l.name, l.namespace).Inc()
+	prunedObjectsTotal.WithLabelValues(l.name, l.namespace).Add(float64(l.pruned))
+	return nil
+}
+
+// EndReconcile records the reconcile duration and the status of each component.
+func (l *metricsListener) EndReconcile(_ runtime.Object, status *v1alpha1.InstallStatus) error {
+	result := resultSuccess
+	for _, vs := range status.GetComponentStatus() {
+		if vs.Status == v1alpha1.InstallStatus_ERROR {
+			result = resultError
+		}
+	}
+	l.mu.Lock()
+	start := l.start
+	l.mu.Unlock()
+	if !start.IsZero() {
+		reconcileDuration.WithLabelValues(l.name, l.namespace, result).Observe(time.Since(start).Seconds())
+	}
+	reconcilesTotal.WithLabelValues(l.name, l.namespace, result).Inc()
+	recordComponentStatus(l.name, l.namespace, status)
+	return nil
+}
+
+// EndDelete deletes the per component series of the deleted IstioOperator.
+func (l *metricsListener) EndDelete(_ runtime.Object, _ error) error {
+	deleteComponentSeries(l.name, l.namespace)
+	return nil
+}
+
+// recordComponentStatus sets the componentStatusGauge series of the IstioOperator with the given name and namespace
+// to the status of each component in status, and deletes the series of components which are no longer in status.
+func recordComponentStatus(crName, crNamespace string, status *v1alpha1.InstallStatus) {
+	gaugeComponentsMu.Lock()
+	defer gaugeComponentsMu.Unlock()
+	key := crNamespace + "/" + crName
+	for _, c := range gaugeComponents[key] {
+		if _, ok := status.GetComponentStatus()[c]; ok {
+			continue
+		}
+		for _, s := range v1alpha1.InstallStatus_Status_name {
+			componentStatusGauge.DeleteLabelValues(crName, crNamespace, c, s)
+		}
+	}
+	var components []string
+	for c, vs := range status.GetComponentStatus() {
+		components = append(components, c)
+		for v, s := range v1alpha1.InstallStatus_Status_name {
+			value := 0.0
+			if vs.Status == v1alpha1.InstallStatus_Status(v) {
+				value = 1
+			}
+			componentStatusGauge.WithLabelValues(crName, crNamespace, c, s).Set(value)
+		}
+	}
+	gaugeComponents[key] = components
+}
+
+// deleteComponentSeries deletes the componentStatusGauge and componentReconcileDuration series of the IstioOperator
+// with the given name and namespace.
+func deleteComponentSeries(crName, crNamespace string) {
+	gaugeComponentsMu.Lock()
+	defer gaugeComponentsMu.Unlock()
+	key := crNamespace + "/" + crName
+	for _, c := range gaugeComponents[key] {
+		for _, s := range v1alpha1.InstallStatus_Status_name {
+			componentStatusGauge.DeleteLabelValues(crName, crNamespace, c, s)
+		}
+	}
+	delete(gaugeComponents, key)
+	for _, c := range allComponents() {
+		for _, result := range []string{resultSuccess, resultError} {
+			componentReconcileDuration.DeleteLabelValues(crName, crNamespace, string(c), result)
+		}
+	}
+}
+
+// observeComponentReconcile records the duration of applying the manifest of component c of the IstioOperator with
+// the given name and namespace, which failed if err is not nil.
+func observeComponentReconcile(crName, crNamespace string, c name.ComponentName, d time.Duration, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	componentReconcileDuration.WithLabelValues(crName, crNamespace, string(c), result).Observe(d.Seconds())
+}
+
+// observeRender records the duration of rendering the manifest of chart c.
+func observeRender(c name.ComponentName, d time.Duration) {
+	renderDuration.WithLabelValues(string(c)).Observe(d.Seconds())
+}
+
+func countObject(operation string, obj runtime.Object) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	objectsTotal.WithLabelValues(operation, gvk.Group, gvk.Version, gvk.Kind).Inc()
+}
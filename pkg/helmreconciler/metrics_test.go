@@ -0,0 +1,167 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmreconciler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"istio.io/api/operator/v1alpha1"
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+// gatherSeries returns the number of series of metric in the controller-runtime metrics registry which match the
+// given labels, and the total number of observations of those series if metric is a histogram.
+func gatherSeries(t *testing.T, metric string, labels map[string]string) (int, uint64) {
+	t.Helper()
+	mfs, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, samples := 0, uint64(0)
+	for _, mf := range mfs {
+		if mf.GetName() != metric {
+			continue
+		}
+	next:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v != lp.GetValue() {
+					continue next
+				}
+			}
+			series++
+			samples += m.GetHistogram().GetSampleCount()
+		}
+	}
+	return series, samples
+}
+
+func TestMetricsListener(t *testing.T) {
+	instance := &iop.IstioOperator{}
+	instance.SetName("metrics-test")
+	instance.SetNamespace("istio-system")
+	l := NewMetricsListener(instance)
+
+	cm := objecttest.New("ConfigMap", "istio-system", "cm")
+	created := testutil.ToFloat64(objectsTotal.WithLabelValues(operationCreated, "", "v1", "ConfigMap"))
+	failed := testutil.ToFloat64(objectsTotal.WithLabelValues(operationError, "", "v1", "ConfigMap"))
+	deleted := testutil.ToFloat64(objectsTotal.WithLabelValues(operationDeleted, "", "v1", "ConfigMap"))
+
+	if err := l.BeginReconcile(instance); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{l.ResourceCreated(cm), l.ResourceCreated(cm), l.ResourceError(cm, fmt.Errorf("denied"))} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.BeginPrune(false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := l.ResourceDeleted(cm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.EndPrune(); err != nil {
+		t.Fatal(err)
+	}
+	status := &v1alpha1.InstallStatus{
+		ComponentStatus: map[string]*v1alpha1.InstallStatus_VersionStatus{
+			"Base":  {Status: v1alpha1.InstallStatus_HEALTHY},
+			"Pilot": {Status: v1alpha1.InstallStatus_ERROR},
+		},
+	}
+	if err := l.EndReconcile(instance, status); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		got  float64
+		want float64
+	}{
+		{"created", testutil.ToFloat64(objectsTotal.WithLabelValues(operationCreated, "", "v1", "ConfigMap")) - created, 2},
+		{"errors", testutil.ToFloat64(objectsTotal.WithLabelValues(operationError, "", "v1", "ConfigMap")) - failed, 1},
+		{"deleted", testutil.ToFloat64(objectsTotal.WithLabelValues(operationDeleted, "", "v1", "ConfigMap")) - deleted, 3},
+		{"prunes", testutil.ToFloat64(prunesTotal.WithLabelValues("metrics-test", "istio-system")), 1},
+		{"pruned objects", testutil.ToFloat64(prunedObjectsTotal.WithLabelValues("metrics-test", "istio-system")), 3},
+		{"reconciles", testutil.ToFloat64(reconcilesTotal.WithLabelValues("metrics-test", "istio-system", resultError)), 1},
+		{"Base healthy", testutil.ToFloat64(componentStatusGauge.WithLabelValues("metrics-test", "istio-system", "Base", "HEALTHY")), 1},
+		{"Base error", testutil.ToFloat64(componentStatusGauge.WithLabelValues("metrics-test", "istio-system", "Base", "ERROR")), 0},
+		{"Pilot error", testutil.ToFloat64(componentStatusGauge.WithLabelValues("metrics-test", "istio-system", "Pilot", "ERROR")), 1},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.desc, tt.got, tt.want)
+		}
+	}
+	if _, got := gatherSeries(t, "istio_operator_reconcile_duration_seconds",
+		map[string]string{"name": "metrics-test", "result": resultError}); got != 1 {
+		t.Errorf("reconcile duration: got %d observations, want 1", got)
+	}
+
+	// The series of components which are no longer installed are removed.
+	status.ComponentStatus = map[string]*v1alpha1.InstallStatus_VersionStatus{"Base": {Status: v1alpha1.InstallStatus_HEALTHY}}
+	if err := l.EndReconcile(instance, status); err != nil {
+		t.Fatal(err)
+	}
+	for component, want := range map[string]int{"Base": len(v1alpha1.InstallStatus_Status_name), "Pilot": 0} {
+		if got, _ := gatherSeries(t, "istio_operator_component_status",
+			map[string]string{"name": "metrics-test", "component": component}); got != want {
+			t.Errorf("%s: got %d component_status series, want %d", component, got, want)
+		}
+	}
+
+	// Deleting the IstioOperator removes its per component series.
+	observeComponentReconcile("metrics-test", "istio-system", name.PilotComponentName, time.Second, nil)
+	if err := l.EndDelete(instance, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{"istio_operator_component_status", "istio_operator_component_reconcile_duration_seconds"} {
+		if got, _ := gatherSeries(t, metric, map[string]string{"name": "metrics-test"}); got != 0 {
+			t.Errorf("got %d %s series after delete, want 0", got, metric)
+		}
+	}
+	if got := len(gaugeComponents["istio-system/metrics-test"]); got != 0 {
+		t.Errorf("got %d components with status series after delete, want 0", got)
+	}
+}
+
+func TestObserveDurations(t *testing.T) {
+	labels := map[string]string{"name": "durations-test", "namespace": "istio-system", "component": "Policy",
+		"result": resultError}
+	_, components := gatherSeries(t, "istio_operator_component_reconcile_duration_seconds", labels)
+	_, renders := gatherSeries(t, "istio_operator_render_duration_seconds", map[string]string{"chart": "Policy"})
+
+	observeComponentReconcile("durations-test", "istio-system", name.PolicyComponentName, time.Second,
+		fmt.Errorf("failed"))
+	observeRender(name.PolicyComponentName, time.Millisecond)
+	observeRender(name.PolicyComponentName, time.Millisecond)
+
+	if _, got := gatherSeries(t, "istio_operator_component_reconcile_duration_seconds", labels); got-components != 1 {
+		t.Errorf("component reconcile duration: got %d observations, want 1", got-components)
+	}
+	if _, got := gatherSeries(t, "istio_operator_render_duration_seconds",
+		map[string]string{"chart": "Policy"}); got-renders != 2 {
+		t.Errorf("render duration: got %d observations, want 2", got-renders)
+	}
+}
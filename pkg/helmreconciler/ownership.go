@@ -0,0 +1,130 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmreconciler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+	"istio.io/pkg/log"
+)
+
+// OwnershipConflict is a rendered object which already exists and is owned by another custom resource.
+type OwnershipConflict struct {
+	// Object is the object, as Kind/namespace/name.
+	Object string
+	// Component is the component which rendered the object.
+	Component string
+	// OwnerLabels are the owner labels of the existing object.
+	OwnerLabels map[string]string
+}
+
+// OwnershipConflictError is returned by Reconcile if rendered objects are owned by another custom resource. Nothing
+// is applied in that case.
+type OwnershipConflictError struct {
+	Conflicts []OwnershipConflict
+}
+
+// Error implements the error interface.
+func (e *OwnershipConflictError) Error() string {
+	objects := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		objects = append(objects, c.Object)
+	}
+	return fmt.Sprintf("%d objects are owned by another resource: %s", len(objects), strings.Join(objects, ", "))
+}
+
+// IsOwnershipConflict returns the OwnershipConflictError if err is one.
+func IsOwnershipConflict(err error) (*OwnershipConflictError, bool) {
+	e, ok := err.(*OwnershipConflictError)
+	return e, ok
+}
+
+// checkOwnership returns an OwnershipConflictError if any object in manifests exists with owner labels which differ
+// from the owner labels of h. Objects without owner labels, e.g. those installed by the CLI, are not conflicts.
+// Paused components are not checked, since they are not applied.
+func (h *HelmReconciler) checkOwnership(manifests ChartManifestsMap) error {
+	if h.adopt {
+		return nil
+	}
+	ownerLabels := h.customizer.PruningDetails().GetOwnerLabels()
+	var conflicts []OwnershipConflict
+	for chart, ms := range manifests {
+		if h.paused[name.ComponentName(chart)] {
+			continue
+		}
+		for _, m := range ms {
+			objects, err := object.ParseK8sObjectsFromYAMLManifest(m.Content)
+			if err != nil {
+				return err
+			}
+			for _, o := range objects {
+				live := &unstructured.Unstructured{}
+				live.SetGroupVersionKind(o.GroupVersionKind())
+				err := h.client.Get(context.TODO(), client.ObjectKey{Namespace: o.Namespace, Name: o.Name}, live)
+				if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+					continue
+				}
+				if err != nil {
+					// Not a reason to hold the reconcile back, applying the object reports the error.
+					log.Warnf("could not check the owner of %s: %s", objectRef(live), err)
+					continue
+				}
+				if otherOwner(live.GetLabels(), ownerLabels) {
+					conflicts = append(conflicts, OwnershipConflict{
+						Object:      objectRef(live),
+						Component:   chart,
+						OwnerLabels: ownerLabelsOf(live.GetLabels(), ownerLabels),
+					})
+				}
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Object < conflicts[j].Object })
+	return &OwnershipConflictError{Conflicts: conflicts}
+}
+
+// otherOwner reports whether labels has any of the owner labels with a different value.
+func otherOwner(labels, ownerLabels map[string]string) bool {
+	for k, v := range ownerLabels {
+		if lv, ok := labels[k]; ok && lv != v {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerLabelsOf returns the owner labels present in labels.
+func ownerLabelsOf(labels, ownerLabels map[string]string) map[string]string {
+	out := make(map[string]string)
+	for k := range ownerLabels {
+		if v, ok := labels[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
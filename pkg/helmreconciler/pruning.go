@@ -16,72 +16,170 @@ package helmreconciler
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
-	"istio.io/pkg/log"
-
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"istio.io/operator/pkg/inventory"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/util"
+	"istio.io/pkg/log"
 )
 
-// Prune removes any resources not specified in manifests generated by HelmReconciler h. If all is set to true, this
-// function prunes all resources.
+// Prune removes the objects in the inventory of each component processed by HelmReconciler h which are no longer in
+// its manifest, and records the processed objects as the new inventory. If all is set to true, this function prunes
+// all objects in all inventories.
 func (h *HelmReconciler) Prune(all bool) error {
-	allErrors := []error{}
-	namespacedResourceMap, nonNamespacedResourceMap, _ := h.customizer.PruningDetails().GetResourceTypes()
-	targetNamespace := h.customizer.Input().GetTargetNamespace()
-	err := h.PruneResources(namespacedResourceMap, all, targetNamespace)
-	if err != nil {
-		allErrors = append(allErrors, err)
+	store := h.inventoryStore()
+	var errs util.Errors
+	if all {
+		// Objects applied before inventories were kept are only found while there are no inventories.
+		legacy, err := h.legacyEntries(nil)
+		errs = util.AppendErr(errs, err)
+		errs = util.AppendErr(errs, h.deleteEntries(legacy))
+		// The inventory of every component which can be rendered is pruned, whether or not it is enabled.
+		for _, c := range allComponents() {
+			_, err := inventory.Sync(store, c, nil, true, h.deleteEntry)
+			errs = util.AppendErr(errs, err)
+		}
+		return errs.ToError()
 	}
-	err = h.PruneResources(nonNamespacedResourceMap, all, "")
-	if err != nil {
-		allErrors = append(allErrors, err)
+
+	h.inventoryMu.Lock()
+	defer h.inventoryMu.Unlock()
+	for c, entries := range h.processed {
+		_, err := inventory.Sync(store, c, entries, true, h.deleteEntry)
+		errs = util.AppendErr(errs, err)
 	}
-	return utilerrors.NewAggregate(allErrors)
+	errs = util.AppendErr(errs, h.deleteEntries(h.legacy))
+	h.legacy = nil
+	return errs.ToError()
 }
 
-// Prune removes any resources not specified resourceMap. If all is set to true, it prunes all
-// resources.
-func (h *HelmReconciler) PruneResources(resourceMap map[schema.GroupVersionKind]bool, all bool, namespace string) error {
-	allErrors := []error{}
-	ownerLabels := h.customizer.PruningDetails().GetOwnerLabels()
-	ownerAnnotations := h.customizer.PruningDetails().GetOwnerAnnotations()
-	for gvk, exists := range resourceMap {
-		if exists {
-			objects := &unstructured.UnstructuredList{}
-			objects.SetGroupVersionKind(gvk)
-			err := h.client.List(context.TODO(), objects, client.MatchingLabels(ownerLabels), client.InNamespace(namespace))
+// legacyEntries returns the objects of h.instance applied by releases which pruned by owner label rather than by
+// inventory, and which are not in manifests. They are only looked for while h.instance has no inventories, i.e. on the
+// first reconcile after upgrading from such a release. Objects which are in manifests are added to the inventories
+// when they are applied.
+func (h *HelmReconciler) legacyEntries(manifests ChartManifestsMap) ([]inventory.Entry, error) {
+	store := h.inventoryStore()
+	for _, c := range allComponents() {
+		inv, err := store.Get(c)
+		if err != nil || inv != nil {
+			return nil, err
+		}
+	}
+	namespaced, nonNamespaced, mu := h.customizer.PruningDetails().GetResourceTypes()
+	mu.Lock()
+	kinds := make([]schema.GroupVersionKind, 0, len(namespaced)+len(nonNamespaced))
+	for gvk := range namespaced {
+		kinds = append(kinds, gvk)
+	}
+	for gvk := range nonNamespaced {
+		kinds = append(kinds, gvk)
+	}
+	mu.Unlock()
+	selector := labels.SelectorFromSet(h.customizer.PruningDetails().GetOwnerLabels())
+	var entries []inventory.Entry
+	for _, gvk := range kinds {
+		es, err := inventory.Labeled(h.client, []schema.GroupVersionKind{gvk}, selector)
+		if err != nil {
+			// As when pruning by label, a kind which cannot be listed does not hold back the reconcile.
+			log.Warnf("retrieving resources to prune type %s: %s", gvk, err)
+			continue
+		}
+		entries = append(entries, es...)
+	}
+
+	var rendered []inventory.Entry
+	for _, ms := range manifests {
+		for _, m := range ms {
+			objects, err := object.ParseK8sObjectsFromYAMLManifest(m.Content)
 			if err != nil {
-				// we only want to retrieve resources clusters
-				log.Warnf("retrieving resources to prune type %s: %s not found", gvk.String(), err)
-				continue
-			}
-		objectLoop:
-			for _, object := range objects.Items {
-				annotations := object.GetAnnotations()
-				for ownerKey, ownerValue := range ownerAnnotations {
-					// we only want to delete objects that contain the annotations
-					// if we're not pruning all objects, we only want to prune those whose annotation value does not match what is expected
-					if value, ok := annotations[ownerKey]; !ok || (!all && value == ownerValue) {
-						continue objectLoop
-					}
-				}
-				err = h.client.Delete(context.TODO(), &object, client.PropagationPolicy(metav1.DeletePropagationBackground))
-				if err == nil {
-					if listenerErr := h.customizer.Listener().ResourceDeleted(&object); listenerErr != nil {
-						log.Errorf("error calling listener: %s", err)
-					}
-				} else {
-					if listenerErr := h.customizer.Listener().ResourceError(&object, err); listenerErr != nil {
-						log.Errorf("error calling listener: %s", err)
-					}
-					allErrors = append(allErrors, err)
-				}
+				return nil, err
 			}
+			rendered = append(rendered, inventory.EntriesFor(objects)...)
 		}
 	}
-	return utilerrors.NewAggregate(allErrors)
+	legacy := &inventory.Inventory{Entries: entries}
+	return legacy.Prunable(rendered), nil
+}
+
+// deleteEntries deletes the objects for entries, logging each deleted object.
+func (h *HelmReconciler) deleteEntries(entries []inventory.Entry) error {
+	var errs util.Errors
+	for _, e := range entries {
+		log.Infof("pruning %s, applied before inventories were kept", e)
+		if err := h.deleteEntry(e); err != nil {
+			errs = util.AppendErr(errs, fmt.Errorf("%s: %s", e, err))
+		}
+	}
+	return errs.ToError()
+}
+
+// recordInventory adds the objects of component c to its inventory, without pruning, and remembers them for Prune.
+// Objects are recorded even if processing them failed, so that any which were created can be pruned later.
+func (h *HelmReconciler) recordInventory(c name.ComponentName, objects object.K8sObjects) error {
+	entries := inventory.EntriesFor(objects)
+	h.inventoryMu.Lock()
+	if h.processed == nil {
+		h.processed = make(map[name.ComponentName][]inventory.Entry)
+	}
+	h.processed[c] = entries
+	h.inventoryMu.Unlock()
+	_, err := inventory.Sync(h.inventoryStore(), c, entries, false, nil)
+	return err
+}
+
+// deleteEntry deletes the object for inventory entry e. Objects which no longer exist are not treated as an error.
+// Objects owned by another custom resource, e.g. one which adopted them, are left alone and dropped from the
+// inventory, unless adopting is allowed.
+func (h *HelmReconciler) deleteEntry(e inventory.Entry) error {
+	obj := e.Unstructured()
+	err := h.client.Get(context.TODO(), client.ObjectKey{Namespace: e.Namespace, Name: e.Name}, obj)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !h.adopt && otherOwner(obj.GetLabels(), h.customizer.PruningDetails().GetOwnerLabels()) {
+		log.Infof("not pruning %s, which is owned by another resource", objectRef(obj))
+		return nil
+	}
+	err = h.client.Delete(context.TODO(), obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !apierrors.IsNotFound(err) {
+		if listenerErr := h.customizer.Listener().ResourceError(obj, err); listenerErr != nil {
+			log.Errorf("error calling listener: %s", listenerErr)
+		}
+		return err
+	}
+	if listenerErr := h.customizer.Listener().ResourceDeleted(obj); listenerErr != nil {
+		log.Errorf("error calling listener: %s", listenerErr)
+	}
+	return nil
+}
+
+// inventoryStore returns the store holding the component inventories of h.instance and its revision. Each
+// IstioOperator has its own inventories, so that reconciling or deleting one never prunes the objects of another.
+func (h *HelmReconciler) inventoryStore() inventory.Store {
+	owner := h.instance.GetNamespace() + "/" + h.instance.GetName()
+	return inventory.NewOwnerConfigMapStore(h.client, h.customizer.Input().GetTargetNamespace(), owner,
+		h.instance.Spec.GetResourceSuffix())
+}
+
+// allComponents returns the names of all components, sorted.
+func allComponents() []name.ComponentName {
+	out := make([]name.ComponentName, 0, len(name.ComponentDependencies))
+	for c := range name.ComponentDependencies {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
 }
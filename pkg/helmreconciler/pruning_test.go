@@ -0,0 +1,117 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmreconciler
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/inventory"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+// testInput is a RenderingInput for objects in istio-system.
+type testInput struct{}
+
+func (testInput) GetCRPath() string                                        { return "" }
+func (testInput) GetInputConfig() interface{}                              { return nil }
+func (testInput) GetTargetNamespace() string                               { return "istio-system" }
+func (testInput) GetProcessingOrder(ChartManifestsMap) (*dag.Graph, error) { return nil, nil }
+
+func TestPruneUpgradeFromLabels(t *testing.T) {
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	ownerLabels := map[string]string{"example.com/owner-name": "example"}
+	configMap := func(name string, labels map[string]string) *unstructured.Unstructured {
+		o := objecttest.New("ConfigMap", "istio-system", name)
+		o.SetLabels(labels)
+		return o
+	}
+	// Objects applied by a release which pruned by owner label, with no inventory yet.
+	fake := cluster.NewFake(
+		objecttest.New("Namespace", "", "istio-system"),
+		configMap("stale", ownerLabels),
+		configMap("rendered", ownerLabels),
+		configMap("other", map[string]string{"example.com/owner-name": "other"}),
+	)
+	instance := &iop.IstioOperator{}
+	instance.SetNamespace("istio-system")
+	instance.SetName("example")
+	h := &HelmReconciler{
+		client: cluster.NewClient(fake),
+		customizer: &SimpleRenderingCustomizer{
+			InputValue: testInput{},
+			PruningDetailsValue: &SimplePruningDetails{
+				OwnerLabels:              ownerLabels,
+				NamespacedResourceMap:    map[schema.GroupVersionKind]bool{configMapGVK: false},
+				NonNamespacedResourceMap: map[schema.GroupVersionKind]bool{},
+				PruningDetailsMU:         &sync.Mutex{},
+			},
+			ListenerValue: &DefaultRenderingListener{},
+		},
+		instance: instance,
+	}
+	rendered := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: rendered
+  namespace: istio-system
+`
+
+	legacy, err := h.legacyEntries(ChartManifestsMap{"Pilot": {{Name: "Pilot", Content: rendered}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []inventory.Entry{{Version: "v1", Kind: "ConfigMap", Namespace: "istio-system", Name: "stale"}}
+	if !reflect.DeepEqual(legacy, want) {
+		t.Fatalf("got legacy entries %v, want %v", legacy, want)
+	}
+
+	objects, err := object.ParseK8sObjectsFromYAMLManifest(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.legacy = legacy
+	if err := h.recordInventory(name.PilotComponentName, objects); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Prune(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.Get(configMapGVK, "istio-system", "stale"); !apierrors.IsNotFound(err) {
+		t.Errorf("object of the earlier release not pruned: %v", err)
+	}
+	for _, n := range []string{"rendered", "other"} {
+		if _, err := fake.Get(configMapGVK, "istio-system", n); err != nil {
+			t.Errorf("%s: %v", n, err)
+		}
+	}
+
+	// Once there are inventories, objects are no longer searched by label.
+	legacy, err = h.legacyEntries(nil)
+	if err != nil || legacy != nil {
+		t.Errorf("got legacy entries %v, %v with inventories, want none", legacy, err)
+	}
+}
@@ -16,13 +16,18 @@ package helmreconciler
 
 import (
 	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/helm/pkg/manifest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"istio.io/api/operator/v1alpha1"
 	iop "istio.io/operator/pkg/apis/istio/v1alpha1"
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/inventory"
 	"istio.io/operator/pkg/name"
 	"istio.io/operator/pkg/util"
 	"istio.io/pkg/log"
@@ -35,12 +40,30 @@ type HelmReconciler struct {
 	customizer         RenderingCustomizer
 	instance           *iop.IstioOperator
 	needUpdateAndPrune bool
+	// concurrency is the maximum number of components processed at the same time. If 0, there is no limit.
+	concurrency int
+	// paused are the components which are neither applied nor pruned.
+	paused map[name.ComponentName]bool
+	// adopt allows applying objects owned by another custom resource, which then become owned by instance.
+	adopt bool
+
+	// inventoryMu protects processed and legacy.
+	inventoryMu sync.Mutex
+	// processed holds the inventory entries of each component processed by the current Reconcile.
+	processed map[name.ComponentName][]inventory.Entry
+	// legacy holds the objects applied before inventories were kept which are no longer rendered, found by the
+	// current Reconcile and deleted by Prune.
+	legacy []inventory.Entry
 }
 
 // Factory is a factory for creating HelmReconciler objects using the specified CustomizerFactory.
 type Factory struct {
 	// CustomizerFactory is a factory for creating the Customizer object for the HelmReconciler.
 	CustomizerFactory RenderingCustomizerFactory
+	// Concurrency is the maximum number of components processed at the same time. If 0, there is no limit.
+	Concurrency int
+	// EventRecorder records Events on the custom resource. If nil, no Events are recorded.
+	EventRecorder record.EventRecorder
 }
 
 // New Returns a new HelmReconciler for the custom resource.
@@ -52,36 +75,41 @@ func (f *Factory) New(instance *iop.IstioOperator, client client.Client) (*HelmR
 	if err != nil {
 		return nil, err
 	}
-	wrappedcustomizer, err := wrapCustomizer(instance, delegate)
+	wrappedcustomizer, err := wrapCustomizer(instance, delegate, f.EventRecorder)
 	if err != nil {
 		return nil, err
 	}
-	reconciler := &HelmReconciler{client: client, customizer: wrappedcustomizer, instance: instance, needUpdateAndPrune: true}
+	reconciler := &HelmReconciler{client: client, customizer: wrappedcustomizer, instance: instance, needUpdateAndPrune: true,
+		concurrency: f.Concurrency}
 	wrappedcustomizer.RegisterReconciler(reconciler)
 	return reconciler, nil
 }
 
 // wrapCustomizer creates a new internalCustomizer object wrapping the delegate, by inject a LoggingRenderingListener,
-// an OwnerReferenceDecorator, and a PruningDetailsDecorator into a CompositeRenderingListener that includes the listener
-// from the delegate.  This ensures the HelmReconciler can properly implement pruning, etc.
+// a metrics listener, an EventRecordingListener if recorder is not nil, an OwnerReferenceDecorator, and a PruningDetailsDecorator into a
+// CompositeRenderingListener that includes the listener from the delegate.  This ensures the HelmReconciler can
+// properly implement pruning, etc.
 // instance is the custom resource to be processed by the HelmReconciler
 // delegate is the delegate
-func wrapCustomizer(instance runtime.Object, delegate RenderingCustomizer) (*SimpleRenderingCustomizer, error) {
+// recorder records Events on instance
+func wrapCustomizer(instance runtime.Object, delegate RenderingCustomizer, recorder record.EventRecorder) (*SimpleRenderingCustomizer, error) {
 	ownerReferenceDecorator, err := NewOwnerReferenceDecorator(instance)
 	if err != nil {
 		return nil, err
 	}
+	listeners := []RenderingListener{&LoggingRenderingListener{Level: 1}, NewMetricsListener(instance)}
+	if recorder != nil {
+		listeners = append(listeners, NewEventRecordingListener(instance, recorder))
+	}
+	listeners = append(listeners,
+		ownerReferenceDecorator,
+		NewPruningMarkingsDecorator(delegate.PruningDetails()),
+		delegate.Listener(),
+	)
 	return &SimpleRenderingCustomizer{
 		InputValue:          delegate.Input(),
 		PruningDetailsValue: delegate.PruningDetails(),
-		ListenerValue: &CompositeRenderingListener{
-			Listeners: []RenderingListener{
-				&LoggingRenderingListener{Level: 1},
-				ownerReferenceDecorator,
-				NewPruningMarkingsDecorator(delegate.PruningDetails()),
-				delegate.Listener(),
-			},
-		},
+		ListenerValue:       &CompositeRenderingListener{Listeners: listeners},
 	}, nil
 }
 
@@ -93,12 +121,26 @@ func (h *HelmReconciler) Reconcile() error {
 		return err
 	}
 
-	// render charts
-	manifestMap, err := h.renderCharts(h.customizer.Input())
+	h.inventoryMu.Lock()
+	h.processed, h.legacy = nil, nil
+	h.inventoryMu.Unlock()
+
+	// render charts, refusing to take over objects of other custom resources
+	manifestMap, err := h.RenderManifests()
 	if err != nil {
 		// TODO: this needs to update status to RECONCILING.
 		return err
 	}
+	if h.needUpdateAndPrune {
+		// Look for objects to prune by label before the first inventories are stored by processing the manifests.
+		legacy, err := h.legacyEntries(manifestMap)
+		if err != nil {
+			return err
+		}
+		h.inventoryMu.Lock()
+		h.legacy = legacy
+		h.inventoryMu.Unlock()
+	}
 
 	// handle the defined callbacks to the generated manifests for each subchart chart.
 	//for chartName, manifests := range manifestMap {
@@ -108,7 +150,10 @@ func (h *HelmReconciler) Reconcile() error {
 	//	}
 	//	manifestMap[chartName] = newManifests
 	//}
-	status := h.processRecursive(manifestMap)
+	status, err := h.processRecursive(manifestMap)
+	if err != nil {
+		return err
+	}
 
 	// Delete any resources not in the manifest but managed by operator.
 	var errs util.Errors
@@ -121,80 +166,127 @@ func (h *HelmReconciler) Reconcile() error {
 	return errs.ToError()
 }
 
-// processRecursive processes the given manifests in an order of dependencies defined in h. Dependencies are a tree,
-// where a child must wait for the parent to complete before starting.
-func (h *HelmReconciler) processRecursive(manifests ChartManifestsMap) *v1alpha1.InstallStatus {
-	deps, dch := h.customizer.Input().GetProcessingOrder(manifests)
+// RenderManifests renders the manifests of the custom resource instance, without applying them. It returns an
+// OwnershipConflictError if rendered objects are owned by another custom resource, unless adopting is allowed.
+func (h *HelmReconciler) RenderManifests() (ChartManifestsMap, error) {
+	manifestMap, err := h.renderCharts(h.customizer.Input())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkOwnership(manifestMap); err != nil {
+		return nil, err
+	}
+	return manifestMap, nil
+}
+
+// ApplyComponent applies content, a manifest of component c, outside of a full Reconcile, e.g. to upgrade or roll back
+// components one at a time. If prune is set, the objects in the inventory of c which are not in content are deleted.
+func (h *HelmReconciler) ApplyComponent(c name.ComponentName, content string, prune bool) error {
+	h.inventoryMu.Lock()
+	h.processed, h.legacy = nil, nil
+	h.inventoryMu.Unlock()
+	if _, err := h.ProcessManifest(manifest.Manifest{Name: string(c), Content: content}); err != nil {
+		return err
+	}
+	if !prune {
+		return nil
+	}
+	return h.Prune(false)
+}
+
+// processRecursive processes the given manifests in the order of the dependency graph returned by the rendering
+// input. A component is processed once all of its dependencies have been processed successfully, with at most
+// h.concurrency components processed at the same time. If a dependency
+// fails, the component is not processed and its status is set to ERROR with the reason it was skipped. Paused
+// components are not processed, and do not block the components depending on them.
+func (h *HelmReconciler) processRecursive(manifests ChartManifestsMap) (*v1alpha1.InstallStatus, error) {
+	g, err := h.customizer.Input().GetProcessingOrder(manifests)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Component dependencies tree: \n%s", g)
 	componentStatus := make(map[string]*v1alpha1.InstallStatus_VersionStatus)
 
 	// mu protects the shared InstallStatus componentStatus across goroutines
 	var mu sync.Mutex
-	// wg waits for all manifest processing goroutines to finish
-	var wg sync.WaitGroup
-
-	for c, m := range manifests {
-		c, m := c, m
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			cn := name.ComponentName(c)
-			if s := dch[cn]; s != nil {
-				log.Infof("%s is waiting on dependency...", c)
-				<-s
-				log.Infof("Dependency for %s has completed, proceeding.", c)
-			}
 
-			// Set status when reconciling starts
-			status := v1alpha1.InstallStatus_RECONCILING
+	results := g.ExecuteWithLimit(h.concurrency, func(cn name.ComponentName) error {
+		c := string(cn)
+		m := manifests[c]
+
+		// Paused components keep their previous status. Since they are not recorded in the inventory, they are not
+		// pruned either.
+		if h.paused[cn] {
+			log.Infof("Skipping paused component %s", c)
 			mu.Lock()
-			if _, ok := componentStatus[c]; !ok {
-				componentStatus[c] = &v1alpha1.InstallStatus_VersionStatus{}
-				componentStatus[c].Status = status
+			defer mu.Unlock()
+			if h.instance.Status != nil {
+				if vs := h.instance.Status.GetComponentStatus()[c]; vs != nil {
+					componentStatus[c] = vs
+				}
 			}
-			mu.Unlock()
+			return nil
+		}
 
-			// Process manifests and get the status result
-			errString := ""
-			if len(m) == 0 {
+		// Set status when reconciling starts
+		status := v1alpha1.InstallStatus_RECONCILING
+		mu.Lock()
+		if _, ok := componentStatus[c]; !ok {
+			componentStatus[c] = &v1alpha1.InstallStatus_VersionStatus{}
+			componentStatus[c].Status = status
+		}
+		mu.Unlock()
+
+		// Process manifests and get the status result
+		var processErr error
+		start := time.Now()
+		if len(m) == 0 {
+			status = v1alpha1.InstallStatus_NONE
+			processErr = h.recordInventory(cn, nil)
+		} else {
+			status = v1alpha1.InstallStatus_HEALTHY
+			if cnt, err := h.ProcessManifest(m[0]); err != nil {
+				processErr = err
+				status = v1alpha1.InstallStatus_ERROR
+			} else if cnt == 0 {
 				status = v1alpha1.InstallStatus_NONE
-			} else {
-				status = v1alpha1.InstallStatus_HEALTHY
-				if cnt, err := h.ProcessManifest(m[0]); err != nil {
-					errString = err.Error()
-					status = v1alpha1.InstallStatus_ERROR
-				} else if cnt == 0 {
-					status = v1alpha1.InstallStatus_NONE
-				}
 			}
+		}
+		observeComponentReconcile(h.instance.GetName(), h.instance.GetNamespace(), cn, time.Since(start), processErr)
 
-			// Update status based on the result
-			mu.Lock()
-			if status == v1alpha1.InstallStatus_NONE {
-				delete(componentStatus, c)
-			} else {
-				componentStatus[c].Status = status
-				componentStatus[c].StatusString = v1alpha1.InstallStatus_Status_name[int32(status)]
-				if errString != "" {
-					componentStatus[c].Error = errString
-				}
-			}
-			mu.Unlock()
+		// Update status based on the result
+		mu.Lock()
+		defer mu.Unlock()
+		if status == v1alpha1.InstallStatus_NONE {
+			delete(componentStatus, c)
+		} else {
+			setComponentStatus(componentStatus[c], status, processErr)
+		}
+		return processErr
+	})
 
-			// Signal all the components that depend on us.
-			for _, ch := range deps[cn] {
-				log.Infof("Unblocking dependency %s.", ch)
-				dch[ch] <- struct{}{}
-			}
-		}()
+	for cn, err := range results {
+		if dag.IsSkipped(err) {
+			log.Infof("Skipped component %s: %s", cn, err)
+			componentStatus[string(cn)] = &v1alpha1.InstallStatus_VersionStatus{}
+			setComponentStatus(componentStatus[string(cn)], v1alpha1.InstallStatus_ERROR, err)
+		}
 	}
-	wg.Wait()
 
 	out := &v1alpha1.InstallStatus{
 		//TODO: add overall status logic
 		ComponentStatus: componentStatus,
 	}
 
-	return out
+	return out, nil
+}
+
+func setComponentStatus(vs *v1alpha1.InstallStatus_VersionStatus, status v1alpha1.InstallStatus_Status, err error) {
+	vs.Status = status
+	vs.StatusString = v1alpha1.InstallStatus_Status_name[int32(status)]
+	if err != nil {
+		vs.Error = err.Error()
+	}
 }
 
 // Delete resources associated with the custom resource instance
@@ -255,3 +347,17 @@ func (h *HelmReconciler) SetInstance(instance *iop.IstioOperator) {
 func (h *HelmReconciler) SetNeedUpdateAndPrune(u bool) {
 	h.needUpdateAndPrune = u
 }
+
+// SetAdopt sets whether Reconcile applies objects owned by another custom resource, which then become owned by the
+// instance of this HelmReconciler. Otherwise, Reconcile returns an OwnershipConflictError without applying anything.
+func (h *HelmReconciler) SetAdopt(adopt bool) {
+	h.adopt = adopt
+}
+
+// SetPausedComponents sets the components which Reconcile neither applies nor prunes.
+func (h *HelmReconciler) SetPausedComponents(components []name.ComponentName) {
+	h.paused = make(map[name.ComponentName]bool)
+	for _, c := range components {
+		h.paused[c] = true
+	}
+}
@@ -33,6 +33,7 @@ import (
 	istiomanifest "istio.io/operator/pkg/manifest"
 	"istio.io/operator/pkg/name"
 	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/retry"
 	"istio.io/operator/pkg/translate"
 	"istio.io/operator/pkg/util"
 	"istio.io/operator/pkg/validate"
@@ -69,7 +70,7 @@ func (h *HelmReconciler) renderCharts(in RenderingInput) (ChartManifestsMap, err
 		return nil, fmt.Errorf("failed to create Istio control plane with spec: \n%v\nerror: %s", mergedIOPS, err)
 	}
 
-	manifests, errs := cp.RenderManifest()
+	manifests, errs := cp.RenderManifestWithObserver(observeRender)
 	if errs != nil {
 		err = errs.ToError()
 	}
@@ -185,6 +186,9 @@ func (h *HelmReconciler) ProcessManifest(manifest manifest.Manifest) (int, error
 			errs = append(errs, err)
 		}
 	}
+	if err := h.recordInventory(name.ComponentName(manifest.Name), objects); err != nil {
+		errs = append(errs, err)
+	}
 	return len(objects), utilerrors.NewAggregate(errs)
 }
 
@@ -231,39 +235,48 @@ func (h *HelmReconciler) ProcessObject(chartName string, obj *unstructured.Unstr
 		pruningDetailsMU.Unlock()
 	}
 
-	var patch Patch
-
-	err = h.client.Get(context.TODO(), objectKey, receiver)
-	if err != nil {
-		if apierrors.IsNotFound(err) {
+	// Transient errors, e.g. a webhook which is not ready yet, are retried. The listeners are only called with the
+	// outcome of the last attempt.
+	var updatedObj *unstructured.Unstructured
+	created := false
+	retries, err := retry.Do(retry.DefaultBackoff, func() error {
+		updatedObj, created = nil, false
+		err := h.client.Get(context.TODO(), objectKey, receiver)
+		switch {
+		case apierrors.IsNotFound(err):
 			log.Infof("creating resource: %s", objectKey)
-			err = h.client.Create(context.TODO(), mutatedObj)
-			if err == nil {
-				// special handling
-				if err = h.customizer.Listener().ResourceCreated(mutatedObj); err != nil {
-					log.Errorf("unexpected error occurred during postprocessing of new resource: %s", err)
-				}
-			} else {
-				listenerErr := h.customizer.Listener().ResourceError(mutatedObj, err)
-				if listenerErr != nil {
-					log.Errorf("unexpected error occurred invoking ResourceError on listener: %s", listenerErr)
-				}
+			if err := h.client.Create(context.TODO(), mutatedObj); err != nil {
+				return err
 			}
+			created = true
+			return nil
+		case err != nil || !h.needUpdateAndPrune:
+			return err
 		}
-	} else if h.needUpdateAndPrune {
-		if patch, err = h.CreatePatch(receiver, mutatedObj); err == nil && patch != nil {
-			log.Info("updating existing resource")
-			mutatedObj, err = patch.Apply()
-			if err == nil {
-				if err = h.customizer.Listener().ResourceUpdated(mutatedObj, receiver); err != nil {
-					log.Errorf("unexpected error occurred during postprocessing of updated resource: %s", err)
-				}
-			} else {
-				listenerErr := h.customizer.Listener().ResourceError(obj, err)
-				if listenerErr != nil {
-					log.Errorf("unexpected error occurred invoking ResourceError on listener: %s", listenerErr)
-				}
-			}
+		patch, err := h.CreatePatch(receiver, mutatedObj)
+		if err != nil || patch == nil {
+			return err
+		}
+		log.Info("updating existing resource")
+		updatedObj, err = patch.Apply()
+		return err
+	})
+	if retries > 0 {
+		log.Infof("resource %s: retried %d times after transient errors", objectKey, retries)
+	}
+	switch {
+	case err != nil:
+		if listenerErr := h.customizer.Listener().ResourceError(mutatedObj, err); listenerErr != nil {
+			log.Errorf("unexpected error occurred invoking ResourceError on listener: %s", listenerErr)
+		}
+	case created:
+		// special handling
+		if err := h.customizer.Listener().ResourceCreated(mutatedObj); err != nil {
+			log.Errorf("unexpected error occurred during postprocessing of new resource: %s", err)
+		}
+	case updatedObj != nil:
+		if err := h.customizer.Listener().ResourceUpdated(updatedObj, receiver); err != nil {
+			log.Errorf("unexpected error occurred during postprocessing of updated resource: %s", err)
 		}
 	}
 	if err != nil {
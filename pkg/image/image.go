@@ -0,0 +1,222 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package image finds and rewrites the container images used by rendered manifests.
+
+Images are found in the containers and init containers of all pod specs, in the proxy images the sidecar injector
+derives from its values, and in image fields of other manifests embedded in ConfigMaps. Rewrites replace the registry
+of image references throughout the manifests, including in the sidecar injector values, so that an installation can
+pull all of its images from a mirror. A Lock pins every image to a digest, so that an installation is reproducible
+even if tags are pushed again.
+*/
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/operator/pkg/helm"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+var (
+	// embeddedImageRegexp matches the image fields of manifests embedded in ConfigMap data. Template expressions are
+	// not matched.
+	embeddedImageRegexp = regexp.MustCompile(`(?m)^\s*-?\s*image:\s*["']?([^\s"'{}]+)["']?\s*$`)
+)
+
+// Image is a container image used by the manifests.
+type Image struct {
+	// Image is the image reference.
+	Image string `json:"image"`
+	// Rewritten is the image reference after registry rewrites, if any were given.
+	Rewritten string `json:"rewritten,omitempty"`
+	// Sources are the places the image is used.
+	Sources []Source `json:"sources"`
+}
+
+// Source is a place an image is used.
+type Source struct {
+	// Component is the component the object using the image belongs to.
+	Component name.ComponentName `json:"component"`
+	// Object is the object using the image, as Kind/namespace/name.
+	Object string `json:"object"`
+	// Path is the location of the image in the object.
+	Path string `json:"path"`
+}
+
+// List returns the images used by manifests, sorted by image reference. The rewritten reference of each image is
+// set if rewrites is not empty.
+func List(manifests name.ManifestMap, rewrites Rewrites) ([]*Image, error) {
+	images := make(map[string]*Image)
+	add := func(ref string, src Source) {
+		if images[ref] == nil {
+			images[ref] = &Image{Image: ref}
+			if len(rewrites) != 0 {
+				images[ref].Rewritten = rewrites.Rewrite(ref)
+			}
+		}
+		images[ref].Sources = append(images[ref].Sources, src)
+	}
+
+	var components []string
+	for c := range manifests {
+		components = append(components, string(c))
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		objs, err := object.ParseK8sObjectsFromYAMLManifest(strings.Join(manifests[name.ComponentName(c)], helm.YAMLSeparator))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse manifest for component %s: %s", c, err)
+		}
+		for _, o := range objs {
+			visitImages(o.UnstructuredObject().DeepCopy(), func(img, path string) string {
+				add(img, Source{Component: name.ComponentName(c), Object: objectRef(o), Path: path})
+				return img
+			})
+		}
+	}
+
+	var out []*Image
+	for _, img := range images {
+		out = append(out, img)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Image < out[j].Image })
+	return out, nil
+}
+
+// visitImages calls fn with every image reference used by u and the path of the reference in u, and replaces the
+// reference with the one fn returns.
+func visitImages(u *unstructured.Unstructured, fn func(image, path string) string) {
+	visitContainers(u.Object, "", fn)
+	if u.GetKind() != "ConfigMap" {
+		return
+	}
+	data, _ := u.Object["data"].(map[string]interface{})
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok := data[k].(string)
+		if !ok {
+			continue
+		}
+		data[k] = embeddedImageRegexp.ReplaceAllStringFunc(v, func(m string) string {
+			sub := embeddedImageRegexp.FindStringSubmatchIndex(m)
+			img := m[sub[2]:sub[3]]
+			if !strings.ContainsAny(img, "/:") {
+				return m
+			}
+			return m[:sub[2]] + fn(img, "data."+k) + m[sub[3]:]
+		})
+	}
+	if _, ok := data["config"]; ok {
+		if v, ok := data["values"].(string); ok {
+			data["values"] = visitInjectorImages(v, fn)
+		}
+	}
+}
+
+// visitContainers calls fn for the image of every container and init container in the tree rooted at node,
+// which is at path.
+func visitContainers(node interface{}, path string, fn func(image, path string) string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		var keys []string
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := strings.TrimPrefix(path+"."+k, ".")
+			if k != "containers" && k != "initContainers" {
+				visitContainers(n[k], p, fn)
+				continue
+			}
+			l, _ := n[k].([]interface{})
+			for i, c := range l {
+				cm, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				id := fmt.Sprint(i)
+				if name, ok := cm["name"].(string); ok {
+					id = name
+				}
+				if img, ok := cm["image"].(string); ok && img != "" {
+					cm["image"] = fn(img, fmt.Sprintf("%s[%s]", p, id))
+				}
+			}
+		}
+	case []interface{}:
+		for i, v := range n {
+			visitContainers(v, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	}
+}
+
+// visitInjectorImages calls fn for the proxy images the sidecar injector derives from its values, in the same way as
+// the injection template, and returns the values with the images fn changed set as full references.
+func visitInjectorImages(values string, fn func(image, path string) string) string {
+	d := json.NewDecoder(strings.NewReader(values))
+	d.UseNumber()
+	v := make(map[string]interface{})
+	if err := d.Decode(&v); err != nil {
+		return values
+	}
+	global, _ := v["global"].(map[string]interface{})
+	hub, _ := global["hub"].(string)
+	tag, _ := global["tag"].(string)
+	changed := false
+	for _, key := range []string{"proxy", "proxy_init"} {
+		proxy, _ := global[key].(map[string]interface{})
+		img, _ := proxy["image"].(string)
+		if img == "" {
+			continue
+		}
+		if !strings.Contains(img, "/") {
+			img = fmt.Sprintf("%s/%s:%s", hub, img, tag)
+		}
+		if out := fn(img, "data.values.global."+key+".image"); out != img {
+			proxy["image"] = out
+			changed = true
+		}
+	}
+	if !changed {
+		return values
+	}
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return values
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func objectRef(o *object.K8sObject) string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s/%s", o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", o.Kind, o.Namespace, o.Name)
+}
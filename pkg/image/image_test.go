@@ -0,0 +1,138 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/operator/pkg/name"
+)
+
+const (
+	testPilot = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: docker.io/istio/proxyv2:1.5.0
+      containers:
+      - name: discovery
+        image: docker.io/istio/pilot:1.5.0
+      - name: istio-proxy
+        image: docker.io/istio/proxyv2:1.5.0
+`
+	testInjector = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: istio-sidecar-injector
+  namespace: istio-system
+data:
+  config: |-
+    containers:
+    - name: istio-proxy
+      image: "{{ .Values.global.hub }}/{{ .Values.global.proxy.image }}:{{ .Values.global.tag }}"
+  values: '{"global":{"hub":"docker.io/istio","tag":"1.5.0","proxy":{"image":"proxyv2"},"proxy_init":{"image":"quay.io/custom/init:v1"}}}'
+`
+	testCronJob = `
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: cleanup
+  namespace: istio-system
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: busybox:1.28
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: job-template
+  namespace: istio-system
+data:
+  job.yaml: |
+    spec:
+      containers:
+      - image: "gcr.io/example/hook:v2"
+      - image: pilot
+`
+)
+
+func TestList(t *testing.T) {
+	manifests := name.ManifestMap{
+		name.PilotComponentName:     {testPilot + "---" + testInjector},
+		name.IstioBaseComponentName: {testCronJob},
+	}
+	images, err := List(manifests, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]Source)
+	for _, img := range images {
+		if img.Rewritten != "" {
+			t.Errorf("%s: got rewritten image %s without rewrites", img.Image, img.Rewritten)
+		}
+		got[img.Image] = img.Sources
+	}
+	want := map[string][]Source{
+		"busybox:1.28": {
+			{name.IstioBaseComponentName, "CronJob/istio-system/cleanup", "spec.jobTemplate.spec.template.spec.containers[cleanup]"},
+		},
+		"gcr.io/example/hook:v2": {
+			{name.IstioBaseComponentName, "ConfigMap/istio-system/job-template", "data.job.yaml"},
+		},
+		"docker.io/istio/pilot:1.5.0": {
+			{name.PilotComponentName, "Deployment/istio-system/istio-pilot", "spec.template.spec.containers[discovery]"},
+		},
+		"docker.io/istio/proxyv2:1.5.0": {
+			{name.PilotComponentName, "Deployment/istio-system/istio-pilot", "spec.template.spec.containers[istio-proxy]"},
+			{name.PilotComponentName, "Deployment/istio-system/istio-pilot", "spec.template.spec.initContainers[init]"},
+			{name.PilotComponentName, "ConfigMap/istio-system/istio-sidecar-injector", "data.values.global.proxy.image"},
+		},
+		"quay.io/custom/init:v1": {
+			{name.PilotComponentName, "ConfigMap/istio-system/istio-sidecar-injector", "data.values.global.proxy_init.image"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got images %v, want %v", got, want)
+	}
+	for i := 1; i < len(images); i++ {
+		if images[i-1].Image >= images[i].Image {
+			t.Errorf("images not sorted: %s before %s", images[i-1].Image, images[i].Image)
+		}
+	}
+
+	images, err = List(manifests, Rewrites{{From: "docker.io", To: "mirror.local"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range images {
+		if img.Image == "docker.io/istio/pilot:1.5.0" && img.Rewritten != "mirror.local/istio/pilot:1.5.0" {
+			t.Errorf("got rewritten image %s, want mirror.local/istio/pilot:1.5.0", img.Rewritten)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/operator/pkg/helm"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+var (
+	digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Lock maps image references, as they appear in the rendered manifests, to the digests they are pinned to.
+type Lock map[string]string
+
+// ReadLock reads a lock file, a YAML or JSON map of image references to sha256 digests, e.g.
+//
+//	docker.io/istio/pilot:1.5.0: sha256:1b2c...
+func ReadLock(path string) (Lock, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read image lock file: %s", err)
+	}
+	l := make(Lock)
+	if err := yaml.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("could not parse image lock file %s: %s", path, err)
+	}
+	for img, digest := range l {
+		if !digestRegexp.MatchString(digest) {
+			return nil, fmt.Errorf("image lock file %s: digest %q of %s is not a sha256 digest", path, digest, img)
+		}
+	}
+	return l, nil
+}
+
+// Pin returns a copy of manifests with every image reference pinned to its digest in the lock, as image@digest.
+// References which already have a digest are kept. An error listing all images without a lock entry is returned if
+// there are any.
+func (l Lock) Pin(manifests name.ManifestMap) (name.ManifestMap, error) {
+	missing := make(map[string]bool)
+	out := make(name.ManifestMap)
+	for c, ms := range manifests {
+		objs, err := object.ParseK8sObjectsFromYAMLManifest(strings.Join(ms, helm.YAMLSeparator))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse manifest for component %s: %s", c, err)
+		}
+		var pinned object.K8sObjects
+		for _, o := range objs {
+			u := o.UnstructuredObject().DeepCopy()
+			visitImages(u, func(img, _ string) string {
+				if strings.Contains(img, "@") {
+					return img
+				}
+				digest, ok := l[img]
+				if !ok {
+					missing[img] = true
+					return img
+				}
+				return img + "@" + digest
+			})
+			pinned = append(pinned, object.NewK8sObject(u, nil, nil))
+		}
+		ym, err := pinned.YAMLManifest()
+		if err != nil {
+			return nil, err
+		}
+		out[c] = []string{ym}
+	}
+	if len(missing) != 0 {
+		var imgs []string
+		for img := range missing {
+			imgs = append(imgs, img)
+		}
+		sort.Strings(imgs)
+		return nil, fmt.Errorf("no image lock entry for %s", strings.Join(imgs, ", "))
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/operator/pkg/name"
+)
+
+func digest(c string) string {
+	return "sha256:" + strings.Repeat(c, 64)
+}
+
+func TestReadLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		desc    string
+		content string
+		want    Lock
+		wantErr bool
+	}{
+		{
+			desc:    "valid",
+			content: "docker.io/istio/pilot:1.5.0: " + digest("a") + "\nbusybox: " + digest("b") + "\n",
+			want:    Lock{"docker.io/istio/pilot:1.5.0": digest("a"), "busybox": digest("b")},
+		},
+		{
+			desc:    "not a digest",
+			content: "docker.io/istio/pilot:1.5.0: 1.5.0\n",
+			wantErr: true,
+		},
+		{
+			desc:    "not a map",
+			content: "- docker.io/istio/pilot:1.5.0\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path := filepath.Join(dir, "images.lock")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadLock(path)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPin(t *testing.T) {
+	manifests := name.ManifestMap{
+		name.PilotComponentName:     {testPilot + "---" + testInjector},
+		name.IstioBaseComponentName: {testCronJob},
+	}
+	lock := Lock{
+		"docker.io/istio/pilot:1.5.0":   digest("a"),
+		"docker.io/istio/proxyv2:1.5.0": digest("b"),
+		"quay.io/custom/init:v1":        digest("c"),
+		"busybox:1.28":                  digest("d"),
+	}
+	if _, err := lock.Pin(manifests); err == nil || err.Error() != "no image lock entry for gcr.io/example/hook:v2" {
+		t.Fatalf("got error %v, want missing entry for gcr.io/example/hook:v2", err)
+	}
+
+	lock["gcr.io/example/hook:v2"] = digest("e")
+	pinned, err := lock.Pin(manifests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := List(pinned, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, img := range images {
+		got = append(got, img.Image)
+	}
+	want := []string{
+		"busybox:1.28@" + digest("d"),
+		"docker.io/istio/pilot:1.5.0@" + digest("a"),
+		"docker.io/istio/proxyv2:1.5.0@" + digest("b"),
+		"gcr.io/example/hook:v2@" + digest("e"),
+		"quay.io/custom/init:v1@" + digest("c"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got pinned images %v, want %v", got, want)
+	}
+	// The injector uses a proxy image containing a path as is, so the pinned reference must be a full reference.
+	if !strings.Contains(pinned[name.PilotComponentName][0], `"proxy":{"image":"docker.io/istio/proxyv2:1.5.0@`+digest("b")) {
+		t.Errorf("injector values not pinned:\n%s", pinned[name.PilotComponentName][0])
+	}
+
+	// Pinning again keeps the pinned references.
+	again, err := Lock{}.Pin(pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, pinned) {
+		t.Error("pinning pinned manifests changed them")
+	}
+}
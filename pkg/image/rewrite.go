@@ -0,0 +1,95 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"istio.io/operator/pkg/name"
+)
+
+// Rewrite replaces the registry From with To in image references. From matches whole path segments at the start of
+// a reference: docker.io/istio matches docker.io/istio and docker.io/istio/pilot:1.5, but not docker.io/istio-testing.
+type Rewrite struct {
+	From string
+	To   string
+}
+
+// Rewrites is a list of registry rewrites. If several rewrites match a reference, the one with the longest From is
+// used.
+type Rewrites []Rewrite
+
+// ParseRewrite parses a rewrite in from=to form.
+func ParseRewrite(s string) (Rewrite, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return Rewrite{}, fmt.Errorf("registry rewrite %q must be in from=to form", s)
+	}
+	return Rewrite{From: strings.TrimSuffix(kv[0], "/"), To: strings.TrimSuffix(kv[1], "/")}, nil
+}
+
+// ParseRewrites parses a list of rewrites in from=to form.
+func ParseRewrites(ss []string) (Rewrites, error) {
+	var out Rewrites
+	for _, s := range ss {
+		r, err := ParseRewrite(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Rewrite returns s with the registry of all image references in it rewritten. s may be a single reference or a
+// whole manifest.
+func (rs Rewrites) Rewrite(s string) string {
+	if len(rs) == 0 {
+		return s
+	}
+	to := make(map[string]string)
+	var froms []string
+	for _, r := range rs {
+		if _, ok := to[r.From]; !ok {
+			froms = append(froms, r.From)
+		}
+		to[r.From] = r.To
+	}
+	sort.Slice(froms, func(i, j int) bool { return len(froms[i]) > len(froms[j]) })
+	for i, f := range froms {
+		froms[i] = regexp.QuoteMeta(f)
+	}
+	// A reference starts at the start of a line or after a delimiter, and the registry is followed by a path
+	// separator or the end of the reference.
+	re := regexp.MustCompile(`(?m)(^|[\s"'=,\[])(` + strings.Join(froms, "|") + `)(/|[\s"',\]]|$)`)
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		sub := re.FindStringSubmatch(m)
+		return sub[1] + to[sub[2]] + sub[3]
+	})
+}
+
+// RewriteManifests returns a copy of manifests with the registry of all image references rewritten.
+func (rs Rewrites) RewriteManifests(manifests name.ManifestMap) name.ManifestMap {
+	out := make(name.ManifestMap)
+	for c, ms := range manifests {
+		for _, m := range ms {
+			out[c] = append(out[c], rs.Rewrite(m))
+		}
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/operator/pkg/name"
+)
+
+func TestParseRewrites(t *testing.T) {
+	got, err := ParseRewrites([]string{"docker.io/istio/=registry.local:5000/istio", "quay.io=mirror"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Rewrites{{"docker.io/istio", "registry.local:5000/istio"}, {"quay.io", "mirror"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, s := range []string{"docker.io", "=mirror", "docker.io="} {
+		if _, err := ParseRewrite(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rs := Rewrites{
+		{From: "docker.io", To: "mirror.local"},
+		{From: "docker.io/istio", To: "registry.local/istio"},
+	}
+	tests := []struct {
+		desc string
+		in   string
+		want string
+	}{
+		{
+			desc: "longest match",
+			in:   "image: docker.io/istio/pilot:1.5.0",
+			want: "image: registry.local/istio/pilot:1.5.0",
+		},
+		{
+			desc: "shorter match",
+			in:   `image: "docker.io/prom/prometheus:v2.15.1"`,
+			want: `image: "mirror.local/prom/prometheus:v2.15.1"`,
+		},
+		{
+			desc: "whole segments only",
+			in:   "image: docker.io/istio-testing/pilot:1.5.0\nimage: mydocker.io/pilot",
+			want: "image: mirror.local/istio-testing/pilot:1.5.0\nimage: mydocker.io/pilot",
+		},
+		{
+			desc: "registry only",
+			in:   `{"hub":"docker.io/istio","tag":"1.5.0"}`,
+			want: `{"hub":"registry.local/istio","tag":"1.5.0"}`,
+		},
+		{
+			desc: "argument",
+			in:   "- --proxyImage=docker.io/istio/proxyv2:1.5.0",
+			want: "- --proxyImage=registry.local/istio/proxyv2:1.5.0",
+		},
+		{
+			desc: "reference",
+			in:   "docker.io/istio/proxyv2:1.5.0",
+			want: "registry.local/istio/proxyv2:1.5.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := rs.Rewrite(tt.in); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	mm := rs.RewriteManifests(name.ManifestMap{name.PilotComponentName: {tests[0].in}})
+	if got := mm[name.PilotComponentName][0]; got != tests[0].want {
+		t.Errorf("got manifest %q, want %q", got, tests[0].want)
+	}
+	if got := Rewrites(nil).Rewrite(tests[0].in); got != tests[0].in {
+		t.Errorf("got %q without rewrites, want input unchanged", got)
+	}
+}
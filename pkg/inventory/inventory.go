@@ -0,0 +1,382 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package inventory records the exact set of objects applied for each component, so that objects can be pruned without
+searching the cluster by label.
+
+An Inventory lists the group, kind, namespace and name of every object in the last applied manifest of a component.
+When a component is applied again, only the inventory entries missing from the new manifest are deleted, and the new
+manifest becomes the inventory. Objects which merely carry the labels of a component are never touched, except that the
+objects applied by releases which pruned by label are adopted by a component which has no inventory yet (see
+SeededStore). Inventories are stored in a ConfigMap per component and are used by both the CLI and the controller. Each
+owner, i.e. the CLI or an IstioOperator resource reconciled by the controller, and each control plane revision has its
+own inventories, so that applying one never prunes the objects of another.
+*/
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/util"
+)
+
+const (
+	// configMapPrefix is the prefix of the name of the ConfigMap holding the inventory of a component.
+	configMapPrefix = "istio-inventory-"
+	// entriesKey is the ConfigMap data key holding the JSON encoded inventory entries.
+	entriesKey = "entries"
+	// CLIOwner is the owner of the inventories of objects applied by the CLI.
+	CLIOwner = "cli"
+)
+
+var (
+	// inventoryLabelStr marks ConfigMaps holding an inventory.
+	inventoryLabelStr = name.OperatorAPINamespace + "/inventory"
+	// componentLabelStr is the component the inventory belongs to.
+	componentLabelStr = name.OperatorAPINamespace + "/component"
+	// ownerAnnotationStr is the owner the inventory belongs to. Inventories without it belong to CLIOwner.
+	ownerAnnotationStr = name.OperatorAPINamespace + "/inventory-owner"
+)
+
+// Entry identifies a single applied object.
+type Entry struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// EntryFor returns the Entry for o.
+func EntryFor(o *object.K8sObject) Entry {
+	gvk := o.GroupVersionKind()
+	return Entry{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Namespace: o.Namespace, Name: o.Name}
+}
+
+// EntriesFor returns the Entries for objects, sorted.
+func EntriesFor(objects object.K8sObjects) []Entry {
+	out := make([]Entry, 0, len(objects))
+	for _, o := range objects {
+		out = append(out, EntryFor(o))
+	}
+	sortEntries(out)
+	return out
+}
+
+// GroupVersionKind returns the GroupVersionKind of the object.
+func (e Entry) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: e.Group, Version: e.Version, Kind: e.Kind}
+}
+
+// Unstructured returns an object holding only the type, namespace and name of the entry.
+func (e Entry) Unstructured() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(e.GroupVersionKind())
+	u.SetNamespace(e.Namespace)
+	u.SetName(e.Name)
+	return u
+}
+
+// String implements the Stringer interface, using Kind/namespace/name format.
+func (e Entry) String() string {
+	if e.Namespace == "" {
+		return fmt.Sprintf("%s/%s", e.Kind, e.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", e.Kind, e.Namespace, e.Name)
+}
+
+// key identifies the object independently of its API version, so that moving a kind to a new version does not prune
+// it.
+func (e Entry) key() string {
+	return strings.Join([]string{e.Group, e.Kind, e.Namespace, e.Name}, ":")
+}
+
+// Inventory is the set of objects applied for a component.
+type Inventory struct {
+	Component name.ComponentName
+	Entries   []Entry
+}
+
+// Prunable returns the entries of the inventory which are not in current.
+func (inv *Inventory) Prunable(current []Entry) []Entry {
+	if inv == nil {
+		return nil
+	}
+	keep := make(map[string]bool)
+	for _, e := range current {
+		keep[e.key()] = true
+	}
+	var out []Entry
+	for _, e := range inv.Entries {
+		if !keep[e.key()] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Store persists inventories.
+type Store interface {
+	// Get returns the inventory of component, or nil if there is none.
+	Get(component name.ComponentName) (*Inventory, error)
+	// List returns all stored inventories.
+	List() ([]*Inventory, error)
+	// Put stores inv, replacing any existing inventory of the same component.
+	Put(inv *Inventory) error
+	// Delete removes the inventory of component. Deleting a missing inventory is not an error.
+	Delete(component name.ComponentName) error
+}
+
+// Sync deletes the entries of the stored inventory of component which are not in current, and stores current as the
+// new inventory. del is called for each entry to delete; entries it fails to delete stay in the inventory, so that
+// deleting them is retried the next time. If prune is false, nothing is deleted and the stored inventory keeps the
+// old entries in addition to current. If the resulting inventory is empty, it is deleted.
+// Sync returns the entries it deleted.
+func Sync(s Store, component name.ComponentName, current []Entry, prune bool, del func(Entry) error) ([]Entry, error) {
+	old, err := s.Get(component)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory of component %s: %s", component, err)
+	}
+	var errs util.Errors
+	var deleted []Entry
+	entries := append([]Entry{}, current...)
+	for _, e := range old.Prunable(current) {
+		if !prune {
+			entries = append(entries, e)
+			continue
+		}
+		if err := del(e); err != nil {
+			errs = util.AppendErr(errs, fmt.Errorf("%s: %s", e, err))
+			entries = append(entries, e)
+			continue
+		}
+		deleted = append(deleted, e)
+	}
+	if len(entries) == 0 {
+		if old != nil {
+			errs = util.AppendErr(errs, s.Delete(component))
+		}
+		return deleted, errs.ToError()
+	}
+	sortEntries(entries)
+	errs = util.AppendErr(errs, s.Put(&Inventory{Component: component, Entries: entries}))
+	return deleted, errs.ToError()
+}
+
+// SeededStore is a Store which returns the entries found by Seed for components without a stored inventory. It adopts
+// the objects applied by releases which pruned by label rather than by inventory: the first Sync of a component stores
+// them, after which they are pruned like any other entry once they are no longer in the manifest.
+type SeededStore struct {
+	Store
+	// Seed returns the entries for the objects of component applied before inventories were kept.
+	Seed func(component name.ComponentName) ([]Entry, error)
+}
+
+// Get implements Store.
+func (s *SeededStore) Get(component name.ComponentName) (*Inventory, error) {
+	inv, err := s.Store.Get(component)
+	if err != nil || inv != nil {
+		return inv, err
+	}
+	entries, err := s.Seed(component)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find objects of component %s applied before inventories: %s", component, err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	sortEntries(entries)
+	return &Inventory{Component: component, Entries: entries}, nil
+}
+
+// Labeled returns the entries for the objects of the given kinds in all namespaces which match selector. Kinds which
+// are not served by the cluster are skipped.
+func Labeled(c client.Client, kinds []schema.GroupVersionKind, selector labels.Selector) ([]Entry, error) {
+	var out []Entry
+	for _, gvk := range kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := c.List(context.TODO(), list, client.MatchingLabelsSelector{Selector: selector})
+		if meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %s", gvk.Kind, err)
+		}
+		for _, o := range list.Items {
+			out = append(out, Entry{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Namespace: o.GetNamespace(),
+				Name: o.GetName()})
+		}
+	}
+	sortEntries(out)
+	return out, nil
+}
+
+// ConfigMapStore is a Store keeping each inventory in a ConfigMap in a single namespace.
+type ConfigMapStore struct {
+	client    client.Client
+	namespace string
+	// owner is who applied the objects in the inventories, CLIOwner or namespace/name of an IstioOperator.
+	owner string
+	// revision is the control plane revision the inventories belong to, empty for the install without a revision.
+	revision string
+}
+
+// NewConfigMapStore creates a ConfigMapStore for the inventories of the CLI using the given client and namespace.
+func NewConfigMapStore(c client.Client, namespace string) *ConfigMapStore {
+	return &ConfigMapStore{client: c, namespace: namespace, owner: CLIOwner}
+}
+
+// NewRevisionConfigMapStore creates a ConfigMapStore for the inventories of the CLI for the given control plane
+// revision, which are kept apart from the inventories of other revisions.
+func NewRevisionConfigMapStore(c client.Client, namespace, revision string) *ConfigMapStore {
+	return &ConfigMapStore{client: c, namespace: namespace, owner: CLIOwner, revision: revision}
+}
+
+// NewOwnerConfigMapStore creates a ConfigMapStore for the inventories of the given owner and control plane revision.
+// owner is the namespace/name of an IstioOperator resource, or CLIOwner.
+func NewOwnerConfigMapStore(c client.Client, namespace, owner, revision string) *ConfigMapStore {
+	return &ConfigMapStore{client: c, namespace: namespace, owner: owner, revision: revision}
+}
+
+// Get implements Store.
+func (s *ConfigMapStore) Get(component name.ComponentName) (*Inventory, error) {
+	cm := &corev1.ConfigMap{}
+	err := s.client.Get(context.TODO(), client.ObjectKey{Namespace: s.namespace, Name: s.configMapName(component)}, cm)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromConfigMap(cm)
+}
+
+// List implements Store.
+func (s *ConfigMapStore) List() ([]*Inventory, error) {
+	cms := &corev1.ConfigMapList{}
+	err := s.client.List(context.TODO(), cms, client.InNamespace(s.namespace), client.MatchingLabels{inventoryLabelStr: "true"})
+	if err != nil {
+		return nil, err
+	}
+	var out []*Inventory
+	for i := range cms.Items {
+		if cms.Items[i].Labels[name.RevisionLabel] != s.revision || ownerOf(&cms.Items[i]) != s.owner {
+			continue
+		}
+		inv, err := fromConfigMap(&cms.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Component < out[j].Component })
+	return out, nil
+}
+
+// Put implements Store.
+func (s *ConfigMapStore) Put(inv *Inventory) error {
+	j, err := json.Marshal(inv.Entries)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.configMapName(inv.Component),
+			Namespace: s.namespace,
+			Labels: map[string]string{
+				inventoryLabelStr: "true",
+				componentLabelStr: string(inv.Component),
+			},
+		},
+		Data: map[string]string{entriesKey: string(j)},
+	}
+	if s.revision != "" {
+		cm.Labels[name.RevisionLabel] = s.revision
+	}
+	if s.owner != CLIOwner {
+		cm.Annotations = map[string]string{ownerAnnotationStr: s.owner}
+	}
+	existing := &corev1.ConfigMap{}
+	err = s.client.Get(context.TODO(), client.ObjectKey{Namespace: cm.Namespace, Name: cm.Name}, existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		return s.client.Create(context.TODO(), cm)
+	case err != nil:
+		return err
+	}
+	existing.Labels = cm.Labels
+	existing.Annotations = cm.Annotations
+	existing.Data = cm.Data
+	return s.client.Update(context.TODO(), existing)
+}
+
+// Delete implements Store.
+func (s *ConfigMapStore) Delete(component name.ComponentName) error {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.configMapName(component), Namespace: s.namespace}}
+	if err := s.client.Delete(context.TODO(), cm); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// configMapName returns the name of the ConfigMap holding the inventory of component. The names of the inventories
+// of the CLI carry no owner, as they predate owners.
+func (s *ConfigMapStore) configMapName(component name.ComponentName) string {
+	n := configMapPrefix
+	if s.owner != CLIOwner {
+		n += strings.ReplaceAll(s.owner, "/", ".") + "-"
+	}
+	n += strings.ToLower(string(component))
+	if s.revision != "" {
+		n += "-" + s.revision
+	}
+	return n
+}
+
+// ownerOf returns the owner of the inventory in cm.
+func ownerOf(cm *corev1.ConfigMap) string {
+	if o, ok := cm.Annotations[ownerAnnotationStr]; ok {
+		return o
+	}
+	return CLIOwner
+}
+
+func fromConfigMap(cm *corev1.ConfigMap) (*Inventory, error) {
+	inv := &Inventory{Component: name.ComponentName(cm.Labels[componentLabelStr])}
+	if err := json.Unmarshal([]byte(cm.Data[entriesKey]), &inv.Entries); err != nil {
+		return nil, fmt.Errorf("bad inventory in ConfigMap %s/%s: %s", cm.Namespace, cm.Name, err)
+	}
+	return inv, nil
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key() < entries[j].key() })
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"istio.io/operator/pkg/name"
+)
+
+var (
+	svc      = Entry{Version: "v1", Kind: "Service", Namespace: "istio-system", Name: "istio-pilot"}
+	cm       = Entry{Version: "v1", Kind: "ConfigMap", Namespace: "istio-system", Name: "istio"}
+	deploy   = Entry{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "istio-system", Name: "istio-pilot"}
+	deployV2 = Entry{Group: "apps", Version: "v1beta1", Kind: "Deployment", Namespace: "istio-system", Name: "istio-pilot"}
+	role     = Entry{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole", Name: "istio-pilot"}
+)
+
+func TestPrunable(t *testing.T) {
+	inv := &Inventory{Component: name.PilotComponentName, Entries: []Entry{svc, cm, deploy, role}}
+	got := inv.Prunable([]Entry{svc, deployV2})
+	want := []Entry{cm, role}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var none *Inventory
+	if got := none.Prunable([]Entry{svc}); got != nil {
+		t.Errorf("got %v for missing inventory, want nil", got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		desc        string
+		old         []Entry
+		current     []Entry
+		prune       bool
+		failDelete  map[Entry]bool
+		wantDeleted []Entry
+		wantEntries []Entry
+		wantErr     bool
+	}{
+		{
+			desc:        "first apply",
+			current:     []Entry{svc, cm},
+			prune:       true,
+			wantEntries: []Entry{cm, svc},
+		},
+		{
+			desc:        "prune removed objects",
+			old:         []Entry{svc, cm, role},
+			current:     []Entry{svc},
+			prune:       true,
+			wantDeleted: []Entry{cm, role},
+			wantEntries: []Entry{svc},
+		},
+		{
+			desc:        "prune disabled keeps old entries",
+			old:         []Entry{svc, cm},
+			current:     []Entry{svc, deploy},
+			wantEntries: []Entry{cm, svc, deploy},
+		},
+		{
+			desc:        "failed deletes are retried",
+			old:         []Entry{svc, cm, role},
+			current:     []Entry{svc},
+			prune:       true,
+			failDelete:  map[Entry]bool{role: true},
+			wantDeleted: []Entry{cm},
+			wantEntries: []Entry{svc, role},
+			wantErr:     true,
+		},
+		{
+			desc:        "disabled component",
+			old:         []Entry{svc, cm},
+			prune:       true,
+			wantDeleted: []Entry{cm, svc},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			s := NewConfigMapStore(fake.NewFakeClient(), "istio-system")
+			if tt.old != nil {
+				if err := s.Put(&Inventory{Component: name.PilotComponentName, Entries: tt.old}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var deleted []Entry
+			gotDeleted, err := Sync(s, name.PilotComponentName, tt.current, tt.prune, func(e Entry) error {
+				if tt.failDelete[e] {
+					return fmt.Errorf("forbidden")
+				}
+				deleted = append(deleted, e)
+				return nil
+			})
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			sortEntries(gotDeleted)
+			sortEntries(deleted)
+			if !reflect.DeepEqual(gotDeleted, tt.wantDeleted) || !reflect.DeepEqual(deleted, gotDeleted) {
+				t.Errorf("got deleted %v, returned %v, want %v", deleted, gotDeleted, tt.wantDeleted)
+			}
+			inv, err := s.Get(name.PilotComponentName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantEntries == nil {
+				if inv != nil {
+					t.Errorf("got inventory %v, want none", inv.Entries)
+				}
+				return
+			}
+			if inv == nil || !reflect.DeepEqual(inv.Entries, tt.wantEntries) {
+				t.Errorf("got inventory %v, want %v", inv, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestSeededStore(t *testing.T) {
+	seeded := 0
+	s := &SeededStore{
+		Store: NewConfigMapStore(fake.NewFakeClient(), "istio-system"),
+		Seed: func(c name.ComponentName) ([]Entry, error) {
+			seeded++
+			return []Entry{svc, cm}, nil
+		},
+	}
+	del := func(e Entry) error { return nil }
+
+	// The objects found by Seed which are not in the manifest are pruned and the rest are kept in the inventory.
+	deleted, err := Sync(s, name.PilotComponentName, []Entry{svc, deploy}, true, del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Entry{cm}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("first sync: got deleted %v, want %v", deleted, want)
+	}
+
+	// Once the component has an inventory, Seed is no longer used.
+	deleted, err = Sync(s, name.PilotComponentName, []Entry{deploy}, true, del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Entry{svc}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("second sync: got deleted %v, want %v", deleted, want)
+	}
+	if seeded != 1 {
+		t.Errorf("got %d calls to Seed, want 1", seeded)
+	}
+}
+
+func TestConfigMapStoreList(t *testing.T) {
+	s := NewConfigMapStore(fake.NewFakeClient(), "istio-system")
+	for _, inv := range []*Inventory{
+		{Component: name.PilotComponentName, Entries: []Entry{svc}},
+		{Component: name.IstioBaseComponentName, Entries: []Entry{role}},
+	} {
+		if err := s.Put(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(&Inventory{Component: name.PilotComponentName, Entries: []Entry{svc, deploy}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Inventory{
+		{Component: name.IstioBaseComponentName, Entries: []Entry{role}},
+		{Component: name.PilotComponentName, Entries: []Entry{svc, deploy}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.Delete(name.PilotComponentName); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(name.PilotComponentName); err != nil {
+		t.Errorf("Delete of missing inventory: %v", err)
+	}
+}
+
+func TestConfigMapStoreOwnerRevision(t *testing.T) {
+	c := fake.NewFakeClient()
+	def := NewConfigMapStore(c, "istio-system")
+	canary := NewRevisionConfigMapStore(c, "istio-system", "canary")
+	owned := NewOwnerConfigMapStore(c, "istio-system", "istio-system/example", "")
+	ownedCanary := NewOwnerConfigMapStore(c, "istio-system", "istio-system/example", "canary")
+	for s, e := range map[*ConfigMapStore]Entry{def: svc, canary: deploy, owned: cm, ownedCanary: role} {
+		if err := s.Put(&Inventory{Component: name.PilotComponentName, Entries: []Entry{e}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		desc  string
+		store *ConfigMapStore
+		want  []Entry
+	}{
+		{desc: "default", store: def, want: []Entry{svc}},
+		{desc: "canary", store: canary, want: []Entry{deploy}},
+		{desc: "owner", store: owned, want: []Entry{cm}},
+		{desc: "owner canary", store: ownedCanary, want: []Entry{role}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			inv, err := tt.store.Get(name.PilotComponentName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inv == nil || !reflect.DeepEqual(inv.Entries, tt.want) {
+				t.Errorf("Get: got %v, want entries %v", inv, tt.want)
+			}
+			invs, err := tt.store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(invs) != 1 || !reflect.DeepEqual(invs[0].Entries, tt.want) {
+				t.Errorf("List: got %v, want one inventory with entries %v", invs, tt.want)
+			}
+		})
+	}
+
+	if err := canary.Delete(name.PilotComponentName); err != nil {
+		t.Fatal(err)
+	}
+	if err := owned.Delete(name.PilotComponentName); err != nil {
+		t.Fatal(err)
+	}
+	if inv, err := def.Get(name.PilotComponentName); err != nil || inv == nil {
+		t.Errorf("inventory of the CLI deleted with another revision or owner: %v, %v", inv, err)
+	}
+	if inv, err := ownedCanary.Get(name.PilotComponentName); err != nil || inv == nil {
+		t.Errorf("canary inventory of the owner deleted with another revision: %v, %v", inv, err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/retry"
+	"istio.io/operator/pkg/util"
+)
+
+// ObjectAction is the action taken on a single object by the apply engine.
+type ObjectAction string
+
+const (
+	// ObjectCreated means the object did not exist and was created.
+	ObjectCreated ObjectAction = "created"
+	// ObjectUpdated means the object existed and was changed by the apply.
+	ObjectUpdated ObjectAction = "updated"
+	// ObjectUnchanged means the object existed and the apply did not change it.
+	ObjectUnchanged ObjectAction = "unchanged"
+	// ObjectPruned means the object was deleted because it is no longer part of the manifest.
+	ObjectPruned ObjectAction = "pruned"
+)
+
+// ObjectApplyResult is the result of applying or pruning a single object.
+type ObjectApplyResult struct {
+	// Object is the object that was applied or pruned.
+	Object *object.K8sObject
+	// Action is the action taken on the object. It is empty if Err is set.
+	Action ObjectAction
+	// Err is the error returned by the API server, if any.
+	Err error
+	// Retries is the number of times the request was retried after a transient error.
+	Retries int
+}
+
+// String implements the Stringer interface.
+func (r *ObjectApplyResult) String() string {
+	retries := retrySummary([]ObjectApplyResult{*r})
+	if r.Err != nil {
+		return fmt.Sprintf("%s failed%s: %s", objectRef(r.Object), retries, r.Err)
+	}
+	return fmt.Sprintf("%s %s%s", objectRef(r.Object), r.Action, retries)
+}
+
+// objectRef returns a human readable reference for o in Kind/namespace/name format.
+func objectRef(o *object.K8sObject) string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s/%s", o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// resultErrors returns the errors in results, each prefixed with the reference of the object it applies to.
+func resultErrors(results []ObjectApplyResult) util.Errors {
+	var errs util.Errors
+	for _, r := range results {
+		if r.Err != nil {
+			errs = util.AppendErr(errs, fmt.Errorf("%s: %s", objectRef(r.Object), r.Err))
+		}
+	}
+	return errs
+}
+
+// clusterApplier applies, fetches and deletes objects through a cluster.Backend. Objects are applied with
+// server-side apply under cluster.FieldManager.
+type clusterApplier struct {
+	cluster.Backend
+}
+
+// apply applies o to the cluster with server-side apply and reports whether it was created, updated or unchanged.
+// Requests failing with a transient error are retried as given by backoff.
+func (a *clusterApplier) apply(o *object.K8sObject, backoff wait.Backoff) ObjectApplyResult {
+	result := ObjectApplyResult{Object: o}
+	u := o.UnstructuredObject()
+	result.Retries, result.Err = retry.Do(backoff, func() error {
+		oldVersion := ""
+		current, err := a.Get(o.GroupVersionKind(), o.Namespace, o.Name)
+		switch {
+		case err == nil:
+			oldVersion = current.GetResourceVersion()
+		case !apierrors.IsNotFound(err):
+			return err
+		}
+
+		applied, err := a.Apply(u, false)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case oldVersion == "":
+			result.Action = ObjectCreated
+		case applied.GetResourceVersion() != oldVersion:
+			result.Action = ObjectUpdated
+		default:
+			result.Action = ObjectUnchanged
+		}
+		return nil
+	})
+	return result
+}
+
+// delete deletes o from the cluster. Objects that are already gone are not treated as an error. Requests failing with
+// a transient error are retried as given by backoff.
+func (a *clusterApplier) delete(o *object.K8sObject, backoff wait.Backoff) ObjectApplyResult {
+	result := ObjectApplyResult{Object: o}
+	result.Retries, result.Err = retry.Do(backoff, func() error {
+		err := a.Delete(o.GroupVersionKind(), o.Namespace, o.Name)
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			// A kind which is no longer served has no objects left.
+			return nil
+		}
+		return err
+	})
+	if result.Err == nil {
+		result.Action = ObjectPruned
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+func TestObjectApplyResultString(t *testing.T) {
+	tests := []struct {
+		result ObjectApplyResult
+		want   string
+	}{
+		{
+			result: ObjectApplyResult{Object: objecttest.NewK8sObject("Service", "istio-system", "istio-pilot"), Action: ObjectPruned},
+			want:   "Service/istio-system/istio-pilot pruned",
+		},
+		{
+			result: ObjectApplyResult{Object: objecttest.NewK8sObject("ClusterRole", "", "istio-reader"), Err: fmt.Errorf("forbidden")},
+			want:   "ClusterRole/istio-reader failed: forbidden",
+		},
+		{
+			result: ObjectApplyResult{Object: objecttest.NewK8sObject("ConfigMap", "istio-system", "istio"), Action: ObjectUpdated, Retries: 1},
+			want:   "ConfigMap/istio-system/istio updated (1 retry)",
+		},
+		{
+			result: ObjectApplyResult{Object: objecttest.NewK8sObject("Service", "istio-system", "istio-galley"), Err: fmt.Errorf("conflict"), Retries: 6},
+			want:   "Service/istio-system/istio-galley failed (6 retries): conflict",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.result.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// forbiddenDeleteBackend is a cluster.Backend which refuses all deletes.
+type forbiddenDeleteBackend struct {
+	cluster.Backend
+}
+
+func (forbiddenDeleteBackend) Delete(gvk schema.GroupVersionKind, _, name string) error {
+	return apierrors.NewForbidden(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, name, fmt.Errorf("denied"))
+}
+
+func TestClusterApplierDelete(t *testing.T) {
+	obj := objecttest.NewK8sObject("ConfigMap", "default", "istio")
+	tests := []struct {
+		desc       string
+		backend    cluster.Backend
+		wantAction ObjectAction
+		wantErr    bool
+	}{
+		{
+			desc:       "already deleted",
+			backend:    cluster.NewFake(),
+			wantAction: ObjectPruned,
+		},
+		{
+			desc:    "forbidden",
+			backend: forbiddenDeleteBackend{cluster.NewFake()},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := &clusterApplier{Backend: tt.backend}
+			got := a.delete(obj, wait.Backoff{Steps: 1})
+			if gotErr := got.Err != nil; gotErr != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", got.Err, tt.wantErr)
+			}
+			if got.Action != tt.wantAction {
+				t.Errorf("got action %q, want %q", got.Action, tt.wantAction)
+			}
+		})
+	}
+}
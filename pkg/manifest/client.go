@@ -15,19 +15,22 @@
 package manifest
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/docker/distribution/reference"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 
+	"istio.io/operator/pkg/cluster"
 	"istio.io/operator/pkg/util"
 )
 
-// Client is a helper wrapper around the Kube RESTClient for istioctl -> Pilot/Envoy/Mesh related things
+// Client is a helper wrapper around the cluster backend for istioctl -> Pilot/Envoy/Mesh related things
 type Client struct {
-	Config *rest.Config
-	*rest.RESTClient
+	client ctrlclient.Client
 }
 
 // ComponentVersion is a pair of component name and version
@@ -50,17 +53,14 @@ type ExecClient interface {
 	ConfigMapForSelector(namespace, labelSelector string) (*v1.ConfigMapList, error)
 }
 
-// NewClient is the constructor for the client wrapper
+// NewClient is the constructor for the client wrapper. It uses the same backend as the installer for the cluster given
+// by kubeconfig and configContext, see SetBackendFactory.
 func NewClient(kubeconfig, configContext string) (*Client, error) {
-	config, err := defaultRestConfig(kubeconfig, configContext)
+	a, err := getApplier(kubeconfig, configContext)
 	if err != nil {
 		return nil, err
 	}
-	restClient, err := rest.RESTClientFor(config)
-	if err != nil {
-		return nil, err
-	}
-	return &Client{config, restClient}, nil
+	return &Client{client: cluster.NewClient(a)}, nil
 }
 
 // GetIstioVersions gets the version for each Istio component
@@ -137,31 +137,46 @@ func (client *Client) PodsForSelector(namespace, labelSelector string) (*v1.PodL
 	return pods, nil
 }
 
-// GetPods retrieves the pod objects for Istio deployments
+// GetPods retrieves the pod objects for Istio deployments. The labelSelector and fieldSelector params are supported;
+// field selectors may select on the name, namespace, node and phase of pods.
 func (client *Client) GetPods(namespace string, params map[string]string) (*v1.PodList, error) {
-	req := client.Get().
-		Resource("pods").
-		Namespace(namespace)
-	for k, v := range params {
-		req.Param(k, v)
+	ls, err := labels.Parse(params["labelSelector"])
+	if err != nil {
+		return nil, fmt.Errorf("bad label selector: %v", err)
 	}
-
-	res := req.Do()
-	if res.Error() != nil {
-		return nil, fmt.Errorf("unable to retrieve Pods: %v", res.Error())
+	fs, err := fields.ParseSelector(params["fieldSelector"])
+	if err != nil {
+		return nil, fmt.Errorf("bad field selector: %v", err)
 	}
 	list := &v1.PodList{}
-	if err := res.Into(list); err != nil {
-		return nil, fmt.Errorf("unable to parse PodList: %v", res.Error())
+	if err := client.client.List(context.TODO(), list, ctrlclient.InNamespace(namespace),
+		ctrlclient.MatchingLabelsSelector{Selector: ls}); err != nil {
+		return nil, fmt.Errorf("unable to retrieve Pods: %v", err)
+	}
+	pods := list.Items[:0]
+	for _, pod := range list.Items {
+		if fs.Matches(fields.Set{
+			"metadata.name":      pod.Name,
+			"metadata.namespace": pod.Namespace,
+			"spec.nodeName":      pod.Spec.NodeName,
+			"status.phase":       string(pod.Status.Phase),
+		}) {
+			pods = append(pods, pod)
+		}
 	}
+	list.Items = pods
 	return list, nil
 }
 
 func (client *Client) ConfigMapForSelector(namespace, labelSelector string) (*v1.ConfigMapList, error) {
-	cmGet := client.Get().Resource("configmaps").Namespace(namespace).Param("labelSelector", labelSelector)
-	obj, err := cmGet.Do().Get()
+	ls, err := labels.Parse(labelSelector)
 	if err != nil {
+		return nil, fmt.Errorf("bad label selector: %v", err)
+	}
+	list := &v1.ConfigMapList{}
+	if err := client.client.List(context.TODO(), list, ctrlclient.InNamespace(namespace),
+		ctrlclient.MatchingLabelsSelector{Selector: ls}); err != nil {
 		return nil, fmt.Errorf("failed retrieving configmap: %v", err)
 	}
-	return obj.(*v1.ConfigMapList), nil
+	return list, nil
 }
@@ -0,0 +1,228 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/name"
+	"istio.io/pkg/log"
+)
+
+const (
+	// DefaultHistoryNamespace is the namespace install revisions are stored in if no other namespace is given.
+	DefaultHistoryNamespace = "istio-system"
+	// DefaultMaxHistory is the default number of install revisions kept in the cluster.
+	DefaultMaxHistory = 10
+
+	// installRevisionSecretType is the type of the Secrets holding install revisions.
+	installRevisionSecretType v1.SecretType = name.OperatorAPINamespace + "/install-revision"
+	// installRevisionDataKey is the data key holding the compressed revision in an install revision Secret.
+	installRevisionDataKey = "revision"
+)
+
+var (
+	// installHistoryLabelStr marks Secrets holding install revisions.
+	installHistoryLabelStr = name.OperatorAPINamespace + "/install-history"
+	// installRevisionLabelStr holds the revision number of an install revision Secret.
+	installRevisionLabelStr = name.OperatorAPINamespace + "/install-revision"
+)
+
+// InstallRevision is a record of a successful install, as stored in the cluster.
+type InstallRevision struct {
+	// Revision is the sequence number of the revision, starting at 1.
+	Revision int `json:"revision"`
+	// OperatorVersion is the version of the operator binary that applied the revision.
+	OperatorVersion string `json:"operatorVersion"`
+	// Timestamp is the time the revision was applied.
+	Timestamp time.Time `json:"timestamp"`
+	// Description is a short human readable description of the revision, e.g. "Rollback to 3".
+	Description string `json:"description,omitempty"`
+	// Spec is the merged IstioOperatorSpec used to generate the manifests, in YAML format.
+	Spec string `json:"spec"`
+	// Manifests are the rendered manifests for each component.
+	Manifests name.ManifestMap `json:"manifests"`
+}
+
+// SaveInstallRevision stores rev in namespace as the next revision and deletes the oldest revisions, so that at most
+// maxHistory revisions are kept. If maxHistory is not positive, no revisions are deleted. rev.Revision is set to the
+// number of the newly stored revision.
+// InitK8SRestClient must be called before SaveInstallRevision.
+func SaveInstallRevision(namespace string, rev *InstallRevision, maxHistory int) error {
+	return saveInstallRevision(cluster.NewClient(k8sApplier), namespace, rev, maxHistory)
+}
+
+// ListInstallRevisions returns all install revisions stored in namespace, ordered from oldest to newest.
+// InitK8SRestClient must be called before ListInstallRevisions.
+func ListInstallRevisions(namespace string) ([]*InstallRevision, error) {
+	return listInstallRevisions(cluster.NewClient(k8sApplier), namespace)
+}
+
+// GetInstallRevision returns the install revision with the given number stored in namespace.
+// InitK8SRestClient must be called before GetInstallRevision.
+func GetInstallRevision(namespace string, revision int) (*InstallRevision, error) {
+	return getInstallRevision(cluster.NewClient(k8sApplier), namespace, revision)
+}
+
+func saveInstallRevision(c client.Client, namespace string, rev *InstallRevision, maxHistory int) error {
+	secrets, err := listInstallRevisionSecrets(c, namespace)
+	if err != nil {
+		return err
+	}
+	rev.Revision = 1
+	if len(secrets) > 0 {
+		rev.Revision = revisionOfSecret(&secrets[len(secrets)-1]) + 1
+	}
+
+	data, err := encodeInstallRevision(rev)
+	if err != nil {
+		return err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      installRevisionSecretName(rev.Revision),
+			Namespace: namespace,
+			Labels: map[string]string{
+				installHistoryLabelStr:  "true",
+				installRevisionLabelStr: strconv.Itoa(rev.Revision),
+				istioVersionLabelStr:    rev.OperatorVersion,
+			},
+		},
+		Type: installRevisionSecretType,
+		Data: map[string][]byte{installRevisionDataKey: data},
+	}
+	if err := c.Create(context.TODO(), secret); err != nil {
+		return fmt.Errorf("failed to store install revision %d: %s", rev.Revision, err)
+	}
+
+	if maxHistory <= 0 {
+		return nil
+	}
+	// The new revision is not part of secrets, so keep one less of the existing ones.
+	for len(secrets) > maxHistory-1 {
+		oldest := secrets[0]
+		secrets = secrets[1:]
+		log.Infof("Deleting install revision %d from namespace %s.", revisionOfSecret(&oldest), namespace)
+		if err := c.Delete(context.TODO(), &oldest); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete install revision %s: %s", oldest.Name, err)
+		}
+	}
+	return nil
+}
+
+func listInstallRevisions(c client.Client, namespace string) ([]*InstallRevision, error) {
+	secrets, err := listInstallRevisionSecrets(c, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var out []*InstallRevision
+	for i := range secrets {
+		rev, err := decodeInstallRevision(secrets[i].Data[installRevisionDataKey])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode install revision %s: %s", secrets[i].Name, err)
+		}
+		out = append(out, rev)
+	}
+	return out, nil
+}
+
+func getInstallRevision(c client.Client, namespace string, revision int) (*InstallRevision, error) {
+	secret := &v1.Secret{}
+	err := c.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: installRevisionSecretName(revision)}, secret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("install revision %d not found in namespace %s", revision, namespace)
+		}
+		return nil, err
+	}
+	rev, err := decodeInstallRevision(secret.Data[installRevisionDataKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode install revision %s: %s", secret.Name, err)
+	}
+	return rev, nil
+}
+
+// listInstallRevisionSecrets returns the install revision Secrets in namespace, ordered from oldest to newest.
+func listInstallRevisionSecrets(c client.Client, namespace string) ([]v1.Secret, error) {
+	list := &v1.SecretList{}
+	err := c.List(context.TODO(), list, client.InNamespace(namespace), client.MatchingLabels{installHistoryLabelStr: "true"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list install revisions in namespace %s: %s", namespace, err)
+	}
+	secrets := list.Items
+	sort.Slice(secrets, func(i, j int) bool {
+		return revisionOfSecret(&secrets[i]) < revisionOfSecret(&secrets[j])
+	})
+	return secrets, nil
+}
+
+func revisionOfSecret(s *v1.Secret) int {
+	// Secrets with a malformed label sort first and are therefore pruned first.
+	r, _ := strconv.Atoi(s.Labels[installRevisionLabelStr])
+	return r
+}
+
+func installRevisionSecretName(revision int) string {
+	return fmt.Sprintf("istio-install-revision-v%d", revision)
+}
+
+// encodeInstallRevision returns the gzip compressed JSON encoding of rev.
+func encodeInstallRevision(rev *InstallRevision) ([]byte, error) {
+	j, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(j); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeInstallRevision is the inverse of encodeInstallRevision.
+func decodeInstallRevision(data []byte) (*InstallRevision, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	j, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	rev := &InstallRevision{}
+	if err := json.Unmarshal(j, rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
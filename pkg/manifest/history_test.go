@@ -0,0 +1,83 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+func TestInstallRevisionHistory(t *testing.T) {
+	const ns = "istio-system"
+	cs := cluster.NewClient(cluster.NewFake(objecttest.NewK8sObject("Namespace", "", ns).UnstructuredObject()))
+	const maxHistory = 3
+
+	for i := 1; i <= 5; i++ {
+		rev := &InstallRevision{
+			OperatorVersion: "1.5.0",
+			Timestamp:       time.Unix(int64(i), 0).UTC(),
+			Spec:            "profile: default\n",
+			Manifests: name.ManifestMap{
+				name.PilotComponentName: {"kind: Deployment"},
+			},
+		}
+		if err := saveInstallRevision(cs, ns, rev, maxHistory); err != nil {
+			t.Fatalf("saveInstallRevision(%d): %v", i, err)
+		}
+		if rev.Revision != i {
+			t.Fatalf("saveInstallRevision: got revision %d, want %d", rev.Revision, i)
+		}
+	}
+
+	revs, err := listInstallRevisions(cs, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, r := range revs {
+		got = append(got, r.Revision)
+	}
+	if want := []int{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listInstallRevisions: got revisions %v, want %v", got, want)
+	}
+
+	rev, err := getInstallRevision(cs, ns, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rev, revs[1]) {
+		t.Errorf("getInstallRevision: got %v, want %v", rev, revs[1])
+	}
+	if _, err := getInstallRevision(cs, ns, 1); err == nil {
+		t.Errorf("getInstallRevision: expected error for pruned revision 1")
+	}
+}
+
+func TestWithMissingComponents(t *testing.T) {
+	got := WithMissingComponents(name.ManifestMap{name.PilotComponentName: {"kind: Deployment"}})
+	if !reflect.DeepEqual(got[name.PilotComponentName], []string{"kind: Deployment"}) {
+		t.Errorf("WithMissingComponents: manifest for %s changed: %v", name.PilotComponentName, got[name.PilotComponentName])
+	}
+	for _, c := range []name.ComponentName{name.IstioBaseComponentName, name.GalleyComponentName, name.AddonComponentName} {
+		if m, ok := got[c]; !ok || len(m) != 0 {
+			t.Errorf("WithMissingComponents: got %v for %s, want empty manifest", m, c)
+		}
+	}
+}
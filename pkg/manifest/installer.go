@@ -27,38 +27,38 @@ import (
 	"github.com/ghodss/yaml"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
-	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 
 	// For GCP auth functionality.
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	kubectlutil "k8s.io/kubectl/pkg/util/deployment"
-	"k8s.io/utils/pointer"
 
 	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/dag"
 	"istio.io/operator/pkg/helm"
-	"istio.io/operator/pkg/kubectlcmd"
+	"istio.io/operator/pkg/inventory"
 	"istio.io/operator/pkg/name"
 	"istio.io/operator/pkg/object"
+	"istio.io/operator/pkg/readiness"
+	"istio.io/operator/pkg/retry"
 	"istio.io/operator/pkg/util"
 	pkgversion "istio.io/operator/pkg/version"
 	"istio.io/pkg/log"
 )
 
 const (
-	// cRDPollInterval is how often the state of CRDs is polled when waiting for their creation.
+	// cRDPollInterval is how often the state of namespaces and CRDs is polled when waiting for their creation.
 	cRDPollInterval = 500 * time.Millisecond
-	// cRDPollTimeout is the maximum wait time for all CRDs to be created.
+	// cRDPollTimeout is the maximum wait time for all namespaces and CRDs to be created.
 	cRDPollTimeout = 60 * time.Second
 
 	// operatorReconcileStr indicates that the operator will reconcile the resource.
@@ -72,66 +72,159 @@ var (
 	istioComponentLabelStr = name.OperatorAPINamespace + "/component"
 	// istioVersionLabelStr indicates the Istio version of the installation.
 	istioVersionLabelStr = name.OperatorAPINamespace + "/version"
+
+	// legacyPruneKinds are the kinds releases without inventories pruned by label, i.e. the default prune whitelist of
+	// kubectl apply.
+	legacyPruneKinds = []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+		{Version: "v1", Kind: "Endpoints"},
+		{Version: "v1", Kind: "Namespace"},
+		{Version: "v1", Kind: "PersistentVolumeClaim"},
+		{Version: "v1", Kind: "PersistentVolume"},
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "ReplicationController"},
+		{Version: "v1", Kind: "Secret"},
+		{Version: "v1", Kind: "Service"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+		{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+		{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+		{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+		{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	}
 )
 
-// ComponentApplyOutput is used to capture errors and stdout/stderr outputs for a command, per component.
+// ComponentApplyOutput is used to capture the per object results and errors of applying a component.
 type ComponentApplyOutput struct {
-	// Stdout is the stdout output.
-	Stdout string
-	// Stderr is the stderr output.
-	Stderr string
+	// Objects holds the result for each object applied or pruned for the component.
+	Objects []ObjectApplyResult
 	// Error is the error output.
 	Err error
 	// Manifest is the manifest applied to the cluster.
 	Manifest string
+	// Duration is the time taken to apply the component.
+	Duration time.Duration
+}
+
+// InstallOptions control how manifests are applied to the cluster.
+type InstallOptions struct {
+	// DryRun performs all steps except actually applying the manifests or creating output dirs/files.
+	DryRun bool
+	// Verbose enables verbose debug output.
+	Verbose bool
+	// Wait for resources to be ready after install.
+	Wait bool
+	// Maximum amount of time to wait for resources to be ready after install when Wait=true.
+	WaitTimeout time.Duration
+	// Path to the kubeconfig file.
+	Kubeconfig string
+	// Name of the kubeconfig context to use.
+	Context string
+	// Prune removes objects belonging to a component which are no longer in its manifest. If nil, all components
+	// except Base are pruned.
+	Prune *bool
+	// ReadinessTimeouts overrides WaitTimeout for the listed components.
+	ReadinessTimeouts map[name.ComponentName]time.Duration
+	// Concurrency is the maximum number of components applied at the same time. If 0, there is no limit.
+	Concurrency int
+	// MaxRetries is the maximum number of times a request for a single object is retried after a transient error,
+	// with exponential backoff. If nil, the retries of retry.DefaultBackoff are used.
+	MaxRetries *int
+	// ProgressOut is where progress messages are printed. If nil, the writer set with SetProgressOutput is used.
+	ProgressOut io.Writer
+	// InventoryNamespace is the namespace holding the inventory of applied objects for each component, which is
+	// used for pruning. If empty, DefaultHistoryNamespace is used.
+	InventoryNamespace string
+	// Revision is the control plane revision applied, set in IstioOperatorSpec.ResourceSuffix. Each revision has its
+	// own inventories, so that applying one revision never prunes the objects of another.
+	Revision string
 }
 
 type CompositeOutput map[name.ComponentName]*ComponentApplyOutput
 
-type componentNameToListMap map[name.ComponentName][]name.ComponentName
-type componentTree map[name.ComponentName]interface{}
+var (
+	// progressOut is where progress messages are printed.
+	progressOut io.Writer = os.Stdout
 
-// deployment holds associated replicaSets for a deployment
-type deployment struct {
-	replicaSets *appsv1.ReplicaSet
-	deployment  *appsv1.Deployment
-}
+	// k8sApplier is the applier for the cluster set with InitK8SRestClient.
+	k8sApplier *clusterApplier
 
-var (
-	componentDependencies = componentNameToListMap{
-		name.IstioBaseComponentName: {
-			name.PilotComponentName,
-			name.PolicyComponentName,
-			name.TelemetryComponentName,
-			name.GalleyComponentName,
-			name.CitadelComponentName,
-			name.NodeAgentComponentName,
-			name.CertManagerComponentName,
-			name.SidecarInjectorComponentName,
-			name.CNIComponentName,
-			name.IngressComponentName,
-			name.EgressComponentName,
-			name.AddonComponentName,
-		},
-	}
-
-	installTree      = make(componentTree)
-	dependencyWaitCh = make(map[name.ComponentName]chan struct{})
-	kubectl          = kubectlcmd.New()
-
-	k8sRESTConfig     *rest.Config
-	currentKubeconfig string
-	currentContext    string
+	appliersMu sync.Mutex
+	// appliers caches the applier for each kubeconfig and context.
+	appliers = make(map[string]*clusterApplier)
+	// newBackend creates the backend of new appliers.
+	newBackend BackendFactory = restBackend
 )
 
-func init() {
-	buildInstallTree()
-	for _, parent := range componentDependencies {
-		for _, child := range parent {
-			dependencyWaitCh[child] = make(chan struct{}, 1)
-		}
+// BackendFactory creates the backend for the cluster given by kubeconfig and context.
+type BackendFactory func(kubeconfig, context string) (cluster.Backend, error)
+
+// SetBackendFactory sets the factory used to create the backend for each cluster, and drops all backends created
+// so far. By default, backends talk to the API server given by the kubeconfig and context. Tests can use it to run
+// against a cluster.Fake. A nil factory restores the default.
+func SetBackendFactory(f BackendFactory) {
+	appliersMu.Lock()
+	defer appliersMu.Unlock()
+	if f == nil {
+		f = restBackend
+	}
+	newBackend = f
+	appliers = make(map[string]*clusterApplier)
+	k8sApplier = nil
+}
+
+func restBackend(kubeconfig, context string) (cluster.Backend, error) {
+	config, err := defaultRestConfig(kubeconfig, context)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.NewRESTBackend(config)
+}
+
+// getApplier returns the applier for the cluster given by kubeconfig and context, creating it on first use.
+func getApplier(kubeconfig, context string) (*clusterApplier, error) {
+	appliersMu.Lock()
+	defer appliersMu.Unlock()
+	key := kubeconfig + "\x00" + context
+	if a, ok := appliers[key]; ok {
+		return a, nil
+	}
+	b, err := newBackend(kubeconfig, context)
+	if err != nil {
+		return nil, err
+	}
+	a := &clusterApplier{Backend: b}
+	appliers[key] = a
+	return a, nil
+}
+
+// installer applies manifests to a single cluster.
+type installer struct {
+	applier *clusterApplier
+	opts    *InstallOptions
+	// out is where progress messages are printed.
+	out io.Writer
+}
+
+// newInstaller returns an installer for the cluster given by opts. Progress messages are printed to opts.ProgressOut,
+// or to the writer set with SetProgressOutput if it is not set.
+func newInstaller(opts *InstallOptions) (*installer, error) {
+	a, err := getApplier(opts.Kubeconfig, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	out := opts.ProgressOut
+	if out == nil {
+		out = progressOut
 	}
+	return &installer{applier: a, opts: opts, out: out}, nil
+}
 
+func (i *installer) logAndPrint(format string, v ...interface{}) {
+	s := fmt.Sprintf(format, v...)
+	log.Infof(s)
+	_, _ = fmt.Fprintln(i.out, s)
 }
 
 // ParseK8SYAMLToIstioOperatorSpec parses a IstioOperator CustomResource YAML string and unmarshals in into
@@ -157,20 +250,34 @@ func ParseK8SYAMLToIstioOperatorSpec(yml string) (*v1alpha1.IstioOperatorSpec, *
 	return iop, &gvk, nil
 }
 
-// RenderToDir writes manifests to a local filesystem directory tree.
+// RenderToDir writes manifests to a local filesystem directory tree. The directory of each component is nested
+// inside the directory of its first dependency.
 func RenderToDir(manifests name.ManifestMap, outputDir string, dryRun bool) error {
-	logAndPrint("Component dependencies tree: \n%s", installTreeString())
+	g, err := dag.ComponentGraph(componentNames(manifests))
+	if err != nil {
+		return err
+	}
+	logAndPrint("Component dependencies tree: \n%s", g)
 	logAndPrint("Rendering manifests to output dir %s", outputDir)
-	return renderRecursive(manifests, installTree, outputDir, dryRun)
-}
-
-func renderRecursive(manifests name.ManifestMap, installTree componentTree, outputDir string, dryRun bool) error {
-	for k, v := range installTree {
-		componentName := string(k)
+	dirs := make(map[name.ComponentName]string)
+	var dirFor func(c name.ComponentName) string
+	dirFor = func(c name.ComponentName) string {
+		if d, ok := dirs[c]; ok {
+			return d
+		}
+		parent := outputDir
+		if deps := g.Dependencies(c); len(deps) != 0 {
+			parent = dirFor(deps[0])
+		}
+		dirs[c] = filepath.Join(parent, string(c))
+		return dirs[c]
+	}
+	for _, c := range g.Nodes() {
+		componentName := string(c)
 		// In cases (like gateways) where multiple instances can exist, concatenate the manifests and apply as one.
-		ym := strings.Join(manifests[k], helm.YAMLSeparator)
+		ym := strings.Join(manifests[c], helm.YAMLSeparator)
 		logAndPrint("Rendering: %s", componentName)
-		dirName := filepath.Join(outputDir, componentName)
+		dirName := dirFor(c)
 		if !dryRun {
 			if err := os.MkdirAll(dirName, os.ModePerm); err != nil {
 				return fmt.Errorf("could not create directory %s; %s", outputDir, err)
@@ -183,232 +290,337 @@ func renderRecursive(manifests name.ManifestMap, installTree componentTree, outp
 				return fmt.Errorf("could not write manifest config; %s", err)
 			}
 		}
-
-		kt, ok := v.(componentTree)
-		if !ok {
-			// Leaf
-			return nil
-		}
-		if err := renderRecursive(manifests, kt, dirName, dryRun); err != nil {
-			return err
-		}
 	}
 	return nil
 }
 
-// ApplyAll applies all given manifests using kubectl client.
-func ApplyAll(manifests name.ManifestMap, version pkgversion.Version, opts *kubectlcmd.Options) (CompositeOutput, error) {
+// ApplyAll applies all given manifests to the cluster using server-side apply. Components are applied concurrently
+// once their dependencies have been applied. If a component fails, the components which depend on it are not applied
+// and their output holds a *dag.SkippedError.
+func ApplyAll(manifests name.ManifestMap, version pkgversion.Version, opts *InstallOptions) (CompositeOutput, error) {
 	log.Infof("Preparing manifests for these components:")
 	for c := range manifests {
 		log.Infof("- %s", c)
 	}
-	log.Infof("Component dependencies tree: \n%s", installTreeString())
-	if err := InitK8SRestClient(opts.Kubeconfig, opts.Context); err != nil {
+	g, err := dag.ComponentGraph(componentNames(manifests))
+	if err != nil {
 		return nil, err
 	}
-	return applyRecursive(manifests, version, opts)
-}
+	log.Infof("Component dependencies tree: \n%s", g)
+	inst, err := newInstaller(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// All progress messages go through the progress display while components are being applied.
+	progress := newProgressLog(inst.out, g, isTerminal(inst.out))
+	inst.out = progress
+	progress.start()
 
-func applyRecursive(manifests name.ManifestMap, version pkgversion.Version, opts *kubectlcmd.Options) (CompositeOutput, error) {
-	var wg sync.WaitGroup
 	var mu sync.Mutex
 	out := CompositeOutput{}
-	allAppliedObjects := object.K8sObjects{}
-	for c, m := range manifests {
-		c := c
-		m := m
-		wg.Add(1)
-		go func() {
-			if s := dependencyWaitCh[c]; s != nil {
-				log.Infof("%s is waiting on a prerequisite...", c)
-				<-s
-				log.Infof("Prerequisite for %s has completed, proceeding with install.", c)
-			}
-			applyOut, appliedObjects := ApplyManifest(c, strings.Join(m, helm.YAMLSeparator), version.String(), *opts)
-			mu.Lock()
-			out[c] = applyOut
-			allAppliedObjects = append(allAppliedObjects, appliedObjects...)
-			mu.Unlock()
-
-			// Signal all the components that depend on us.
-			for _, ch := range componentDependencies[c] {
-				log.Infof("unblocking child %s.", ch)
-				dependencyWaitCh[ch] <- struct{}{}
-			}
-			wg.Done()
-		}()
-	}
-	wg.Wait()
-	if opts.Wait {
-		return out, waitForResources(allAppliedObjects, opts)
+	results := g.ExecuteWithLimit(opts.Concurrency, func(c name.ComponentName) error {
+		start := time.Now()
+		progress.setPhase(c, phaseApplying)
+		applyOut, _ := inst.applyManifest(c, strings.Join(manifests[c], helm.YAMLSeparator), version.String())
+		applyOut.Duration = time.Since(start)
+		if applyOut.Err == nil && opts.Wait {
+			progress.setPhase(c, phaseReadiness)
+			applyOut.Err = inst.waitForComponent(c, applyOut.Objects, func(pending []string) {
+				progress.setPending(c, pending)
+			})
+			applyOut.Duration = time.Since(start)
+		}
+		if applyOut.Err != nil {
+			progress.setPhase(c, phaseFailed)
+		} else {
+			progress.setPhase(c, phaseDone)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		out[c] = applyOut
+		return applyOut.Err
+	})
+	for c, err := range results {
+		if dag.IsSkipped(err) {
+			progress.setPhase(c, phaseSkipped)
+			inst.logAndPrint("✘ Skipped component %s: %s", c, err)
+			out[c] = &ComponentApplyOutput{Err: err}
+		}
 	}
+	progress.stop()
 	return out, nil
 }
 
+// ApplyManifest applies the manifest for the given component to the cluster. Namespaces are applied first, then CRDs,
+// which are waited on until established, then all remaining objects. If pruning is enabled, objects in the inventory of
+// the component which are no longer in the manifest are deleted. A component with an empty manifest is treated as
+// disabled and all of its objects are deleted.
+// InitK8SRestClient must be called before ApplyManifest.
 func ApplyManifest(componentName name.ComponentName, manifestStr, version string,
-	opts kubectlcmd.Options) (*ComponentApplyOutput, object.K8sObjects) {
-	stdout, stderr := "", ""
+	opts InstallOptions) (*ComponentApplyOutput, object.K8sObjects) {
+	start := time.Now()
+	out := opts.ProgressOut
+	if out == nil {
+		out = progressOut
+	}
+	inst := &installer{applier: k8sApplier, opts: &opts, out: out}
+	co, appliedObjects := inst.applyManifest(componentName, manifestStr, version)
+	co.Duration = time.Since(start)
+	return co, appliedObjects
+}
+
+func (i *installer) applyManifest(componentName name.ComponentName, manifestStr, version string) (*ComponentApplyOutput,
+	object.K8sObjects) {
+	opts := i.opts
 	appliedObjects := object.K8sObjects{}
 	objects, err := object.ParseK8sObjectsFromYAMLManifest(manifestStr)
 	if err != nil {
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		return buildComponentApplyOutput(nil, appliedObjects, err), appliedObjects
 	}
-	componentLabel := fmt.Sprintf("%s=%s", istioComponentLabelStr, componentName)
-
-	// TODO: remove this when `kubectl --prune` supports empty objects
-	//  (https://github.com/kubernetes/kubernetes/issues/40635)
-	// Delete all resources for a disabled component
+	// Delete all resources for a disabled component.
 	if len(objects) == 0 {
-		getOpts := opts
-		getOpts.Output = "yaml"
-		getOpts.ExtraArgs = []string{"--all-namespaces", "--selector", componentLabel}
-		stdoutGet, stderrGet, err := kubectl.GetAll(&getOpts)
-		if err != nil {
-			stdout += "\n" + stdoutGet
-			stderr += "\n" + stderrGet
-			return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		if opts.DryRun {
+			log.Infof("Not pruning objects for disabled component %s in dry run mode.", componentName)
+			return buildComponentApplyOutput(nil, appliedObjects, nil), appliedObjects
 		}
-		items, err := GetKubectlGetItems(stdoutGet)
+		inv, err := i.inventoryStore().Get(componentName)
 		if err != nil {
-			return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+			return buildComponentApplyOutput(nil, appliedObjects, err), appliedObjects
 		}
-		if len(items) == 0 {
-			return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		if inv == nil {
+			return buildComponentApplyOutput(nil, appliedObjects, nil), appliedObjects
 		}
-
-		logAndPrint("- Pruning objects for disabled component %s...", componentName)
-		delObjects, err := object.ParseK8sObjectsFromYAMLManifest(stdoutGet)
+		i.logAndPrint("- Pruning objects for disabled component %s...", componentName)
+		results, err := i.pruneComponent(componentName, nil)
 		if err != nil {
-			return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+			i.logAndPrint("✘ Finished pruning objects for disabled component %s%s.", componentName, retrySummary(results))
+			return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
 		}
-		delOpts := opts
-		delOpts.ExtraArgs = []string{"--selector", componentLabel}
-		stdoutDel, stderrDel, err := kubectl.Delete(stdoutGet, &delOpts)
-		stdout += "\n" + stdoutDel
-		stderr += "\n" + stderrDel
-		if err != nil {
-			logAndPrint("✘ Finished pruning objects for disabled component %s.", componentName)
-			return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		for _, r := range results {
+			appliedObjects = append(appliedObjects, r.Object)
 		}
-		appliedObjects = append(appliedObjects, delObjects...)
-		logAndPrint("✔ Finished pruning objects for disabled component %s.", componentName)
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
-	}
-
-	for _, o := range objects {
-		o.AddLabels(map[string]string{istioComponentLabelStr: string(componentName)})
-		o.AddLabels(map[string]string{operatorLabelStr: operatorReconcileStr})
-		o.AddLabels(map[string]string{istioVersionLabelStr: version})
+		i.logAndPrint("✔ Finished pruning objects for disabled component %s%s.", componentName, retrySummary(results))
+		return buildComponentApplyOutput(results, appliedObjects, nil), appliedObjects
 	}
 
-	opts.ExtraArgs = []string{"--force", "--selector", componentLabel}
-	// Base components include namespaces and CRDs, pruning them will remove user configs, which makes it hard to roll back.
-	if componentName != name.IstioBaseComponentName && opts.Prune == nil {
-		opts.Prune = pointer.BoolPtr(true)
-	}
+	addInstallerLabels(objects, componentName, version)
 
-	logAndPrint("- Applying manifest for component %s...", componentName)
+	i.logAndPrint("- Applying manifest for component %s...", componentName)
+	var results []ObjectApplyResult
 
 	// Apply namespace resources first, then wait.
 	nsObjects := nsKindObjects(objects)
-	stdout, stderr, err = applyObjects(nsObjects, &opts, stdout, stderr)
+	results, err = i.applyObjects(nsObjects, results)
 	if err != nil {
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
 	}
-	if err := waitForResources(nsObjects, &opts); err != nil {
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+	if err := i.waitForObjects(nsObjects); err != nil {
+		return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
 	}
 	appliedObjects = append(appliedObjects, nsObjects...)
 
+	// Add the objects to the inventory before applying them, so that objects created by a failed apply are pruned
+	// later. This is done after applying namespaces, since the inventory namespace may be created by this component.
+	if !opts.DryRun {
+		if _, err := inventory.Sync(i.inventoryStore(), componentName, inventory.EntriesFor(objects), false, nil); err != nil {
+			return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
+		}
+	}
+
 	// Apply CRDs, then wait.
 	crdObjects := cRDKindObjects(objects)
-	stdout, stderr, err = applyObjects(crdObjects, &opts, stdout, stderr)
+	results, err = i.applyObjects(crdObjects, results)
 	if err != nil {
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
 	}
-	if err := waitForCRDs(crdObjects, opts.DryRun); err != nil {
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+	if err := i.waitForObjects(crdObjects); err != nil {
+		return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
 	}
 	appliedObjects = append(appliedObjects, crdObjects...)
 
 	// Apply all remaining objects.
 	nonNsCrdObjects := objectsNotInLists(objects, nsObjects, crdObjects)
-	stdout, stderr, err = applyObjects(nonNsCrdObjects, &opts, stdout, stderr)
+	results, err = i.applyObjects(nonNsCrdObjects, results)
+	if err == nil && !opts.DryRun {
+		var pruneResults []ObjectApplyResult
+		pruneResults, err = i.pruneComponent(componentName, objects)
+		results = append(results, pruneResults...)
+	}
 	mark := "✔"
 	if err != nil {
 		mark = "✘"
 	}
-	logAndPrint("%s Finished applying manifest for component %s.", mark, componentName)
+	i.logAndPrint("%s Finished applying manifest for component %s%s.", mark, componentName, retrySummary(results))
 	if err != nil {
-		return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+		return buildComponentApplyOutput(results, appliedObjects, err), appliedObjects
 	}
 	appliedObjects = append(appliedObjects, nonNsCrdObjects...)
-	return buildComponentApplyOutput(stdout, stderr, appliedObjects, err), appliedObjects
+	return buildComponentApplyOutput(results, appliedObjects, nil), appliedObjects
 }
 
-func GetKubectlGetItems(stdoutGet string) ([]interface{}, error) {
-	yamlGet := make(map[string]interface{})
-	err := yaml.Unmarshal([]byte(stdoutGet), &yamlGet)
+// DeleteManifest deletes all objects in the given manifest from the cluster.
+func DeleteManifest(manifestStr string, opts InstallOptions) ([]ObjectApplyResult, error) {
+	objects, err := object.ParseK8sObjectsFromYAMLManifest(manifestStr)
 	if err != nil {
 		return nil, err
 	}
-	if yamlGet == nil {
-		return []interface{}{}, nil
-	}
-	if yamlGet["kind"] != "List" {
-		return nil, fmt.Errorf("`kubectl get` returned a yaml whose kind is not List")
+	if opts.DryRun {
+		for _, o := range objects {
+			log.Infof("Not deleting %s in dry run mode.", objectRef(o))
+		}
+		return nil, nil
 	}
-	if _, ok := yamlGet["items"]; !ok {
-		return nil, fmt.Errorf("`kubectl get` returned a yaml without 'items' in the root")
+	a, err := getApplier(opts.Kubeconfig, opts.Context)
+	if err != nil {
+		return nil, err
 	}
-	switch items := yamlGet["items"].(type) {
-	case []interface{}:
-		return items, nil
+	// Delete in the reverse order of creation.
+	objects.Sort(func(o *object.K8sObject) int {
+		return -defaultObjectOrder()(o)
+	})
+	var results []ObjectApplyResult
+	for _, o := range objects {
+		results = append(results, a.delete(o, opts.backoff()))
 	}
-	return nil, fmt.Errorf("`kubectl get` returned a yaml incorrecnt type 'items' in the root")
+	return results, resultErrors(results).ToError()
 }
 
+// DeploymentExists reports whether the Deployment with the given namespace and name exists in the cluster given by
+// kubeconfig and context.
 func DeploymentExists(kubeconfig, context, namespace, name string) (bool, error) {
 	if err := InitK8SRestClient(kubeconfig, context); err != nil {
 		return false, err
 	}
-
-	cs, err := kubernetes.NewForConfig(k8sRESTConfig)
-	if err != nil {
-		return false, fmt.Errorf("k8s client error: %s", err)
-	}
-
-	d, err := cs.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
-	if err != nil {
+	_, err := k8sApplier.Get(appsv1.SchemeGroupVersion.WithKind("Deployment"), namespace, name)
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
 		return false, err
 	}
-	return d != nil, nil
+	return true, nil
 }
 
-func applyObjects(objs object.K8sObjects, opts *kubectlcmd.Options, stdout, stderr string) (string, string, error) {
+// applyObjects applies objs to the cluster and appends the per object results to results. The returned error
+// aggregates the errors for all objects that failed to apply.
+func (i *installer) applyObjects(objs object.K8sObjects, results []ObjectApplyResult) ([]ObjectApplyResult, error) {
 	if len(objs) == 0 {
-		return stdout, stderr, nil
+		return results, nil
 	}
 
 	objs.Sort(defaultObjectOrder())
 
-	mns, err := objs.JSONManifest()
-	if err != nil {
-		return stdout, stderr, err
+	if i.opts.DryRun {
+		for _, o := range objs {
+			log.Infof("Not applying %s in dry run mode.", objectRef(o))
+		}
+		return results, nil
 	}
 
-	stdoutApply, stderrApply, err := kubectl.Apply(mns, opts)
-	stdout += "\n" + stdoutApply
-	stderr += "\n" + stderrApply
+	var errs util.Errors
+	for _, o := range objs {
+		r := i.applier.apply(o, i.opts.backoff())
+		log.Infof("%s", r.String())
+		if r.Err != nil {
+			errs = util.AppendErr(errs, fmt.Errorf("%s: %s", objectRef(o), r.Err))
+		}
+		results = append(results, r)
+	}
+	return results, errs.ToError()
+}
 
-	return stdout, stderr, err
+// addInstallerLabels adds the labels identifying the component, version and manager of objects installed by the
+// installer.
+func addInstallerLabels(objects object.K8sObjects, componentName name.ComponentName, version string) {
+	for _, o := range objects {
+		o.AddLabels(map[string]string{istioComponentLabelStr: string(componentName)})
+		o.AddLabels(map[string]string{operatorLabelStr: operatorReconcileStr})
+		o.AddLabels(map[string]string{istioVersionLabelStr: version})
+	}
+}
+
+// retrySummary returns a note on the number of retried requests in results, or "" if none were retried.
+func retrySummary(results []ObjectApplyResult) string {
+	retries := 0
+	for _, r := range results {
+		retries += r.Retries
+	}
+	switch retries {
+	case 0:
+		return ""
+	case 1:
+		return " (1 retry)"
+	}
+	return fmt.Sprintf(" (%d retries)", retries)
 }
 
-func buildComponentApplyOutput(stdout string, stderr string, objects object.K8sObjects, err error) *ComponentApplyOutput {
+// backoff returns the backoff for retrying requests for a single object.
+func (opts *InstallOptions) backoff() wait.Backoff {
+	b := retry.DefaultBackoff
+	if opts.MaxRetries != nil {
+		b.Steps = *opts.MaxRetries + 1
+	}
+	return b
+}
+
+// pruneEnabled reports whether objects of the given component which are no longer in its manifest are deleted.
+func pruneEnabled(componentName name.ComponentName, opts *InstallOptions) bool {
+	if opts.Prune != nil {
+		return *opts.Prune
+	}
+	// Base components include namespaces and CRDs, pruning them will remove user configs, which makes it hard to roll back.
+	return componentName != name.IstioBaseComponentName
+}
+
+// pruneComponent deletes the objects in the inventory of componentName which are not in objects, and records objects
+// as the new inventory of the component. If pruning is disabled for the component, nothing is deleted and the
+// inventory keeps the objects which are no longer in the manifest, so that they can be pruned later.
+func (i *installer) pruneComponent(componentName name.ComponentName, objects object.K8sObjects) ([]ObjectApplyResult, error) {
+	var results []ObjectApplyResult
+	prune := len(objects) == 0 || pruneEnabled(componentName, i.opts)
+	_, err := inventory.Sync(i.inventoryStore(), componentName, inventory.EntriesFor(objects), prune,
+		func(e inventory.Entry) error {
+			r := i.applier.delete(object.NewK8sObject(e.Unstructured(), nil, nil), i.opts.backoff())
+			log.Infof("%s", r.String())
+			results = append(results, r)
+			return r.Err
+		})
+	return results, err
+}
+
+// inventoryStore returns the store holding the component inventories. Without a revision, a component which has no
+// inventory yet adopts the objects labeled as its own by releases which pruned by label, so that upgrading from them
+// still prunes the objects they applied.
+func (i *installer) inventoryStore() inventory.Store {
+	ns := i.opts.InventoryNamespace
+	if ns == "" {
+		ns = DefaultHistoryNamespace
+	}
+	c := cluster.NewClient(i.applier)
+	store := inventory.NewRevisionConfigMapStore(c, ns, i.opts.Revision)
+	if i.opts.Revision != "" {
+		return store
+	}
+	return &inventory.SeededStore{Store: store, Seed: func(componentName name.ComponentName) ([]inventory.Entry, error) {
+		return inventory.Labeled(c, legacyPruneKinds, legacySelector(componentName))
+	}}
+}
+
+// legacySelector selects the objects of componentName applied by the CLI without a revision, as labeled by
+// addInstallerLabels.
+func legacySelector(componentName name.ComponentName) labels.Selector {
+	noRevision, _ := labels.NewRequirement(name.RevisionLabel, selection.DoesNotExist, nil)
+	return labels.SelectorFromSet(labels.Set{
+		istioComponentLabelStr: string(componentName),
+		operatorLabelStr:       operatorReconcileStr,
+	}).Add(*noRevision)
+}
+
+func buildComponentApplyOutput(results []ObjectApplyResult, objects object.K8sObjects, err error) *ComponentApplyOutput {
 	manifest, _ := objects.YAMLManifest()
 	return &ComponentApplyOutput{
-		Stdout:   stdout,
-		Stderr:   stderr,
+		Objects:  results,
 		Manifest: manifest,
 		Err:      err,
 	}
@@ -488,279 +700,93 @@ func objectsNotInLists(objects object.K8sObjects, lists ...object.K8sObjects) ob
 	return ret
 }
 
-func waitForCRDs(objects object.K8sObjects, dryRun bool) error {
-	if dryRun {
-		log.Info("Not waiting for CRDs in dry run mode.")
+// waitForObjects waits for namespaces and CRDs to be ready before the objects which need them are applied.
+func (i *installer) waitForObjects(objects object.K8sObjects) error {
+	if len(objects) == 0 {
 		return nil
 	}
-
-	log.Info("Waiting for CRDs to be applied.")
-	cs, err := apiextensionsclient.NewForConfig(k8sRESTConfig)
-	if err != nil {
-		return fmt.Errorf("k8s client error: %s", err)
+	if i.opts.DryRun {
+		log.Info("Not waiting for objects in dry run mode.")
+		return nil
 	}
-
-	var crdNames []string
-	for _, o := range cRDKindObjects(objects) {
-		crdNames = append(crdNames, o.Name)
+	var us []*unstructured.Unstructured
+	for _, o := range objects {
+		us = append(us, o.UnstructuredObject())
 	}
-
-	errPoll := wait.Poll(cRDPollInterval, cRDPollTimeout, func() (bool, error) {
-	descriptor:
-		for _, crdName := range crdNames {
-			crd, errGet := cs.ApiextensionsV1beta1().CustomResourceDefinitions().Get(crdName, metav1.GetOptions{})
-			if errGet != nil {
-				return false, errGet
-			}
-			for _, cond := range crd.Status.Conditions {
-				switch cond.Type {
-				case apiextensionsv1beta1.Established:
-					if cond.Status == apiextensionsv1beta1.ConditionTrue {
-						log.Infof("established CRD %q", crdName)
-						continue descriptor
-					}
-				case apiextensionsv1beta1.NamesAccepted:
-					if cond.Status == apiextensionsv1beta1.ConditionFalse {
-						log.Warnf("name conflict: %v", cond.Reason)
-					}
-				}
-			}
-			log.Infof("missing status condition for %q", crdName)
-			return false, nil
-		}
-		return true, nil
-	})
-
-	if errPoll != nil {
-		log.Errorf("failed to verify CRD creation; %s", errPoll)
-		return fmt.Errorf("failed to verify CRD creation: %s", errPoll)
+	if err := readiness.Wait(i.applier, us, cRDPollInterval, cRDPollTimeout); err != nil {
+		log.Errorf("failed to wait for namespaces and CRDs; %s", err)
+		return fmt.Errorf("failed to wait for namespaces and CRDs: %s", err)
+	}
+	if len(cRDKindObjects(objects)) != 0 {
+		// Instances of the new CRDs can only be applied once the API mapping has been refreshed.
+		i.applier.ResetMapping()
+		log.Info("Finished applying CRDs.")
 	}
-
-	log.Info("Finished applying CRDs.")
 	return nil
 }
 
-// waitForResources polls to get the current status of all pods, PVCs, and Services
-// until all are ready or a timeout is reached
-// TODO - plumb through k8s client and remove global `k8sRESTConfig`
-func waitForResources(objects object.K8sObjects, opts *kubectlcmd.Options) error {
+// waitForComponent polls the objects applied for a component until they are ready, using the readiness timeout of
+// the component if one is set in opts. pending is called after each poll with the objects which are not ready yet.
+func (i *installer) waitForComponent(componentName name.ComponentName, results []ObjectApplyResult,
+	pending func([]string)) error {
+	opts := i.opts
 	if opts.DryRun {
-		logAndPrint("Not waiting for resources ready in dry run mode.")
+		i.logAndPrint("Not waiting for resources ready in dry run mode.")
 		return nil
 	}
-
-	cs, err := kubernetes.NewForConfig(k8sRESTConfig)
-	if err != nil {
-		return fmt.Errorf("k8s client error: %s", err)
-	}
-
-	errPoll := wait.Poll(2*time.Second, opts.WaitTimeout, func() (bool, error) {
-		pods := []v1.Pod{}
-		services := []v1.Service{}
-		deployments := []deployment{}
-		namespaces := []v1.Namespace{}
-
-		for _, o := range objects {
-			kind := o.GroupVersionKind().Kind
-			switch kind {
-			case "Namespace":
-				namespace, err := cs.CoreV1().Namespaces().Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				namespaces = append(namespaces, *namespace)
-			case "Pod":
-				pod, err := cs.CoreV1().Pods(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				pods = append(pods, *pod)
-			case "ReplicationController":
-				rc, err := cs.CoreV1().ReplicationControllers(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				list, err := getPods(cs, rc.Namespace, rc.Spec.Selector)
-				if err != nil {
-					return false, err
-				}
-				pods = append(pods, list...)
-			case "Deployment":
-				currentDeployment, err := cs.AppsV1().Deployments(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				_, _, newReplicaSet, err := kubectlutil.GetAllReplicaSets(currentDeployment, cs.AppsV1())
-				if err != nil || newReplicaSet == nil {
-					return false, err
-				}
-				newDeployment := deployment{
-					newReplicaSet,
-					currentDeployment,
-				}
-				deployments = append(deployments, newDeployment)
-			case "DaemonSet":
-				ds, err := cs.AppsV1().DaemonSets(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				list, err := getPods(cs, ds.Namespace, ds.Spec.Selector.MatchLabels)
-				if err != nil {
-					return false, err
-				}
-				pods = append(pods, list...)
-			case "StatefulSet":
-				sts, err := cs.AppsV1().StatefulSets(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				list, err := getPods(cs, sts.Namespace, sts.Spec.Selector.MatchLabels)
-				if err != nil {
-					return false, err
-				}
-				pods = append(pods, list...)
-			case "ReplicaSet":
-				rs, err := cs.AppsV1().ReplicaSets(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				list, err := getPods(cs, rs.Namespace, rs.Spec.Selector.MatchLabels)
-				if err != nil {
-					return false, err
-				}
-				pods = append(pods, list...)
-			case "Service":
-				svc, err := cs.CoreV1().Services(o.Namespace).Get(o.Name, metav1.GetOptions{})
-				if err != nil {
-					return false, err
-				}
-				services = append(services, *svc)
-			}
+	var us []*unstructured.Unstructured
+	for _, r := range results {
+		if r.Err == nil && r.Action != ObjectPruned {
+			us = append(us, r.Object.UnstructuredObject())
 		}
-		isReady := namespacesReady(namespaces) && podsReady(pods) && deploymentsReady(deployments) && servicesReady(services)
-		if !isReady {
-			logAndPrint("Waiting for resources ready with timeout of %v", opts.WaitTimeout)
-		}
-		return isReady, nil
-	})
-
-	if errPoll != nil {
-		logAndPrint("Failed to wait for resources ready: %v", errPoll)
-		return fmt.Errorf("failed to wait for resources ready: %s", errPoll)
 	}
-	return nil
-}
-
-func getPods(client kubernetes.Interface, namespace string, selector map[string]string) ([]v1.Pod, error) {
-	list, err := client.CoreV1().Pods(namespace).List(metav1.ListOptions{
-		FieldSelector: fields.Everything().String(),
-		LabelSelector: labels.Set(selector).AsSelector().String(),
-	})
-	return list.Items, err
-}
-
-func namespacesReady(namespaces []v1.Namespace) bool {
-	for _, namespace := range namespaces {
-		if !isNamespaceReady(&namespace) {
-			logAndPrint("Namespace is not ready: %s/%s", namespace.GetName())
-			return false
-		}
+	if len(us) == 0 {
+		return nil
 	}
-	return true
-}
-
-func podsReady(pods []v1.Pod) bool {
-	for _, pod := range pods {
-		if !isPodReady(&pod) {
-			logAndPrint("Pod is not ready: %s/%s", pod.GetNamespace(), pod.GetName())
-			return false
-		}
+	timeout := opts.WaitTimeout
+	if t, ok := opts.ReadinessTimeouts[componentName]; ok {
+		timeout = t
 	}
-	return true
-}
-
-func isNamespaceReady(namespace *v1.Namespace) bool {
-	return namespace.Status.Phase == v1.NamespaceActive
-}
-
-func isPodReady(pod *v1.Pod) bool {
-	if len(pod.Status.Conditions) > 0 {
-		for _, condition := range pod.Status.Conditions {
-			if condition.Type == v1.PodReady &&
-				condition.Status == v1.ConditionTrue {
-				return true
-			}
-		}
+	i.logAndPrint("- Waiting for component %s to be ready with timeout of %v...", componentName, timeout)
+	if err := readiness.WaitWithProgress(i.applier, us, readiness.DefaultPollInterval, timeout, pending); err != nil {
+		i.logAndPrint("✘ Component %s is not ready: %s", componentName, err)
+		return err
 	}
-	return false
+	i.logAndPrint("✔ Component %s is ready.", componentName)
+	return nil
 }
 
-func deploymentsReady(deployments []deployment) bool {
-	for _, v := range deployments {
-		if v.replicaSets.Status.ReadyReplicas < *v.deployment.Spec.Replicas {
-			logAndPrint("Deployment is not ready: %s/%s", v.deployment.GetNamespace(), v.deployment.GetName())
-			return false
-		}
+// WithMissingComponents returns a copy of manifests with an empty manifest added for each known component which is
+// missing from manifests. Applying the result with ApplyAll prunes the objects of those components.
+func WithMissingComponents(manifests name.ManifestMap) name.ManifestMap {
+	out := make(name.ManifestMap)
+	for c, m := range manifests {
+		out[c] = m
 	}
-	return true
-}
-
-func servicesReady(svc []v1.Service) bool {
-	for _, s := range svc {
-		if s.Spec.Type == v1.ServiceTypeExternalName {
-			continue
-		}
-		if s.Spec.ClusterIP != v1.ClusterIPNone && s.Spec.ClusterIP == "" {
-			logAndPrint("Service is not ready: %s/%s", s.GetNamespace(), s.GetName())
-			return false
-		}
-		if s.Spec.Type == v1.ServiceTypeLoadBalancer && s.Status.LoadBalancer.Ingress == nil {
-			logAndPrint("Service is not ready: %s/%s", s.GetNamespace(), s.GetName())
-			return false
+	for c := range name.ComponentDependencies {
+		if _, ok := out[c]; !ok {
+			out[c] = nil
 		}
 	}
-	return true
-}
-
-func buildInstallTree() {
-	// Starting with root, recursively insert each first level child into each node.
-	insertChildrenRecursive(name.IstioBaseComponentName, installTree, componentDependencies)
+	return out
 }
 
-func insertChildrenRecursive(componentName name.ComponentName, tree componentTree, children componentNameToListMap) {
-	tree[componentName] = make(componentTree)
-	for _, child := range children[componentName] {
-		insertChildrenRecursive(child, tree[componentName].(componentTree), children)
-	}
-}
-
-func installTreeString() string {
-	var sb strings.Builder
-	buildInstallTreeString(name.IstioBaseComponentName, "", &sb)
-	return sb.String()
-}
-
-func buildInstallTreeString(componentName name.ComponentName, prefix string, sb io.StringWriter) {
-	_, _ = sb.WriteString(prefix + string(componentName) + "\n")
-	if _, ok := installTree[componentName].(componentTree); !ok {
-		return
-	}
-	for k := range installTree[componentName].(componentTree) {
-		buildInstallTreeString(k, prefix+"  ", sb)
+// componentNames returns the components in manifests.
+func componentNames(manifests name.ManifestMap) []name.ComponentName {
+	var out []name.ComponentName
+	for c := range manifests {
+		out = append(out, c)
 	}
+	return out
 }
 
+// InitK8SRestClient sets the cluster used by ApplyManifest, the install history functions and DeploymentExists.
 func InitK8SRestClient(kubeconfig, context string) error {
-	var err error
-	if kubeconfig == currentKubeconfig && context == currentContext && k8sRESTConfig != nil {
-		return nil
-	}
-	currentKubeconfig, currentContext = kubeconfig, context
-
-	k8sRESTConfig, err = defaultRestConfig(kubeconfig, context)
+	a, err := getApplier(kubeconfig, context)
 	if err != nil {
 		return err
 	}
+	k8sApplier = a
 	return nil
 }
 
@@ -806,8 +832,13 @@ func BuildClientConfig(kubeconfig, context string) (*rest.Config, error) {
 	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
 }
 
+// SetProgressOutput sets the writer progress messages are printed to, which is stdout by default.
+func SetProgressOutput(w io.Writer) {
+	progressOut = w
+}
+
 func logAndPrint(v ...interface{}) {
 	s := fmt.Sprintf(v[0].(string), v[1:]...)
 	log.Infof(s)
-	fmt.Println(s)
+	_, _ = fmt.Fprintln(progressOut, s)
 }
@@ -0,0 +1,219 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"istio.io/operator/pkg/cluster"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object/objecttest"
+	pkgversion "istio.io/operator/pkg/version"
+)
+
+const (
+	testBaseManifest = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: istio-system
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.istio.io
+spec:
+  group: example.istio.io
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  version: v1alpha1
+`
+	testPilotDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.5
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  ports:
+  - port: 15010
+---
+apiVersion: example.istio.io/v1alpha1
+kind: Widget
+metadata:
+  name: pilot-widget
+  namespace: istio-system
+`
+	testPilotConfigMap = `
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: pilot-extra
+  namespace: istio-system
+data:
+  a: b
+`
+)
+
+var (
+	deploymentGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	configMapGVK  = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	widgetGVK     = schema.GroupVersionKind{Group: "example.istio.io", Version: "v1alpha1", Kind: "Widget"}
+)
+
+func TestApplyAllFakeCluster(t *testing.T) {
+	fake := cluster.NewFake()
+	SetBackendFactory(func(string, string) (cluster.Backend, error) { return fake, nil })
+	defer SetBackendFactory(restBackend)
+	version := pkgversion.NewVersion(1, 5, 0, "")
+
+	apply := func(pilot string, wait bool) CompositeOutput {
+		t.Helper()
+		var progress bytes.Buffer
+		opts := &InstallOptions{Wait: wait, WaitTimeout: 30 * time.Second, ProgressOut: &progress}
+		out, err := ApplyAll(name.ManifestMap{
+			name.IstioBaseComponentName: {testBaseManifest},
+			name.PilotComponentName:     {pilot},
+		}, version, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c, o := range out {
+			if o.Err != nil {
+				t.Fatalf("component %s: %s\n%s", c, o.Err, progress.String())
+			}
+		}
+		return out
+	}
+	actions := func(o *ComponentApplyOutput) map[string]ObjectAction {
+		out := make(map[string]ObjectAction)
+		for _, r := range o.Objects {
+			out[objectRef(r.Object)] = r.Action
+		}
+		return out
+	}
+
+	// The Widget can only be applied once the CRD in Base is established, and the Deployment must become ready.
+	out := apply(testPilotDeployment+testPilotConfigMap, true)
+	for ref, action := range actions(out[name.PilotComponentName]) {
+		if action != ObjectCreated {
+			t.Errorf("first apply: got %s %s, want %s", ref, action, ObjectCreated)
+		}
+	}
+	if _, err := fake.Get(widgetGVK, "istio-system", "pilot-widget"); err != nil {
+		t.Errorf("Widget not applied: %v", err)
+	}
+	d, err := fake.Get(deploymentGVK, "istio-system", "istio-pilot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.GetLabels()[istioComponentLabelStr] != string(name.PilotComponentName) {
+		t.Errorf("Deployment has no component label: %v", d.GetLabels())
+	}
+
+	// Applying again without the ConfigMap prunes it and leaves everything else unchanged.
+	out = apply(testPilotDeployment, false)
+	got := actions(out[name.PilotComponentName])
+	want := map[string]ObjectAction{
+		"Deployment/istio-system/istio-pilot": ObjectUnchanged,
+		"Service/istio-system/istio-pilot":    ObjectUnchanged,
+		"Widget/istio-system/pilot-widget":    ObjectUnchanged,
+		"ConfigMap/istio-system/pilot-extra":  ObjectPruned,
+	}
+	for ref, action := range want {
+		if got[ref] != action {
+			t.Errorf("second apply: got %s %s, want %s", ref, got[ref], action)
+		}
+	}
+	if _, err := fake.Get(configMapGVK, "istio-system", "pilot-extra"); !apierrors.IsNotFound(err) {
+		t.Errorf("ConfigMap not pruned: %v", err)
+	}
+
+	// Disabling Pilot prunes all of its objects.
+	apply("", false)
+	for _, gvk := range []schema.GroupVersionKind{deploymentGVK, widgetGVK} {
+		if _, err := fake.Get(gvk, "istio-system", "istio-pilot"); !apierrors.IsNotFound(err) {
+			t.Errorf("%s not pruned: %v", gvk.Kind, err)
+		}
+	}
+	if _, err := fake.Get(widgetGVK, "istio-system", "pilot-widget"); !apierrors.IsNotFound(err) {
+		t.Errorf("Widget not pruned: %v", err)
+	}
+
+	exists, err := DeploymentExists("", "", "istio-system", "istio-pilot")
+	if err != nil || exists {
+		t.Errorf("DeploymentExists: got %v, %v, want false", exists, err)
+	}
+}
+
+func TestApplyAllUpgradeFromLabels(t *testing.T) {
+	legacy := func(name string, labels map[string]string) *unstructured.Unstructured {
+		o := objecttest.New("ConfigMap", "istio-system", name)
+		o.SetLabels(labels)
+		return o
+	}
+	// Objects applied by a release which pruned by label, with no inventory yet.
+	fake := cluster.NewFake(
+		objecttest.New("Namespace", "", "istio-system"),
+		legacy("pilot-old", map[string]string{istioComponentLabelStr: "Pilot", operatorLabelStr: operatorReconcileStr}),
+		legacy("pilot-user", map[string]string{istioComponentLabelStr: "Pilot"}),
+		legacy("pilot-canary", map[string]string{istioComponentLabelStr: "Pilot", operatorLabelStr: operatorReconcileStr,
+			name.RevisionLabel: "canary"}),
+	)
+	SetBackendFactory(func(string, string) (cluster.Backend, error) { return fake, nil })
+	defer SetBackendFactory(restBackend)
+
+	out, err := ApplyAll(name.ManifestMap{
+		name.IstioBaseComponentName: {testBaseManifest},
+		name.PilotComponentName:     {testPilotDeployment},
+	}, pkgversion.NewVersion(1, 5, 0, ""), &InstallOptions{ProgressOut: &bytes.Buffer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out[name.PilotComponentName].Err; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fake.Get(configMapGVK, "istio-system", "pilot-old"); !apierrors.IsNotFound(err) {
+		t.Errorf("object of the earlier install not pruned: %v", err)
+	}
+	for _, n := range []string{"pilot-user", "pilot-canary"} {
+		if _, err := fake.Get(configMapGVK, "istio-system", n); err != nil {
+			t.Errorf("%s: %v", n, err)
+		}
+	}
+}
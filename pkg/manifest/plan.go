@@ -0,0 +1,228 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/operator/pkg/compare"
+	"istio.io/operator/pkg/helm"
+	"istio.io/operator/pkg/inventory"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+	pkgversion "istio.io/operator/pkg/version"
+)
+
+// planIgnorePaths are the paths which are set by the API server and are not compared when computing the diff of an
+// object.
+var planIgnorePaths = []string{
+	"metadata.managedFields*",
+	"metadata.resourceVersion",
+	"metadata.generation",
+	"metadata.creationTimestamp",
+	"metadata.uid",
+	"metadata.selfLink",
+	"metadata.annotations.deployment.kubernetes.io/revision",
+	"status*",
+}
+
+// PlannedChange is the change an apply would make to a single object.
+type PlannedChange struct {
+	// Object is the object from the manifest, or the inventory entry for pruned objects.
+	Object *object.K8sObject
+	// Action is the action an apply would take on the object. It is empty if Err is set.
+	Action ObjectAction
+	// Diff is a field level diff between the live object and the object after the apply, for updated objects.
+	Diff string
+	// Err is the error returned by the server-side dry run of the change.
+	Err error
+}
+
+// Plan holds the changes an apply would make, for each component.
+type Plan map[name.ComponentName][]PlannedChange
+
+// PlanAll computes the changes ApplyAll would make to the cluster for the given manifests, without changing the
+// cluster. Each object is applied with a server-side dry run and compared to the live object. Objects in the inventory
+// which would be pruned are listed as well.
+func PlanAll(manifests name.ManifestMap, version pkgversion.Version, opts *InstallOptions) (Plan, error) {
+	inst, err := newInstaller(opts)
+	if err != nil {
+		return nil, err
+	}
+	plan := make(Plan)
+	for c, m := range manifests {
+		changes, err := inst.planComponent(c, strings.Join(m, helm.YAMLSeparator), version.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan component %s: %s", c, err)
+		}
+		plan[c] = changes
+	}
+	return plan, nil
+}
+
+func (i *installer) planComponent(componentName name.ComponentName, manifestStr, version string) ([]PlannedChange, error) {
+	objects, err := object.ParseK8sObjectsFromYAMLManifest(manifestStr)
+	if err != nil {
+		return nil, err
+	}
+	addInstallerLabels(objects, componentName, version)
+	objects.Sort(defaultObjectOrder())
+
+	var changes []PlannedChange
+	for _, o := range objects {
+		changes = append(changes, i.planObject(o))
+	}
+
+	// A component with an empty manifest is disabled and all of its objects are pruned.
+	if len(objects) == 0 || pruneEnabled(componentName, i.opts) {
+		inv, err := i.inventoryStore().Get(componentName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get inventory: %s", err)
+		}
+		for _, e := range inv.Prunable(inventory.EntriesFor(objects)) {
+			changes = append(changes, PlannedChange{Object: object.NewK8sObject(e.Unstructured(), nil, nil), Action: ObjectPruned})
+		}
+	}
+	return changes, nil
+}
+
+// planObject applies o with a server-side dry run and compares the result to the live object.
+func (i *installer) planObject(o *object.K8sObject) PlannedChange {
+	change := PlannedChange{Object: o}
+	live, applied, err := i.applier.dryRunApply(o)
+	switch {
+	case live == nil && (err == nil || meta.IsNoMatchError(err) || apierrors.IsNotFound(err)):
+		// The dry run of a new object fails if its namespace or CRD is created by the same apply.
+		change.Action = ObjectCreated
+	case err != nil:
+		change.Err = err
+	default:
+		diff, err := objectDiff(live, applied)
+		switch {
+		case err != nil:
+			change.Err = err
+		case diff == "":
+			change.Action = ObjectUnchanged
+		default:
+			change.Action = ObjectUpdated
+			change.Diff = diff
+		}
+	}
+	return change
+}
+
+// objectDiff returns a field level diff between live and applied, ignoring fields which are set by the API server.
+func objectDiff(live, applied *unstructured.Unstructured) (string, error) {
+	ly, err := yaml.Marshal(live.Object)
+	if err != nil {
+		return "", err
+	}
+	ay, err := yaml.Marshal(applied.Object)
+	if err != nil {
+		return "", err
+	}
+	return compare.YAMLCmpWithIgnore(string(ly), string(ay), planIgnorePaths, ""), nil
+}
+
+// HasChanges reports whether applying the plan would change the cluster.
+func (p Plan) HasChanges() bool {
+	for _, changes := range p {
+		for _, c := range changes {
+			if c.Err != nil || c.Action != ObjectUnchanged {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Errors returns the errors of all planned changes.
+func (p Plan) Errors() []error {
+	var errs []error
+	for _, cn := range p.components() {
+		for _, c := range p[cn] {
+			if c.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", objectRef(c.Object), c.Err))
+			}
+		}
+	}
+	return errs
+}
+
+// String returns a human readable description of the plan. Unchanged objects are only counted.
+func (p Plan) String() string {
+	var sb strings.Builder
+	counts := make(map[ObjectAction]int)
+	for _, cn := range p.components() {
+		var lines []string
+		for _, c := range p[cn] {
+			counts[c.Action]++
+			ref := objectRef(c.Object)
+			switch {
+			case c.Err != nil:
+				lines = append(lines, fmt.Sprintf("  ! %s: %s", ref, c.Err))
+			case c.Action == ObjectCreated:
+				lines = append(lines, "  + "+ref)
+			case c.Action == ObjectUpdated:
+				lines = append(lines, "  ~ "+ref, indent(strings.TrimRight(c.Diff, "\n"), "      "))
+			case c.Action == ObjectPruned:
+				lines = append(lines, "  - "+ref)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("Component %s:\n", cn))
+		sb.WriteString(strings.Join(lines, "\n"))
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fmt.Sprintf("Plan: %d to create, %d to update, %d to prune, %d unchanged.\n",
+		counts[ObjectCreated], counts[ObjectUpdated], counts[ObjectPruned], counts[ObjectUnchanged]))
+	return sb.String()
+}
+
+func (p Plan) components() []name.ComponentName {
+	var out []name.ComponentName
+	for cn := range p {
+		out = append(out, cn)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.Replace(s, "\n", "\n"+prefix, -1)
+}
+
+// dryRunApply applies o with a server-side dry run. It returns the live object, or nil if it does not exist, and the
+// object as it would be after the apply.
+func (a *clusterApplier) dryRunApply(o *object.K8sObject) (live, applied *unstructured.Unstructured, err error) {
+	live, err = a.Get(o.GroupVersionKind(), o.Namespace, o.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+		live = nil
+	case err != nil:
+		return nil, nil, err
+	}
+	applied, err = a.Apply(o.UnstructuredObject(), true)
+	return live, applied, err
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object/objecttest"
+)
+
+func TestObjectDiff(t *testing.T) {
+	live := objecttest.NewK8sObject("ConfigMap", "istio-system", "istio").UnstructuredObject()
+	live.SetResourceVersion("1")
+	_ = unstructured.SetNestedField(live.Object, "a", "data", "key")
+	_ = unstructured.SetNestedField(live.Object, "ready", "status", "phase")
+
+	applied := live.DeepCopy()
+	applied.SetResourceVersion("2")
+	_ = unstructured.SetNestedField(applied.Object, "other", "status", "phase")
+
+	diff, err := objectDiff(live, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("got diff %q for server-set fields, want none", diff)
+	}
+
+	_ = unstructured.SetNestedField(applied.Object, "b", "data", "key")
+	diff, err = objectDiff(live, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "data:\n  key: a -> b\n"; diff != want {
+		t.Errorf("got diff %q, want %q", diff, want)
+	}
+}
+
+func TestPlanString(t *testing.T) {
+	plan := Plan{
+		name.PilotComponentName: {
+			{Object: objecttest.NewK8sObject("Service", "istio-system", "istio-pilot"), Action: ObjectCreated},
+			{Object: objecttest.NewK8sObject("ConfigMap", "istio-system", "istio"), Action: ObjectUpdated, Diff: "data:\n  key: a -> b\n"},
+			{Object: objecttest.NewK8sObject("Secret", "istio-system", "old"), Action: ObjectPruned},
+			{Object: objecttest.NewK8sObject("ServiceAccount", "istio-system", "istio-pilot"), Action: ObjectUnchanged},
+		},
+		name.GalleyComponentName: {
+			{Object: objecttest.NewK8sObject("ServiceAccount", "istio-system", "istio-galley"), Action: ObjectUnchanged},
+		},
+		name.PolicyComponentName: {
+			{Object: objecttest.NewK8sObject("Service", "istio-system", "istio-policy"), Err: fmt.Errorf("forbidden")},
+		},
+	}
+	want := `Component Pilot:
+  + Service/istio-system/istio-pilot
+  ~ ConfigMap/istio-system/istio
+      data:
+        key: a -> b
+  - Secret/istio-system/old
+Component Policy:
+  ! Service/istio-system/istio-policy: forbidden
+Plan: 1 to create, 1 to update, 1 to prune, 2 unchanged.
+`
+	if got := plan.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !plan.HasChanges() {
+		t.Errorf("HasChanges: got false, want true")
+	}
+	if got := len(plan.Errors()); got != 1 {
+		t.Errorf("Errors: got %d errors, want 1", got)
+	}
+	unchanged := Plan{name.GalleyComponentName: plan[name.GalleyComponentName]}
+	if unchanged.HasChanges() {
+		t.Errorf("HasChanges: got true for unchanged plan, want false")
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/name"
+)
+
+const (
+	// progressRedrawInterval is how often the progress display is redrawn on a terminal.
+	progressRedrawInterval = 500 * time.Millisecond
+	// progressLogInterval is how often a progress summary is printed when the output is not a terminal.
+	progressLogInterval = 15 * time.Second
+	// progressMaxLineWidth is the width at which lines of the progress display are truncated, so that they do not
+	// wrap and break redrawing.
+	progressMaxLineWidth = 120
+)
+
+// componentPhase is the phase of a component while it is being applied.
+type componentPhase string
+
+const (
+	phaseWaiting   componentPhase = "waiting"
+	phaseApplying  componentPhase = "applying"
+	phaseReadiness componentPhase = "waiting for readiness"
+	phaseDone      componentPhase = "done"
+	phaseFailed    componentPhase = "failed"
+	phaseSkipped   componentPhase = "skipped"
+)
+
+// componentProgress is the progress of a single component.
+type componentProgress struct {
+	phase componentPhase
+	// start and end are set when the component starts and finishes applying.
+	start, end time.Time
+	// pending lists the resources which are not ready yet.
+	pending []string
+}
+
+// progressLog displays the progress of each component of an ApplyAll. On a terminal, a status block with a line per
+// component is redrawn in place, and other output is printed above it. Otherwise, output is passed through and a
+// summary of the components in progress is printed periodically.
+type progressLog struct {
+	mu         sync.Mutex
+	out        io.Writer
+	tty        bool
+	graph      *dag.Graph
+	components map[name.ComponentName]*componentProgress
+	// drawnLines is the number of lines of the status block currently on the terminal.
+	drawnLines int
+	interval   time.Duration
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+	// now returns the current time, and can be replaced in tests.
+	now func() time.Time
+}
+
+func newProgressLog(out io.Writer, g *dag.Graph, tty bool) *progressLog {
+	p := &progressLog{
+		out:        out,
+		tty:        tty,
+		graph:      g,
+		components: make(map[name.ComponentName]*componentProgress),
+		interval:   progressLogInterval,
+		now:        time.Now,
+	}
+	if tty {
+		p.interval = progressRedrawInterval
+	}
+	for _, c := range g.Nodes() {
+		p.components[c] = &componentProgress{phase: phaseWaiting}
+	}
+	return p
+}
+
+// start starts refreshing the display in the background until stop is called.
+func (p *progressLog) start() {
+	p.stopCh = make(chan struct{})
+	p.doneCh = make(chan struct{})
+	go func() {
+		defer close(p.doneCh)
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-t.C:
+				p.refresh()
+			}
+		}
+	}()
+}
+
+// stop stops refreshing the display. On a terminal, the final state of all components is left on screen.
+func (p *progressLog) stop() {
+	if p.stopCh != nil {
+		close(p.stopCh)
+		<-p.doneCh
+	}
+	if p.tty {
+		p.refresh()
+	}
+}
+
+// setPhase sets the phase of component c.
+func (p *progressLog) setPhase(c name.ComponentName, phase componentPhase) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cp, ok := p.components[c]
+	if !ok {
+		return
+	}
+	switch phase {
+	case phaseApplying:
+		cp.start = p.now()
+	case phaseDone, phaseFailed, phaseSkipped:
+		cp.end = p.now()
+		cp.pending = nil
+	}
+	cp.phase = phase
+	if p.tty {
+		p.redrawLocked(nil)
+	}
+}
+
+// setPending sets the resources of component c which are not ready yet.
+func (p *progressLog) setPending(c name.ComponentName, pending []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cp, ok := p.components[c]; ok {
+		cp.pending = pending
+	}
+}
+
+// Write implements io.Writer. On a terminal, b is printed above the status block.
+func (p *progressLog) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.tty {
+		return p.out.Write(b)
+	}
+	p.redrawLocked(b)
+	return len(b), nil
+}
+
+func (p *progressLog) refresh() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty {
+		p.redrawLocked(nil)
+		return
+	}
+	var inProgress []string
+	for _, c := range p.graph.Nodes() {
+		cp := p.components[c]
+		if cp.phase == phaseApplying || cp.phase == phaseReadiness {
+			inProgress = append(inProgress, p.statusLocked(c))
+		}
+	}
+	if len(inProgress) != 0 {
+		_, _ = fmt.Fprintf(p.out, "Still in progress:\n  %s\n", strings.Join(inProgress, "\n  "))
+	}
+}
+
+// redrawLocked erases the status block, prints b if it is not nil, and draws the status block again.
+func (p *progressLog) redrawLocked(b []byte) {
+	var buf bytes.Buffer
+	if p.drawnLines > 0 {
+		// Move the cursor to the start of the status block and clear everything below it.
+		fmt.Fprintf(&buf, "\x1b[%dA\x1b[J", p.drawnLines)
+	}
+	buf.Write(b)
+	nodes := p.graph.Nodes()
+	for _, c := range nodes {
+		buf.WriteString(truncate(p.statusLocked(c), progressMaxLineWidth))
+		buf.WriteString("\n")
+	}
+	p.drawnLines = len(nodes)
+	_, _ = p.out.Write(buf.Bytes())
+}
+
+// statusLocked returns a single line describing the progress of component c.
+func (p *progressLog) statusLocked(c name.ComponentName) string {
+	cp := p.components[c]
+	mark := " "
+	switch cp.phase {
+	case phaseDone:
+		mark = "✔"
+	case phaseFailed, phaseSkipped:
+		mark = "✘"
+	case phaseApplying, phaseReadiness:
+		mark = "-"
+	}
+	status := string(cp.phase)
+	if cp.phase == phaseWaiting {
+		var waitingOn []string
+		for _, d := range p.graph.Dependencies(c) {
+			if p.components[d].phase != phaseDone {
+				waitingOn = append(waitingOn, string(d))
+			}
+		}
+		if len(waitingOn) != 0 {
+			status = "waiting for " + strings.Join(waitingOn, ", ")
+		}
+	}
+	line := fmt.Sprintf("%s %-16s %s", mark, c, status)
+	if !cp.start.IsZero() {
+		end := cp.end
+		if end.IsZero() {
+			end = p.now()
+		}
+		line += fmt.Sprintf(" (%s)", end.Sub(cp.start).Round(time.Second))
+	}
+	switch len(cp.pending) {
+	case 0:
+	case 1:
+		line += ", pending: " + cp.pending[0]
+	default:
+		line += fmt.Sprintf(", pending: %s and %d more", cp.pending[0], len(cp.pending)-1)
+	}
+	return line
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width-3]) + "..."
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
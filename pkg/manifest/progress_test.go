@@ -0,0 +1,93 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/name"
+)
+
+func newTestProgressLog(t *testing.T, tty bool) (*progressLog, *bytes.Buffer, *time.Time) {
+	g, err := dag.ComponentGraph([]name.ComponentName{name.IstioBaseComponentName, name.PilotComponentName, name.IngressComponentName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	p := newProgressLog(buf, g, tty)
+	now := time.Unix(0, 0)
+	p.now = func() time.Time { return now }
+	return p, buf, &now
+}
+
+func TestProgressLogPlain(t *testing.T) {
+	p, buf, now := newTestProgressLog(t, false)
+
+	p.setPhase(name.IstioBaseComponentName, phaseApplying)
+	*now = now.Add(2 * time.Second)
+	p.setPhase(name.IstioBaseComponentName, phaseDone)
+	p.setPhase(name.PilotComponentName, phaseApplying)
+	p.setPhase(name.PilotComponentName, phaseReadiness)
+	p.setPending(name.PilotComponentName, []string{"Deployment/istio-system/istio-pilot (0 of 1 replicas updated)", "Service/istio-system/istio-pilot (not found)"})
+	*now = now.Add(65 * time.Second)
+	if buf.Len() != 0 {
+		t.Errorf("got output %q before refresh, want none", buf.String())
+	}
+	if _, err := p.Write([]byte("some message\n")); err != nil {
+		t.Fatal(err)
+	}
+	p.refresh()
+
+	want := `some message
+Still in progress:
+  - Pilot            waiting for readiness (1m5s), pending: Deployment/istio-system/istio-pilot (0 of 1 replicas updated) and 1 more
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestProgressLogTerminal(t *testing.T) {
+	p, buf, now := newTestProgressLog(t, true)
+
+	p.setPhase(name.IstioBaseComponentName, phaseApplying)
+	want := `- Base             applying (0s)
+  IngressGateways  waiting for Pilot
+  Pilot            waiting for Base
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	buf.Reset()
+	*now = now.Add(3 * time.Second)
+	if _, err := p.Write([]byte("message\n")); err != nil {
+		t.Fatal(err)
+	}
+	p.setPhase(name.IstioBaseComponentName, phaseFailed)
+	p.setPhase(name.PilotComponentName, phaseSkipped)
+	buf.Reset()
+	p.refresh()
+	want = "\x1b[3A\x1b[J" + `✘ Base             failed (3s)
+  IngressGateways  waiting for Pilot
+✘ Pilot            skipped
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/helm"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+const (
+	// kustomizationFilename is the name of the file kustomize reads in each directory.
+	kustomizationFilename = "kustomization.yaml"
+	// kustomizationHeader is written at the top of the top-level kustomization.
+	kustomizationHeader = `# Components are listed in dependency order. Build with "kustomize build --reorder none" to apply them in
+# this order; by default kustomize orders all resources by kind.
+`
+
+	// Helm hook annotations, see https://helm.sh/docs/topics/charts_hooks/.
+	helmHookAnnotation           = "helm.sh/hook"
+	helmHookWeightAnnotation     = "helm.sh/hook-weight"
+	helmResourcePolicyAnnotation = "helm.sh/resource-policy"
+	// helmHooks are the hooks namespaces and CRDs are installed with. They are also upgrade hooks, so that namespaces
+	// and CRDs added by a new version exist before the resources using them are upgraded.
+	helmHooks = "pre-install,pre-upgrade"
+	// helmHubValue and helmTagValue are the template expressions for the hub and tag values of the chart.
+	helmHubValue = "{{ .Values.hub }}"
+	helmTagValue = "{{ .Values.tag }}"
+
+	// ManifestIndexFilename is the name of the index file written by RenderToFlatDir. It does not have a YAML
+	// extension, so that tools applying all YAML files in the directory skip it.
+	ManifestIndexFilename = "manifest-index.txt"
+	// clusterScopedFilename is the file cluster-scoped objects are written to in the per-namespace layout. It sorts
+	// before all namespace files, as namespace names cannot contain underscores.
+	clusterScopedFilename = "_cluster.yaml"
+)
+
+// FlatLayout is a layout of the files written by RenderToFlatDir.
+type FlatLayout int
+
+const (
+	// PerResourceLayout writes each object to its own file named <order>-<kind>-<namespace>-<name>.yaml.
+	PerResourceLayout FlatLayout = iota
+	// PerNamespaceLayout writes the objects in each namespace to a file named <namespace>.yaml, and cluster-scoped
+	// objects to _cluster.yaml.
+	PerNamespaceLayout
+)
+
+var (
+	// helmHookWeights are the hook weights of the kinds which are installed before the rest of the chart, in the same
+	// order ApplyManifest applies them.
+	helmHookWeights = map[string]string{
+		"Namespace":                "-2",
+		"CustomResourceDefinition": "-1",
+	}
+)
+
+// HelmChartOptions describes the Helm chart written by RenderToHelmChart.
+type HelmChartOptions struct {
+	// Name is the name of the chart, which is also the name of the chart directory.
+	Name string
+	// Version is the version of the chart.
+	Version string
+	// Hub and Tag are the default values of the hub and tag chart values. Images from Hub with tag Tag in the
+	// manifests are rendered from the chart values instead.
+	Hub string
+	Tag string
+}
+
+// helmChart is the Chart.yaml written by RenderToHelmChart.
+type helmChart struct {
+	APIVersion  string `json:"apiVersion"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description"`
+}
+
+// kustomization is the subset of a kustomize Kustomization written by RenderToKustomize.
+type kustomization struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Resources  []string `json:"resources,omitempty"`
+}
+
+// RenderToKustomize writes manifests to outputDir as kustomize bases. Each component is written to its own
+// directory, holding the component manifest and a kustomization.yaml listing it. The kustomization.yaml in outputDir
+// composes all components, listing each component after the components it depends on. Components with empty
+// manifests are not written.
+func RenderToKustomize(manifests name.ManifestMap, outputDir string, dryRun bool) error {
+	g, err := dag.ComponentGraph(componentNames(manifests))
+	if err != nil {
+		return err
+	}
+	logAndPrint("Component dependencies tree: \n%s", g)
+	logAndPrint("Rendering kustomize bases to output dir %s", outputDir)
+	var components []string
+	for _, c := range g.Sorted() {
+		ym := strings.TrimSpace(strings.Join(manifests[c], helm.YAMLSeparator))
+		if ym == "" {
+			continue
+		}
+		componentName := string(c)
+		components = append(components, componentName)
+		dirName := filepath.Join(outputDir, componentName)
+		logAndPrint("Rendering: %s to %s", componentName, dirName)
+		if dryRun {
+			continue
+		}
+		if err := os.MkdirAll(dirName, os.ModePerm); err != nil {
+			return fmt.Errorf("could not create directory %s; %s", dirName, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dirName, componentName+".yaml"), []byte(ym+"\n"), 0644); err != nil {
+			return fmt.Errorf("could not write manifest config; %s", err)
+		}
+		if err := writeKustomization(dirName, "", componentName+".yaml"); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	return writeKustomization(outputDir, kustomizationHeader, components...)
+}
+
+// writeKustomization writes a kustomization.yaml listing resources to dir, preceded by header.
+func writeKustomization(dir, header string, resources ...string) error {
+	y, err := yaml.Marshal(&kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, kustomizationFilename), append([]byte(header), y...), 0644); err != nil {
+		return fmt.Errorf("could not write kustomization; %s", err)
+	}
+	return nil
+}
+
+// RenderToHelmChart writes manifests to outputDir as a Helm chart named opts.Name. Each component is written as a
+// template, with the images from opts.Hub and opts.Tag rendered from the hub and tag chart values, and all other
+// template delimiters escaped. Namespaces and CRDs are pre-install and pre-upgrade hooks, so that Helm applies them
+// before the rest of the chart, namespaces first, both on install and upgrade. Like CRDs in the crds directory of a
+// chart, they are kept when the release is deleted.
+func RenderToHelmChart(manifests name.ManifestMap, outputDir string, opts *HelmChartOptions, dryRun bool) error {
+	if opts.Name == "" || strings.ContainsAny(opts.Name, `/\`) {
+		return fmt.Errorf("invalid chart name %q", opts.Name)
+	}
+	g, err := dag.ComponentGraph(componentNames(manifests))
+	if err != nil {
+		return err
+	}
+	chartDir := filepath.Join(outputDir, opts.Name)
+	logAndPrint("Rendering Helm chart %s version %s to %s", opts.Name, opts.Version, chartDir)
+	templates := make(map[string]string)
+	for _, c := range g.Sorted() {
+		ym, err := helmTemplate(strings.Join(manifests[c], helm.YAMLSeparator), opts.Hub, opts.Tag)
+		if err != nil {
+			return fmt.Errorf("could not render template for component %s: %s", c, err)
+		}
+		if ym != "" {
+			logAndPrint("Rendering: %s", c)
+			templates[string(c)+".yaml"] = ym
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(chartDir, "templates"), os.ModePerm); err != nil {
+		return fmt.Errorf("could not create directory %s; %s", chartDir, err)
+	}
+	chart, err := yaml.Marshal(&helmChart{
+		APIVersion:  "v1",
+		Name:        opts.Name,
+		Version:     opts.Version,
+		AppVersion:  opts.Tag,
+		Description: "Istio installation rendered by the Istio operator",
+	})
+	if err != nil {
+		return err
+	}
+	values, err := yaml.Marshal(map[string]string{"hub": opts.Hub, "tag": opts.Tag})
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"Chart.yaml":  chart,
+		"values.yaml": values,
+	}
+	for fname, ym := range templates {
+		files[filepath.Join("templates", fname)] = []byte(ym)
+	}
+	for fname, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(chartDir, fname), b, 0644); err != nil {
+			return fmt.Errorf("could not write chart file %s; %s", fname, err)
+		}
+	}
+	return nil
+}
+
+// removeIndexedFiles removes the files listed in the manifest index in dir, if there is one.
+func removeIndexedFiles(dir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ManifestIndexFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read manifest index; %s", err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// Only files directly in dir are ever listed.
+		fname := filepath.Base(fields[1])
+		if err := os.Remove(filepath.Join(dir, fname)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove %s; %s", fname, err)
+		}
+	}
+	return nil
+}
+
+// helmTemplate returns manifest as a Helm template. Namespaces and CRDs are annotated as hooks, existing template
+// delimiters are escaped and images from hub with tag are rendered from the chart values.
+func helmTemplate(manifest, hub, tag string) (string, error) {
+	objs, err := object.ParseK8sObjectsFromYAMLManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+	for i, o := range objs {
+		weight, ok := helmHookWeights[o.Kind]
+		if !ok {
+			continue
+		}
+		u := o.UnstructuredObject().DeepCopy()
+		annotations := u.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[helmHookAnnotation] = helmHooks
+		annotations[helmHookWeightAnnotation] = weight
+		annotations[helmResourcePolicyAnnotation] = "keep"
+		u.SetAnnotations(annotations)
+		objs[i] = object.NewK8sObject(u, nil, nil)
+	}
+	if len(objs) == 0 {
+		return "", nil
+	}
+	ym, err := objs.YAMLManifest()
+	if err != nil {
+		return "", err
+	}
+	ym = strings.Replace(ym, "{{", `{{ "{{" }}`, -1)
+	if hub != "" && tag != "" {
+		image := regexp.MustCompile(regexp.QuoteMeta(hub) + `/([\w.-]+):` + regexp.QuoteMeta(tag) + `([^\w.-]|$)`)
+		ym = image.ReplaceAllString(ym, helmHubValue+"/${1}:"+helmTagValue+"${2}")
+	}
+	return ym, nil
+}
+
+// RenderToFlatDir writes manifests to files directly in outputDir using layout. Objects are sorted in the order they
+// would be applied: components in dependency order and, within each component, namespaces, then CRDs, then the
+// remaining objects in the order ApplyManifest applies them. A manifest index listing every object with the SHA-256
+// hash of its YAML is written to ManifestIndexFilename. The files listed in an existing index are removed first, so
+// that the files of objects which are no longer rendered are not left behind.
+func RenderToFlatDir(manifests name.ManifestMap, outputDir string, layout FlatLayout, dryRun bool) error {
+	g, err := dag.ComponentGraph(componentNames(manifests))
+	if err != nil {
+		return err
+	}
+	logAndPrint("Rendering manifests to output dir %s", outputDir)
+	var objs object.K8sObjects
+	components := make(map[*object.K8sObject]name.ComponentName)
+	order := make(map[name.ComponentName]int)
+	for i, c := range g.Sorted() {
+		order[c] = i
+		cobjs, err := object.ParseK8sObjectsFromYAMLManifest(strings.Join(manifests[c], helm.YAMLSeparator))
+		if err != nil {
+			return fmt.Errorf("could not parse manifest for component %s: %s", c, err)
+		}
+		for _, o := range cobjs {
+			components[o] = c
+		}
+		objs = append(objs, cobjs...)
+	}
+	objectOrder := defaultObjectOrder()
+	objs.Sort(func(o *object.K8sObject) int {
+		score := objectOrder(o)
+		if o.Kind == "Namespace" {
+			score = -2000
+		}
+		return order[components[o]]*100000 + score
+	})
+
+	files := make(map[string]string)
+	var fileOrder []string
+	var index strings.Builder
+	index.WriteString("# sha256 file component object\n")
+	width := len(fmt.Sprint(len(objs)))
+	if width < 3 {
+		width = 3
+	}
+	for i, o := range objs {
+		y, err := o.YAML()
+		if err != nil {
+			return err
+		}
+		var fname string
+		switch layout {
+		case PerNamespaceLayout:
+			fname = clusterScopedFilename
+			if o.Namespace != "" {
+				fname = o.Namespace + ".yaml"
+			}
+		default:
+			parts := []string{fmt.Sprintf("%0*d", width, i+1), strings.ToLower(o.Kind), o.Namespace, o.Name}
+			if o.Namespace == "" {
+				parts = append(parts[:2], o.Name)
+			}
+			fname = strings.Join(parts, "-") + ".yaml"
+		}
+		if _, ok := files[fname]; !ok {
+			fileOrder = append(fileOrder, fname)
+		} else {
+			files[fname] += helm.YAMLSeparator
+		}
+		files[fname] += string(y)
+		fmt.Fprintf(&index, "%x %s %s %s\n", sha256.Sum256(y), fname, components[o], objectRef(o))
+	}
+
+	for _, fname := range fileOrder {
+		logAndPrint("Writing manifest to %s", filepath.Join(outputDir, fname))
+	}
+	if dryRun {
+		return nil
+	}
+	if err := removeIndexedFiles(outputDir); err != nil {
+		return err
+	}
+	for _, fname := range fileOrder {
+		if err := ioutil.WriteFile(filepath.Join(outputDir, fname), []byte(files[fname]), 0644); err != nil {
+			return fmt.Errorf("could not write manifest config; %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(outputDir, ManifestIndexFilename), []byte(index.String()), 0644); err != nil {
+		return fmt.Errorf("could not write manifest index; %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+func readKustomization(t *testing.T, dir string) *kustomization {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(dir, kustomizationFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &kustomization{}
+	if err := yaml.Unmarshal(b, k); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRenderToKustomize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kustomize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifests := name.ManifestMap{
+		name.IngressComponentName:   {"kind: Deployment\nmetadata:\n  name: ingress-1\n", "kind: Deployment\nmetadata:\n  name: ingress-2\n"},
+		name.PilotComponentName:     {"kind: Deployment\nmetadata:\n  name: pilot\n"},
+		name.IstioBaseComponentName: {"kind: Namespace\nmetadata:\n  name: istio-system\n"},
+		name.CNIComponentName:       {""},
+	}
+	if err := RenderToKustomize(manifests, dir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	top := readKustomization(t, dir)
+	want := []string{"Base", "Pilot", "IngressGateways"}
+	if !reflect.DeepEqual(top.Resources, want) {
+		t.Errorf("got top-level resources %v, want %v", top.Resources, want)
+	}
+	for _, c := range want {
+		k := readKustomization(t, filepath.Join(dir, c))
+		if !reflect.DeepEqual(k.Resources, []string{c + ".yaml"}) || k.Kind != "Kustomization" {
+			t.Errorf("%s: got kustomization %v", c, k)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "IngressGateways", "IngressGateways.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := object.ParseK8sObjectsFromYAMLManifest(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Errorf("got %d gateway objects, want 2", len(objs))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Cni")); !os.IsNotExist(err) {
+		t.Errorf("got %v, want empty component to not be written", err)
+	}
+}
+
+func TestRenderToHelmChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-chart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pilot := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: docker.io/istio/pilot:1.5.0
+      - name: proxy
+        image: docker.io/istio/proxyv2:1.5.0-custom
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: injector
+  namespace: istio-system
+data:
+  config: '{{ .Values.global.proxy.image }}'
+`
+	base := `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: istio-system
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.istio.io
+spec:
+  group: example.istio.io
+`
+	manifests := name.ManifestMap{
+		name.IstioBaseComponentName: {base},
+		name.PilotComponentName:     {pilot},
+	}
+	opts := &HelmChartOptions{Name: "istio", Version: "1.5.0", Hub: "docker.io/istio", Tag: "1.5.0"}
+	if err := RenderToHelmChart(manifests, dir, opts, false); err != nil {
+		t.Fatal(err)
+	}
+	chartDir := filepath.Join(dir, "istio")
+
+	b, err := ioutil.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chart := &helmChart{}
+	if err := yaml.Unmarshal(b, chart); err != nil {
+		t.Fatal(err)
+	}
+	if chart.Name != "istio" || chart.Version != "1.5.0" || chart.AppVersion != "1.5.0" {
+		t.Errorf("got Chart.yaml %v", chart)
+	}
+
+	// Render the templates with a different hub, the way Helm would.
+	render := func(fname string) object.K8sObjects {
+		t.Helper()
+		b, err := ioutil.ReadFile(filepath.Join(chartDir, "templates", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := template.New(fname).Parse(string(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		values := map[string]interface{}{"Values": map[string]string{"hub": "gcr.io/mirror", "tag": "1.5.1"}}
+		if err := tmpl.Execute(&out, values); err != nil {
+			t.Fatal(err)
+		}
+		objs, err := object.ParseK8sObjectsFromYAMLManifest(out.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return objs
+	}
+
+	objs := render("Pilot.yaml")
+	y, err := objs.YAMLManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"image: gcr.io/mirror/pilot:1.5.1",
+		// Images with another tag are not templated.
+		"image: docker.io/istio/proxyv2:1.5.0-custom",
+		// Existing template delimiters are kept.
+		"config: '{{ .Values.global.proxy.image }}'",
+	} {
+		if !strings.Contains(y, want) {
+			t.Errorf("rendered Pilot template does not contain %q:\n%s", want, y)
+		}
+	}
+
+	weights := make(map[string]int)
+	for _, o := range render("Base.yaml") {
+		a := o.UnstructuredObject().GetAnnotations()
+		if !isHelmHook(a, "pre-install") || a[helmResourcePolicyAnnotation] != "keep" {
+			t.Errorf("%s is not a pre-install hook kept on delete: %v", o.Kind, a)
+		}
+		if weights[o.Kind], err = strconv.Atoi(a[helmHookWeightAnnotation]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if weights["Namespace"] >= weights["CustomResourceDefinition"] {
+		t.Errorf("Namespace hook weight %d is not lower than CRD hook weight %d", weights["Namespace"],
+			weights["CustomResourceDefinition"])
+	}
+	for _, o := range objs {
+		if _, ok := o.UnstructuredObject().GetAnnotations()[helmHookAnnotation]; ok {
+			t.Errorf("%s should not be a hook", o.Kind)
+		}
+	}
+
+	// A CRD added by the upgraded chart is applied by Helm before the resources using it are upgraded.
+	manifests[name.IstioBaseComponentName] = []string{base + `
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: gadgets.example.istio.io
+spec:
+  group: example.istio.io
+`}
+	opts.Version, opts.Tag = "1.5.1", "1.5.1"
+	if err := RenderToHelmChart(manifests, dir, opts, false); err != nil {
+		t.Fatal(err)
+	}
+	upgraded := render("Base.yaml")
+	if len(upgraded) != 3 {
+		t.Fatalf("got %d objects in upgraded Base template, want 3", len(upgraded))
+	}
+	for _, o := range upgraded {
+		a := o.UnstructuredObject().GetAnnotations()
+		if !isHelmHook(a, "pre-upgrade") || a[helmResourcePolicyAnnotation] != "keep" {
+			t.Errorf("%s %s is not a pre-upgrade hook kept on delete: %v", o.Kind, o.Name, a)
+		}
+	}
+
+	if err := RenderToHelmChart(manifests, dir, &HelmChartOptions{Name: "a/b"}, true); err == nil {
+		t.Error("expected error for invalid chart name")
+	}
+}
+
+// isHelmHook reports whether annotations make an object a Helm hook of the given type.
+func isHelmHook(annotations map[string]string, hook string) bool {
+	for _, h := range strings.Split(annotations[helmHookAnnotation], ",") {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRenderToFlatDir(t *testing.T) {
+	manifests := name.ManifestMap{
+		name.IngressComponentName: {`
+apiVersion: v1
+kind: Service
+metadata:
+  name: ingressgateway
+  namespace: istio-system
+`, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: ingressgateway
+  namespace: istio-ingress
+`},
+		name.PilotComponentName: {`
+apiVersion: v1
+kind: Service
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+`},
+		name.IstioBaseComponentName: {`
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.istio.io
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: istio-system
+`},
+	}
+	tests := []struct {
+		desc   string
+		layout FlatLayout
+		want   []string
+	}{
+		{
+			desc:   "per resource",
+			layout: PerResourceLayout,
+			want: []string{
+				"001-namespace-istio-system.yaml",
+				"002-customresourcedefinition-widgets.example.istio.io.yaml",
+				"003-deployment-istio-system-istio-pilot.yaml",
+				"004-service-istio-system-istio-pilot.yaml",
+				"005-service-istio-ingress-ingressgateway.yaml",
+				"006-service-istio-system-ingressgateway.yaml",
+			},
+		},
+		{
+			desc:   "per namespace",
+			layout: PerNamespaceLayout,
+			want: []string{
+				"_cluster.yaml",
+				"_cluster.yaml",
+				"istio-system.yaml",
+				"istio-system.yaml",
+				"istio-ingress.yaml",
+				"istio-system.yaml",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "flat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := RenderToFlatDir(manifests, dir, tt.layout, false); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, ManifestIndexFilename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(b)), "\n")[1:]
+			var got []string
+			for _, l := range lines {
+				fields := strings.Fields(l)
+				if len(fields) != 4 {
+					t.Fatalf("malformed index line %q", l)
+				}
+				got = append(got, fields[1])
+				content, err := ioutil.ReadFile(filepath.Join(dir, fields[1]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.layout == PerResourceLayout && fmt.Sprintf("%x", sha256.Sum256(content)) != fields[0] {
+					t.Errorf("%s: index hash %s does not match file content", fields[1], fields[0])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got files %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderToFlatDirRerender(t *testing.T) {
+	pilot := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+`
+	ingress := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: ingressgateway
+  namespace: istio-ingress
+`
+	dir, err := ioutil.TempDir("", "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Files which were not rendered are kept.
+	if err := ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, manifests := range []name.ManifestMap{
+		{name.PilotComponentName: {pilot}, name.IngressComponentName: {ingress}},
+		{name.PilotComponentName: {pilot}},
+	} {
+		if err := RenderToFlatDir(manifests, dir, PerResourceLayout, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range infos {
+		got = append(got, fi.Name())
+	}
+	want := []string{"001-service-istio-system-istio-pilot.yaml", "kustomization.yaml", ManifestIndexFilename}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v after rendering again, want %v", got, want)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+// ApplyStatus is the outcome of applying a component, or all components.
+type ApplyStatus string
+
+const (
+	// ApplySucceeded means all objects were applied without errors.
+	ApplySucceeded ApplyStatus = "Succeeded"
+	// ApplyFailed means at least one error occurred.
+	ApplyFailed ApplyStatus = "Failed"
+	// ApplySkipped means the component is disabled and there was nothing to prune, or that it was not applied because
+	// a dependency failed.
+	ApplySkipped ApplyStatus = "Skipped"
+)
+
+const (
+	// ReportFormatJSON is the JSON apply report format.
+	ReportFormatJSON = "json"
+	// ReportFormatYAML is the YAML apply report format.
+	ReportFormatYAML = "yaml"
+)
+
+// ApplyReport is a machine readable summary of applying manifests to a cluster.
+type ApplyReport struct {
+	// Status is ApplyFailed if any component failed, ApplySucceeded otherwise.
+	Status ApplyStatus `json:"status"`
+	// Duration is the total time taken by the apply.
+	Duration string `json:"duration"`
+	// Components holds the report for each component, ordered by component name.
+	Components []*ComponentReport `json:"components"`
+}
+
+// ComponentReport is the apply report for a single component.
+type ComponentReport struct {
+	// Component is the name of the component.
+	Component name.ComponentName `json:"component"`
+	// Status is the outcome of applying the component.
+	Status ApplyStatus `json:"status"`
+	// SkippedAsDisabled is set if the component is disabled in the spec and there was nothing to prune.
+	SkippedAsDisabled bool `json:"skippedAsDisabled"`
+	// Duration is the time taken to apply the component.
+	Duration string `json:"duration"`
+	// Created lists the objects which were created.
+	Created []ObjectReference `json:"created,omitempty"`
+	// Updated lists the objects which were changed.
+	Updated []ObjectReference `json:"updated,omitempty"`
+	// Unchanged lists the objects which were applied without changes.
+	Unchanged []ObjectReference `json:"unchanged,omitempty"`
+	// Pruned lists the objects which were deleted.
+	Pruned []ObjectReference `json:"pruned,omitempty"`
+	// Errors lists the errors which occurred while applying the component.
+	Errors []ReportError `json:"errors,omitempty"`
+}
+
+// ObjectReference identifies a K8s object in a report.
+type ObjectReference struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Retries is the number of times a request for the object was retried after a transient error.
+	Retries int `json:"retries,omitempty"`
+}
+
+// ReportError is an error in a report, with a reference to the object it applies to, if any.
+type ReportError struct {
+	Object  *ObjectReference `json:"object,omitempty"`
+	Message string           `json:"message"`
+}
+
+// NewApplyReport creates an ApplyReport from the output of ApplyAll or ApplyManifest. iops is used to determine
+// which components are disabled; if it is nil, all components are assumed to be enabled.
+func NewApplyReport(out CompositeOutput, iops *v1alpha1.IstioOperatorSpec, duration time.Duration) *ApplyReport {
+	report := &ApplyReport{
+		Status:   ApplySucceeded,
+		Duration: formatDuration(duration),
+	}
+	for cn, o := range out {
+		cr := newComponentReport(cn, o)
+		if iops != nil && cr.Status == ApplySucceeded && o.Manifest == "" {
+			enabled, err := name.IsComponentEnabledInSpec(cn, iops)
+			if err == nil && !enabled {
+				cr.Status = ApplySkipped
+				cr.SkippedAsDisabled = true
+			}
+		}
+		if cr.Status == ApplyFailed {
+			report.Status = ApplyFailed
+		}
+		report.Components = append(report.Components, cr)
+	}
+	sort.Slice(report.Components, func(i, j int) bool {
+		return report.Components[i].Component < report.Components[j].Component
+	})
+	return report
+}
+
+// Component returns the report for the given component, or nil if the report does not contain it.
+func (r *ApplyReport) Component(cn name.ComponentName) *ComponentReport {
+	for _, c := range r.Components {
+		if c.Component == cn {
+			return c
+		}
+	}
+	return nil
+}
+
+// Marshal returns the report in the given format, which must be ReportFormatJSON or ReportFormatYAML.
+func (r *ApplyReport) Marshal(format string) ([]byte, error) {
+	return marshalReport(r, format)
+}
+
+// MultiClusterApplyReport is a machine readable summary of applying manifests to several clusters.
+type MultiClusterApplyReport struct {
+	// Status is ApplyFailed if any cluster failed, ApplySucceeded otherwise.
+	Status ApplyStatus `json:"status"`
+	// Duration is the total time taken by the apply.
+	Duration string `json:"duration"`
+	// Clusters holds the report for each cluster, in the order the clusters were given.
+	Clusters []*ClusterApplyReport `json:"clusters"`
+}
+
+// ClusterApplyReport is the apply report for a single cluster.
+type ClusterApplyReport struct {
+	// Context is the kubeconfig context of the cluster.
+	Context string `json:"context"`
+	// Primary is set for the primary cluster, which is applied before the remote clusters.
+	Primary bool `json:"primary,omitempty"`
+	// Status is the outcome of applying to the cluster. It is ApplySkipped if the cluster was not applied because
+	// the primary cluster failed.
+	Status ApplyStatus `json:"status"`
+	// Duration is the time taken to apply to the cluster.
+	Duration string `json:"duration"`
+	// Error is the error which occurred while applying to the cluster, if any.
+	Error string `json:"error,omitempty"`
+	// Report is the apply report of the cluster. It is nil if the manifests could not be applied at all.
+	Report *ApplyReport `json:"report,omitempty"`
+}
+
+// Cluster returns the report for the cluster with the given context, or nil if the report does not contain it.
+func (r *MultiClusterApplyReport) Cluster(context string) *ClusterApplyReport {
+	for _, c := range r.Clusters {
+		if c.Context == context {
+			return c
+		}
+	}
+	return nil
+}
+
+// Marshal returns the report in the given format, which must be ReportFormatJSON or ReportFormatYAML.
+func (r *MultiClusterApplyReport) Marshal(format string) ([]byte, error) {
+	return marshalReport(r, format)
+}
+
+func marshalReport(r interface{}, format string) ([]byte, error) {
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ReportFormatJSON:
+		return j, nil
+	case ReportFormatYAML:
+		return yaml.JSONToYAML(j)
+	default:
+		return nil, fmt.Errorf("unknown report format %q, must be one of %s, %s", format, ReportFormatJSON, ReportFormatYAML)
+	}
+}
+
+func newComponentReport(cn name.ComponentName, o *ComponentApplyOutput) *ComponentReport {
+	cr := &ComponentReport{
+		Component: cn,
+		Status:    ApplySucceeded,
+		Duration:  formatDuration(o.Duration),
+	}
+	for _, r := range o.Objects {
+		ref := newObjectReference(r.Object)
+		ref.Retries = r.Retries
+		if r.Err != nil {
+			cr.Errors = append(cr.Errors, ReportError{Object: &ref, Message: r.Err.Error()})
+			continue
+		}
+		switch r.Action {
+		case ObjectCreated:
+			cr.Created = append(cr.Created, ref)
+		case ObjectUpdated:
+			cr.Updated = append(cr.Updated, ref)
+		case ObjectUnchanged:
+			cr.Unchanged = append(cr.Unchanged, ref)
+		case ObjectPruned:
+			cr.Pruned = append(cr.Pruned, ref)
+		}
+	}
+	// Errors which are not caused by a single object, e.g. timeouts waiting for CRDs, are only in Err.
+	if o.Err != nil && len(cr.Errors) == 0 {
+		cr.Errors = append(cr.Errors, ReportError{Message: o.Err.Error()})
+	}
+	switch {
+	case dag.IsSkipped(o.Err):
+		cr.Status = ApplySkipped
+	case len(cr.Errors) != 0:
+		cr.Status = ApplyFailed
+	}
+	return cr
+}
+
+func newObjectReference(o *object.K8sObject) ObjectReference {
+	return ObjectReference{
+		Group:     o.Group,
+		Kind:      o.Kind,
+		Namespace: o.Namespace,
+		Name:      o.Name,
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
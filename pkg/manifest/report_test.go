@@ -0,0 +1,116 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/dag"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object/objecttest"
+	"istio.io/operator/pkg/util"
+)
+
+func TestNewApplyReport(t *testing.T) {
+	svc := objecttest.NewK8sObject("Service", "istio-system", "istio-pilot")
+	dep := objecttest.NewK8sObject("Deployment", "istio-system", "istio-pilot")
+	cm := objecttest.NewK8sObject("ConfigMap", "istio-system", "istio")
+	iops := &v1alpha1.IstioOperatorSpec{
+		Components: &v1alpha1.IstioComponentSetSpec{
+			Policy: &v1alpha1.ComponentSpec{Enabled: &v1alpha1.BoolValueForPB{BoolValue: types.BoolValue{Value: false}}},
+		},
+	}
+	out := CompositeOutput{
+		name.PilotComponentName: {
+			Objects: []ObjectApplyResult{
+				{Object: svc, Action: ObjectCreated, Retries: 2},
+				{Object: dep, Err: fmt.Errorf("forbidden")},
+				{Object: cm, Action: ObjectPruned},
+			},
+			Err:      fmt.Errorf("Deployment/istio-system/istio-pilot: forbidden"),
+			Manifest: "kind: Service",
+			Duration: 1500 * time.Millisecond,
+		},
+		name.PolicyComponentName: {},
+		name.GalleyComponentName: {
+			Err: fmt.Errorf("failed to verify CRD creation"),
+		},
+		name.IngressComponentName: {
+			Err: &dag.SkippedError{Component: name.IngressComponentName, Dependency: name.PilotComponentName, Cause: fmt.Errorf("forbidden")},
+		},
+	}
+
+	got := NewApplyReport(out, iops, 2*time.Second)
+	if got.Status != ApplyFailed {
+		t.Errorf("report status: got %s, want %s", got.Status, ApplyFailed)
+	}
+	if got.Duration != "2s" {
+		t.Errorf("report duration: got %s, want 2s", got.Duration)
+	}
+	var gotOrder []name.ComponentName
+	for _, c := range got.Components {
+		gotOrder = append(gotOrder, c.Component)
+	}
+	if want := []name.ComponentName{name.GalleyComponentName, name.IngressComponentName,
+		name.PilotComponentName, name.PolicyComponentName}; !reflect.DeepEqual(gotOrder, want) {
+		t.Errorf("component order: got %v, want %v", gotOrder, want)
+	}
+
+	wantPilot := &ComponentReport{
+		Component: name.PilotComponentName,
+		Status:    ApplyFailed,
+		Duration:  "1.5s",
+		Created:   []ObjectReference{{Kind: "Service", Namespace: "istio-system", Name: "istio-pilot", Retries: 2}},
+		Pruned:    []ObjectReference{{Kind: "ConfigMap", Namespace: "istio-system", Name: "istio"}},
+		Errors: []ReportError{{
+			Object:  &ObjectReference{Kind: "Deployment", Namespace: "istio-system", Name: "istio-pilot"},
+			Message: "forbidden",
+		}},
+	}
+	if gotPilot := got.Component(name.PilotComponentName); !reflect.DeepEqual(gotPilot, wantPilot) {
+		t.Errorf("pilot report: got\n%s\nwant\n%s", util.ToYAML(gotPilot), util.ToYAML(wantPilot))
+	}
+
+	policy := got.Component(name.PolicyComponentName)
+	if policy.Status != ApplySkipped || !policy.SkippedAsDisabled {
+		t.Errorf("policy report: got status %s, skippedAsDisabled %v, want Skipped, true", policy.Status, policy.SkippedAsDisabled)
+	}
+
+	galley := got.Component(name.GalleyComponentName)
+	if want := []ReportError{{Message: "failed to verify CRD creation"}}; !reflect.DeepEqual(galley.Errors, want) {
+		t.Errorf("galley errors: got %v, want %v", galley.Errors, want)
+	}
+
+	ingress := got.Component(name.IngressComponentName)
+	if ingress.Status != ApplySkipped || ingress.SkippedAsDisabled || len(ingress.Errors) != 1 {
+		t.Errorf("ingress report: got status %s, skippedAsDisabled %v, errors %v, want Skipped with the skip reason",
+			ingress.Status, ingress.SkippedAsDisabled, ingress.Errors)
+	}
+
+	if _, err := got.Marshal("xml"); err == nil {
+		t.Errorf("Marshal: expected error for unknown format")
+	}
+	for _, f := range []string{ReportFormatJSON, ReportFormatYAML} {
+		if _, err := got.Marshal(f); err != nil {
+			t.Errorf("Marshal(%s): %v", f, err)
+		}
+	}
+}
@@ -27,6 +27,11 @@ const (
 	// OperatorAPINamespace is the API namespace for operator config.
 	// TODO: move this to a base definitions file when one is created.
 	OperatorAPINamespace = "operator.istio.io"
+
+	// RevisionLabel is the label holding the control plane revision of an object, set in
+	// IstioOperatorSpec.ResourceSuffix. Namespaces with this label are injected by the sidecar injector of that
+	// revision.
+	RevisionLabel = "istio.io/rev"
 )
 
 // ComponentName is a component name string, typed to constrain allowed values.
@@ -72,6 +77,26 @@ var (
 		CNIComponentName,
 	}
 	allComponentNamesMap = make(map[ComponentName]bool)
+
+	// ComponentDependencies maps each component to the components which must be installed before it. Components
+	// which are not listed depend on the base component.
+	ComponentDependencies = map[ComponentName][]ComponentName{
+		IstioBaseComponentName:       nil,
+		PilotComponentName:           {IstioBaseComponentName},
+		GalleyComponentName:          {IstioBaseComponentName},
+		SidecarInjectorComponentName: {IstioBaseComponentName},
+		PolicyComponentName:          {IstioBaseComponentName},
+		TelemetryComponentName:       {IstioBaseComponentName},
+		CitadelComponentName:         {IstioBaseComponentName},
+		CertManagerComponentName:     {IstioBaseComponentName},
+		NodeAgentComponentName:       {IstioBaseComponentName},
+		CNIComponentName:             {IstioBaseComponentName},
+		// Gateways need Pilot to serve their configuration.
+		IngressComponentName: {PilotComponentName},
+		EgressComponentName:  {PilotComponentName},
+		// Addons scrape and visualize telemetry.
+		AddonComponentName: {TelemetryComponentName},
+	}
 )
 
 func init() {
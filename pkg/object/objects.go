@@ -315,7 +315,7 @@ func (os K8sObjects) YAMLManifest() (string, error) {
 	return b.String(), nil
 }
 
-// Sort will order the items in K8sObjects in order of score, group, kind, name.  The intent is to
+// Sort will order the items in K8sObjects in order of score, group, kind, namespace, name.  The intent is to
 // have a deterministic ordering in which K8sObjects are applied.
 func (os K8sObjects) Sort(score func(o *K8sObject) int) {
 	sort.Slice(os, func(i, j int) bool {
@@ -330,6 +330,11 @@ func (os K8sObjects) Sort(score func(o *K8sObject) int) {
 			(iScore == jScore &&
 				os[i].Group == os[j].Group &&
 				os[i].Kind == os[j].Kind &&
+				os[i].Namespace < os[j].Namespace) ||
+			(iScore == jScore &&
+				os[i].Group == os[j].Group &&
+				os[i].Kind == os[j].Kind &&
+				os[i].Namespace == os[j].Namespace &&
 				os[i].Name < os[j].Name)
 	})
 }
@@ -502,3 +502,27 @@ spec:
 		})
 	}
 }
+
+func TestK8sObjectsSort(t *testing.T) {
+	objs := K8sObjects{
+		{Kind: "Service", Namespace: "b", Name: "a"},
+		{Kind: "Service", Namespace: "a", Name: "b"},
+		{Kind: "Service", Namespace: "a", Name: "a"},
+		{Kind: "Deployment", Namespace: "b", Name: "a"},
+		{Group: "apps", Kind: "Deployment", Namespace: "a", Name: "a"},
+	}
+	objs.Sort(func(o *K8sObject) int {
+		if o.Kind == "Service" {
+			return 0
+		}
+		return 1
+	})
+	var got []string
+	for _, o := range objs {
+		got = append(got, o.Hash())
+	}
+	want := []string{"Service:a:a", "Service:a:b", "Service:b:a", "Deployment:b:a", "Deployment:a:a"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+}
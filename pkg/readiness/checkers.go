@@ -0,0 +1,258 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"fmt"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	for _, group := range []string{"apps", "extensions"} {
+		Register(schema.GroupKind{Group: group, Kind: "Deployment"}, deploymentReady)
+		Register(schema.GroupKind{Group: group, Kind: "DaemonSet"}, daemonSetReady)
+		Register(schema.GroupKind{Group: group, Kind: "StatefulSet"}, statefulSetReady)
+	}
+	Register(schema.GroupKind{Group: "batch", Kind: "Job"}, jobReady)
+	Register(schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}, crdReady)
+	Register(schema.GroupKind{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}, webhookReady)
+	Register(schema.GroupKind{Kind: "Service"}, serviceReady)
+	Register(schema.GroupKind{Kind: "Namespace"}, namespaceReady)
+	Register(schema.GroupKind{Kind: "Pod"}, podReady)
+}
+
+// deploymentReady follows the logic of kubectl rollout status: the latest spec must be observed, all replicas updated
+// and available, and no old replicas left.
+func deploymentReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	d := &appsv1.Deployment{}
+	if err := fromUnstructured(u, d); err != nil {
+		return false, "", err
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, "waiting for the latest spec to be observed", nil
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return false, "", fmt.Errorf("rollout exceeded its progress deadline")
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return false, fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas), nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas), nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas), nil
+	}
+	return true, "", nil
+}
+
+// daemonSetReady requires every scheduled pod to be updated and ready.
+func daemonSetReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	ds := &appsv1.DaemonSet{}
+	if err := fromUnstructured(u, ds); err != nil {
+		return false, "", err
+	}
+	if ds.Generation > ds.Status.ObservedGeneration {
+		return false, "waiting for the latest spec to be observed", nil
+	}
+	desired := ds.Status.DesiredNumberScheduled
+	switch {
+	case ds.Spec.UpdateStrategy.Type != appsv1.OnDeleteDaemonSetStrategyType && ds.Status.UpdatedNumberScheduled < desired:
+		return false, fmt.Sprintf("%d of %d pods updated", ds.Status.UpdatedNumberScheduled, desired), nil
+	case ds.Status.NumberReady < desired:
+		return false, fmt.Sprintf("%d of %d pods ready", ds.Status.NumberReady, desired), nil
+	}
+	return true, "", nil
+}
+
+// statefulSetReady requires all replicas to be ready and, for rolling updates, all replicas above the partition to be
+// updated.
+func statefulSetReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	sts := &appsv1.StatefulSet{}
+	if err := fromUnstructured(u, sts); err != nil {
+		return false, "", err
+	}
+	if sts.Generation > sts.Status.ObservedGeneration {
+		return false, "waiting for the latest spec to be observed", nil
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d replicas ready", sts.Status.ReadyReplicas, replicas), nil
+	}
+	if sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true, "", nil
+	}
+	if ru := sts.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil && *ru.Partition > 0 {
+		if want := replicas - *ru.Partition; sts.Status.UpdatedReplicas < want {
+			return false, fmt.Sprintf("%d of %d replicas above partition updated", sts.Status.UpdatedReplicas, want), nil
+		}
+		return true, "", nil
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		return false, fmt.Sprintf("%d of %d replicas updated", sts.Status.UpdatedReplicas, replicas), nil
+	}
+	return true, "", nil
+}
+
+// jobReady requires the Job to have completed. A failed Job can never become ready.
+func jobReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	job := &batchv1.Job{}
+	if err := fromUnstructured(u, job); err != nil {
+		return false, "", err
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Status != v1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, "", nil
+		case batchv1.JobFailed:
+			return false, "", fmt.Errorf("job failed: %s", c.Message)
+		}
+	}
+	return false, fmt.Sprintf("%d pods succeeded, %d active", job.Status.Succeeded, job.Status.Active), nil
+}
+
+// crdReady requires the CRD to be established, so that instances of it can be created.
+func crdReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	crd := &apiextensionsv1beta1.CustomResourceDefinition{}
+	if err := fromUnstructured(u, crd); err != nil {
+		return false, "", err
+	}
+	reason := "not established"
+	for _, c := range crd.Status.Conditions {
+		switch c.Type {
+		case apiextensionsv1beta1.Established:
+			if c.Status == apiextensionsv1beta1.ConditionTrue {
+				return true, "", nil
+			}
+		case apiextensionsv1beta1.NamesAccepted:
+			if c.Status == apiextensionsv1beta1.ConditionFalse {
+				reason = fmt.Sprintf("name conflict: %s", c.Reason)
+			}
+		}
+	}
+	return false, reason, nil
+}
+
+// webhookReady requires the Service of each webhook to have at least one ready endpoint, otherwise requests for
+// resources matching the webhook would be rejected or ignored.
+func webhookReady(g Getter, u *unstructured.Unstructured) (bool, string, error) {
+	mwc := &admissionregistrationv1beta1.MutatingWebhookConfiguration{}
+	if err := fromUnstructured(u, mwc); err != nil {
+		return false, "", err
+	}
+	for _, wh := range mwc.Webhooks {
+		svc := wh.ClientConfig.Service
+		if svc == nil {
+			continue
+		}
+		ready, err := hasReadyEndpoints(g, svc.Namespace, svc.Name)
+		if err != nil {
+			return false, "", err
+		}
+		if !ready {
+			return false, fmt.Sprintf("webhook %s has no ready endpoints in service %s/%s", wh.Name, svc.Namespace, svc.Name), nil
+		}
+	}
+	return true, "", nil
+}
+
+// serviceReady requires a cluster IP, unless the Service is headless or external, and ingress for LoadBalancer
+// Services.
+func serviceReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	svc := &v1.Service{}
+	if err := fromUnstructured(u, svc); err != nil {
+		return false, "", err
+	}
+	switch {
+	case svc.Spec.Type == v1.ServiceTypeExternalName:
+		return true, "", nil
+	case svc.Spec.ClusterIP != v1.ClusterIPNone && svc.Spec.ClusterIP == "":
+		return false, "no cluster IP assigned", nil
+	case svc.Spec.Type == v1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0:
+		return false, "no load balancer ingress assigned", nil
+	}
+	return true, "", nil
+}
+
+func namespaceReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	ns := &v1.Namespace{}
+	if err := fromUnstructured(u, ns); err != nil {
+		return false, "", err
+	}
+	if ns.Status.Phase != v1.NamespaceActive {
+		return false, fmt.Sprintf("phase is %s", ns.Status.Phase), nil
+	}
+	return true, "", nil
+}
+
+func podReady(_ Getter, u *unstructured.Unstructured) (bool, string, error) {
+	pod := &v1.Pod{}
+	if err := fromUnstructured(u, pod); err != nil {
+		return false, "", err
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady && c.Status == v1.ConditionTrue {
+			return true, "", nil
+		}
+	}
+	return false, "pod not ready", nil
+}
+
+func hasReadyEndpoints(g Getter, namespace, name string) (bool, error) {
+	u, err := g.Get(v1.SchemeGroupVersion.WithKind("Endpoints"), namespace, name)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ep := &v1.Endpoints{}
+	if err := fromUnstructured(u, ep); err != nil {
+		return false, err
+	}
+	for _, s := range ep.Subsets {
+		if len(s.Addresses) != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func fromUnstructured(u *unstructured.Unstructured, obj interface{}) error {
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return fmt.Errorf("could not convert %s: %s", u.GetKind(), err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/name"
+)
+
+const (
+	// HealthGatesKey is the key in the free-form spec of a component which holds its health gates, e.g.
+	// components.sidecarInjector.spec.healthGates.
+	HealthGatesKey = "healthGates"
+
+	// httpGateTimeout is the timeout of the request of an HTTP health gate.
+	httpGateTimeout = 5 * time.Second
+)
+
+var httpGateClient = &http.Client{Timeout: httpGateTimeout}
+
+// HealthGate is a check which must pass, in addition to the readiness of the resources of a component, before the
+// component is considered healthy after an upgrade. Exactly one of Resource and HTTPGet is set.
+type HealthGate struct {
+	// Resource is an object which must be ready, e.g. the MutatingWebhookConfiguration of the sidecar injector, which
+	// is ready once its Service has ready endpoints.
+	Resource *ObjectRef `json:"resource,omitempty"`
+	// HTTPGet is a URL which must respond with a 2xx status, e.g. the readiness endpoint of pilot.
+	HTTPGet string `json:"httpGet,omitempty"`
+}
+
+// ObjectRef refers to a K8s object.
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// String implements fmt.Stringer.
+func (g HealthGate) String() string {
+	if g.Resource != nil {
+		return objectRef(g.Resource.unstructured())
+	}
+	return "GET " + g.HTTPGet
+}
+
+// Check reports whether the gate passes. If it does not, reason describes why. An error is returned if it can never
+// pass, e.g. a failed Job.
+func (g HealthGate) Check(getter Getter) (bool, string, error) {
+	if g.Resource != nil {
+		return Check(getter, g.Resource.unstructured())
+	}
+	resp, err := httpGateClient.Get(g.HTTPGet)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Sprintf("status %s", resp.Status), nil
+	}
+	return true, "", nil
+}
+
+func (g HealthGate) validate() error {
+	switch {
+	case g.Resource != nil && g.HTTPGet != "":
+		return fmt.Errorf("health gate sets both resource and httpGet")
+	case g.Resource != nil:
+		r := g.Resource
+		if r.APIVersion == "" || r.Kind == "" || r.Name == "" {
+			return fmt.Errorf("health gate resource needs apiVersion, kind and name")
+		}
+		if _, err := schema.ParseGroupVersion(r.APIVersion); err != nil {
+			return fmt.Errorf("health gate resource: %s", err)
+		}
+	case g.HTTPGet == "":
+		return fmt.Errorf("health gate sets neither resource nor httpGet")
+	}
+	return nil
+}
+
+func (r *ObjectRef) unstructured() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(r.APIVersion)
+	u.SetKind(r.Kind)
+	u.SetNamespace(r.Namespace)
+	u.SetName(r.Name)
+	return u
+}
+
+// ComponentHealthGates returns the health gates set in the free-form spec of each component in iops, under
+// HealthGatesKey. Addons share the health gates of all addons. Gateways do not have a free-form spec and have no
+// health gates.
+func ComponentHealthGates(iops *v1alpha1.IstioOperatorSpec) (map[name.ComponentName][]HealthGate, error) {
+	out := make(map[name.ComponentName][]HealthGate)
+	err := componentSpecs(iops, func(cn name.ComponentName, desc string, specI interface{}) error {
+		gates, err := healthGatesFromSpec(specI)
+		if err != nil {
+			return fmt.Errorf("%s: %s", desc, err)
+		}
+		out[cn] = append(out[cn], gates...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func healthGatesFromSpec(specI interface{}) ([]HealthGate, error) {
+	spec, ok := specI.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	v, ok := spec[HealthGatesKey]
+	if !ok {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var gates []HealthGate
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&gates); err != nil {
+		return nil, fmt.Errorf("bad %s: %s", HealthGatesKey, err)
+	}
+	for _, g := range gates {
+		if err := g.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return gates, nil
+}
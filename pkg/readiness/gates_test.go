@@ -0,0 +1,125 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/util"
+)
+
+func TestComponentHealthGates(t *testing.T) {
+	iops := &v1alpha1.IstioOperatorSpec{}
+	err := util.UnmarshalWithJSONPB(`{
+  "components": {
+    "pilot": {"spec": {"healthGates": [{"httpGet": "http://istio-pilot.istio-system:8080/ready"}]}},
+    "sidecarInjector": {"spec": {"healthGates": [{"resource": {"apiVersion": "admissionregistration.k8s.io/v1beta1",
+      "kind": "MutatingWebhookConfiguration", "name": "istio-sidecar-injector"}}]}}
+  },
+  "addonComponents": {"grafana": {"spec": {"healthGates": [{"httpGet": "http://grafana.istio-system:3000"}]}}}
+}`, iops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ComponentHealthGates(iops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[name.ComponentName][]HealthGate{
+		name.PilotComponentName: {{HTTPGet: "http://istio-pilot.istio-system:8080/ready"}},
+		name.SidecarInjectorComponentName: {{Resource: &ObjectRef{APIVersion: "admissionregistration.k8s.io/v1beta1",
+			Kind: "MutatingWebhookConfiguration", Name: "istio-sidecar-injector"}}},
+		name.AddonComponentName: {{HTTPGet: "http://grafana.istio-system:3000"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got health gates %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{
+		`{"components": {"pilot": {"spec": {"healthGates": [{}]}}}}`,
+		`{"components": {"pilot": {"spec": {"healthGates": [{"httpGet": "http://a", "resource": {"apiVersion": "v1", "kind": "Service", "name": "a"}}]}}}}`,
+		`{"components": {"pilot": {"spec": {"healthGates": [{"resource": {"kind": "Service", "name": "a"}}]}}}}`,
+		`{"components": {"pilot": {"spec": {"healthGates": [{"url": "http://a"}]}}}}`,
+	} {
+		iops := &v1alpha1.IstioOperatorSpec{}
+		if err := util.UnmarshalWithJSONPB(bad, iops); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ComponentHealthGates(iops); err == nil {
+			t.Errorf("ComponentHealthGates(%s): expected error", bad)
+		}
+	}
+}
+
+func TestHealthGateCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	g := make(fakeGetter)
+	g.add(t, `
+apiVersion: v1
+kind: Namespace
+metadata: {name: istio-system}
+status: {phase: Active}
+`)
+
+	tests := []struct {
+		desc       string
+		gate       HealthGate
+		wantReady  bool
+		wantReason string
+	}{
+		{
+			desc:      "URL ready",
+			gate:      HealthGate{HTTPGet: ts.URL + "/ready"},
+			wantReady: true,
+		},
+		{
+			desc:       "URL not ready",
+			gate:       HealthGate{HTTPGet: ts.URL + "/other"},
+			wantReason: "status 503 Service Unavailable",
+		},
+		{
+			desc:      "resource ready",
+			gate:      HealthGate{Resource: &ObjectRef{APIVersion: "v1", Kind: "Namespace", Name: "istio-system"}},
+			wantReady: true,
+		},
+		{
+			desc:       "resource missing",
+			gate:       HealthGate{Resource: &ObjectRef{APIVersion: "v1", Kind: "Namespace", Name: "istio-control"}},
+			wantReason: "not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ready, reason, err := tt.gate.Check(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != tt.wantReady || !strings.Contains(reason, tt.wantReason) {
+				t.Errorf("got ready %v, reason %q, want ready %v, reason %q", ready, reason, tt.wantReady, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package readiness determines whether K8s objects applied by the installer are ready.
+
+Readiness is decided by a Checker registered for the GroupKind of an object. Checkers inspect the live state of the
+object, fetched through a Getter, and may fetch other objects, e.g. the Endpoints of a webhook Service. Objects of
+kinds without a registered Checker are ready as soon as they exist. The same checkers are used by the CLI, which
+fetches objects with a dynamic client, and the controller, which uses a controller-runtime client.
+*/
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/tpath"
+)
+
+const (
+	// DefaultPollInterval is the default interval between readiness checks.
+	DefaultPollInterval = 2 * time.Second
+	// TimeoutKey is the key in the free-form spec of a component which holds its readiness timeout, e.g.
+	// components.pilot.spec.readinessTimeout: 10m.
+	TimeoutKey = "readinessTimeout"
+)
+
+// Getter fetches the live state of an object from the cluster. It returns a NotFound API error if the object does not
+// exist.
+type Getter interface {
+	Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error)
+}
+
+// Checker reports whether the live object u is ready. If it is not, reason describes what it is waiting for. An error
+// is returned if the object can never become ready, e.g. a failed Job.
+type Checker func(g Getter, u *unstructured.Unstructured) (ready bool, reason string, err error)
+
+var (
+	checkersMu sync.RWMutex
+	checkers   = make(map[schema.GroupKind]Checker)
+)
+
+// Register sets the Checker for objects of the given GroupKind, replacing any existing one.
+func Register(gk schema.GroupKind, c Checker) {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	checkers[gk] = c
+}
+
+// Check fetches the live state of u and reports whether it is ready, using the Checker registered for its kind.
+func Check(g Getter, u *unstructured.Unstructured) (bool, string, error) {
+	gvk := u.GroupVersionKind()
+	live, err := g.Get(gvk, u.GetNamespace(), u.GetName())
+	if apierrors.IsNotFound(err) {
+		return false, "not found", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	checkersMu.RLock()
+	c := checkers[gvk.GroupKind()]
+	checkersMu.RUnlock()
+	if c == nil {
+		return true, "", nil
+	}
+	return c(g, live)
+}
+
+// Wait polls the objects every interval until all of them are ready, and returns an error listing the objects which
+// are not ready if timeout expires first. A zero timeout waits forever.
+func Wait(g Getter, objects []*unstructured.Unstructured, interval, timeout time.Duration) error {
+	return WaitWithProgress(g, objects, interval, timeout, nil)
+}
+
+// WaitWithProgress is like Wait, but calls progress after each poll with the objects which are not ready yet, each
+// described as "Kind/namespace/name (reason)". progress may be nil.
+func WaitWithProgress(g Getter, objects []*unstructured.Unstructured, interval, timeout time.Duration,
+	progress func(notReady []string)) error {
+	var notReady []string
+	errPoll := wait.Poll(interval, timeout, func() (bool, error) {
+		notReady = nil
+		for _, u := range objects {
+			ready, reason, err := Check(g, u)
+			if err != nil {
+				return false, fmt.Errorf("%s: %s", objectRef(u), err)
+			}
+			if !ready {
+				notReady = append(notReady, fmt.Sprintf("%s (%s)", objectRef(u), reason))
+			}
+		}
+		sort.Strings(notReady)
+		if progress != nil {
+			progress(notReady)
+		}
+		return len(notReady) == 0, nil
+	})
+	if errPoll == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out after %s waiting for resources to be ready: %s", timeout, strings.Join(notReady, ", "))
+	}
+	return errPoll
+}
+
+// ComponentTimeouts returns the readiness timeouts set in the free-form spec of each component in iops, under
+// TimeoutKey. Addons share a single timeout, which is the largest timeout set for any addon. Gateways do not have a
+// free-form spec and always use the default timeout.
+func ComponentTimeouts(iops *v1alpha1.IstioOperatorSpec) (map[name.ComponentName]time.Duration, error) {
+	out := make(map[name.ComponentName]time.Duration)
+	err := componentSpecs(iops, func(cn name.ComponentName, desc string, specI interface{}) error {
+		d, err := timeoutFromSpec(specI)
+		if err != nil {
+			return fmt.Errorf("%s: %s", desc, err)
+		}
+		if d > out[cn] {
+			out[cn] = d
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// componentSpecs calls fn with the free-form spec of each component in iops, with desc describing the component in
+// errors. The specs of addons are passed for name.AddonComponentName, sorted by addon name.
+func componentSpecs(iops *v1alpha1.IstioOperatorSpec, fn func(cn name.ComponentName, desc string,
+	specI interface{}) error) error {
+	if iops == nil {
+		return nil
+	}
+	for cn := range name.ComponentDependencies {
+		if cn.IsGateway() || cn.IsAddon() {
+			continue
+		}
+		specI, found, err := tpath.GetFromStructPath(iops, "Components."+string(cn)+".Spec")
+		if err != nil || !found {
+			continue
+		}
+		if err := fn(cn, "component "+string(cn), specI); err != nil {
+			return err
+		}
+	}
+	addons := make([]string, 0, len(iops.AddonComponents))
+	for an := range iops.AddonComponents {
+		addons = append(addons, an)
+	}
+	sort.Strings(addons)
+	for _, an := range addons {
+		ac := iops.AddonComponents[an]
+		if ac == nil {
+			continue
+		}
+		if err := fn(name.AddonComponentName, "addon component "+an, ac.Spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClientGetter is a Getter using a controller-runtime client or reader.
+type ClientGetter struct {
+	Client client.Reader
+}
+
+// Get implements Getter.
+func (c *ClientGetter) Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	if err := c.Client.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func timeoutFromSpec(specI interface{}) (time.Duration, error) {
+	spec, ok := specI.(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+	v, ok := spec[TimeoutKey]
+	if !ok {
+		return 0, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("%s has bad type %T, expect duration string", TimeoutKey, v)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q: %s", TimeoutKey, s, err)
+	}
+	return d, nil
+}
+
+func objectRef(u *unstructured.Unstructured) string {
+	if u.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", u.GetKind(), u.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", u.GetKind(), u.GetNamespace(), u.GetName())
+}
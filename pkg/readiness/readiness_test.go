@@ -0,0 +1,275 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"istio.io/api/operator/v1alpha1"
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object/objecttest"
+	"istio.io/operator/pkg/util"
+)
+
+// fakeGetter returns objects from a map keyed by kind/namespace/name.
+type fakeGetter map[string]*unstructured.Unstructured
+
+func (f fakeGetter) Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	if u, ok := f[gvk.Kind+"/"+namespace+"/"+name]; ok {
+		return u, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, name)
+}
+
+func (f fakeGetter) add(t *testing.T, y string) *unstructured.Unstructured {
+	t.Helper()
+	u := objecttest.Unstructured(t, y)
+	f[u.GetKind()+"/"+u.GetNamespace()+"/"+u.GetName()] = u
+	return u
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		desc       string
+		objects    []string
+		wantReady  bool
+		wantReason string
+		wantErr    string
+	}{
+		{
+			desc: "deployment rolled out",
+			objects: []string{`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: pilot, namespace: istio-system, generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, availableReplicas: 2, readyReplicas: 2}
+`},
+			wantReady: true,
+		},
+		{
+			desc: "deployment with old replicas",
+			objects: []string{`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: pilot, namespace: istio-system, generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 3, updatedReplicas: 2, availableReplicas: 3, readyReplicas: 3}
+`},
+			wantReason: "1 old replicas pending termination",
+		},
+		{
+			desc: "deployment not observed",
+			objects: []string{`
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: pilot, namespace: istio-system, generation: 3}
+spec: {replicas: 1}
+status: {observedGeneration: 2, replicas: 1, updatedReplicas: 1, availableReplicas: 1}
+`},
+			wantReason: "waiting for the latest spec to be observed",
+		},
+		{
+			desc: "daemonset not updated",
+			objects: []string{`
+apiVersion: apps/v1
+kind: DaemonSet
+metadata: {name: cni, namespace: kube-system}
+spec: {updateStrategy: {type: RollingUpdate}}
+status: {desiredNumberScheduled: 3, updatedNumberScheduled: 2, numberReady: 3}
+`},
+			wantReason: "2 of 3 pods updated",
+		},
+		{
+			desc: "statefulset not ready",
+			objects: []string{`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: prometheus, namespace: istio-system}
+spec: {replicas: 2}
+status: {readyReplicas: 1, currentRevision: a, updateRevision: a}
+`},
+			wantReason: "1 of 2 replicas ready",
+		},
+		{
+			desc: "job complete",
+			objects: []string{`
+apiVersion: batch/v1
+kind: Job
+metadata: {name: init, namespace: istio-system}
+status: {conditions: [{type: Complete, status: "True"}]}
+`},
+			wantReady: true,
+		},
+		{
+			desc: "job failed",
+			objects: []string{`
+apiVersion: batch/v1
+kind: Job
+metadata: {name: init, namespace: istio-system}
+status: {conditions: [{type: Failed, status: "True", message: BackoffLimitExceeded}]}
+`},
+			wantErr: "job failed: BackoffLimitExceeded",
+		},
+		{
+			desc: "crd not established",
+			objects: []string{`
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata: {name: gateways.networking.istio.io}
+status: {conditions: [{type: NamesAccepted, status: "False", reason: Conflict}]}
+`},
+			wantReason: "name conflict: Conflict",
+		},
+		{
+			desc: "webhook without endpoints",
+			objects: []string{`
+apiVersion: admissionregistration.k8s.io/v1beta1
+kind: MutatingWebhookConfiguration
+metadata: {name: istio-sidecar-injector}
+webhooks: [{name: sidecar-injector.istio.io, clientConfig: {service: {namespace: istio-system, name: istio-sidecar-injector}}}]
+`, `
+apiVersion: v1
+kind: Endpoints
+metadata: {name: istio-sidecar-injector, namespace: istio-system}
+subsets: [{notReadyAddresses: [{ip: 10.0.0.1}]}]
+`},
+			wantReason: "webhook sidecar-injector.istio.io has no ready endpoints in service istio-system/istio-sidecar-injector",
+		},
+		{
+			desc: "webhook with endpoints",
+			objects: []string{`
+apiVersion: admissionregistration.k8s.io/v1beta1
+kind: MutatingWebhookConfiguration
+metadata: {name: istio-sidecar-injector}
+webhooks: [{name: sidecar-injector.istio.io, clientConfig: {service: {namespace: istio-system, name: istio-sidecar-injector}}}]
+`, `
+apiVersion: v1
+kind: Endpoints
+metadata: {name: istio-sidecar-injector, namespace: istio-system}
+subsets: [{addresses: [{ip: 10.0.0.1}]}]
+`},
+			wantReady: true,
+		},
+		{
+			desc: "load balancer without ingress",
+			objects: []string{`
+apiVersion: v1
+kind: Service
+metadata: {name: istio-ingressgateway, namespace: istio-system}
+spec: {type: LoadBalancer, clusterIP: 10.0.0.2}
+`},
+			wantReason: "no load balancer ingress assigned",
+		},
+		{
+			desc: "kind without checker",
+			objects: []string{`
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: istio, namespace: istio-system}
+`},
+			wantReady: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			g := make(fakeGetter)
+			var u *unstructured.Unstructured
+			for i, o := range tt.objects {
+				if i == 0 {
+					u = g.add(t, o)
+				} else {
+					g.add(t, o)
+				}
+			}
+			ready, reason, err := Check(g, u)
+			if gotErr, wantErr := errString(err), tt.wantErr; gotErr != wantErr {
+				t.Fatalf("got error %q, want %q", gotErr, wantErr)
+			}
+			if ready != tt.wantReady || reason != tt.wantReason {
+				t.Errorf("got ready %v, reason %q, want ready %v, reason %q", ready, reason, tt.wantReady, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	g := make(fakeGetter)
+	ns := g.add(t, `
+apiVersion: v1
+kind: Namespace
+metadata: {name: istio-system}
+status: {phase: Active}
+`)
+	missing := &unstructured.Unstructured{}
+	missing.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Service"})
+	missing.SetNamespace("istio-system")
+	missing.SetName("istio-pilot")
+
+	if err := Wait(g, []*unstructured.Unstructured{ns}, time.Millisecond, time.Second); err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+	err := Wait(g, []*unstructured.Unstructured{ns, missing}, time.Millisecond, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "Service/istio-system/istio-pilot (not found)") {
+		t.Errorf("Wait: got error %v, want timeout naming the missing Service", err)
+	}
+}
+
+func TestComponentTimeouts(t *testing.T) {
+	iops := &v1alpha1.IstioOperatorSpec{}
+	err := util.UnmarshalWithJSONPB(`{
+  "components": {"pilot": {"spec": {"readinessTimeout": "10m"}}, "policy": {"enabled": true}},
+  "addonComponents": {"grafana": {"spec": {"readinessTimeout": "1m"}}, "kiali": {"spec": {"readinessTimeout": "3m"}}}
+}`, iops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ComponentTimeouts(iops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[name.ComponentName]time.Duration{
+		name.PilotComponentName: 10 * time.Minute,
+		name.AddonComponentName: 3 * time.Minute,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got timeouts %v, want %v", got, want)
+	}
+	for c, w := range want {
+		if got[c] != w {
+			t.Errorf("%s: got timeout %v, want %v", c, got[c], w)
+		}
+	}
+
+	if err := util.UnmarshalWithJSONPB(`{"components": {"pilot": {"spec": {"readinessTimeout": "soon"}}}}`, iops); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ComponentTimeouts(iops); err == nil {
+		t.Errorf("ComponentTimeouts: expected error for bad duration")
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package retry retries K8s API calls which fail with transient errors.
+
+Errors are classified as retryable or fatal. Retryable errors are those expected while the control plane is coming
+up or under load, e.g. a webhook whose service has no endpoints yet, an update conflict, throttling or a reset
+connection. All other errors, e.g. an invalid object or a forbidden request, are fatal and are returned immediately.
+*/
+package retry
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultBackoff is the backoff used for retrying object operations, giving up after about a minute.
+var DefaultBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    7,
+	Cap:      30 * time.Second,
+}
+
+// IsRetryable reports whether err is a transient error, after which the same request may succeed.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch {
+	case apierrors.IsConflict(err),
+		apierrors.IsServerTimeout(err),
+		apierrors.IsTimeout(err),
+		apierrors.IsTooManyRequests(err),
+		apierrors.IsServiceUnavailable(err),
+		apierrors.IsInternalError(err):
+		return true
+	case utilnet.IsConnectionReset(err), utilnet.IsConnectionRefused(err), utilnet.IsProbableEOF(err):
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// The API server reports webhooks which are not reachable yet with different status codes depending on the
+	// version.
+	return strings.Contains(err.Error(), "failed calling webhook")
+}
+
+// Do calls fn until it returns nil or a fatal error, sleeping between attempts as given by backoff, and gives up once
+// the backoff steps are exhausted. It returns the number of retries and the error of the last attempt.
+func Do(backoff wait.Backoff, fn func() error) (int, error) {
+	retries := 0
+	for {
+		err := fn()
+		if err == nil || !IsRetryable(err) || backoff.Steps <= 1 {
+			return retries, err
+		}
+		time.Sleep(backoff.Step())
+		retries++
+	}
+}
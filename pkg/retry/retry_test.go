@@ -0,0 +1,96 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestIsRetryable(t *testing.T) {
+	gr := schema.GroupResource{Resource: "services"}
+	tests := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{desc: "nil", err: nil, want: false},
+		{desc: "conflict", err: apierrors.NewConflict(gr, "istio-pilot", fmt.Errorf("modified")), want: true},
+		{desc: "throttled", err: apierrors.NewTooManyRequests("slow down", 1), want: true},
+		{desc: "unavailable", err: apierrors.NewServiceUnavailable("starting"), want: true},
+		{
+			desc: "webhook not ready",
+			err: apierrors.NewInternalError(fmt.Errorf(`failed calling webhook "pilot.validation.istio.io": ` +
+				`Post https://istio-galley.istio-system.svc:443/admitpilot: no endpoints available`)),
+			want: true,
+		},
+		{desc: "webhook denied with other status", err: fmt.Errorf(`failed calling webhook "x": EOF`), want: true},
+		{desc: "connection reset", err: fmt.Errorf("read: %s", syscall.ECONNRESET), want: true},
+		{desc: "eof", err: io.EOF, want: true},
+		{desc: "invalid", err: apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "istio-pilot", nil), want: false},
+		{desc: "forbidden", err: apierrors.NewForbidden(gr, "istio-pilot", fmt.Errorf("no")), want: false},
+		{desc: "not found", err: apierrors.NewNotFound(gr, "istio-pilot"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v): got %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 4}
+	transient := apierrors.NewServiceUnavailable("starting")
+
+	calls := 0
+	retries, err := Do(backoff, func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || retries != 2 {
+		t.Errorf("got retries %d, error %v, want 2 retries and no error", retries, err)
+	}
+
+	calls = 0
+	retries, err = Do(backoff, func() error {
+		calls++
+		return transient
+	})
+	if err != transient || retries != 3 || calls != 4 {
+		t.Errorf("got retries %d, calls %d, error %v, want 3 retries, 4 calls and the last error", retries, calls, err)
+	}
+
+	fatal := apierrors.NewBadRequest("bad")
+	calls = 0
+	retries, err = Do(backoff, func() error {
+		calls++
+		return fatal
+	})
+	if err != fatal || retries != 0 || calls != 1 {
+		t.Errorf("got retries %d, calls %d, error %v, want no retries for a fatal error", retries, calls, err)
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+// sharedKinds are the kinds of the objects which are shared by all revisions. They are left to the install without a
+// revision. Objects of all other kinds, including Istio configuration such as EnvoyFilters, belong to the control
+// plane and are renamed for a revision.
+var sharedKinds = map[string]bool{
+	"CustomResourceDefinition": true,
+	"Namespace":                true,
+}
+
+// selectorLabels are the pod labels the Services of the install without a revision select by. Their values get the
+// revision suffix in the pods and selectors of a revision, so that those Services never select the pods of a revision.
+var selectorLabels = []string{"app", "istio"}
+
+// ApplyRevision returns the manifests of the control plane revision, which can be installed side by side with the
+// control planes of other revisions:
+//   - the objects of each component are renamed to <name>-<revision>, and references between them are updated,
+//     including the addresses of renamed Services in ConfigMaps, arguments and environment variables.
+//   - all objects, the pods of workloads and the selectors of workloads and Services get the name.RevisionLabel, and
+//     the selectorLabels of pods and selectors get the -<revision> suffix.
+//   - the sidecar injector webhook only selects namespaces labeled with the name.RevisionLabel set to revision.
+//   - the addresses of renamed Services in the spec of other objects, e.g. the hosts of DestinationRules, are updated.
+//   - objects embedded in ConfigMaps, e.g. the webhook configuration applied by galley, are updated in the same way.
+//   - shared objects, i.e. CRDs, namespaces and the Base component, are not rendered.
+//
+// If revision is empty, manifests are returned unchanged.
+func ApplyRevision(manifests name.ManifestMap, revision string) (name.ManifestMap, error) {
+	if revision == "" {
+		return manifests, nil
+	}
+	r := &revisioner{revision: revision, renamed: make(map[string]bool), names: make(map[string]bool)}
+	parsed := make(map[name.ComponentName][][]*unstructured.Unstructured)
+	for c, ms := range manifests {
+		for _, m := range ms {
+			var objects []*unstructured.Unstructured
+			if c != name.IstioBaseComponentName {
+				os, err := object.ParseK8sObjectsFromYAMLManifest(m)
+				if err != nil {
+					return nil, fmt.Errorf("could not parse the manifest of component %s: %s", c, err)
+				}
+				for _, o := range os {
+					if sharedKinds[o.Kind] {
+						continue
+					}
+					r.add(o.UnstructuredObject())
+					objects = append(objects, o.UnstructuredObject())
+				}
+			}
+			parsed[c] = append(parsed[c], objects)
+		}
+	}
+
+	out := make(name.ManifestMap)
+	for c, ms := range parsed {
+		for _, objects := range ms {
+			var os object.K8sObjects
+			for _, u := range objects {
+				r.apply(u)
+				os = append(os, object.NewK8sObject(u, nil, nil))
+			}
+			y, err := os.YAMLManifest()
+			if err != nil {
+				return nil, err
+			}
+			out[c] = append(out[c], y)
+		}
+	}
+	return out, nil
+}
+
+// revisioner renames the objects of a revision and the references between them.
+type revisioner struct {
+	revision string
+	// renamed holds the renamed objects, keyed by revisionKey.
+	renamed map[string]bool
+	// names holds the names of all renamed objects, keyed by namespace/name. Cluster scoped objects have an empty
+	// namespace.
+	names map[string]bool
+	// hosts rewrite the addresses of the renamed Services.
+	hosts []hostRewrite
+}
+
+// hostRewrite rewrites the address <name>.<namespace> of a Service.
+type hostRewrite struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+func revisionKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// add registers obj as an object of the revision, which is renamed.
+func (r *revisioner) add(obj *unstructured.Unstructured) {
+	kind, ns, n := obj.GetKind(), obj.GetNamespace(), obj.GetName()
+	r.renamed[revisionKey(kind, ns, n)] = true
+	r.names[ns+"/"+n] = true
+	if kind == "Service" {
+		r.hosts = append(r.hosts, hostRewrite{
+			re:   regexp.MustCompile(`(^|[^a-z0-9.-])` + regexp.QuoteMeta(n+"."+ns) + `($|[^a-z0-9-])`),
+			repl: "${1}" + r.name(n) + "." + ns + "${2}",
+		})
+	}
+}
+
+// name returns the name of an object of the revision.
+func (r *revisioner) name(n string) string {
+	return n + "-" + r.revision
+}
+
+// rename returns the new name of the object with the given kind, namespace and name, or n if it is not renamed.
+func (r *revisioner) rename(kind, namespace, n string) string {
+	if r.renamed[revisionKey(kind, namespace, n)] {
+		return r.name(n)
+	}
+	return n
+}
+
+// apply turns obj into an object of the revision.
+func (r *revisioner) apply(obj *unstructured.Unstructured) {
+	kind, ns := obj.GetKind(), obj.GetNamespace()
+	o := obj.Object
+	obj.SetName(r.name(obj.GetName()))
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[name.RevisionLabel] = r.revision
+	obj.SetLabels(labels)
+
+	switch kind {
+	case "Deployment", "DaemonSet", "StatefulSet":
+		r.addRevisionLabel(o, true, "spec", "selector", "matchLabels")
+		r.addRevisionLabel(o, true, "spec", "template", "metadata", "labels")
+		if spec, ok := nestedMap(o, "spec", "template", "spec"); ok {
+			r.applyPodSpec(spec, ns)
+		}
+	case "Service":
+		r.addRevisionLabel(o, false, "spec", "selector")
+	case "PodDisruptionBudget":
+		r.addRevisionLabel(o, false, "spec", "selector", "matchLabels")
+	case "HorizontalPodAutoscaler":
+		if ref, ok := nestedMap(o, "spec", "scaleTargetRef"); ok {
+			r.renameRef(ref, "name", stringField(ref, "kind"), ns)
+		}
+	case "Role", "ClusterRole":
+		for _, rule := range nestedMaps(o, "rules") {
+			if names, ok := rule["resourceNames"].([]interface{}); ok {
+				for i, n := range names {
+					if s, ok := n.(string); ok && r.isRenamed(s, ns) {
+						names[i] = r.name(s)
+					}
+				}
+			}
+		}
+	case "RoleBinding", "ClusterRoleBinding":
+		if ref, ok := nestedMap(o, "roleRef"); ok {
+			refKind := stringField(ref, "kind")
+			refNs := ns
+			if refKind == "ClusterRole" {
+				refNs = ""
+			}
+			r.renameRef(ref, "name", refKind, refNs)
+		}
+		for _, s := range nestedMaps(o, "subjects") {
+			if stringField(s, "kind") == "ServiceAccount" {
+				r.renameRef(s, "name", "ServiceAccount", stringField(s, "namespace"))
+			}
+		}
+	case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+		for _, w := range nestedMaps(o, "webhooks") {
+			if svc, ok := nestedMap(w, "clientConfig", "service"); ok {
+				r.renameRef(svc, "name", "Service", stringField(svc, "namespace"))
+			}
+			if kind == "MutatingWebhookConfiguration" {
+				w["namespaceSelector"] = map[string]interface{}{
+					"matchLabels": map[string]interface{}{name.RevisionLabel: r.revision},
+				}
+			}
+		}
+	case "ConfigMap":
+		if data, ok := nestedMap(o, "data"); ok {
+			for k, v := range data {
+				if s, ok := v.(string); ok {
+					data[k] = r.rewriteData(s)
+				}
+			}
+		}
+	default:
+		// Istio configuration of the revision selects the workloads of the revision, e.g. its gateways.
+		if kind == "Gateway" {
+			r.addRevisionLabel(o, false, "spec", "selector")
+		}
+		r.addRevisionLabel(o, false, "spec", "selector", "matchLabels")
+		r.addRevisionLabel(o, false, "spec", "workloadSelector", "labels")
+		if spec, ok := o["spec"]; ok {
+			o["spec"] = r.rewriteStrings(spec)
+		}
+	}
+}
+
+// rewriteStrings rewrites the addresses of renamed Services in all strings held in v.
+func (r *revisioner) rewriteStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.rewriteHosts(v)
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = r.rewriteStrings(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = r.rewriteStrings(e)
+		}
+	}
+	return v
+}
+
+// rewriteData rewrites a ConfigMap value. Values holding an object of the revision, e.g. the webhook configuration
+// applied by galley itself, are turned into an object of the revision. Otherwise the addresses of renamed Services are
+// rewritten.
+func (r *revisioner) rewriteData(s string) string {
+	o, err := object.ParseYAMLToK8sObject([]byte(s))
+	if err != nil || o.Kind == "" || sharedKinds[o.Kind] || o.Name == "" {
+		return r.rewriteHosts(s)
+	}
+	u := o.UnstructuredObject()
+	r.apply(u)
+	y, err := object.NewK8sObject(u, nil, nil).YAML()
+	if err != nil {
+		return r.rewriteHosts(s)
+	}
+	return string(y)
+}
+
+// applyPodSpec updates the references of a pod spec in namespace ns to renamed objects.
+func (r *revisioner) applyPodSpec(spec map[string]interface{}, ns string) {
+	r.renameRef(spec, "serviceAccountName", "ServiceAccount", ns)
+	for _, v := range nestedMaps(spec, "volumes") {
+		if cm, ok := nestedMap(v, "configMap"); ok {
+			r.renameRef(cm, "name", "ConfigMap", ns)
+		}
+		if secret, ok := nestedMap(v, "secret"); ok {
+			r.renameRef(secret, "secretName", "Secret", ns)
+		}
+	}
+	containers := append(nestedMaps(spec, "initContainers"), nestedMaps(spec, "containers")...)
+	for _, c := range containers {
+		for _, field := range []string{"command", "args"} {
+			if args, ok := c[field].([]interface{}); ok {
+				for i, a := range args {
+					if s, ok := a.(string); ok {
+						args[i] = r.rewriteValue(s, ns)
+					}
+				}
+			}
+		}
+		for _, e := range nestedMaps(c, "env") {
+			if s, ok := e["value"].(string); ok {
+				e["value"] = r.rewriteValue(s, ns)
+			}
+			if ref, ok := nestedMap(e, "valueFrom", "configMapKeyRef"); ok {
+				r.renameRef(ref, "name", "ConfigMap", ns)
+			}
+			if ref, ok := nestedMap(e, "valueFrom", "secretKeyRef"); ok {
+				r.renameRef(ref, "name", "Secret", ns)
+			}
+		}
+		for _, e := range nestedMaps(c, "envFrom") {
+			if ref, ok := nestedMap(e, "configMapRef"); ok {
+				r.renameRef(ref, "name", "ConfigMap", ns)
+			}
+			if ref, ok := nestedMap(e, "secretRef"); ok {
+				r.renameRef(ref, "name", "Secret", ns)
+			}
+		}
+	}
+}
+
+// rewriteValue rewrites an argument or environment variable value of a container in namespace ns. Values which are
+// the name of a renamed object, either alone or as --flag=name, are renamed, and the addresses of renamed Services
+// are rewritten.
+func (r *revisioner) rewriteValue(s, ns string) string {
+	if r.isRenamed(s, ns) {
+		return r.name(s)
+	}
+	if strings.HasPrefix(s, "-") {
+		if parts := strings.SplitN(s, "=", 2); len(parts) == 2 && r.isRenamed(parts[1], ns) {
+			return parts[0] + "=" + r.name(parts[1])
+		}
+	}
+	return r.rewriteHosts(s)
+}
+
+// isRenamed reports whether n is the name of a renamed object in namespace ns, or of a renamed cluster scoped object.
+func (r *revisioner) isRenamed(n, ns string) bool {
+	return r.names[ns+"/"+n] || r.names["/"+n]
+}
+
+// rewriteHosts rewrites the addresses of renamed Services in s, e.g. istio-pilot.istio-system:15010.
+func (r *revisioner) rewriteHosts(s string) string {
+	for _, h := range r.hosts {
+		s = h.re.ReplaceAllString(s, h.repl)
+	}
+	return s
+}
+
+// renameRef renames the reference to an object of the given kind and namespace held in m[field].
+func (r *revisioner) renameRef(m map[string]interface{}, field, kind, ns string) {
+	if n, ok := m[field].(string); ok {
+		m[field] = r.rename(kind, ns, n)
+	}
+}
+
+// addRevisionLabel adds the name.RevisionLabel to the pod label map or selector at path in o, and adds the revision
+// suffix to the selectorLabels in it. If create is set, a missing map is created.
+func (r *revisioner) addRevisionLabel(o map[string]interface{}, create bool, path ...string) {
+	labels, ok := nestedMap(o, path...)
+	if !ok || len(labels) == 0 {
+		if !create {
+			return
+		}
+		labels = make(map[string]interface{})
+		_ = unstructured.SetNestedField(o, labels, path...)
+	}
+	labels[name.RevisionLabel] = r.revision
+	for _, l := range selectorLabels {
+		if v, ok := labels[l].(string); ok {
+			labels[l] = r.name(v)
+		}
+	}
+}
+
+// nestedMap returns the map at path in o, without copying it.
+func nestedMap(o map[string]interface{}, path ...string) (map[string]interface{}, bool) {
+	v, found, err := unstructured.NestedFieldNoCopy(o, path...)
+	if err != nil || !found {
+		return nil, false
+	}
+	m, ok := v.(map[string]interface{})
+	return m, ok
+}
+
+// nestedMaps returns the maps in the list at path in o, without copying them.
+func nestedMaps(o map[string]interface{}, path ...string) []map[string]interface{} {
+	v, found, err := unstructured.NestedFieldNoCopy(o, path...)
+	if err != nil || !found {
+		return nil
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	var out []map[string]interface{}
+	for _, e := range l {
+		if m, ok := e.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func stringField(m map[string]interface{}, field string) string {
+	s, _ := m[field].(string)
+	return s
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translate
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"istio.io/operator/pkg/name"
+	"istio.io/operator/pkg/object"
+)
+
+const revisionTestPilot = `
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: istio-pilot-service-account
+  namespace: istio-system
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  selector:
+    istio: pilot
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  selector:
+    matchLabels:
+      istio: pilot
+  template:
+    metadata:
+      labels:
+        istio: pilot
+    spec:
+      serviceAccountName: istio-pilot-service-account
+      containers:
+      - name: discovery
+        args:
+        - --meshConfig=istio
+        env:
+        - name: PILOT_ADDRESS
+          value: istio-pilot.istio-system.svc:15012
+      volumes:
+      - name: config-volume
+        configMap:
+          name: istio
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: istio
+  namespace: istio-system
+data:
+  mesh: |-
+    discoveryAddress: istio-pilot.istio-system.svc:15012
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: istio-pilot-istio-system
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: istio-pilot-istio-system
+subjects:
+- kind: ServiceAccount
+  name: istio-pilot-service-account
+  namespace: istio-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: istio-pilot-istio-system
+rules:
+- apiGroups: ["policy"]
+  resources: ["podsecuritypolicies"]
+  resourceNames: ["istio-pilot"]
+  verbs: ["use"]
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: DestinationRule
+metadata:
+  name: istio-pilot
+  namespace: istio-system
+spec:
+  host: istio-pilot.istio-system.svc.cluster.local
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: EnvoyFilter
+metadata:
+  name: metadata-exchange
+  namespace: istio-system
+spec:
+  configPatches:
+  - applyTo: HTTP_FILTER
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: Gateway
+metadata:
+  name: ingressgateway
+  namespace: istio-system
+spec:
+  selector:
+    istio: ingressgateway
+---
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: istio-pilot
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: envoyfilters.networking.istio.io
+`
+
+const revisionTestInjector = `
+apiVersion: admissionregistration.k8s.io/v1beta1
+kind: MutatingWebhookConfiguration
+metadata:
+  name: istio-sidecar-injector
+webhooks:
+- name: sidecar-injector.istio.io
+  clientConfig:
+    service:
+      name: istio-sidecar-injector
+      namespace: istio-system
+  namespaceSelector:
+    matchLabels:
+      istio-injection: enabled
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: istio-sidecar-injector
+  namespace: istio-system
+spec:
+  selector:
+    istio: sidecar-injector
+`
+
+const revisionTestBase = `
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: istio-reader-service-account
+  namespace: istio-system
+`
+
+func TestApplyRevision(t *testing.T) {
+	manifests := name.ManifestMap{
+		name.PilotComponentName:           {revisionTestPilot},
+		name.SidecarInjectorComponentName: {revisionTestInjector},
+		name.IstioBaseComponentName:       {revisionTestBase},
+	}
+	got, err := ApplyRevision(manifests, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[name.PilotComponentName][0] != revisionTestPilot {
+		t.Error("manifests changed without a revision")
+	}
+
+	got, err = ApplyRevision(manifests, "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Base component is kept, so that the components depending on it are applied, but it has no objects.
+	for _, m := range got[name.IstioBaseComponentName] {
+		if strings.TrimSpace(m) != "" {
+			t.Errorf("got Base manifest %q, want it empty", m)
+		}
+	}
+	objects := make(map[string]*unstructured.Unstructured)
+	for _, c := range []name.ComponentName{name.PilotComponentName, name.SidecarInjectorComponentName} {
+		os, err := object.ParseK8sObjectsFromYAMLManifest(got[c][0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range os {
+			u := o.UnstructuredObject()
+			if u.GetLabels()[name.RevisionLabel] != "canary" {
+				t.Errorf("%s/%s has labels %v, want the revision label", u.GetKind(), u.GetName(), u.GetLabels())
+			}
+			objects[u.GetKind()+"/"+u.GetName()] = u
+		}
+	}
+	if _, ok := objects["CustomResourceDefinition/envoyfilters.networking.istio.io"]; ok {
+		t.Error("shared CRD rendered for the revision")
+	}
+	for _, o := range []string{"EnvoyFilter/metadata-exchange-canary", "PodSecurityPolicy/istio-pilot-canary"} {
+		if _, ok := objects[o]; !ok {
+			t.Errorf("%s not rendered", o)
+		}
+	}
+
+	tests := []struct {
+		desc   string
+		object string
+		path   []string
+		want   string
+	}{
+		{
+			desc:   "Service selector",
+			object: "Service/istio-pilot-canary",
+			path:   []string{"spec", "selector", name.RevisionLabel},
+			want:   "canary",
+		},
+		{
+			desc:   "Deployment selector",
+			object: "Deployment/istio-pilot-canary",
+			path:   []string{"spec", "selector", "matchLabels", name.RevisionLabel},
+			want:   "canary",
+		},
+		{
+			desc:   "pod labels",
+			object: "Deployment/istio-pilot-canary",
+			path:   []string{"spec", "template", "metadata", "labels", name.RevisionLabel},
+			want:   "canary",
+		},
+		{
+			desc:   "pod selector label",
+			object: "Deployment/istio-pilot-canary",
+			path:   []string{"spec", "template", "metadata", "labels", "istio"},
+			want:   "pilot-canary",
+		},
+		{
+			desc:   "Service selector label",
+			object: "Service/istio-pilot-canary",
+			path:   []string{"spec", "selector", "istio"},
+			want:   "pilot-canary",
+		},
+		{
+			desc:   "Gateway selector",
+			object: "Gateway/ingressgateway-canary",
+			path:   []string{"spec", "selector", "istio"},
+			want:   "ingressgateway-canary",
+		},
+		{
+			desc:   "service account",
+			object: "Deployment/istio-pilot-canary",
+			path:   []string{"spec", "template", "spec", "serviceAccountName"},
+			want:   "istio-pilot-service-account-canary",
+		},
+		{
+			desc:   "ConfigMap addresses",
+			object: "ConfigMap/istio-canary",
+			path:   []string{"data", "mesh"},
+			want:   "discoveryAddress: istio-pilot-canary.istio-system.svc:15012",
+		},
+		{
+			desc:   "DestinationRule host",
+			object: "DestinationRule/istio-pilot-canary",
+			path:   []string{"spec", "host"},
+			want:   "istio-pilot-canary.istio-system.svc.cluster.local",
+		},
+		{
+			desc:   "role reference",
+			object: "ClusterRoleBinding/istio-pilot-istio-system-canary",
+			path:   []string{"roleRef", "name"},
+			want:   "istio-pilot-istio-system-canary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			o, ok := objects[tt.object]
+			if !ok {
+				t.Fatalf("%s not rendered", tt.object)
+			}
+			if got, _, _ := unstructured.NestedString(o.Object, tt.path...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	deployment := objects["Deployment/istio-pilot-canary"]
+	// The Service of the install without a revision does not select the pods of the revision.
+	defaultObjects, err := object.ParseK8sObjectsFromYAMLManifest(revisionTestPilot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podLabels, _, _ := unstructured.NestedStringMap(deployment.Object, "spec", "template", "metadata", "labels")
+	for _, o := range defaultObjects {
+		if o.Kind != "Service" {
+			continue
+		}
+		selector, _, _ := unstructured.NestedStringMap(o.UnstructuredObject().Object, "spec", "selector")
+		if labels.SelectorFromSet(selector).Matches(labels.Set(podLabels)) {
+			t.Errorf("Service %s without a revision selects the revision pods with labels %v", o.Name, podLabels)
+		}
+	}
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	container := containers[0].(map[string]interface{})
+	if got := container["args"].([]interface{})[0]; got != "--meshConfig=istio-canary" {
+		t.Errorf("got argument %q, want --meshConfig=istio-canary", got)
+	}
+	if got := container["env"].([]interface{})[0].(map[string]interface{})["value"]; got != "istio-pilot-canary.istio-system.svc:15012" {
+		t.Errorf("got PILOT_ADDRESS %q, want the address of istio-pilot-canary", got)
+	}
+	volumes, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "volumes")
+	if got, _, _ := unstructured.NestedString(volumes[0].(map[string]interface{}), "configMap", "name"); got != "istio-canary" {
+		t.Errorf("got volume ConfigMap %q, want istio-canary", got)
+	}
+
+	binding := objects["ClusterRoleBinding/istio-pilot-istio-system-canary"]
+	subjects, _, _ := unstructured.NestedSlice(binding.Object, "subjects")
+	if got := subjects[0].(map[string]interface{})["name"]; got != "istio-pilot-service-account-canary" {
+		t.Errorf("got subject %q, want istio-pilot-service-account-canary", got)
+	}
+
+	role := objects["ClusterRole/istio-pilot-istio-system-canary"]
+	rules, _, _ := unstructured.NestedSlice(role.Object, "rules")
+	if got := rules[0].(map[string]interface{})["resourceNames"].([]interface{})[0]; got != "istio-pilot-canary" {
+		t.Errorf("got PodSecurityPolicy reference %q, want istio-pilot-canary", got)
+	}
+
+	webhook := objects["MutatingWebhookConfiguration/istio-sidecar-injector-canary"]
+	webhooks, _, _ := unstructured.NestedSlice(webhook.Object, "webhooks")
+	wh := webhooks[0].(map[string]interface{})
+	if got, _, _ := unstructured.NestedString(wh, "clientConfig", "service", "name"); got != "istio-sidecar-injector-canary" {
+		t.Errorf("got webhook service %q, want istio-sidecar-injector-canary", got)
+	}
+	selector, _, _ := unstructured.NestedStringMap(wh, "namespaceSelector", "matchLabels")
+	if len(selector) != 1 || selector[name.RevisionLabel] != "canary" {
+		t.Errorf("got namespace selector %v, want %s=canary", selector, name.RevisionLabel)
+	}
+}
@@ -83,6 +83,9 @@ var (
 
 	// ObjectNameRegexp is a legal name for a k8s object.
 	ObjectNameRegexp = match(`[a-z0-9.-]{1,254}`)
+
+	// RevisionRegexp is a legal control plane revision. It is short, since it is appended to the names of objects.
+	RevisionRegexp = match(`[a-z0-9]([a-z0-9-]{0,18}[a-z0-9])?`)
 )
 
 // validateWithRegex checks whether the given value matches the regexp r.
@@ -29,6 +29,7 @@ var (
 		"Hub":                validateHub,
 		"Tag":                validateTag,
 		"InstallPackagePath": validateInstallPackagePath,
+		"ResourceSuffix":     validateRevision,
 	}
 	// requiredValues lists all the values that must be non-empty.
 	requiredValues = map[string]bool{}
@@ -134,6 +135,14 @@ func validateTag(path util.Path, val interface{}) util.Errors {
 	return validateWithRegex(path, val, TagRegexp)
 }
 
+func validateRevision(path util.Path, val interface{}) util.Errors {
+	if val == "" {
+		// no revision
+		return nil
+	}
+	return validateWithRegex(path, val, RevisionRegexp)
+}
+
 func validateInstallPackagePath(path util.Path, val interface{}) util.Errors {
 	valStr, ok := val.(string)
 	if !ok {
@@ -104,6 +104,19 @@ hub: docker.io:tag/istio
 `,
 			wantErrs: makeErrors([]string{`invalid value Hub: docker.io:tag/istio`}),
 		},
+		{
+			desc: "GoodRevision",
+			yamlStr: `
+resourceSuffix: canary-1
+`,
+		},
+		{
+			desc: "BadRevision",
+			yamlStr: `
+resourceSuffix: Canary_1
+`,
+			wantErrs: makeErrors([]string{`invalid value ResourceSuffix: Canary_1`}),
+		},
 		{
 			desc: "GoodURL",
 			yamlStr: `
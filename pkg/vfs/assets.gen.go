@@ -38882,6 +38882,16 @@ spec:
   scope: Namespaced
   subresources:
     status: {}
+  additionalPrinterColumns:
+  - name: Ready
+    type: string
+    JSONPath: .status.conditions[?(@.type=="Ready")].status
+  - name: Reconciled
+    type: string
+    JSONPath: .status.conditions[?(@.type=="Reconciled")].status
+  - name: Age
+    type: date
+    JSONPath: .metadata.creationTimestamp
   validation:
     openAPIV3Schema:
       properties:
@@ -38902,10 +38912,10 @@ spec:
             More info: https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#spec-and-status'
           type: object
         status:
-          description: 'Status describes each of istio control plane component status at the current time.
-            0 means NONE, 1 means UPDATING, 2 means HEALTHY, 3 means ERROR, 4 means RECONCILING.
-            More info: https://github.com/istio/api/blob/master/operator/v1alpha1/istio.operator.v1alpha1.pb.html &
-            https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#spec-and-status'
+          description: 'Status describes the istio control plane and each of its components at the current time,
+            with the Ready, Progressing, Degraded and Reconciled conditions of the resource under conditions and of
+            each component under components.
+            More info: https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#spec-and-status'
           type: object
   versions:
   - name: v1alpha1
@@ -38991,7 +39001,7 @@ func operatorTemplatesDeploymentYaml() (*asset, error) {
 var _operatorTemplatesNamespaceYaml = []byte(`apiVersion: v1
 kind: Namespace
 metadata:
-  name: istio-operator
+  name: {{.Values.operatorNamespace}}
   labels:
     istio-operator-managed: Reconcile
     istio-injection: disabled